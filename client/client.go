@@ -2,12 +2,15 @@
 package client
 
 import (
+	"path/filepath"
 	"strings"
 
 	"github.com/eduvpn/eduvpn-common/internal/config"
 	"github.com/eduvpn/eduvpn-common/internal/discovery"
 	"github.com/eduvpn/eduvpn-common/internal/fsm"
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
 	"github.com/eduvpn/eduvpn-common/internal/log"
+	"github.com/eduvpn/eduvpn-common/internal/secret"
 	"github.com/eduvpn/eduvpn-common/internal/server"
 	"github.com/eduvpn/eduvpn-common/internal/util"
 	"github.com/eduvpn/eduvpn-common/types"
@@ -29,6 +32,18 @@ func (client *Client) handleError(message string, err error) error {
 	return nil
 }
 
+// handleFailure wraps the error and logs it like handleError.
+// As this is a failure in the flow, it also moves the FSM to the error state such that the UI can show it.
+// Afterwards the FSM goes back to the main screen.
+func (client *Client) handleFailure(message string, err error) error {
+	wrappedErr := client.handleError(message, err)
+	if wrappedErr != nil {
+		client.FSM.GoError(wrappedErr)
+		client.goBackInternal()
+	}
+	return wrappedErr
+}
+
 func (client Client) isLetsConnect() bool {
 	// see https://git.sr.ht/~fkooman/vpn-user-portal/tree/v3/item/src/OAuth/ClientDb.php
 	return strings.HasPrefix(client.Name, "org.letsconnect-vpn.app")
@@ -48,6 +63,12 @@ type Client struct {
 	// The list of servers and organizations from disco
 	Discovery discovery.Discovery `json:"discovery"`
 
+	// The session of the last obtained configuration, used for recovering after a restart
+	Session Session `json:"session"`
+
+	// The config transforms for each server and profile
+	Customizations Customizations `json:"customizations,omitempty"`
+
 	// The fsm
 	FSM fsm.FSM `json:"-"`
 
@@ -62,6 +83,60 @@ type Client struct {
 
 	// Whether to enable debugging
 	Debug bool `json:"-"`
+
+	// SecretStore stores secrets such as WireGuard keys separately from the state file
+	// If this is nil when registering, the secrets are saved in secrets.json in the config directory
+	SecretStore secret.Store `json:"-"`
+
+	// HTTPOptions are the options of the HTTP client that is used for all requests, e.g. the root CAs and the minimum TLS version
+	// Changing them after registering is done with SetHTTPOptions
+	HTTPOptions HTTPOptions `json:"-"`
+
+	// ProxyConfig is the proxy for all HTTP requests, by default the environment variables are used like any Go program
+	// Changing it after registering is done with SetProxy
+	ProxyConfig ProxyConfig `json:"-"`
+
+	// PinPolicy defines whether the TLS public keys of servers without pins are learned on first use
+	PinPolicy PinPolicy `json:"-"`
+
+	// locationErr is the error of setting the location that was chosen in the ASK_LOCATION state, nil if none
+	locationErr error
+
+	// migration is the server migration that is asked in the ASK_MIGRATION state, nil if none
+	migration *pendingMigration
+
+	// managedPins are the pins that were set with SetServerPins for each server URL
+	managedPins map[string][]string
+
+	// pins are the pins for each host that the HTTP client enforces
+	pins *httpw.Pins
+
+	// httpClient is the HTTP client that is shared by discovery and all servers such that connections are reused
+	httpClient *httpw.Client
+
+	// WireGuardKeyPolicy defines whether WireGuard keys are reused and when they are rotated
+	// By default a new key is generated for every configuration
+	WireGuardKeyPolicy WireGuardKeyPolicy `json:"-"`
+
+	// ConfigCachePolicy defines whether configurations are reused instead of doing a new /connect on every reconnect
+	// By default configurations are not cached
+	ConfigCachePolicy ConfigCachePolicy `json:"-"`
+
+	// OpenVPNPolicy defines the OpenVPN directives that are rejected and the rewrites that are applied to OpenVPN configs
+	// The directives that could run code are always rejected and the management interface of the server is always removed
+	OpenVPNPolicy OpenVPNPolicy `json:"-"`
+
+	// ConfigHooks customize the obtained configurations before they are returned by the GetConfig functions
+	// They are called in order after the config transforms of the server and profile
+	ConfigHooks []ConfigHook `json:"-"`
+
+	// ProgressCallback is called with the progress of long-running operations such as adding a server and getting a config
+	// This can be used by the UI to show what is happening within the LOADING_SERVER and REQUEST_CONFIG states
+	ProgressCallback func(ProgressEvent) `json:"-"`
+
+	// Whether the FSM panics on an invalid transition that is not checked, meant for tests
+	// This should be set before registering
+	StrictFSM bool `json:"-"`
 }
 
 // Register initializes the clientwith the following parameters:
@@ -103,6 +178,10 @@ func (client *Client) Register(
 
 	// Initialize the FSM
 	client.FSM = newFSM(stateCallback, directory, debug)
+	client.FSM.Strict = client.StrictFSM
+	client.FSM.HistoryCallback = func(entry FSMHistoryEntry) {
+		client.Logger.Debugf("FSM transition: %s", entry.String(GetStateName))
+	}
 
 	// By default we support wireguard
 	client.SupportsWireguard = true
@@ -113,12 +192,38 @@ func (client *Client) Register(
 	// Initialize the Config
 	client.Config.Init(directory, "state")
 
+	// Initialize the secret store if the client did not give one
+	if client.SecretStore == nil {
+		client.SecretStore = secret.NewFileStore(directory, "secrets")
+	}
+
 	// Try to load the previous configuration
 	if client.Config.Load(&client) != nil {
 		// This error can be safely ignored, as when the config does not load, the struct will not be filled
 		client.Logger.Infof("Previous configuration not found")
 	}
 
+	// Cache the discovery files and the well-known endpoints in the config directory if the client did not give a cache
+	if client.HTTPOptions.Cache == nil {
+		client.HTTPOptions.Cache = httpw.NewCache(filepath.Join(directory, "http-cache"))
+	}
+
+	// Create the HTTP client for discovery and the servers with the proxy
+	proxyErr := client.SetProxy(client.ProxyConfig)
+	if proxyErr != nil {
+		return client.handleError(errorMessage, proxyErr)
+	}
+
+	// Enforce the pins of the saved servers
+	client.updatePins()
+
+	// Check if there is a session that should be recovered
+	// The client can resume it with ResumeSession or discard it with DiscardSession
+	client.loadSession()
+
+	// Report the progress of fetching discovery
+	client.Discovery.Progress = client.progress
+
 	// Go to the No Server state with the saved servers after we're done
 	defer client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
 
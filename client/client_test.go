@@ -11,8 +11,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/eduvpn/eduvpn-common/internal/fsm"
 	httpw "github.com/eduvpn/eduvpn-common/internal/http"
 	"github.com/eduvpn/eduvpn-common/internal/oauth"
+	"github.com/eduvpn/eduvpn-common/internal/server"
 	"github.com/eduvpn/eduvpn-common/internal/util"
 	"github.com/eduvpn/eduvpn-common/types"
 )
@@ -95,7 +97,7 @@ func TestServer(t *testing.T) {
 	if addErr != nil {
 		t.Fatalf("Add error: %v", addErr)
 	}
-	_, _, configErr := state.GetConfigCustomServer(serverURI, false)
+	_, configErr := state.GetConfigCustomServer(serverURI, false)
 	if configErr != nil {
 		t.Fatalf("Connect error: %v", configErr)
 	}
@@ -241,7 +243,7 @@ func TestTokenExpired(t *testing.T) {
 		t.Fatalf("Add error: %v", addErr)
 	}
 
-	_, _, configErr := state.GetConfigCustomServer(serverURI, false)
+	_, configErr := state.GetConfigCustomServer(serverURI, false)
 
 	if configErr != nil {
 		t.Fatalf("Connect error before expired: %v", configErr)
@@ -262,7 +264,7 @@ func TestTokenExpired(t *testing.T) {
 	// Wait for TTL so that the tokens expire
 	time.Sleep(time.Duration(expiredInt) * time.Second)
 
-	_, _, configErr = state.GetConfigCustomServer(serverURI, false)
+	_, configErr = state.GetConfigCustomServer(serverURI, false)
 
 	if configErr != nil {
 		t.Fatalf("Connect error after expiry: %v", configErr)
@@ -303,7 +305,7 @@ func TestInvalidProfileCorrected(t *testing.T) {
 		t.Fatalf("Add error: %v", addErr)
 	}
 
-	_, _, configErr := state.GetConfigCustomServer(serverURI, false)
+	_, configErr := state.GetConfigCustomServer(serverURI, false)
 
 	if configErr != nil {
 		t.Fatalf("First connect error: %v", configErr)
@@ -322,7 +324,7 @@ func TestInvalidProfileCorrected(t *testing.T) {
 	previousProfile := base.Profiles.Current
 	base.Profiles.Current = "IDONOTEXIST"
 
-	_, _, configErr = state.GetConfigCustomServer(serverURI, false)
+	_, configErr = state.GetConfigCustomServer(serverURI, false)
 
 	if configErr != nil {
 		t.Fatalf("Second connect error: %v", configErr)
@@ -362,10 +364,10 @@ func TestPreferTCP(t *testing.T) {
 	}
 
 	// get a config with preferTCP set to true
-	config, configType, configErr := state.GetConfigCustomServer(serverURI, true)
+	result, configErr := state.GetConfigCustomServer(serverURI, true)
 
 	// Test server should accept prefer TCP!
-	if configType != "openvpn" {
+	if result != nil && result.Protocol != "openvpn" {
 		t.Fatalf("Invalid protocol for prefer TCP, got: WireGuard, want: OpenVPN")
 	}
 
@@ -373,18 +375,132 @@ func TestPreferTCP(t *testing.T) {
 		t.Fatalf("Config error: %v", configErr)
 	}
 
+	// The config is serialized again by the OpenVPN policy, so it ends with a newline
+	config := strings.TrimSpace(result.Config)
 	if !strings.HasSuffix(config, "remote eduvpnserver 1194 tcp\nremote eduvpnserver 1194 udp") {
 		t.Fatalf("Suffix for prefer TCP is not in the right order for config: %s", config)
 	}
 
 	// get a config with preferTCP set to false
-	config, configType, configErr = state.GetConfigCustomServer(serverURI, false)
+	result, configErr = state.GetConfigCustomServer(serverURI, false)
 	if configErr != nil {
 		t.Fatalf("Config error: %v", configErr)
 	}
 
-	if configType == "openvpn" &&
+	config = strings.TrimSpace(result.Config)
+	if result.Protocol == "openvpn" &&
 		!strings.HasSuffix(config, "remote eduvpnserver 1194 udp\nremote eduvpnserver 1194 tcp") {
 		t.Fatalf("Suffix for disable prefer TCP is not in the right order for config: %s", config)
 	}
 }
+
+// Test if a saved session is restored after the client is registered again.
+func TestResumeSession(t *testing.T) {
+	directory := t.TempDir()
+	state := &Client{}
+	register := func() {
+		state.StrictFSM = true
+		registerErr := state.Register(
+			"org.letsconnect-vpn.app.linux",
+			directory,
+			"en",
+			func(old FSMStateID, new FSMStateID, data interface{}) bool {
+				return true
+			},
+			false,
+		)
+		if registerErr != nil {
+			t.Fatalf("Register error: %v", registerErr)
+		}
+	}
+	register()
+
+	// Add a server without contacting it
+	serverURL := "https://example.com/"
+	customServer := &server.InstituteAccessServer{}
+	customServer.Basic.URL = serverURL
+	customServer.Basic.Type = "custom_server"
+	customServer.Basic.Profiles.Current = "employees"
+	customServer.Basic.EndTime = time.Now().Add(time.Hour)
+	state.Servers.CustomServers.Map = map[string]*server.InstituteAccessServer{
+		serverURL: customServer,
+	}
+	setErr := state.Servers.SetCustomServer(customServer)
+	if setErr != nil {
+		t.Fatalf("Set custom server error: %v", setErr)
+	}
+	state.updateSession(StateConnected)
+
+	// Simulate a restart
+	state.Deregister()
+	register()
+
+	session := state.SavedSession()
+	if session == nil {
+		t.Fatalf("No saved session found after registering again")
+	}
+	if session.Identifier != serverURL || session.ProfileID != "employees" {
+		t.Fatalf("Saved session does not match, got: %v", session)
+	}
+
+	resumeErr := state.ResumeSession(true)
+	if resumeErr != nil {
+		t.Fatalf("Resume session error: %v", resumeErr)
+	}
+	if !state.InFSMState(StateConnected) {
+		t.Fatalf("Got state: %s, want: %s", GetStateName(state.FSM.Current), GetStateName(StateConnected))
+	}
+	if state.SavedSession() != nil {
+		t.Fatalf("Saved session is still available after resuming")
+	}
+	state.Deregister()
+}
+
+// Test if a failure moves the FSM to the error state with the error as data.
+func TestErrorState(t *testing.T) {
+	state := &Client{StrictFSM: true}
+	var gotStates []FSMStateID
+	var gotErr error
+	registerErr := state.Register(
+		"org.letsconnect-vpn.app.linux",
+		t.TempDir(),
+		"en",
+		func(old FSMStateID, new FSMStateID, data interface{}) bool {
+			gotStates = append(gotStates, new)
+			if new == StateError {
+				gotErr, _ = data.(error)
+			}
+			return true
+		},
+		false,
+	)
+	if registerErr != nil {
+		t.Fatalf("Register error: %v", registerErr)
+	}
+	defer state.Deregister()
+
+	// Nothing listens on this port so loading the server fails
+	_, addErr := state.AddCustomServer("https://127.0.0.1:1")
+	if addErr == nil {
+		t.Fatalf("Got nil error, want: non-nil")
+	}
+	if gotErr == nil || gotErr.Error() != addErr.Error() {
+		t.Fatalf("Got error state data: %v, want: %v", gotErr, addErr)
+	}
+
+	wantStates := []FSMStateID{StateNoServer, StateLoadingServer, StateError, StateNoServer}
+	if fmt.Sprint(gotStates) != fmt.Sprint(wantStates) {
+		t.Fatalf("Got states: %v, want: %v", gotStates, wantStates)
+	}
+
+	// An invalid transition is reported as an error
+	var invalidErr *fsm.InvalidTransitionError
+	connectingErr := state.SetConnecting()
+	if connectingErr == nil {
+		t.Fatalf("Got nil error for an invalid transition")
+	}
+	_, transitionErr := state.FSM.GoTransitionChecked(StateDisconnecting, "")
+	if !errors.As(transitionErr, &invalidErr) {
+		t.Fatalf("Got error: %v, want: %T", transitionErr, invalidErr)
+	}
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"github.com/eduvpn/eduvpn-common/internal/customize"
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// ConfigHook customizes the obtained configurations before they are returned by the GetConfig functions.
+type ConfigHook = customize.Hook
+
+// ConfigHookFunc is a function that is used as a config hook.
+type ConfigHookFunc = customize.HookFunc
+
+// CustomizedConfig is the parsed configuration that is given to the config hooks.
+type CustomizedConfig = customize.Config
+
+// ConfigTransforms are the declarative customizations of the configurations for a server and profile.
+type ConfigTransforms = customize.Transforms
+
+// Customizations are the config transforms by server URL and profile ID.
+// The transforms for the empty profile ID are used for the profiles of the server without their own transforms.
+type Customizations map[string]map[string]ConfigTransforms
+
+// get returns the transforms for the server with `serverURL` and the profile with `profileID`.
+func (customizations Customizations) get(serverURL string, profileID string) (ConfigTransforms, bool) {
+	profiles, ok := customizations[serverURL]
+	if !ok {
+		return ConfigTransforms{}, false
+	}
+	if transforms, ok := profiles[profileID]; ok {
+		return transforms, true
+	}
+	transforms, ok := profiles[""]
+	return transforms, ok
+}
+
+// ConfigTransforms returns the transforms that are applied to the configurations for the server with `serverURL` and the profile with `profileID`.
+func (client *Client) ConfigTransforms(serverURL string, profileID string) (ConfigTransforms, bool) {
+	return client.Customizations.get(serverURL, profileID)
+}
+
+// SetConfigTransforms sets the transforms for the server with `serverURL` and the profile with `profileID` and saves them in the state file.
+// An empty profile ID sets the transforms for all profiles of the server without their own transforms.
+// It returns an error if the transforms are invalid.
+func (client *Client) SetConfigTransforms(serverURL string, profileID string, transforms ConfigTransforms) error {
+	errorMessage := "failed setting the config transforms"
+	if validateErr := transforms.Validate(); validateErr != nil {
+		return client.handleError(errorMessage, validateErr)
+	}
+	if client.Customizations == nil {
+		client.Customizations = make(Customizations)
+	}
+	if client.Customizations[serverURL] == nil {
+		client.Customizations[serverURL] = make(map[string]ConfigTransforms)
+	}
+	client.Customizations[serverURL][profileID] = transforms
+	client.saveConfig("after setting the config transforms")
+	return nil
+}
+
+// RemoveConfigTransforms removes the transforms for the server with `serverURL` and the profile with `profileID` and saves the state file.
+func (client *Client) RemoveConfigTransforms(serverURL string, profileID string) {
+	profiles, ok := client.Customizations[serverURL]
+	if !ok {
+		return
+	}
+	delete(profiles, profileID)
+	if len(profiles) == 0 {
+		delete(client.Customizations, serverURL)
+	}
+	client.saveConfig("after removing the config transforms")
+}
+
+// customizeConfig applies the OpenVPN policy, the config transforms and the config hooks to the obtained configuration.
+// It returns the customized and parsed configuration.
+func (client *Client) customizeConfig(
+	base *server.Base,
+	config string,
+	configType string,
+	parsed *WireGuardConfig,
+) (*CustomizedConfig, error) {
+	errorMessage := "failed customizing the configuration"
+	customized := &CustomizedConfig{
+		ServerURL: base.URL,
+		ProfileID: base.Profiles.Current,
+		WireGuard: parsed,
+	}
+
+	// Reject OpenVPN configs that could run code and apply the rewrites
+	if configType == "openvpn" {
+		var policyErr error
+		_, customized.OpenVPN, policyErr = openvpn.ApplyPolicy(config, client.OpenVPNPolicy)
+		if policyErr != nil {
+			return nil, types.NewWrappedError(errorMessage, policyErr)
+		}
+	}
+
+	hooks := client.ConfigHooks
+	if transforms, ok := client.ConfigTransforms(customized.ServerURL, customized.ProfileID); ok {
+		hooks = append([]ConfigHook{transforms}, hooks...)
+	}
+	if hookErr := customize.Apply(customized, hooks...); hookErr != nil {
+		return nil, types.NewWrappedError(errorMessage, hookErr)
+	}
+	return customized, nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"github.com/eduvpn/eduvpn-common/internal/export"
+)
+
+type (
+	// NetworkManagerOptions are the options for exporting a configuration as a NetworkManager keyfile
+	NetworkManagerOptions = export.NetworkManagerOptions
+
+	// NetworkManagerConnection is a configuration that is exported as a NetworkManager connection
+	NetworkManagerConnection = export.NetworkManagerConnection
+
+	// WireGuardExportOptions are the options for exporting a WireGuard configuration for systemd-networkd or wg-quick
+	WireGuardExportOptions = export.WireGuardOptions
+
+	// WireGuardExport is a WireGuard configuration that is exported as files
+	WireGuardExport = export.WireGuardExport
+
+	// DNSMode defines how the DNS servers of an exported WireGuard configuration are used
+	DNSMode = export.DNSMode
+)
+
+const (
+	// DNSDefault uses the DNS servers for all DNS queries
+	DNSDefault = export.DNSDefault
+
+	// DNSSearchDomains only uses the DNS servers for the search domains of the configuration
+	DNSSearchDomains = export.DNSSearchDomains
+
+	// DNSIgnore does not configure DNS
+	DNSIgnore = export.DNSIgnore
+)
+
+// currentIdentity returns the identity of the current server and profile which is used to name exported connections.
+func (client *Client) currentIdentity() (export.Identity, error) {
+	currentServer, currentServerErr := client.Servers.GetCurrentServer()
+	if currentServerErr != nil {
+		return export.Identity{}, currentServerErr
+	}
+	base, baseErr := currentServer.Base()
+	if baseErr != nil {
+		return export.Identity{}, baseErr
+	}
+	return export.Identity{ServerURL: base.URL, ProfileID: base.Profiles.Current}, nil
+}
+
+// ExportNetworkManager exports the `config` with type `configType` as returned by the GetConfig functions as a NetworkManager keyfile.
+// The connection ID and UUID are stable for the current server and profile such that a new config replaces the previous connection.
+// The keyfile and the files that it references can be written with the Write method of the connection.
+func (client *Client) ExportNetworkManager(
+	config string,
+	configType string,
+	options NetworkManagerOptions,
+) (*NetworkManagerConnection, error) {
+	errorMessage := "failed exporting config for NetworkManager"
+	identity, identityErr := client.currentIdentity()
+	if identityErr != nil {
+		return nil, client.handleError(errorMessage, identityErr)
+	}
+	connection, exportErr := export.NetworkManager(config, configType, identity, options)
+	if exportErr != nil {
+		return nil, client.handleError(errorMessage, exportErr)
+	}
+	return connection, nil
+}
+
+// ExportNetworkd exports the WireGuard `config` as returned by the GetConfig functions as a systemd-networkd .netdev and .network file.
+func (client *Client) ExportNetworkd(
+	config string,
+	options WireGuardExportOptions,
+) (*WireGuardExport, error) {
+	exported, exportErr := export.Networkd(config, options)
+	if exportErr != nil {
+		return nil, client.handleError("failed exporting config for systemd-networkd", exportErr)
+	}
+	return exported, nil
+}
+
+// ExportWGQuick exports the WireGuard `config` as returned by the GetConfig functions as a normalized wg-quick configuration.
+func (client *Client) ExportWGQuick(
+	config string,
+	options WireGuardExportOptions,
+) (*WireGuardExport, error) {
+	exported, exportErr := export.WGQuick(config, options)
+	if exportErr != nil {
+		return nil, client.handleError("failed exporting config for wg-quick", exportErr)
+	}
+	return exported, nil
+}
@@ -10,10 +10,11 @@ import (
 )
 
 type (
-	FSMStateID    = fsm.StateID
-	FSMStates     = fsm.States
-	FSMState      = fsm.State
-	FSMTransition = fsm.Transition
+	FSMStateID      = fsm.StateID
+	FSMStates       = fsm.States
+	FSMState        = fsm.State
+	FSMTransition   = fsm.Transition
+	FSMHistoryEntry = fsm.HistoryEntry
 )
 
 const (
@@ -58,6 +59,14 @@ const (
 
 	// StateConnected means the user has been connected to the server.
 	StateConnected
+
+	// StateError means that a failure occurred, the data of this state is the error.
+	// This state can be entered from every state.
+	StateError
+
+	// StateAskMigration means the go code is asking the UI to confirm that a saved server moved to a new base URL.
+	// The data of this state is the ServerMigration, the UI answers with ConfirmMigration.
+	StateAskMigration
 )
 
 func GetStateName(s FSMStateID) string {
@@ -90,11 +99,63 @@ func GetStateName(s FSMStateID) string {
 		return "Connecting"
 	case StateConnected:
 		return "Connected"
+	case StateError:
+		return "Error"
+	case StateAskMigration:
+		return "Ask_Migration"
 	default:
 		panic("unknown conversion of state to string")
 	}
 }
 
+// guardServer makes sure that the transition data is a server such that the UI can show it.
+func guardServer(data interface{}) error {
+	if _, ok := data.(server.Server); !ok {
+		return &FSMDataError{Want: "server", Got: data}
+	}
+	return nil
+}
+
+// guardServers makes sure that the transition data is the list of servers such that the UI can show it.
+func guardServers(data interface{}) error {
+	if _, ok := data.(server.Servers); !ok {
+		return &FSMDataError{Want: "servers", Got: data}
+	}
+	return nil
+}
+
+// guardURL makes sure that the transition data is the OAuth URL such that the UI can open it.
+func guardURL(data interface{}) error {
+	if url, ok := data.(string); !ok || url == "" {
+		return &FSMDataError{Want: "non-empty OAuth URL", Got: data}
+	}
+	return nil
+}
+
+// guardProfiles makes sure that the transition data is the list of profiles such that the UI can choose one.
+func guardProfiles(data interface{}) error {
+	if _, ok := data.(*server.ProfileInfo); !ok {
+		return &FSMDataError{Want: "profiles", Got: data}
+	}
+	return nil
+}
+
+// guardMigration makes sure that the transition data is the migration such that the UI can show it.
+func guardMigration(data interface{}) error {
+	if _, ok := data.(ServerMigration); !ok {
+		return &FSMDataError{Want: "server migration", Got: data}
+	}
+	return nil
+}
+
+// guardLocations makes sure that the transition data is the list of locations such that the UI can choose one.
+func guardLocations(data interface{}) error {
+	if _, ok := data.([]string); !ok {
+		return &FSMDataError{Want: "locations", Got: data}
+	}
+	return nil
+}
+
 func newFSM(
 	callback func(FSMStateID, FSMStateID, interface{}) bool,
 	directory string,
@@ -102,31 +163,46 @@ func newFSM(
 ) fsm.FSM {
 	states := FSMStates{
 		StateDeregistered: FSMState{
-			Transitions: []FSMTransition{{To: StateNoServer, Description: "Client registers"}},
+			Transitions: []FSMTransition{
+				{To: StateNoServer, Description: "Client registers", Guard: guardServers},
+			},
 		},
 		StateNoServer: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateNoServer, Description: "Reload list"},
+				{To: StateNoServer, Description: "Reload list", Guard: guardServers},
 				{To: StateLoadingServer, Description: "User clicks a server in the UI"},
 				{To: StateChosenServer, Description: "The server has been chosen"},
 				{
 					To:          StateSearchServer,
 					Description: "The user is trying to choose a new server in the UI",
 				},
-				{To: StateConnected, Description: "The user is already connected"},
-				{To: StateAskLocation, Description: "Change the location in the main screen"},
+				{
+					To:          StateConnected,
+					Description: "The user is already connected",
+					Guard:       guardServer,
+				},
+				{
+					To:          StateAskLocation,
+					Description: "Change the location in the main screen",
+					Guard:       guardLocations,
+				},
+				{
+					To:          StateDisconnected,
+					Description: "Resume a saved session",
+					Guard:       guardServer,
+				},
 			},
 		},
 		StateSearchServer: FSMState{
 			Transitions: []FSMTransition{
 				{To: StateLoadingServer, Description: "User clicks a server in the UI"},
-				{To: StateNoServer, Description: "Cancel or Error"},
+				{To: StateNoServer, Description: "Cancel or Error", Guard: guardServers},
 			},
 		},
 		StateAskLocation: FSMState{
 			Transitions: []FSMTransition{
 				{To: StateChosenServer, Description: "Location chosen"},
-				{To: StateNoServer, Description: "Go back or Error"},
+				{To: StateNoServer, Description: "Go back or Error", Guard: guardServers},
 				{To: StateSearchServer, Description: "Cancel or Error"},
 			},
 		},
@@ -136,73 +212,119 @@ func newFSM(
 				{
 					To:          StateAskLocation,
 					Description: "User chooses a Secure Internet server but no location is configured",
+					Guard:       guardLocations,
 				},
-				{To: StateNoServer, Description: "Go back or Error"},
+				{To: StateNoServer, Description: "Go back or Error", Guard: guardServers},
 			},
 		},
 		StateChosenServer: FSMState{
 			Transitions: []FSMTransition{
 				{To: StateAuthorized, Description: "Found tokens in config"},
-				{To: StateOAuthStarted, Description: "No tokens found in config"},
+				{To: StateOAuthStarted, Description: "No tokens found in config", Guard: guardURL},
+				{
+					To:          StateAskMigration,
+					Description: "The server moved to a new URL",
+					Guard:       guardMigration,
+				},
+			},
+		},
+		StateAskMigration: FSMState{
+			Transitions: []FSMTransition{
+				{To: StateChosenServer, Description: "User confirms or declines the migration"},
+				{To: StateNoServer, Description: "Cancel or Error", Guard: guardServers},
 			},
 		},
 		StateOAuthStarted: FSMState{
 			Transitions: []FSMTransition{
 				{To: StateAuthorized, Description: "User authorizes with browser"},
-				{To: StateNoServer, Description: "Go back or Error"},
+				{To: StateNoServer, Description: "Go back or Error", Guard: guardServers},
 				{To: StateSearchServer, Description: "Cancel or Error"},
 			},
 		},
 		StateAuthorized: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateOAuthStarted, Description: "Re-authorize with OAuth"},
+				{To: StateOAuthStarted, Description: "Re-authorize with OAuth", Guard: guardURL},
 				{To: StateRequestConfig, Description: "Client requests a config"},
-				{To: StateNoServer, Description: "Client wants to go back to the main screen"},
+				{
+					To:          StateNoServer,
+					Description: "Client wants to go back to the main screen",
+					Guard:       guardServers,
+				},
 			},
 		},
 		StateRequestConfig: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateAskProfile, Description: "Multiple profiles found and no profile chosen"},
-				{To: StateDisconnected, Description: "Only one profile or profile already chosen"},
-				{To: StateNoServer, Description: "Cancel or Error"},
-				{To: StateOAuthStarted, Description: "Re-authorize"},
+				{
+					To:          StateAskProfile,
+					Description: "Multiple profiles found and no profile chosen",
+					Guard:       guardProfiles,
+				},
+				{
+					To:          StateDisconnected,
+					Description: "Only one profile or profile already chosen",
+					Guard:       guardServer,
+				},
+				{To: StateNoServer, Description: "Cancel or Error", Guard: guardServers},
+				{To: StateOAuthStarted, Description: "Re-authorize", Guard: guardURL},
+				{To: StateAuthorized, Description: "Tokens are valid again when retrying"},
 			},
 		},
 		StateAskProfile: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateDisconnected, Description: "User chooses profile"},
-				{To: StateNoServer, Description: "Cancel or Error"},
+				{To: StateDisconnected, Description: "User chooses profile", Guard: guardServer},
+				{To: StateNoServer, Description: "Cancel or Error", Guard: guardServers},
 				{To: StateSearchServer, Description: "Cancel or Error"},
+				{To: StateOAuthStarted, Description: "Re-authorize", Guard: guardURL},
+				{To: StateAuthorized, Description: "Tokens are valid again when retrying"},
 			},
 		},
 		StateDisconnected: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateConnecting, Description: "OS reports it is trying to connect"},
+				{
+					To:          StateConnecting,
+					Description: "OS reports it is trying to connect",
+					Guard:       guardServer,
+				},
 				{To: StateRequestConfig, Description: "User reconnects"},
-				{To: StateNoServer, Description: "User wants to choose a new server"},
-				{To: StateOAuthStarted, Description: "Re-authorize with OAuth"},
+				{To: StateLoadingServer, Description: "User gets a new config"},
+				{
+					To:          StateNoServer,
+					Description: "User wants to choose a new server",
+					Guard:       guardServers,
+				},
+				{To: StateOAuthStarted, Description: "Re-authorize with OAuth", Guard: guardURL},
 			},
 		},
 		StateDisconnecting: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateDisconnected, Description: "Cancel or Error"},
-				{To: StateDisconnected, Description: "Done disconnecting"},
+				{To: StateDisconnected, Description: "Cancel or Error", Guard: guardServer},
+				{To: StateDisconnected, Description: "Done disconnecting", Guard: guardServer},
 			},
 		},
 		StateConnecting: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateDisconnected, Description: "Cancel or Error"},
-				{To: StateConnected, Description: "Done connecting"},
+				{To: StateDisconnected, Description: "Cancel or Error", Guard: guardServer},
+				{To: StateConnected, Description: "Done connecting", Guard: guardServer},
 			},
 		},
 		StateConnected: FSMState{
 			Transitions: []FSMTransition{
-				{To: StateDisconnecting, Description: "App wants to disconnect"},
+				{
+					To:          StateDisconnecting,
+					Description: "App wants to disconnect",
+					Guard:       guardServer,
+				},
+			},
+		},
+		StateError: FSMState{
+			Transitions: []FSMTransition{
+				{To: StateNoServer, Description: "Go back to the main screen", Guard: guardServers},
 			},
 		},
 	}
 	returnedFSM := fsm.FSM{}
 	returnedFSM.Init(StateDeregistered, states, callback, directory, GetStateName, debug)
+	returnedFSM.SetErrorState(StateError)
 	return returnedFSM
 }
 
@@ -233,6 +355,16 @@ func (e FSMWrongStateTransitionError) CustomError() *types.WrappedErrorMessage {
 	)
 }
 
+// FSMDataError indicates that the data of a transition is not of the type that the state needs.
+type FSMDataError struct {
+	Want string
+	Got  interface{}
+}
+
+func (e *FSMDataError) Error() string {
+	return fmt.Sprintf("wrong FSM transition data, got: %T, want: %s", e.Got, e.Want)
+}
+
 type FSMWrongStateError struct {
 	Got  FSMStateID
 	Want FSMStateID
@@ -263,7 +395,10 @@ func (client *Client) SetSearchServer() error {
 		)
 	}
 
-	client.FSM.GoTransition(StateSearchServer)
+	transitionErr := client.goTransition(StateSearchServer, "")
+	if transitionErr != nil {
+		return client.handleError("failed to set search server", transitionErr)
+	}
 	return nil
 }
 
@@ -292,7 +427,11 @@ func (client *Client) SetConnected() error {
 		return client.handleError(errorMessage, currentServerErr)
 	}
 
-	client.FSM.GoTransitionWithData(StateConnected, currentServer)
+	transitionErr := client.goTransition(StateConnected, currentServer)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
+	}
+	client.updateSession(StateConnected)
 	return nil
 }
 
@@ -321,7 +460,11 @@ func (client *Client) SetConnecting() error {
 		return client.handleError(errorMessage, currentServerErr)
 	}
 
-	client.FSM.GoTransitionWithData(StateConnecting, currentServer)
+	transitionErr := client.goTransition(StateConnecting, currentServer)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
+	}
+	client.updateSession(StateConnecting)
 	return nil
 }
 
@@ -350,7 +493,11 @@ func (client *Client) SetDisconnecting() error {
 		return client.handleError(errorMessage, currentServerErr)
 	}
 
-	client.FSM.GoTransitionWithData(StateDisconnecting, currentServer)
+	transitionErr := client.goTransition(StateDisconnecting, currentServer)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
+	}
+	client.updateSession(StateDisconnecting)
 	return nil
 }
 
@@ -382,10 +529,20 @@ func (client *Client) SetDisconnected(cleanup bool) error {
 
 	if cleanup {
 		// Do the /disconnect API call and go to disconnected after...
-		server.Disconnect(currentServer)
+		client.disconnect(currentServer)
+	}
+
+	transitionErr := client.goTransition(StateDisconnected, currentServer)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
 	}
 
-	client.FSM.GoTransitionWithData(StateDisconnected, currentServer)
+	// After a /disconnect the server has no session anymore
+	if cleanup {
+		client.clearSession()
+	} else {
+		client.updateSession(StateDisconnected)
+	}
 
 	return nil
 }
@@ -414,7 +571,20 @@ func (client *Client) GoBack() error {
 	}
 
 	// FIXME: Abitrary back transitions don't work because we need the approriate data
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	transitionErr := client.goTransition(StateNoServer, client.Servers)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
+	}
+	return nil
+}
+
+// goTransition transitions the FSM to `state` with `data`.
+// It returns an error if the transition is not possible, e.g. because the FSM is in the wrong state.
+func (client *Client) goTransition(state FSMStateID, data interface{}) error {
+	_, transitionErr := client.FSM.GoTransitionChecked(state, data)
+	if transitionErr != nil {
+		return types.NewWrappedError("failed FSM transition", transitionErr)
+	}
 	return nil
 }
 
@@ -445,3 +615,9 @@ func (client *Client) CancelOAuth() error {
 func (client *Client) InFSMState(checkState FSMStateID) bool {
 	return client.FSM.InState(checkState)
 }
+
+// FSMHistory returns the last transitions of the FSM, the oldest first.
+// This can be used to debug flows that got stuck.
+func (client *Client) FSMHistory() []FSMHistoryEntry {
+	return client.FSM.History()
+}
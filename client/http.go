@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+)
+
+// HTTPOptions are the options of the HTTP client, e.g. the root CAs, the minimum TLS version and the transport for tests.
+type HTTPOptions = httpw.Options
+
+// HTTPRetryPolicy defines how often and how long after a failed request it is retried.
+type HTTPRetryPolicy = httpw.RetryPolicy
+
+// SetHTTPOptions creates the HTTP client with `options` and uses it for discovery and all servers.
+// If the options have no User-Agent, the User-Agent is the client ID with the version of the library.
+// If the options do not log the attempts of the requests, they are logged with the debug level of the client.
+// The options that have their own setter are kept if `options` does not set them:
+// the proxy of SetProxy, the observer of SetTracing, the allowed origins of SetAllowedOrigins and the cache in the config directory.
+func (client *Client) SetHTTPOptions(options HTTPOptions) {
+	if options.Proxy == nil {
+		options.Proxy = client.HTTPOptions.Proxy
+	}
+	if options.Observer == nil {
+		options.Observer = client.HTTPOptions.Observer
+	}
+	if options.AllowedOrigins == nil {
+		options.AllowedOrigins = client.HTTPOptions.AllowedOrigins
+	}
+	if options.Cache == nil {
+		options.Cache = client.HTTPOptions.Cache
+	}
+	client.applyHTTPOptions(options)
+}
+
+// applyHTTPOptions creates the HTTP client with exactly `options` and uses it for discovery and all servers.
+// The setters of single options use it such that they can also unset their option.
+func (client *Client) applyHTTPOptions(options HTTPOptions) {
+	client.HTTPOptions = options
+	if options.UserAgent == "" {
+		options.UserAgent = httpw.UserAgent(client.Name)
+	}
+	if options.Debugf == nil {
+		options.Debugf = client.Logger.Debugf
+	}
+	if options.Pins == nil {
+		options.Pins = client.pinStore()
+	}
+	client.httpClient = httpw.NewClient(options)
+	client.Discovery.HTTPClient = client.httpClient
+	client.Servers.SetHTTPClient(client.httpClient)
+}
+
+// SetAllowedOrigins sets the origins in the form scheme://host:port, besides the origin of a server, that its API and token endpoints and redirects can be on.
+// By default these must be on the origin of the server and use HTTPS, except for a server on the loopback address.
+// An allowed origin can also use plain HTTP. It returns an error if an origin is invalid, the previous origins are then kept.
+func (client *Client) SetAllowedOrigins(origins []string) error {
+	var allowed []string
+	for _, origin := range origins {
+		parsed, parseErr := url.Parse(origin)
+		if parseErr != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Hostname() == "" {
+			return client.handleError("failed setting the allowed origins", fmt.Errorf("invalid origin: %s, want scheme://host[:port]", origin))
+		}
+		allowed = append(allowed, origin)
+	}
+	options := client.HTTPOptions
+	options.AllowedOrigins = allowed
+	client.applyHTTPOptions(options)
+	return nil
+}
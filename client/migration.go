@@ -0,0 +1,111 @@
+package client
+
+import (
+	"github.com/eduvpn/eduvpn-common/internal/progress"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// ServerMigration is the data of the ASK_MIGRATION state.
+// The well-known document of a saved server permanently redirects to a new base URL, e.g. because the institute moved its portal to a new host name.
+type ServerMigration struct {
+	// From is the saved base URL of the server
+	From string `json:"from"`
+
+	// To is the new base URL of the server
+	To string `json:"to"`
+}
+
+// pendingMigration is the migration that is asked in the ASK_MIGRATION state together with the answer of the UI.
+type pendingMigration struct {
+	ServerMigration
+
+	// accepted indicates that the UI confirmed the migration
+	accepted bool
+}
+
+// ConfirmMigration answers the ASK_MIGRATION state, `accept` indicates whether or not the server is migrated to its new URL.
+// If it is declined, the saved URL is kept and the server is asked again the next time.
+// An error is returned if the client is not asking for a migration.
+func (client *Client) ConfirmMigration(accept bool) error {
+	if !client.InFSMState(StateAskMigration) || client.migration == nil {
+		return client.handleError(
+			"failed confirming the server migration",
+			FSMWrongStateError{
+				Got:  client.FSM.Current,
+				Want: StateAskMigration,
+			}.CustomError(),
+		)
+	}
+	client.migration.accepted = accept
+	return nil
+}
+
+// askMigration asks the UI to confirm that the server with `from` moved to `movedURL` by moving the FSM to the ASK_MIGRATION state.
+// It returns whether or not the migration was accepted, afterwards the FSM is back in the CHOSEN_SERVER state.
+func (client *Client) askMigration(from string, movedURL string) (bool, error) {
+	errorMessage := "failed asking for the server migration"
+	migration := ServerMigration{From: from, To: movedURL}
+	client.migration = &pendingMigration{ServerMigration: migration}
+	defer func() {
+		client.migration = nil
+	}()
+
+	goTransitionErr := client.FSM.GoTransitionRequired(StateAskMigration, migration)
+	if goTransitionErr != nil {
+		return false, types.NewWrappedError(errorMessage, goTransitionErr)
+	}
+	accepted := client.migration.accepted
+	transitionErr := client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return false, types.NewWrappedError(errorMessage, transitionErr)
+	}
+	return accepted, nil
+}
+
+// migrateServer migrates `chosenServer` to `movedURL` after the UI confirmed it.
+// The tokens, the profile choice, the WireGuard keys and the config transforms are kept.
+// The learned pins are not, as the new host has another certificate, they are learned again.
+func (client *Client) migrateServer(chosenServer server.Server, movedURL string, tracker *progress.Tracker) error {
+	errorMessage := "failed migrating the server"
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		return types.NewWrappedError(errorMessage, baseErr)
+	}
+	from := base.URL
+	accepted, askErr := client.askMigration(from, movedURL)
+	if askErr != nil {
+		return types.NewWrappedError(errorMessage, askErr)
+	}
+	if !accepted {
+		client.Logger.Infof("The migration of server: %s to: %s was declined", from, movedURL)
+		return nil
+	}
+
+	migrateErr := client.Servers.Migrate(chosenServer, movedURL, tracker)
+	if migrateErr != nil {
+		return types.NewWrappedError(errorMessage, migrateErr)
+	}
+
+	if customizations, ok := client.Customizations[from]; ok {
+		client.Customizations[movedURL] = customizations
+		delete(client.Customizations, from)
+	}
+	moveErr := client.wireguardKeys().Move(from, movedURL)
+	if moveErr != nil {
+		client.Logger.Infof(
+			"Failed moving the WireGuard keys for server %s: %s",
+			from,
+			types.ErrorTraceback(moveErr),
+		)
+	}
+	// The cached configurations are obtained from the old URL, the session on the server is the same so they are not disconnected
+	client.removeCachedConfigs(from)
+	if client.Session.Identifier == from {
+		client.Session.Identifier = movedURL
+	}
+	base.Pins = client.managedPins[movedURL]
+	client.updatePins()
+	client.Logger.Infof("Migrated server: %s to: %s", from, movedURL)
+	return nil
+}
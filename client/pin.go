@@ -0,0 +1,147 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/util"
+)
+
+// PinPolicy defines whether the TLS public keys of servers without pins are learned.
+type PinPolicy struct {
+	// LearnOnFirstUse indicates that a server without pins is pinned to the keys of its certificate and issuer when it is first authorized
+	LearnOnFirstUse bool
+}
+
+// PinError is returned when the TLS certificate of a server does not match its pins.
+type PinError = httpw.PinError
+
+// pinStore returns the pins that the HTTP client enforces, they are created when needed.
+func (client *Client) pinStore() *httpw.Pins {
+	if client.pins == nil {
+		client.pins = httpw.NewPins()
+	}
+	return client.pins
+}
+
+// bases returns the bases of all servers, including all secure internet locations.
+func (client *Client) bases() []*server.Base {
+	var bases []*server.Base
+	for _, instituteServer := range client.Servers.CustomServers.Map {
+		bases = append(bases, &instituteServer.Basic)
+	}
+	for _, instituteServer := range client.Servers.InstituteServers.Map {
+		bases = append(bases, &instituteServer.Basic)
+	}
+	for _, base := range client.Servers.SecureInternetHomeServer.BaseMap {
+		bases = append(bases, base)
+	}
+	return bases
+}
+
+// hostname returns the host name of `rawURL`, empty if it cannot be parsed.
+func hostname(rawURL string) string {
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+// updatePins gives the HTTP client the pins of all servers and the managed pins.
+// The pins of a server apply to its host and the hosts of its API, authorization and token endpoints.
+func (client *Client) updatePins() {
+	pins := make(map[string][]string)
+	add := func(rawURL string, serverPins []string) {
+		host := hostname(rawURL)
+		if host == "" {
+			return
+		}
+		for _, pin := range serverPins {
+			exists := false
+			for _, existing := range pins[host] {
+				if existing == pin {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				pins[host] = append(pins[host], pin)
+			}
+		}
+	}
+	for serverURL, serverPins := range client.managedPins {
+		add(serverURL, serverPins)
+	}
+	for _, base := range client.bases() {
+		if len(base.Pins) == 0 {
+			continue
+		}
+		endpoints := base.Endpoints.API.V3
+		for _, rawURL := range []string{base.URL, endpoints.API, endpoints.Authorization, endpoints.Token} {
+			add(rawURL, base.Pins)
+		}
+	}
+	client.pinStore().Replace(pins)
+}
+
+// SetServerPins sets the pinned public keys of the TLS certificate of the server with `serverURL`.
+// The pins are base64 encoded SHA-256 hashes of the SubjectPublicKeyInfo, optionally prefixed with "sha256/".
+// A certificate chain must contain at least one of them, so a backup pin allows rotating the keys.
+// The pins are enforced right away, also for a server that is added later. Empty pins remove them.
+func (client *Client) SetServerPins(serverURL string, pins []string) error {
+	errorMessage := fmt.Sprintf("failed setting the pins for server %s", serverURL)
+	serverURL, urlErr := util.EnsureValidURL(serverURL)
+	if urlErr != nil {
+		return client.handleError(errorMessage, urlErr)
+	}
+	var parsed []string
+	for _, pin := range pins {
+		parsedPin, pinErr := httpw.ParsePin(pin)
+		if pinErr != nil {
+			return client.handleError(errorMessage, pinErr)
+		}
+		parsed = append(parsed, parsedPin)
+	}
+
+	if client.managedPins == nil {
+		client.managedPins = make(map[string][]string)
+	}
+	if len(parsed) == 0 {
+		delete(client.managedPins, serverURL)
+	} else {
+		client.managedPins[serverURL] = parsed
+	}
+	for _, base := range client.bases() {
+		if base.URL == serverURL {
+			base.Pins = parsed
+		}
+	}
+	client.updatePins()
+	return nil
+}
+
+// pinServer sets the managed pins on `chosenServer` or learns its pins on first use if the policy allows it.
+// It is done after the well-known endpoints of the server are fetched such that the keys of its certificate are known.
+func (client *Client) pinServer(chosenServer server.Server) {
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		return
+	}
+	if managed, ok := client.managedPins[base.URL]; ok {
+		base.Pins = managed
+	} else if len(base.Pins) == 0 && client.PinPolicy.LearnOnFirstUse {
+		// The leaf and its issuer, such that the server can renew its certificate with the same CA
+		observed := client.pinStore().Observed(hostname(base.URL))
+		if len(observed) > 2 {
+			observed = observed[:2]
+		}
+		if len(observed) > 0 {
+			client.Logger.Infof("Learned the pins: %v for server: %s", observed, base.URL)
+			base.Pins = observed
+		}
+	}
+	client.updatePins()
+}
@@ -0,0 +1,27 @@
+package client
+
+import "github.com/eduvpn/eduvpn-common/internal/progress"
+
+type (
+	ProgressEvent = progress.Event
+	ProgressStep  = progress.Step
+)
+
+// progress logs the progress event at debug level and forwards it to the progress callback if there is one.
+// The log makes it possible to spot slow steps afterwards.
+func (client *Client) progress(event ProgressEvent) {
+	client.Logger.Debugf(
+		"Progress for server %s: step %s after %s",
+		event.URL,
+		event.Step,
+		event.Elapsed,
+	)
+	if client.ProgressCallback != nil {
+		client.ProgressCallback(event)
+	}
+}
+
+// newTracker creates a tracker for an operation for the server with `url` that reports the progress to the client.
+func (client *Client) newTracker(url string) *progress.Tracker {
+	return progress.New(client.progress, url)
+}
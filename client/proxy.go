@@ -0,0 +1,23 @@
+package client
+
+import (
+	"github.com/eduvpn/eduvpn-common/internal/proxy"
+)
+
+// ProxyConfig is the proxy configuration, an explicit proxy URL, the environment variables or a PAC script.
+type ProxyConfig = proxy.Config
+
+// SetProxy sets the proxy that is used for discovery, well-known, token and API requests.
+// The OAuth loopback listener is never proxied and the VPN connections themselves are not affected.
+// It returns an error if the configuration is invalid, the previous proxy is then kept.
+func (client *Client) SetProxy(config ProxyConfig) error {
+	proxyFunc, proxyErr := proxy.New(config)
+	if proxyErr != nil {
+		return client.handleError("failed setting the proxy", proxyErr)
+	}
+	client.ProxyConfig = config
+	options := client.HTTPOptions
+	options.Proxy = proxyFunc
+	client.applyHTTPOptions(options)
+	return nil
+}
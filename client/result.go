@@ -0,0 +1,99 @@
+package client
+
+import (
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+)
+
+// OpenVPNConfig is a parsed OpenVPN configuration.
+type OpenVPNConfig = openvpn.Config
+
+// Profile is a profile of a server.
+type Profile = server.Profile
+
+// ConfigResult is a configuration that was obtained by the GetConfig functions together with the details of its session.
+type ConfigResult struct {
+	// Protocol is the VPN protocol of the configuration, "openvpn" or "wireguard"
+	Protocol string
+
+	// Config is the configuration as it is given to OpenVPN or WireGuard
+	// A WireGuard configuration is normalized, e.g. without the comments of the server, see wireguard.Config.String
+	Config string
+
+	// WireGuard is the parsed WireGuard configuration, nil for OpenVPN
+	WireGuard *WireGuardConfig
+
+	// OpenVPN is the parsed OpenVPN configuration, nil for WireGuard
+	OpenVPN *OpenVPNConfig
+
+	// ServerType is the type of the server, "institute_access", "secure_internet" or "custom_server"
+	ServerType string
+
+	// Identifier identifies the server, the base URL for Institute Access and Custom servers and the organization ID for Secure Internet
+	Identifier string
+
+	// ServerURL is the base URL of the server the configuration was obtained from
+	// For Secure Internet this is the URL of the server for the current location
+	ServerURL string
+
+	// Location is the Secure Internet location (country code), empty for other server types
+	Location string
+
+	// Profile is the profile the configuration was obtained for
+	Profile Profile
+
+	// DefaultGateway is whether or not the configuration routes all traffic through the tunnel
+	DefaultGateway bool
+
+	// StartTime is the time the configuration was obtained
+	StartTime time.Time
+
+	// EndTime is the time the session expires, for OpenVPN this is the expiry time of the client certificate if that is earlier
+	EndTime time.Time
+
+	// Certificate is the client certificate of an OpenVPN configuration, nil for WireGuard
+	Certificate *CertificateInfo
+
+	// Warnings are the mismatches between the configuration and the profile that do not stop the configuration from being used
+	Warnings []ConfigWarning
+}
+
+// newConfigResult returns the result for the customized configuration `customized` that was obtained for `chosenServer` with protocol `protocol`.
+// The session must already be updated for the configuration.
+func (client *Client) newConfigResult(
+	chosenServer server.Server,
+	protocol string,
+	customized *CustomizedConfig,
+) (*ConfigResult, error) {
+	profile, profileErr := server.CurrentProfile(chosenServer)
+	if profileErr != nil {
+		return nil, profileErr
+	}
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		return nil, baseErr
+	}
+
+	result := &ConfigResult{
+		Protocol:       protocol,
+		WireGuard:      customized.WireGuard,
+		OpenVPN:        customized.OpenVPN,
+		ServerType:     base.Type,
+		Identifier:     client.Session.Identifier,
+		ServerURL:      base.URL,
+		Location:       client.Session.Location,
+		Profile:        *profile,
+		DefaultGateway: profile.DefaultGateway,
+		StartTime:      base.StartTime,
+		EndTime:        base.EndTime,
+		Certificate:    base.Certificate,
+	}
+	if customized.WireGuard != nil {
+		result.Config = customized.WireGuard.String()
+	} else if customized.OpenVPN != nil {
+		result.Config = customized.OpenVPN.String()
+	}
+	return result, nil
+}
@@ -5,38 +5,207 @@ import (
 	"fmt"
 
 	"github.com/eduvpn/eduvpn-common/internal/oauth"
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/internal/server"
 	"github.com/eduvpn/eduvpn-common/internal/util"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
+// WireGuardConfig is a parsed WireGuard configuration.
+// It is returned next to the configuration text such that the configuration does not have to be parsed again.
+type WireGuardConfig = wireguard.Config
+
+// WireGuardKeyPolicy defines whether WireGuard keys are reused for each server and profile and when they are rotated.
+type WireGuardKeyPolicy = wireguard.KeyPolicy
+
+// ConfigCachePolicy defines whether configurations are cached for each server and profile and how long they are reused.
+type ConfigCachePolicy = server.CachePolicy
+
+// OpenVPNPolicy defines the OpenVPN directives that are rejected and the rewrites that are applied to OpenVPN configurations.
+type OpenVPNPolicy = openvpn.Policy
+
+// OpenVPNManagement is the management interface that is added to OpenVPN configurations by the OpenVPN policy.
+type OpenVPNManagement = openvpn.Management
+
+// wireguardKeys returns the store for the WireGuard keys using the secret store and the key policy of the client.
+func (client *Client) wireguardKeys() *wireguard.KeyStore {
+	return &wireguard.KeyStore{Secrets: client.SecretStore, Policy: client.WireGuardKeyPolicy}
+}
+
+// configCache returns the cache for the configurations using the secret store and the cache policy of the client.
+func (client *Client) configCache() *server.ConfigCache {
+	return &server.ConfigCache{Secrets: client.SecretStore, Policy: client.ConfigCachePolicy}
+}
+
+// removeCachedConfigs removes the cached configurations for the server with `url`.
+// This is done when the configurations can no longer be used, e.g. the session is gone on the server.
+func (client *Client) removeCachedConfigs(url string) {
+	removeErr := client.configCache().Remove(url)
+	if removeErr != nil {
+		client.Logger.Infof(
+			"Failed removing the cached configurations for server %s: %s",
+			url,
+			types.ErrorTraceback(removeErr),
+		)
+	}
+}
+
+// removeServerData removes the saved WireGuard keys, the cached configurations and the config transforms for the server with `url`.
+func (client *Client) removeServerData(url string) {
+	delete(client.Customizations, url)
+	removeErr := client.wireguardKeys().Remove(url)
+	if removeErr != nil {
+		client.Logger.Infof(
+			"Failed removing the WireGuard keys for server %s: %s",
+			url,
+			types.ErrorTraceback(removeErr),
+		)
+	}
+	client.removeCachedConfigs(url)
+	delete(client.managedPins, url)
+	client.updatePins()
+}
+
+// disconnect does the /disconnect API call for `chosenServer` and removes the cached configurations as the session is gone on the server.
+func (client *Client) disconnect(chosenServer server.Server) {
+	server.Disconnect(chosenServer)
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		client.Logger.Infof(
+			"Failed getting the server to remove the cached configurations: %s",
+			types.ErrorTraceback(baseErr),
+		)
+		return
+	}
+	client.removeCachedConfigs(base.URL)
+}
+
+// configCacheKey returns the key of the configuration for the current profile of `base` with `preferTCP`.
+func (client *Client) configCacheKey(base *server.Base, preferTCP bool) server.CacheKey {
+	return server.CacheKey{
+		ProfileID:         base.Profiles.Current,
+		SupportsWireGuard: client.SupportsWireguard,
+		PreferTCP:         preferTCP,
+	}
+}
+
+// cachedConfig returns the cached configuration for the current profile of `chosenServer` if it can still be reused.
+// It is only reused if it was obtained with the same protocol support and `preferTCP`.
+// The session times of the server are restored from the cached configuration.
+// The boolean is false if there is no configuration that can be reused.
+func (client *Client) cachedConfig(chosenServer server.Server, preferTCP bool) (string, string, *WireGuardConfig, bool) {
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		return "", "", nil, false
+	}
+	cached, ok, cacheErr := client.configCache().Get(base.URL, client.configCacheKey(base, preferTCP))
+	if cacheErr != nil {
+		client.Logger.Infof(
+			"Failed getting the cached configuration for server %s: %s",
+			base.URL,
+			types.ErrorTraceback(cacheErr),
+		)
+		return "", "", nil, false
+	}
+	if !ok {
+		return "", "", nil, false
+	}
+
+	var parsed *WireGuardConfig
+	if cached.Protocol == "wireguard" {
+		var parseErr error
+		parsed, parseErr = wireguard.Parse(cached.Config)
+		if parseErr != nil {
+			client.Logger.Infof(
+				"Failed parsing the cached configuration for server %s: %s",
+				base.URL,
+				types.ErrorTraceback(parseErr),
+			)
+			return "", "", nil, false
+		}
+	}
+	base.StartTime = cached.StartTime
+	base.EndTime = cached.EndTime
+	base.Certificate = cached.Certificate
+	return cached.Config, cached.Protocol, parsed, true
+}
+
+// cacheConfig caches the configuration `config` with protocol `configType` that was obtained for the current profile of `chosenServer` with `preferTCP`.
+func (client *Client) cacheConfig(chosenServer server.Server, config string, configType string, preferTCP bool) {
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		return
+	}
+	cacheErr := client.configCache().Set(base.URL, client.configCacheKey(base, preferTCP), server.CachedConfig{
+		Config:      config,
+		Protocol:    configType,
+		StartTime:   base.StartTime,
+		EndTime:     base.EndTime,
+		Certificate: base.Certificate,
+	})
+	if cacheErr != nil {
+		client.Logger.Infof(
+			"Failed caching the configuration for server %s: %s",
+			base.URL,
+			types.ErrorTraceback(cacheErr),
+		)
+	}
+}
+
 // getConfigAuth gets a config with authorization and authentication.
 // It also asks for a profile if no valid profile is found.
 func (client *Client) getConfigAuth(
 	chosenServer server.Server,
 	preferTCP bool,
-) (string, string, error) {
-	loginErr := client.ensureLogin(chosenServer)
+	tracker *progress.Tracker,
+) (string, string, *WireGuardConfig, error) {
+	loginErr := client.ensureLogin(chosenServer, tracker)
 	if loginErr != nil {
-		return "", "", loginErr
+		return "", "", nil, loginErr
+	}
+	transitionErr := client.goTransition(StateRequestConfig, "")
+	if transitionErr != nil {
+		return "", "", nil, transitionErr
 	}
-	client.FSM.GoTransition(StateRequestConfig)
 
-	validProfile, profileErr := server.HasValidProfile(chosenServer, client.SupportsWireguard)
+	validProfile, profileErr := server.HasValidProfile(
+		chosenServer,
+		client.SupportsWireguard,
+		tracker,
+	)
 	if profileErr != nil {
-		return "", "", profileErr
+		return "", "", nil, profileErr
 	}
 
 	// No valid profile, ask for one
 	if !validProfile {
 		askProfileErr := client.askProfile(chosenServer)
 		if askProfileErr != nil {
-			return "", "", askProfileErr
+			return "", "", nil, askProfileErr
 		}
 	}
 
+	// Reuse the configuration of the previous /connect if its session is still valid long enough
+	// This is done after checking the profile, such that a profile that is gone or not supported is not reused
+	if config, configType, parsed, ok := client.cachedConfig(chosenServer, preferTCP); ok {
+		return config, configType, parsed, nil
+	}
+
+	config, configType, parsed, configErr := server.Config(
+		chosenServer,
+		client.SupportsWireguard,
+		preferTCP,
+		client.wireguardKeys(),
+		tracker,
+	)
 	// We return the error otherwise we wrap it too much
-	return server.Config(chosenServer, client.SupportsWireguard, preferTCP)
+	if configErr != nil {
+		return "", "", nil, configErr
+	}
+	client.cacheConfig(chosenServer, config, configType, preferTCP)
+	return config, configType, parsed, nil
 }
 
 // retryConfigAuth retries the getConfigAuth function if the tokens are invalid.
@@ -44,71 +213,99 @@ func (client *Client) getConfigAuth(
 func (client *Client) retryConfigAuth(
 	chosenServer server.Server,
 	preferTCP bool,
-) (string, string, error) {
+	tracker *progress.Tracker,
+) (string, string, *WireGuardConfig, error) {
 	errorMessage := "failed authorized config retry"
-	config, configType, configErr := client.getConfigAuth(chosenServer, preferTCP)
+	config, configType, parsed, configErr := client.getConfigAuth(chosenServer, preferTCP, tracker)
 	if configErr != nil {
 		var error *oauth.TokensInvalidError
 
 		// Only retry if the error is that the tokens are invalid
 		if errors.As(configErr, &error) {
-			config, configType, configErr = client.getConfigAuth(
+			config, configType, parsed, configErr = client.getConfigAuth(
 				chosenServer,
 				preferTCP,
+				tracker,
 			)
 			if configErr == nil {
-				return config, configType, nil
+				return config, configType, parsed, nil
 			}
 		}
-		client.goBackInternal()
-		return "", "", types.NewWrappedError(errorMessage, configErr)
+		return "", "", nil, types.NewWrappedError(errorMessage, configErr)
 	}
-	return config, configType, nil
+	return config, configType, parsed, nil
 }
 
 // getConfig gets an OpenVPN/WireGuard configuration by contacting the server, moving the FSM towards the DISCONNECTED state and then saving the local configuration file.
 func (client *Client) getConfig(
 	chosenServer server.Server,
 	preferTCP bool,
-) (string, string, error) {
+) (*ConfigResult, error) {
 	errorMessage := "failed to get a configuration for OpenVPN/Wireguard"
 	if client.InFSMState(StateDeregistered) {
-		return "", "", types.NewWrappedError(
+		return nil, types.NewWrappedError(
 			errorMessage,
 			FSMDeregisteredError{}.CustomError(),
 		)
 	}
 
+	base, baseErr := chosenServer.Base()
+	if baseErr != nil {
+		return nil, types.NewWrappedError(errorMessage, baseErr)
+	}
+	tracker := client.newTracker(base.URL)
+
 	// Refresh the server endpoints
-	// This is best effort
-	endpointErr := server.RefreshEndpoints(chosenServer)
-	if endpointErr != nil {
+	// This is best effort, unless the server moved to a new URL
+	endpointErr := server.RefreshEndpoints(chosenServer, tracker)
+	if movedURL, moved := server.MovedURL(endpointErr); moved {
+		migrateErr := client.migrateServer(chosenServer, movedURL, tracker)
+		if migrateErr != nil {
+			return nil, types.NewWrappedError(errorMessage, migrateErr)
+		}
+	} else if endpointErr != nil {
 		client.Logger.Warningf("failed to refresh server endpoints: %v", endpointErr)
 	}
 
-	config, configType, configErr := client.retryConfigAuth(chosenServer, preferTCP)
+	config, configType, parsed, configErr := client.retryConfigAuth(chosenServer, preferTCP, tracker)
 	if configErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, configErr)
+		return nil, types.NewWrappedError(errorMessage, configErr)
+	}
+
+	// Check the config against the profile before it is used
+	warnings, validateErr := client.validateConfig(chosenServer, config, configType, parsed, preferTCP)
+	if validateErr != nil {
+		// The session of the rejected config is ended on the server and a cached config that does not match is not used again
+		client.disconnect(chosenServer)
+		return nil, types.NewWrappedError(errorMessage, validateErr)
+	}
+
+	customized, customizeErr := client.customizeConfig(base, config, configType, parsed)
+	if customizeErr != nil {
+		return nil, types.NewWrappedError(errorMessage, customizeErr)
 	}
 
 	currentServer, currentServerErr := client.Servers.GetCurrentServer()
 	if currentServerErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, currentServerErr)
+		return nil, types.NewWrappedError(errorMessage, currentServerErr)
 	}
 
 	// Signal the server display info
-	client.FSM.GoTransitionWithData(StateDisconnected, currentServer)
-
-	// Save the config
-	saveErr := client.Config.Save(&client)
-	if saveErr != nil {
-		client.Logger.Infof(
-			"Failed saving configuration after getting a server: %s",
-			types.ErrorTraceback(saveErr),
-		)
+	transitionErr := client.goTransition(StateDisconnected, currentServer)
+	if transitionErr != nil {
+		return nil, types.NewWrappedError(errorMessage, transitionErr)
 	}
 
-	return config, configType, nil
+	// Save the session, this also saves the config
+	client.updateSession(StateDisconnected)
+	tracker.Done()
+
+	result, resultErr := client.newConfigResult(chosenServer, configType, customized)
+	if resultErr != nil {
+		return nil, types.NewWrappedError(errorMessage, resultErr)
+	}
+	result.Warnings = warnings
+	return result, nil
 }
 
 // SetSecureLocation sets the location for the current secure location server. countryCode is the secure location to be chosen.
@@ -123,14 +320,14 @@ func (client *Client) SetSecureLocation(countryCode string) error {
 
 	server, serverErr := client.Discovery.ServerByCountryCode(countryCode, "secure_internet")
 	if serverErr != nil {
-		client.goBackInternal()
-		return client.handleError(errorMessage, serverErr)
+		client.locationErr = client.handleError(errorMessage, serverErr)
+		return client.locationErr
 	}
 
-	setLocationErr := client.Servers.SetSecureLocation(server)
+	setLocationErr := client.Servers.SetSecureLocation(server, client.newTracker(server.BaseURL))
 	if setLocationErr != nil {
-		client.goBackInternal()
-		return client.handleError(errorMessage, setLocationErr)
+		client.locationErr = client.handleError(errorMessage, setLocationErr)
+		return client.locationErr
 	}
 	return nil
 }
@@ -145,9 +342,25 @@ func (client *Client) RemoveSecureInternet() error {
 			FSMDeregisteredError{}.CustomError(),
 		)
 	}
+	client.removeSecureInternet()
+	client.goBackInternal()
+	return nil
+}
+
+// removeSecureInternet removes the current secure internet server with its data and saves the config.
+// It does not change the FSM state.
+func (client *Client) removeSecureInternet() {
+	// Remove the keys and config transforms for every location before the locations are gone
+	for _, base := range client.Servers.SecureInternetHomeServer.BaseMap {
+		if base != nil {
+			client.removeServerData(base.URL)
+		}
+	}
 	// No error because we can only have one secure internet server and if there are no secure internet servers, this is a NO-OP
 	client.Servers.RemoveSecureInternet()
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	if client.Session.ServerType == server.SecureInternetServerType {
+		client.Session = Session{}
+	}
 	// Save the config
 	saveErr := client.Config.Save(&client)
 	if saveErr != nil {
@@ -156,7 +369,6 @@ func (client *Client) RemoveSecureInternet() error {
 			types.ErrorTraceback(saveErr),
 		)
 	}
-	return nil
 }
 
 // RemoveInstituteAccess removes the institute access server with `url`.
@@ -169,9 +381,21 @@ func (client *Client) RemoveInstituteAccess(url string) error {
 			FSMDeregisteredError{}.CustomError(),
 		)
 	}
+	client.removeInstituteAccess(url)
+	client.goBackInternal()
+	return nil
+}
+
+// removeInstituteAccess removes the institute access server with `url` with its data and saves the config.
+// It does not change the FSM state.
+func (client *Client) removeInstituteAccess(url string) {
 	// No error because this is a NO-OP if the server doesn't exist
 	client.Servers.RemoveInstituteAccess(url)
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	client.removeServerData(url)
+	if client.Session.ServerType == server.InstituteAccessServerType &&
+		client.Session.Identifier == url {
+		client.Session = Session{}
+	}
 	// Save the config
 	saveErr := client.Config.Save(&client)
 	if saveErr != nil {
@@ -180,7 +404,6 @@ func (client *Client) RemoveInstituteAccess(url string) error {
 			types.ErrorTraceback(saveErr),
 		)
 	}
-	return nil
 }
 
 // RemoveCustomServer removes the custom server with `url`.
@@ -193,9 +416,21 @@ func (client *Client) RemoveCustomServer(url string) error {
 			FSMDeregisteredError{}.CustomError(),
 		)
 	}
+	client.removeCustomServer(url)
+	client.goBackInternal()
+	return nil
+}
+
+// removeCustomServer removes the custom server with `url` with its data and saves the config.
+// It does not change the FSM state.
+func (client *Client) removeCustomServer(url string) {
 	// No error because this is a NO-OP if the server doesn't exist
 	client.Servers.RemoveCustomServer(url)
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	client.removeServerData(url)
+	if client.Session.ServerType == server.CustomServerType &&
+		client.Session.Identifier == url {
+		client.Session = Session{}
+	}
 	// Save the config
 	saveErr := client.Config.Save(&client)
 	if saveErr != nil {
@@ -204,7 +439,6 @@ func (client *Client) RemoveCustomServer(url string) error {
 			types.ErrorTraceback(saveErr),
 		)
 	}
-	return nil
 }
 
 // AddInstituteServer adds an Institute Access server by `url`.
@@ -217,42 +451,51 @@ func (client *Client) AddInstituteServer(url string) (server.Server, error) {
 	}
 
 	// Indicate that we're loading the server
-	client.FSM.GoTransition(StateLoadingServer)
+	transitionErr := client.goTransition(StateLoadingServer, "")
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
 
 	// FIXME: Do nothing with discovery here as the client already has it
 	// So pass a server as the parameter
 	instituteServer, discoErr := client.Discovery.ServerByURL(url, "institute_access")
 	if discoErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, discoErr)
+		return nil, client.handleFailure(errorMessage, discoErr)
 	}
 
-	// Add the secure internet server
-	server, serverErr := client.Servers.AddInstituteAccessServer(instituteServer)
+	tracker := client.newTracker(instituteServer.BaseURL)
+
+	// Add the institute access server
+	server, serverErr := client.Servers.AddInstituteAccessServer(instituteServer, tracker)
 	if serverErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, serverErr)
+		return nil, client.handleFailure(errorMessage, serverErr)
 	}
 
 	// Set the server as the current so OAuth can be cancelled
 	currentErr := client.Servers.SetInstituteAccess(server)
 	if currentErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, currentErr)
+		return nil, client.handleFailure(errorMessage, currentErr)
 	}
 
 	// Indicate that we want to authorize this server
-	client.FSM.GoTransition(StateChosenServer)
+	transitionErr = client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return nil, client.handleFailure(errorMessage, transitionErr)
+	}
 
 	// Authorize it
-	loginErr := client.ensureLogin(server)
+	loginErr := client.ensureLogin(server, tracker)
 	if loginErr != nil {
-		// Removing is best effort
-		_ = client.RemoveInstituteAccess(url)
-		return nil, client.handleError(errorMessage, loginErr)
+		// The server could not be authorized, remove it again with its data
+		client.removeInstituteAccess(url)
+		return nil, client.handleFailure(errorMessage, loginErr)
 	}
 
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	transitionErr = client.goTransition(StateNoServer, client.Servers)
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
+	tracker.Done()
 	return server, nil
 }
 
@@ -270,48 +513,56 @@ func (client *Client) AddSecureInternetHomeServer(orgID string) (server.Server,
 	}
 
 	// Indicate that we're loading the server
-	client.FSM.GoTransition(StateLoadingServer)
+	transitionErr := client.goTransition(StateLoadingServer, "")
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
 
 	// Get the secure internet URL from discovery
 	secureOrg, secureServer, discoErr := client.Discovery.SecureHomeArgs(orgID)
 	if discoErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, discoErr)
+		return nil, client.handleFailure(errorMessage, discoErr)
 	}
 
+	tracker := client.newTracker(secureServer.BaseURL)
+
 	// Add the secure internet server
-	server, serverErr := client.Servers.AddSecureInternet(secureOrg, secureServer)
+	server, serverErr := client.Servers.AddSecureInternet(secureOrg, secureServer, tracker)
 	if serverErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, serverErr)
+		return nil, client.handleFailure(errorMessage, serverErr)
 	}
 
 	locationErr := client.askSecureLocation()
 	if locationErr != nil {
-		// Removing is best effort
-		// This already goes back to the main screen
-		_ = client.RemoveSecureInternet()
-		return nil, client.handleError(errorMessage, locationErr)
+		// The location could not be set, remove the server again with its data
+		client.removeSecureInternet()
+		return nil, client.handleFailure(errorMessage, locationErr)
 	}
 
 	// Set the server as the current so OAuth can be cancelled
 	currentErr := client.Servers.SetSecureInternet(server)
 	if currentErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, currentErr)
+		return nil, client.handleFailure(errorMessage, currentErr)
 	}
 
 	// Server has been chosen for authentication
-	client.FSM.GoTransition(StateChosenServer)
+	transitionErr = client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return nil, client.handleFailure(errorMessage, transitionErr)
+	}
 
 	// Authorize it
-	loginErr := client.ensureLogin(server)
+	loginErr := client.ensureLogin(server, tracker)
 	if loginErr != nil {
-		// Removing is best effort
-		_ = client.RemoveSecureInternet()
-		return nil, client.handleError(errorMessage, loginErr)
+		// The server could not be authorized, remove it again with its data
+		client.removeSecureInternet()
+		return nil, client.handleFailure(errorMessage, loginErr)
 	}
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	transitionErr = client.goTransition(StateNoServer, client.Servers)
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
+	tracker.Done()
 	return server, nil
 }
 
@@ -325,7 +576,10 @@ func (client *Client) AddCustomServer(url string) (server.Server, error) {
 	}
 
 	// Indicate that we're loading the server
-	client.FSM.GoTransition(StateLoadingServer)
+	transitionErr := client.goTransition(StateLoadingServer, "")
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
 
 	customServer := &types.DiscoveryServer{
 		BaseURL:     url,
@@ -333,79 +587,95 @@ func (client *Client) AddCustomServer(url string) (server.Server, error) {
 		Type:        "custom_server",
 	}
 
+	tracker := client.newTracker(url)
+
 	// A custom server is just an institute access server under the hood
-	server, serverErr := client.Servers.AddCustomServer(customServer)
+	server, serverErr := client.Servers.AddCustomServer(customServer, tracker)
 	if serverErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, serverErr)
+		return nil, client.handleFailure(errorMessage, serverErr)
 	}
 
 	// Set the server as the current so OAuth can be cancelled
 	currentErr := client.Servers.SetCustomServer(server)
 	if currentErr != nil {
-		client.goBackInternal()
-		return nil, client.handleError(errorMessage, currentErr)
+		return nil, client.handleFailure(errorMessage, currentErr)
 	}
 
 	// Server has been chosen for authentication
-	client.FSM.GoTransition(StateChosenServer)
+	transitionErr = client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return nil, client.handleFailure(errorMessage, transitionErr)
+	}
 
 	// Authorize it
-	loginErr := client.ensureLogin(server)
+	loginErr := client.ensureLogin(server, tracker)
 	if loginErr != nil {
-		// removing is best effort
-		_ = client.RemoveCustomServer(url)
-		return nil, client.handleError(errorMessage, loginErr)
+		// The server could not be authorized, remove it again with its data
+		client.removeCustomServer(url)
+		return nil, client.handleFailure(errorMessage, loginErr)
 	}
 
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	transitionErr = client.goTransition(StateNoServer, client.Servers)
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
+	tracker.Done()
 	return server, nil
 }
 
 // GetConfigInstituteAccess gets a configuration for an Institute Access Server.
 // It ensures that the Institute Access Server exists by creating or using an existing one with the url.
 // `preferTCP` indicates that the client wants to use TCP (through OpenVPN) to establish the VPN tunnel.
-func (client *Client) GetConfigInstituteAccess(url string, preferTCP bool) (string, string, error) {
+// It returns the configuration with its protocol, parsed representation and session details.
+func (client *Client) GetConfigInstituteAccess(
+	url string,
+	preferTCP bool,
+) (*ConfigResult, error) {
 	errorMessage := fmt.Sprintf("failed getting a configuration for Institute Access %s", url)
 
 	// Not supported with Let's Connect!
 	if client.isLetsConnect() {
-		return "", "", client.handleError(errorMessage, LetsConnectNotSupportedError{})
+		return nil, client.handleError(errorMessage, LetsConnectNotSupportedError{})
 	}
 
-	client.FSM.GoTransition(StateLoadingServer)
+	transitionErr := client.goTransition(StateLoadingServer, "")
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
 
 	// Get the server if it exists
 	server, serverErr := client.Servers.GetInstituteAccess(url)
 	if serverErr != nil {
-		client.goBackInternal()
-		return "", "", client.handleError(errorMessage, serverErr)
+		return nil, client.handleFailure(errorMessage, serverErr)
 	}
 
 	// Set the server as the current
 	currentErr := client.Servers.SetInstituteAccess(server)
 	if currentErr != nil {
-		return "", "", client.handleError(errorMessage, currentErr)
+		return nil, client.handleFailure(errorMessage, currentErr)
 	}
 
 	// The server has now been chosen
-	client.FSM.GoTransition(StateChosenServer)
+	transitionErr = client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return nil, client.handleFailure(errorMessage, transitionErr)
+	}
 
-	config, configType, configErr := client.getConfig(server, preferTCP)
+	result, configErr := client.getConfig(server, preferTCP)
 	if configErr != nil {
-		client.goBackInternal()
-		return "", "", client.handleError(errorMessage, configErr)
+		return nil, client.handleFailure(errorMessage, configErr)
 	}
-	return config, configType, nil
+	return result, nil
 }
 
 // GetConfigSecureInternet gets a configuration for a Secure Internet Server.
 // It ensures that the Secure Internet Server exists by creating or using an existing one with the orgID.
 // `preferTCP` indicates that the client wants to use TCP (through OpenVPN) to establish the VPN tunnel.
+// It returns the configuration with its protocol, parsed representation and session details.
 func (client *Client) GetConfigSecureInternet(
 	orgID string,
 	preferTCP bool,
-) (string, string, error) {
+) (*ConfigResult, error) {
 	errorMessage := fmt.Sprintf(
 		"failed getting a configuration for Secure Internet organization %s",
 		orgID,
@@ -413,68 +683,80 @@ func (client *Client) GetConfigSecureInternet(
 
 	// Not supported with Let's Connect!
 	if client.isLetsConnect() {
-		return "", "", client.handleError(errorMessage, LetsConnectNotSupportedError{})
+		return nil, client.handleError(errorMessage, LetsConnectNotSupportedError{})
 	}
 
-	client.FSM.GoTransition(StateLoadingServer)
+	transitionErr := client.goTransition(StateLoadingServer, "")
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
 
 	// Get the server if it exists
 	server, serverErr := client.Servers.GetSecureInternetHomeServer()
 	if serverErr != nil {
-		client.goBackInternal()
-		return "", "", client.handleError(errorMessage, serverErr)
+		return nil, client.handleFailure(errorMessage, serverErr)
 	}
 
 	// Set the server as the current
 	currentErr := client.Servers.SetSecureInternet(server)
 	if currentErr != nil {
-		return "", "", client.handleError(errorMessage, currentErr)
+		return nil, client.handleFailure(errorMessage, currentErr)
 	}
 
-	client.FSM.GoTransition(StateChosenServer)
+	transitionErr = client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return nil, client.handleFailure(errorMessage, transitionErr)
+	}
 
-	config, configType, configErr := client.getConfig(server, preferTCP)
+	result, configErr := client.getConfig(server, preferTCP)
 	if configErr != nil {
-		client.goBackInternal()
-		return "", "", client.handleError(errorMessage, configErr)
+		return nil, client.handleFailure(errorMessage, configErr)
 	}
-	return config, configType, nil
+	return result, nil
 }
 
 // GetConfigCustomServer gets a configuration for a Custom Server.
 // It ensures that the Custom Server exists by creating or using an existing one with the url.
 // `preferTCP` indicates that the client wants to use TCP (through OpenVPN) to establish the VPN tunnel.
-func (client *Client) GetConfigCustomServer(url string, preferTCP bool) (string, string, error) {
+// It returns the configuration with its protocol, parsed representation and session details.
+func (client *Client) GetConfigCustomServer(
+	url string,
+	preferTCP bool,
+) (*ConfigResult, error) {
 	errorMessage := fmt.Sprintf("failed getting a configuration for custom server %s", url)
 
 	url, urlErr := util.EnsureValidURL(url)
 	if urlErr != nil {
-		return "", "", client.handleError(errorMessage, urlErr)
+		return nil, client.handleError(errorMessage, urlErr)
 	}
 
-	client.FSM.GoTransition(StateLoadingServer)
+	transitionErr := client.goTransition(StateLoadingServer, "")
+	if transitionErr != nil {
+		return nil, client.handleError(errorMessage, transitionErr)
+	}
 
 	// Get the server if it exists
 	server, serverErr := client.Servers.GetCustomServer(url)
 	if serverErr != nil {
-		client.goBackInternal()
-		return "", "", client.handleError(errorMessage, serverErr)
+		return nil, client.handleFailure(errorMessage, serverErr)
 	}
 
 	// Set the server as the current
 	currentErr := client.Servers.SetCustomServer(server)
 	if currentErr != nil {
-		return "", "", client.handleError(errorMessage, currentErr)
+		return nil, client.handleFailure(errorMessage, currentErr)
 	}
 
-	client.FSM.GoTransition(StateChosenServer)
+	transitionErr = client.goTransition(StateChosenServer, "")
+	if transitionErr != nil {
+		return nil, client.handleFailure(errorMessage, transitionErr)
+	}
 
-	config, configType, configErr := client.getConfig(server, preferTCP)
+	result, configErr := client.getConfig(server, preferTCP)
 	if configErr != nil {
-		client.goBackInternal()
-		return "", "", client.handleError(errorMessage, configErr)
+		return nil, client.handleFailure(errorMessage, configErr)
 	}
-	return config, configType, nil
+	return result, nil
 }
 
 // askSecureLocation asks the user to choose a Secure Internet location by moving the FSM to the STATE_ASK_LOCATION state.
@@ -483,11 +765,20 @@ func (client *Client) askSecureLocation() error {
 	locations := client.Discovery.SecureLocationList()
 
 	// Ask for the location in the callback
+	client.locationErr = nil
 	goTransitionErr := client.FSM.GoTransitionRequired(StateAskLocation, locations)
 	if goTransitionErr != nil {
 		return types.NewWrappedError(errorMessage, goTransitionErr)
 	}
 
+	// The location that was chosen in the callback could not be set
+	// The caller handles the failure such that the FSM goes to the error state only once
+	if client.locationErr != nil {
+		locationErr := client.locationErr
+		client.locationErr = nil
+		return types.NewWrappedError(errorMessage, locationErr)
+	}
+
 	// The state has changed, meaning setting the secure location was not successful
 	if client.FSM.Current != StateAskLocation {
 		// TODO: maybe a custom type for this errors.new?
@@ -517,11 +808,18 @@ func (client *Client) ChangeSecureLocation() error {
 
 	askLocationErr := client.askSecureLocation()
 	if askLocationErr != nil {
+		// A location that could not be set leaves the FSM in the ask location state
+		if client.InFSMState(StateAskLocation) {
+			return client.handleFailure(errorMessage, askLocationErr)
+		}
 		return client.handleError(errorMessage, askLocationErr)
 	}
 
 	// Go back to the main screen
-	client.FSM.GoTransitionWithData(StateNoServer, client.Servers)
+	transitionErr := client.goTransition(StateNoServer, client.Servers)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
+	}
 
 	return nil
 }
@@ -538,13 +836,16 @@ func (client *Client) RenewSession() error {
 
 	// The server has not been chosen yet, this means that we want to manually renew
 	if client.FSM.InState(StateNoServer) {
-		client.FSM.GoTransition(StateChosenServer)
+		transitionErr := client.goTransition(StateChosenServer, "")
+		if transitionErr != nil {
+			return client.handleError(errorMessage, transitionErr)
+		}
 	}
 
 	server.MarkTokensForRenew(currentServer)
-	loginErr := client.ensureLogin(currentServer)
+	loginErr := client.ensureLogin(currentServer, nil)
 	if loginErr != nil {
-		return client.handleError(errorMessage, loginErr)
+		return client.handleFailure(errorMessage, loginErr)
 	}
 
 	return nil
@@ -575,32 +876,46 @@ func (client *Client) ShouldRenewButton() bool {
 
 // ensureLogin logs the user back in if needed.
 // It runs the FSM transitions to ask for user input.
-func (client *Client) ensureLogin(chosenServer server.Server) error {
+func (client *Client) ensureLogin(chosenServer server.Server, tracker *progress.Tracker) error {
 	errorMessage := "failed ensuring login"
+	// The well-known endpoints are fetched, so the pins can be set or learned
+	client.pinServer(chosenServer)
+
 	// Relogin with oauth
 	// This moves the state to authorized
 	if server.NeedsRelogin(chosenServer) {
+		// The configurations of the old authorization are not reused
+		if base, baseErr := chosenServer.Base(); baseErr == nil {
+			client.removeCachedConfigs(base.URL)
+		}
+		tracker.Step(progress.StepAuthorize)
 		url, urlErr := server.OAuthURL(chosenServer, client.Name)
+		if urlErr != nil {
+			return types.NewWrappedError(errorMessage, urlErr)
+		}
+
+		// The browser cannot enforce the pins, so check them before the user is sent there
+		pinErr := client.httpClient.VerifyPins(url)
+		if pinErr != nil {
+			return types.NewWrappedError(errorMessage, pinErr)
+		}
 
 		goTransitionErr := client.FSM.GoTransitionRequired(StateOAuthStarted, url)
 		if goTransitionErr != nil {
 			return types.NewWrappedError(errorMessage, goTransitionErr)
 		}
 
-		if urlErr != nil {
-			client.goBackInternal()
-			return types.NewWrappedError(errorMessage, urlErr)
-		}
-
 		exchangeErr := server.OAuthExchange(chosenServer)
 
 		if exchangeErr != nil {
-			client.goBackInternal()
 			return types.NewWrappedError(errorMessage, exchangeErr)
 		}
 	}
 	// OAuth was valid, ensure we are in the authorized state
-	client.FSM.GoTransition(StateAuthorized)
+	transitionErr := client.goTransition(StateAuthorized, "")
+	if transitionErr != nil {
+		return types.NewWrappedError(errorMessage, transitionErr)
+	}
 	return nil
 }
 
@@ -610,14 +925,16 @@ func (client *Client) SetProfileID(profileID string) error {
 	errorMessage := "failed to set the profile ID for the current server"
 	server, serverErr := client.Servers.GetCurrentServer()
 	if serverErr != nil {
-		client.goBackInternal()
-		return client.handleError(errorMessage, serverErr)
+		return client.handleFailure(errorMessage, serverErr)
 	}
 
 	base, baseErr := server.Base()
 	if baseErr != nil {
-		client.goBackInternal()
-		return client.handleError(errorMessage, baseErr)
+		return client.handleFailure(errorMessage, baseErr)
+	}
+	// The cached configuration is for the previous profile
+	if base.Profiles.Current != profileID {
+		client.removeCachedConfigs(base.URL)
 	}
 	base.Profiles.Current = profileID
 	return nil
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// Session is the connection related state that is saved in the state file.
+// It is used to recover after the client crashed or was restarted while a VPN configuration was obtained.
+type Session struct {
+	// State is the last connection related FSM state, e.g. StateConnected
+	State FSMStateID `json:"state"`
+
+	// ServerType is the type of the server the session belongs to
+	ServerType server.Type `json:"server_type"`
+
+	// Identifier identifies the server, the base URL for Institute Access and Custom servers and the organization ID for Secure Internet
+	Identifier string `json:"identifier"`
+
+	// Location is the Secure Internet location (country code) of the session, empty for other server types
+	Location string `json:"location"`
+
+	// ProfileID is the profile that the configuration was obtained for
+	ProfileID string `json:"profile_id"`
+
+	// StartTime is the time the configuration was obtained
+	StartTime time.Time `json:"start_time"`
+
+	// EndTime is the time the session expires on the server
+	// For OpenVPN this is the expiry time of the client certificate if that is earlier
+	EndTime time.Time `json:"expire_time"`
+
+	// Certificate is the client certificate of an OpenVPN configuration, nil for WireGuard
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+}
+
+// CertificateInfo is the subject and validity of the client certificate of an OpenVPN configuration.
+type CertificateInfo = server.CertificateInfo
+
+// SessionInfo is the info of the session of the configuration that was obtained for the current server.
+type SessionInfo struct {
+	// ProfileID is the profile that the configuration was obtained for
+	ProfileID string
+
+	// StartTime is the time the configuration was obtained
+	StartTime time.Time
+
+	// EndTime is the time the session expires, ShouldRenewButton uses this time
+	// For OpenVPN this is the earlier of the expiry time of the server and the expiry time of the client certificate
+	EndTime time.Time
+
+	// Certificate is the client certificate of an OpenVPN configuration, nil for WireGuard
+	Certificate *CertificateInfo
+}
+
+// Empty returns whether or not there is a saved session.
+func (session *Session) Empty() bool {
+	return session.Identifier == ""
+}
+
+// Expired returns whether or not the session has expired on the server.
+func (session *Session) Expired() bool {
+	return !time.Now().Before(session.EndTime)
+}
+
+// isSessionState returns whether or not the FSM state `state` belongs to a session with a /connect allocation.
+func isSessionState(state FSMStateID) bool {
+	switch state {
+	case StateDisconnected, StateConnecting, StateConnected, StateDisconnecting:
+		return true
+	default:
+		return false
+	}
+}
+
+// saveConfig saves the state file and logs if it failed using `context` as additional info.
+func (client *Client) saveConfig(context string) {
+	saveErr := client.Config.Save(&client)
+	if saveErr != nil {
+		client.Logger.Infof(
+			"Failed saving configuration %s: %s",
+			context,
+			types.ErrorTraceback(saveErr),
+		)
+	}
+}
+
+// updateSession saves the session for the current server with the FSM state `state`.
+func (client *Client) updateSession(state FSMStateID) {
+	currentServer, currentServerErr := client.Servers.GetCurrentServer()
+	if currentServerErr != nil {
+		client.Logger.Infof(
+			"No current server to save the session for: %s",
+			types.ErrorTraceback(currentServerErr),
+		)
+		return
+	}
+	base, baseErr := currentServer.Base()
+	if baseErr != nil {
+		client.Logger.Infof(
+			"No server base to save the session for: %s",
+			types.ErrorTraceback(baseErr),
+		)
+		return
+	}
+
+	session := Session{
+		State:       state,
+		ServerType:  client.Servers.IsType,
+		Identifier:  base.URL,
+		ProfileID:   base.Profiles.Current,
+		StartTime:   base.StartTime,
+		EndTime:     base.EndTime,
+		Certificate: base.Certificate,
+	}
+	if session.ServerType == server.SecureInternetServerType {
+		session.Identifier = client.Servers.SecureInternetHomeServer.HomeOrganizationID
+		session.Location = client.Servers.SecureInternetHomeServer.CurrentLocation
+	}
+	client.Session = session
+	client.saveConfig("after updating the session")
+}
+
+// clearSession removes the saved session and saves the state file.
+func (client *Client) clearSession() {
+	if client.Session.Empty() {
+		return
+	}
+	client.Session = Session{}
+	client.saveConfig("after clearing the session")
+}
+
+// sessionServer returns the server that belongs to the saved session and sets it as the current server.
+func (client *Client) sessionServer() (server.Server, error) {
+	errorMessage := "failed getting the server for the saved session"
+	session := &client.Session
+
+	var sessionServer server.Server
+	var setErr error
+	switch session.ServerType {
+	case server.InstituteAccessServerType:
+		instituteServer, instituteErr := client.Servers.GetInstituteAccess(session.Identifier)
+		if instituteErr != nil {
+			return nil, types.NewWrappedError(errorMessage, instituteErr)
+		}
+		sessionServer = instituteServer
+		setErr = client.Servers.SetInstituteAccess(instituteServer)
+	case server.CustomServerType:
+		customServer, customErr := client.Servers.GetCustomServer(session.Identifier)
+		if customErr != nil {
+			return nil, types.NewWrappedError(errorMessage, customErr)
+		}
+		sessionServer = customServer
+		setErr = client.Servers.SetCustomServer(customServer)
+	case server.SecureInternetServerType:
+		secureServer := &client.Servers.SecureInternetHomeServer
+		if secureServer.HomeOrganizationID != session.Identifier {
+			return nil, types.NewWrappedError(
+				errorMessage,
+				fmt.Errorf("no secure internet server with organization ID: %s", session.Identifier),
+			)
+		}
+		if _, ok := secureServer.BaseMap[session.Location]; !ok {
+			return nil, types.NewWrappedError(
+				errorMessage,
+				fmt.Errorf("no secure internet location: %s", session.Location),
+			)
+		}
+		secureServer.CurrentLocation = session.Location
+		sessionServer = secureServer
+		setErr = client.Servers.SetSecureInternet(secureServer)
+	default:
+		return nil, types.NewWrappedError(errorMessage, errors.New("unknown server type"))
+	}
+	if setErr != nil {
+		return nil, types.NewWrappedError(errorMessage, setErr)
+	}
+
+	// Make sure the server details match the session
+	base, baseErr := sessionServer.Base()
+	if baseErr != nil {
+		return nil, types.NewWrappedError(errorMessage, baseErr)
+	}
+	base.Profiles.Current = session.ProfileID
+	base.StartTime = session.StartTime
+	base.EndTime = session.EndTime
+	base.Certificate = session.Certificate
+	return sessionServer, nil
+}
+
+// loadSession checks the session that was loaded from the state file.
+// A session that has already expired on the server is removed, as the server has freed the allocation by itself.
+func (client *Client) loadSession() {
+	if client.Session.Empty() {
+		return
+	}
+	if !isSessionState(client.Session.State) || client.Session.Expired() {
+		client.Logger.Infof(
+			"Saved session for server %s is not valid anymore, removing it",
+			client.Session.Identifier,
+		)
+		client.clearSession()
+		return
+	}
+	client.Logger.Infof(
+		"Found a saved session for server %s in state %s",
+		client.Session.Identifier,
+		GetStateName(client.Session.State),
+	)
+}
+
+// SavedSession returns the session that was saved before the client was restarted.
+// This session can be resumed with ResumeSession or removed with DiscardSession.
+// It returns nil if there is no such session.
+func (client *Client) SavedSession() *Session {
+	// Only a session that has not been resumed yet is a saved session
+	if client.Session.Empty() || !client.InFSMState(StateNoServer) {
+		return nil
+	}
+	return &client.Session
+}
+
+// ResumeSession resumes the session that was saved before the client was restarted.
+// `connected` indicates whether or not the OS still has the VPN connection up.
+// If so, the FSM moves to the CONNECTED state, otherwise to the DISCONNECTED state.
+// It returns an error if there is no saved session or the server of the session cannot be found.
+func (client *Client) ResumeSession(connected bool) error {
+	errorMessage := "failed to resume the saved session"
+	if client.SavedSession() == nil {
+		return client.handleError(errorMessage, SessionNotFoundError{}.CustomError())
+	}
+
+	sessionServer, serverErr := client.sessionServer()
+	if serverErr != nil {
+		client.clearSession()
+		return client.handleError(errorMessage, serverErr)
+	}
+
+	state := StateDisconnected
+	if connected {
+		state = StateConnected
+	}
+	transitionErr := client.goTransition(state, sessionServer)
+	if transitionErr != nil {
+		return client.handleError(errorMessage, transitionErr)
+	}
+	client.updateSession(state)
+	return nil
+}
+
+// SessionInfo returns the info of the session for the current server.
+// It returns an error if there is no current server or no configuration was obtained for it.
+func (client *Client) SessionInfo() (*SessionInfo, error) {
+	errorMessage := "failed getting the session info"
+	currentServer, currentServerErr := client.Servers.GetCurrentServer()
+	if currentServerErr != nil {
+		return nil, client.handleError(errorMessage, currentServerErr)
+	}
+	base, baseErr := currentServer.Base()
+	if baseErr != nil {
+		return nil, client.handleError(errorMessage, baseErr)
+	}
+	if base.EndTime.IsZero() {
+		return nil, client.handleError(errorMessage, SessionNotFoundError{}.CustomError())
+	}
+	return &SessionInfo{
+		ProfileID:   base.Profiles.Current,
+		StartTime:   base.StartTime,
+		EndTime:     base.EndTime,
+		Certificate: base.Certificate,
+	}, nil
+}
+
+// DiscardSession performs the outstanding /disconnect for the session that was saved before the client was restarted.
+// The session is removed afterwards, the FSM stays in the NO_SERVER state.
+// It returns an error if there is no saved session.
+func (client *Client) DiscardSession() error {
+	errorMessage := "failed to discard the saved session"
+	if client.SavedSession() == nil {
+		return client.handleError(errorMessage, SessionNotFoundError{}.CustomError())
+	}
+
+	sessionServer, serverErr := client.sessionServer()
+	if serverErr != nil {
+		client.clearSession()
+		return client.handleError(errorMessage, serverErr)
+	}
+
+	// The /disconnect is best effort
+	client.disconnect(sessionServer)
+	client.clearSession()
+	return nil
+}
+
+// SessionNotFoundError indicates that there is no saved session or no session for the current server.
+type SessionNotFoundError struct{}
+
+func (e SessionNotFoundError) Error() string {
+	return "no saved session found"
+}
+
+func (e SessionNotFoundError) CustomError() *types.WrappedErrorMessage {
+	return types.NewWrappedError("No session found", e)
+}
@@ -0,0 +1,31 @@
+package client
+
+import httpw "github.com/eduvpn/eduvpn-common/internal/http"
+
+type (
+	// HTTPObserver is called at the start and the end of each outbound request, e.g. the token requests and the API calls.
+	HTTPObserver = httpw.Observer
+
+	// TraceSpan is an outbound request in the form of an OpenTelemetry client span.
+	TraceSpan = httpw.Span
+)
+
+// SetTracing sets how the outbound HTTP and OAuth requests are traced, by default they are not.
+// If `log` is true, the start and end of every request are logged at debug level with the redacted URL, status, sizes and duration.
+// If `export` is not nil, every finished request is passed to it as a span such that the UI can forward it to its tracer.
+// The OAuth authorization in the browser is also traced, from getting the URL until the callback.
+func (client *Client) SetTracing(log bool, export func(TraceSpan)) {
+	var observers httpw.Observers
+	if log {
+		observers = append(observers, httpw.LogObserver{Debugf: client.Logger.Debugf})
+	}
+	if export != nil {
+		observers = append(observers, httpw.SpanObserver{Export: export})
+	}
+	options := client.HTTPOptions
+	options.Observer = nil
+	if len(observers) > 0 {
+		options.Observer = observers
+	}
+	client.applyHTTPOptions(options)
+}
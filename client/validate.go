@@ -0,0 +1,51 @@
+package client
+
+import (
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/validate"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// ConfigWarning is a mismatch between an obtained configuration and the request that does not stop the configuration from being used.
+type ConfigWarning = validate.Warning
+
+// ConfigMismatchError is returned by the GetConfig functions when the obtained configuration cannot be used for the profile.
+type ConfigMismatchError = validate.MismatchError
+
+// validateConfig checks the configuration `config` with protocol `configType` that was obtained for the current profile of `chosenServer`.
+// The warnings are logged and returned, an error is returned if the configuration cannot be used.
+func (client *Client) validateConfig(
+	chosenServer server.Server,
+	config string,
+	configType string,
+	parsed *WireGuardConfig,
+	preferTCP bool,
+) ([]ConfigWarning, error) {
+	errorMessage := "failed validating the configuration"
+	profile, profileErr := server.CurrentProfile(chosenServer)
+	if profileErr != nil {
+		return nil, types.NewWrappedError(errorMessage, profileErr)
+	}
+	validated := validate.Config{Protocol: configType, WireGuard: parsed}
+	if configType == "openvpn" {
+		var parseErr error
+		validated.OpenVPN, parseErr = openvpn.Parse(config)
+		if parseErr != nil {
+			return nil, types.NewWrappedError(errorMessage, parseErr)
+		}
+	}
+
+	warnings, validateErr := validate.Validate(validate.Request{
+		Profile:           *profile,
+		SupportsWireGuard: client.SupportsWireguard,
+		PreferTCP:         preferTCP,
+	}, validated)
+	if validateErr != nil {
+		return nil, types.NewWrappedError(errorMessage, validateErr)
+	}
+	for _, warning := range warnings {
+		client.Logger.Warningf("Config for profile %s: %s", profile.ID, warning.String())
+	}
+	return warnings, nil
+}
@@ -4,9 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/eduvpn/eduvpn-common/client"
+	"github.com/eduvpn/eduvpn-common/internal/export"
 	"github.com/eduvpn/eduvpn-common/internal/server"
 	"github.com/eduvpn/eduvpn-common/types"
 )
@@ -72,9 +74,27 @@ func sendProfile(state *client.Client, data interface{}) {
 	}
 }
 
+// Ask to confirm that a server moved to a new URL in the command line.
+func confirmMigration(state *client.Client, data interface{}) {
+	migration, ok := data.(client.ServerMigration)
+	if !ok {
+		fmt.Println("Invalid data type")
+		return
+	}
+	fmt.Printf("The server %s moved to %s. Migrate it? [y/N] ", migration.From, migration.To)
+	var answer string
+	_, _ = fmt.Scanln(&answer)
+	migrationErr := state.ConfirmMigration(strings.EqualFold(answer, "y"))
+	if migrationErr != nil {
+		fmt.Println("Failed confirming the migration with error", migrationErr)
+	}
+}
+
 // The callback function
 // If OAuth is started we open the browser with the Auth URL
 // If we ask for a profile, we send the profile using command line input
+// If a server moved, we ask to confirm the migration using command line input
+// If an error occurred, we print it
 // Note that this has an additional argument, the vpn state which was wrapped into this callback function below.
 func stateCallback(
 	state *client.Client,
@@ -89,10 +109,22 @@ func stateCallback(
 	if newState == client.StateAskProfile {
 		sendProfile(state, data)
 	}
+
+	if newState == client.StateAskMigration {
+		confirmMigration(state, data)
+	}
+
+	if newState == client.StateError {
+		fmt.Println("Error state entered with error:", data)
+	}
 }
 
 // Get a config for Institute Access or Secure Internet Server.
-func getConfig(state *client.Client, url string, serverType ServerTypes) (string, string, error) {
+func getConfig(
+	state *client.Client,
+	url string,
+	serverType ServerTypes,
+) (*client.ConfigResult, error) {
 	if !strings.HasPrefix(url, "http") {
 		url = "https://" + url
 	}
@@ -100,25 +132,91 @@ func getConfig(state *client.Client, url string, serverType ServerTypes) (string
 	if serverType == ServerTypeInstituteAccess {
 		_, addErr := state.AddInstituteServer(url)
 		if addErr != nil {
-			return "", "", addErr
+			return nil, addErr
 		}
 		return state.GetConfigInstituteAccess(url, false)
 	} else if serverType == ServerTypeCustom {
 		_, addErr := state.AddCustomServer(url)
 		if addErr != nil {
-			return "", "", addErr
+			return nil, addErr
 		}
 		return state.GetConfigCustomServer(url, false)
 	}
 	_, addErr := state.AddSecureInternetHomeServer(url)
 	if addErr != nil {
-		return "", "", addErr
+		return nil, addErr
 	}
 	return state.GetConfigSecureInternet(url, false)
 }
 
+// ExportOptions are the options for exporting the config instead of printing it.
+type ExportOptions struct {
+	// Target is "networkmanager", "networkd" or "wg-quick", empty to print the config
+	Target string
+
+	// Directory is the directory where the exported files are written
+	Directory string
+
+	// InterfaceName is the name of the interface
+	InterfaceName string
+
+	// RouteTable is the route table for WireGuard, empty for the default
+	RouteTable string
+
+	// DNS is the DNS mode for WireGuard: default, search-domains or ignore
+	DNS string
+}
+
+// Export the config with the type configType and write the files.
+func exportConfig(state *client.Client, config string, configType string, options ExportOptions) error {
+	directory, directoryErr := filepath.Abs(options.Directory)
+	if directoryErr != nil {
+		return directoryErr
+	}
+	if options.Target == "networkmanager" {
+		connection, exportErr := state.ExportNetworkManager(
+			config,
+			configType,
+			client.NetworkManagerOptions{Directory: directory, InterfaceName: options.InterfaceName},
+		)
+		if exportErr != nil {
+			return exportErr
+		}
+		fmt.Println("Writing NetworkManager connection", connection.ID, "with UUID", connection.UUID)
+		return connection.Write(directory)
+	}
+
+	dns, dnsErr := export.ParseDNSMode(options.DNS)
+	if dnsErr != nil {
+		return dnsErr
+	}
+	wireguardOptions := client.WireGuardExportOptions{
+		Directory:     directory,
+		InterfaceName: options.InterfaceName,
+		RouteTable:    options.RouteTable,
+		DNS:           dns,
+	}
+	var exported *client.WireGuardExport
+	var exportErr error
+	switch options.Target {
+	case "networkd":
+		exported, exportErr = state.ExportNetworkd(config, wireguardOptions)
+	case "wg-quick":
+		exported, exportErr = state.ExportWGQuick(config, wireguardOptions)
+	default:
+		return fmt.Errorf("unknown export target: %s", options.Target)
+	}
+	if exportErr != nil {
+		return exportErr
+	}
+	for _, file := range append(exported.Files, exported.Secrets...) {
+		fmt.Println("Writing", file.Path)
+	}
+	return exported.Write()
+}
+
 // Get a config for a single server, Institute Access or Secure Internet.
-func printConfig(url string, serverType ServerTypes) {
+func printConfig(url string, serverType ServerTypes, exportOptions ExportOptions) {
 	state := &client.Client{}
 
 	registerErr := state.Register(
@@ -138,7 +236,7 @@ func printConfig(url string, serverType ServerTypes) {
 
 	defer state.Deregister()
 
-	config, _, configErr := getConfig(state, url, serverType)
+	result, configErr := getConfig(state, url, serverType)
 
 	if configErr != nil {
 		// Show the usage of tracebacks and causes
@@ -147,7 +245,16 @@ func printConfig(url string, serverType ServerTypes) {
 		return
 	}
 
-	fmt.Println("Obtained config", config)
+	if exportOptions.Target != "" {
+		exportErr := exportConfig(state, result.Config, result.Protocol, exportOptions)
+		if exportErr != nil {
+			fmt.Println("Error exporting config:", types.ErrorTraceback(exportErr))
+		}
+		return
+	}
+
+	fmt.Println("Obtained", result.Protocol, "config for profile", result.Profile.ID, "valid until", result.EndTime)
+	fmt.Println(result.Config)
 }
 
 // The main function
@@ -156,6 +263,12 @@ func main() {
 	customURLArg := flag.String("get-custom", "", "The url of a custom server to connect to")
 	urlArg := flag.String("get-institute", "", "The url of an institute to connect to")
 	secureInternet := flag.String("get-secure", "", "Gets secure internet servers")
+	exportOptions := ExportOptions{}
+	flag.StringVar(&exportOptions.Target, "export", "", "Export the config instead of printing it: networkmanager, networkd or wg-quick")
+	flag.StringVar(&exportOptions.Directory, "export-dir", ".", "The directory where the exported files are written")
+	flag.StringVar(&exportOptions.InterfaceName, "ifname", "eduvpn0", "The interface name of the exported config")
+	flag.StringVar(&exportOptions.RouteTable, "table", "", "The route table of the exported WireGuard config, e.g. main, off or a number")
+	flag.StringVar(&exportOptions.DNS, "dns", "default", "How the exported WireGuard config uses DNS: default, search-domains or ignore")
 	flag.Parse()
 
 	// Connect to a VPN by getting an Institute Access config
@@ -164,11 +277,11 @@ func main() {
 	secureInternetString := *secureInternet
 	switch {
 	case customURLString != "":
-		printConfig(customURLString, ServerTypeCustom)
+		printConfig(customURLString, ServerTypeCustom, exportOptions)
 	case urlString != "":
-		printConfig(urlString, ServerTypeInstituteAccess)
+		printConfig(urlString, ServerTypeInstituteAccess, exportOptions)
 	case secureInternetString != "":
-		printConfig(secureInternetString, ServerTypeSecureInternet)
+		printConfig(secureInternetString, ServerTypeSecureInternet, exportOptions)
 	default:
 		flag.PrintDefaults()
 	}
@@ -0,0 +1,152 @@
+package main
+
+/*
+// for free
+#include <stdlib.h>
+#include "error.h"
+
+// The struct for a config that was obtained with its session details
+typedef struct configResult {
+  const char* protocol;
+  const char* config;
+  const char* server_type;
+  const char* identifier;
+  const char* server_url;
+  const char* location;
+  const char* profile_id;
+  const char* profile_display_name;
+  int default_gateway;
+  unsigned long long int start_time;
+  unsigned long long int end_time;
+  // NULL for WireGuard configs and OpenVPN configs without an inline certificate
+  const char* certificate_subject;
+  unsigned long long int certificate_not_after;
+  // The mismatches between the config and the profile, one per line, empty if there are none
+  const char* warnings;
+} configResult;
+*/
+import "C"
+
+import (
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/eduvpn/eduvpn-common/client"
+)
+
+// Get the unix time as an unsigned long long, zero times are 0
+func getCUnixTime(t time.Time) C.ulonglong {
+	if t.IsZero() {
+		return C.ulonglong(0)
+	}
+	return C.ulonglong(t.Unix())
+}
+
+// Get the pointer to the C struct for the config result
+// We allocate the struct and all the strings inside it
+func getCPtrConfigResult(result *client.ConfigResult) *C.configResult {
+	cResult := (*C.configResult)(C.malloc(C.size_t(unsafe.Sizeof(C.configResult{}))))
+	cResult.protocol = C.CString(result.Protocol)
+	cResult.config = C.CString(result.Config)
+	cResult.server_type = C.CString(result.ServerType)
+	cResult.identifier = C.CString(result.Identifier)
+	cResult.server_url = C.CString(result.ServerURL)
+	cResult.location = C.CString(result.Location)
+	cResult.profile_id = C.CString(result.Profile.ID)
+	cResult.profile_display_name = C.CString(result.Profile.DisplayName)
+	if result.DefaultGateway {
+		cResult.default_gateway = C.int(1)
+	} else {
+		cResult.default_gateway = C.int(0)
+	}
+	cResult.start_time = getCUnixTime(result.StartTime)
+	cResult.end_time = getCUnixTime(result.EndTime)
+	cResult.certificate_subject = nil
+	cResult.certificate_not_after = C.ulonglong(0)
+	if result.Certificate != nil {
+		cResult.certificate_subject = C.CString(result.Certificate.Subject)
+		cResult.certificate_not_after = getCUnixTime(result.Certificate.NotAfter)
+	}
+	warnings := make([]string, len(result.Warnings))
+	for i, warning := range result.Warnings {
+		warnings[i] = warning.String()
+	}
+	cResult.warnings = C.CString(strings.Join(warnings, "\n"))
+	return cResult
+}
+
+// Function for freeing a config result
+// Gets the pointer to C struct
+//
+//export FreeConfigResult
+func FreeConfigResult(result *C.configResult) {
+	// Free strings
+	C.free(unsafe.Pointer(result.protocol))
+	C.free(unsafe.Pointer(result.config))
+	C.free(unsafe.Pointer(result.server_type))
+	C.free(unsafe.Pointer(result.identifier))
+	C.free(unsafe.Pointer(result.server_url))
+	C.free(unsafe.Pointer(result.location))
+	C.free(unsafe.Pointer(result.profile_id))
+	C.free(unsafe.Pointer(result.profile_display_name))
+	// free of NULL is a no-op
+	C.free(unsafe.Pointer(result.certificate_subject))
+	C.free(unsafe.Pointer(result.warnings))
+
+	// Free the struct itself
+	C.free(unsafe.Pointer(result))
+}
+
+// Get the C pointers for the config result and the error, the result is nil if there is an error
+func getConfigResult(result *client.ConfigResult, configErr error) (*C.configResult, *C.error) {
+	if configErr != nil {
+		return nil, getError(configErr)
+	}
+	return getCPtrConfigResult(result), nil
+}
+
+//export GetConfigSecureInternet
+func GetConfigSecureInternet(
+	name *C.char,
+	orgID *C.char,
+	preferTCP C.int,
+) (*C.configResult, *C.error) {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return nil, getError(stateErr)
+	}
+	preferTCPBool := preferTCP == 1
+	return getConfigResult(state.GetConfigSecureInternet(C.GoString(orgID), preferTCPBool))
+}
+
+//export GetConfigInstituteAccess
+func GetConfigInstituteAccess(
+	name *C.char,
+	url *C.char,
+	preferTCP C.int,
+) (*C.configResult, *C.error) {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return nil, getError(stateErr)
+	}
+	preferTCPBool := preferTCP == 1
+	return getConfigResult(state.GetConfigInstituteAccess(C.GoString(url), preferTCPBool))
+}
+
+//export GetConfigCustomServer
+func GetConfigCustomServer(
+	name *C.char,
+	url *C.char,
+	preferTCP C.int,
+) (*C.configResult, *C.error) {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return nil, getError(stateErr)
+	}
+	preferTCPBool := preferTCP == 1
+	return getConfigResult(state.GetConfigCustomServer(C.GoString(url), preferTCPBool))
+}
@@ -12,6 +12,8 @@ typedef struct error {
    errorLevel level;
    const char* traceback;
    const char* cause;
+   // code is a stable code that frontends can branch on, e.g. "profile_not_available", empty if there is none
+   const char* code;
 } error;
 
 #endif /* ERROR_H */
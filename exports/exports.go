@@ -10,14 +10,33 @@ static int call_callback(PythonCB callback, const char *name, int oldstate, int
 {
     return callback(name, oldstate, newstate, data);
 }
+
+typedef void (*ProgressCB)(const char* name, const char* step, const char* url, unsigned long long elapsed_ms);
+
+static void call_progress_callback(ProgressCB callback, const char *name, const char *step, const char *url, unsigned long long elapsed_ms)
+{
+    callback(name, step, url, elapsed_ms);
+}
+
+typedef void (*TraceCB)(const char* name, const char* span);
+
+static void call_trace_callback(TraceCB callback, const char *name, const char *span)
+{
+    callback(name, span);
+}
 */
 import "C"
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/eduvpn/eduvpn-common/client"
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
@@ -49,6 +68,18 @@ func GetStateData(
 		return (unsafe.Pointer)(getTransitionServer(state, data))
 	case client.StateConnected:
 		return (unsafe.Pointer)(getTransitionServer(state, data))
+	case client.StateError:
+		if converted, ok := data.(error); ok {
+			return (unsafe.Pointer)(getError(converted))
+		}
+	case client.StateAskMigration:
+		if converted, ok := data.(client.ServerMigration); ok {
+			migration, jsonErr := json.Marshal(converted)
+			if jsonErr != nil {
+				return nil
+			}
+			return (unsafe.Pointer)(C.CString(string(migration)))
+		}
 	default:
 		return nil
 	}
@@ -144,6 +175,7 @@ func getError(err error) *C.error {
 	errorStruct.level = C.errorLevel(types.ErrorLevel(err))
 	errorStruct.traceback = C.CString(types.ErrorTraceback(err))
 	errorStruct.cause = C.CString(types.ErrorCause(err).Error())
+	errorStruct.code = C.CString(string(types.ErrorCode(err)))
 	return errorStruct
 }
 
@@ -151,6 +183,7 @@ func getError(err error) *C.error {
 func FreeError(err *C.error) {
 	C.free(unsafe.Pointer(err.traceback))
 	C.free(unsafe.Pointer(err.cause))
+	C.free(unsafe.Pointer(err.code))
 	C.free(unsafe.Pointer(err))
 }
 
@@ -234,63 +267,28 @@ func RemoveCustomServer(name *C.char, url *C.char) *C.error {
 	return getError(removeErr)
 }
 
-//export GetConfigSecureInternet
-func GetConfigSecureInternet(
-	name *C.char,
-	orgID *C.char,
-	preferTCP C.int,
-) (*C.char, *C.char, *C.error) {
-	nameStr := C.GoString(name)
-	state, stateErr := GetVPNState(nameStr)
-	if stateErr != nil {
-		return nil, nil, getError(stateErr)
-	}
-	preferTCPBool := preferTCP == 1
-	config, configType, configErr := state.GetConfigSecureInternet(C.GoString(orgID), preferTCPBool)
-	return C.CString(config), C.CString(configType), getError(configErr)
-}
-
-//export GetConfigInstituteAccess
-func GetConfigInstituteAccess(
-	name *C.char,
-	url *C.char,
-	preferTCP C.int,
-) (*C.char, *C.char, *C.error) {
-	nameStr := C.GoString(name)
-	state, stateErr := GetVPNState(nameStr)
-	if stateErr != nil {
-		return nil, nil, getError(stateErr)
-	}
-	preferTCPBool := preferTCP == 1
-	config, configType, configErr := state.GetConfigInstituteAccess(C.GoString(url), preferTCPBool)
-	return C.CString(config), C.CString(configType), getError(configErr)
-}
-
-//export GetConfigCustomServer
-func GetConfigCustomServer(
-	name *C.char,
-	url *C.char,
-	preferTCP C.int,
-) (*C.char, *C.char, *C.error) {
+//export SetProfileID
+func SetProfileID(name *C.char, data *C.char) *C.error {
 	nameStr := C.GoString(name)
 	state, stateErr := GetVPNState(nameStr)
 	if stateErr != nil {
-		return nil, nil, getError(stateErr)
+		return getError(stateErr)
 	}
-	preferTCPBool := preferTCP == 1
-	config, configType, configErr := state.GetConfigCustomServer(C.GoString(url), preferTCPBool)
-	return C.CString(config), C.CString(configType), getError(configErr)
+	profileErr := state.SetProfileID(C.GoString(data))
+	return getError(profileErr)
 }
 
-//export SetProfileID
-func SetProfileID(name *C.char, data *C.char) *C.error {
+// ConfirmMigration answers the Ask_Migration state, accept 1 migrates the server to its new URL and 0 keeps the saved URL
+// The data of the state is a JSON object with the saved URL and the new URL, e.g. {"from": "https://vpn.example.org/", "to": "https://vpn.example.com/"}
+//
+//export ConfirmMigration
+func ConfirmMigration(name *C.char, accept C.int) *C.error {
 	nameStr := C.GoString(name)
 	state, stateErr := GetVPNState(nameStr)
 	if stateErr != nil {
 		return getError(stateErr)
 	}
-	profileErr := state.SetProfileID(C.GoString(data))
-	return getError(profileErr)
+	return getError(state.ConfirmMigration(accept == 1))
 }
 
 //export ChangeSecureLocation
@@ -392,6 +390,41 @@ func RenewSession(name *C.char) *C.error {
 	return getError(renewSessionErr)
 }
 
+//export HasSavedSession
+func HasSavedSession(name *C.char) C.int {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return C.int(0)
+	}
+	if state.SavedSession() != nil {
+		return C.int(1)
+	}
+	return C.int(0)
+}
+
+//export ResumeSession
+func ResumeSession(name *C.char, connected C.int) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	resumeErr := state.ResumeSession(int(connected) == 1)
+	return getError(resumeErr)
+}
+
+//export DiscardSession
+func DiscardSession(name *C.char) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	discardErr := state.DiscardSession()
+	return getError(discardErr)
+}
+
 //export ShouldRenewButton
 func ShouldRenewButton(name *C.char) C.int {
 	nameStr := C.GoString(name)
@@ -431,6 +464,285 @@ func SetSupportWireguard(name *C.char, support C.int) *C.error {
 	return nil
 }
 
+// SetWireGuardKeyPolicy sets whether WireGuard keys are reused for each server and profile
+// rotateAfter is the number of seconds after which a reused key is replaced, 0 means only when the server rejects it
+//
+//export SetWireGuardKeyPolicy
+func SetWireGuardKeyPolicy(name *C.char, reuse C.int, rotateAfter C.ulonglong) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	state.WireGuardKeyPolicy = client.WireGuardKeyPolicy{
+		Reuse:       reuse == 1,
+		RotateAfter: time.Duration(rotateAfter) * time.Second,
+	}
+	return nil
+}
+
+// SetConfigCachePolicy sets whether configurations are cached for each server and profile and reused instead of doing a new /connect
+// minRemaining is the number of seconds the session of a cached configuration must still be valid to reuse it
+//
+//export SetConfigCachePolicy
+func SetConfigCachePolicy(name *C.char, enabled C.int, minRemaining C.ulonglong) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	state.ConfigCachePolicy = client.ConfigCachePolicy{
+		Enabled:      enabled == 1,
+		MinRemaining: time.Duration(minRemaining) * time.Second,
+	}
+	return nil
+}
+
+// SetOpenVPNPolicy sets the rewrites that are applied to OpenVPN configs, the directives that could run code are always rejected
+// If managementAddress is empty no management interface is added, managementPort is 0 for a unix socket
+//
+//export SetOpenVPNPolicy
+func SetOpenVPNPolicy(
+	name *C.char,
+	managementAddress *C.char,
+	managementPort C.int,
+	removeScriptSecurity C.int,
+	remoteRandom C.int,
+	forceTCP C.int,
+) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	policy := client.OpenVPNPolicy{
+		RemoveScriptSecurity: removeScriptSecurity == 1,
+		RemoteRandom:         remoteRandom == 1,
+		ForceTCP:             forceTCP == 1,
+	}
+	if address := C.GoString(managementAddress); address != "" {
+		policy.Management = &client.OpenVPNManagement{Address: address, Port: int(managementPort)}
+	}
+	state.OpenVPNPolicy = policy
+	return nil
+}
+
+// SetHTTPOptions sets the options of the HTTP client that is used for discovery and all servers
+// rootCAsFile is a PEM file with the trusted certificate authorities, empty means the roots of the system
+// minTLSVersion is "1.2" or "1.3", empty means TLS 1.2
+//
+//export SetHTTPOptions
+func SetHTTPOptions(name *C.char, rootCAsFile *C.char, minTLSVersion *C.char) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	errorMessage := "failed setting the HTTP options"
+	options := state.HTTPOptions
+	minVersion, versionErr := httpw.ParseTLSVersion(C.GoString(minTLSVersion))
+	if versionErr != nil {
+		return getError(types.NewWrappedError(errorMessage, versionErr))
+	}
+	options.MinTLSVersion = minVersion
+	options.RootCAs = nil
+	if path := C.GoString(rootCAsFile); path != "" {
+		rootCAs, rootCAsErr := httpw.LoadRootCAs(path)
+		if rootCAsErr != nil {
+			return getError(types.NewWrappedError(errorMessage, rootCAsErr))
+		}
+		options.RootCAs = rootCAs
+	}
+	state.SetHTTPOptions(options)
+	return nil
+}
+
+// SetRetryPolicy sets how often and how long after a failed request it is retried
+// idempotentAttempts is the maximum number of attempts for GET requests, nonIdempotentAttempts for POST requests such as /connect
+// POST requests are only retried if the server certainly did not process them
+// baseDelay is the delay before the first retry in milliseconds, it doubles for each next retry up to maxDelay
+// Zero values mean the defaults and 1 attempt disables retrying
+//
+//export SetRetryPolicy
+func SetRetryPolicy(
+	name *C.char,
+	idempotentAttempts C.int,
+	nonIdempotentAttempts C.int,
+	baseDelay C.ulonglong,
+	maxDelay C.ulonglong,
+) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	options := state.HTTPOptions
+	options.Retry = client.HTTPRetryPolicy{
+		IdempotentAttempts:    int(idempotentAttempts),
+		NonIdempotentAttempts: int(nonIdempotentAttempts),
+		BaseDelay:             time.Duration(baseDelay) * time.Millisecond,
+		MaxDelay:              time.Duration(maxDelay) * time.Millisecond,
+	}
+	state.SetHTTPOptions(options)
+	return nil
+}
+
+// SetProxy sets the proxy that is used for discovery, well-known, token and API requests
+// proxyURL is a proxy for all requests, e.g. http://proxy.example.org:3128 or socks5://127.0.0.1:1080
+// environment 1 uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+// pacFile is a proxy auto-config script whose FindProxyForURL function chooses the proxy
+// At most one of them can be given, none means the environment variables are used like any Go program
+//
+//export SetProxy
+func SetProxy(name *C.char, proxyURL *C.char, environment C.int, pacFile *C.char) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	config := client.ProxyConfig{
+		URL:         C.GoString(proxyURL),
+		Environment: environment == 1,
+	}
+	if path := C.GoString(pacFile); path != "" {
+		script, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			return getError(types.NewWrappedError("failed reading the PAC file", readErr))
+		}
+		config.PAC = string(script)
+	}
+	return getError(state.SetProxy(config))
+}
+
+// SetAllowedOrigins sets the origins, besides the origin of a server, that its API and token endpoints and redirects can be on
+// origins are separated by newlines or commas, each in the form scheme://host[:port]
+// By default the endpoints must be on the origin of the server and use HTTPS, except for a server on the loopback address
+//
+//export SetAllowedOrigins
+func SetAllowedOrigins(name *C.char, origins *C.char) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	originList := strings.FieldsFunc(C.GoString(origins), func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+	return getError(state.SetAllowedOrigins(originList))
+}
+
+// SetServerPins sets the pinned public keys of the TLS certificate of the server with serverURL
+// pins are separated by newlines or commas, each a base64 encoded SHA-256 hash of the SubjectPublicKeyInfo, optionally prefixed with "sha256/"
+// The certificate chain must contain at least one of them, a backup pin allows rotating the keys. Empty pins remove them
+//
+//export SetServerPins
+func SetServerPins(name *C.char, serverURL *C.char, pins *C.char) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	pinList := strings.FieldsFunc(C.GoString(pins), func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+	return getError(state.SetServerPins(C.GoString(serverURL), pinList))
+}
+
+// SetPinPolicy sets whether the TLS public keys of servers without pins are learned when they are first authorized
+// learnOnFirstUse 1 pins such a server to the keys of its certificate and issuer
+//
+//export SetPinPolicy
+func SetPinPolicy(name *C.char, learnOnFirstUse C.int) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	state.PinPolicy = client.PinPolicy{LearnOnFirstUse: learnOnFirstUse == 1}
+	return nil
+}
+
+// SetConfigTransforms sets the transforms that are applied to the configs for the server with serverURL and the profile with profileID
+// An empty profileID sets the transforms for all profiles of the server, the transforms are saved in the state file
+// transforms is a JSON object, e.g. {"mtu": 1412, "dns_search": ["lan"], "exclude_routes": ["192.168.1.0/24"]}
+//
+//export SetConfigTransforms
+func SetConfigTransforms(name *C.char, serverURL *C.char, profileID *C.char, transforms *C.char) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	var configTransforms client.ConfigTransforms
+	jsonErr := json.Unmarshal([]byte(C.GoString(transforms)), &configTransforms)
+	if jsonErr != nil {
+		return getError(types.NewWrappedError("failed parsing the config transforms", jsonErr))
+	}
+	setErr := state.SetConfigTransforms(C.GoString(serverURL), C.GoString(profileID), configTransforms)
+	return getError(setErr)
+}
+
+// The progress events are forwarded to the callback with the step identifier, the server URL and the elapsed time in milliseconds
+// The strings are freed after the callback returns
+//
+//export SetProgressCallback
+func SetProgressCallback(name *C.char, progressCallback C.ProgressCB) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	if progressCallback == nil {
+		state.ProgressCallback = nil
+		return nil
+	}
+	state.ProgressCallback = func(event client.ProgressEvent) {
+		nameC := C.CString(nameStr)
+		stepC := C.CString(string(event.Step))
+		urlC := C.CString(event.URL)
+		C.call_progress_callback(
+			progressCallback,
+			nameC,
+			stepC,
+			urlC,
+			C.ulonglong(event.Elapsed.Milliseconds()),
+		)
+		C.free(unsafe.Pointer(nameC))
+		C.free(unsafe.Pointer(stepC))
+		C.free(unsafe.Pointer(urlC))
+	}
+	return nil
+}
+
+// SetTracing sets how the outbound HTTP and OAuth requests are traced
+// If log is 1, every request is logged at debug level with its timing
+// If traceCallback is not NULL, it gets every finished request as an OpenTelemetry-style span in JSON, the span is freed after the callback returns
+//
+//export SetTracing
+func SetTracing(name *C.char, log C.int, traceCallback C.TraceCB) *C.error {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return getError(stateErr)
+	}
+	if traceCallback == nil {
+		state.SetTracing(log == 1, nil)
+		return nil
+	}
+	state.SetTracing(log == 1, func(span client.TraceSpan) {
+		spanJSON, jsonErr := json.Marshal(span)
+		if jsonErr != nil {
+			return
+		}
+		nameC := C.CString(nameStr)
+		spanC := C.CString(string(spanJSON))
+		C.call_trace_callback(traceCallback, nameC, spanC)
+		C.free(unsafe.Pointer(nameC))
+		C.free(unsafe.Pointer(spanC))
+	})
+	return nil
+}
+
 //export FreeString
 func FreeString(addr *C.char) {
 	C.free(unsafe.Pointer(addr))
@@ -0,0 +1,97 @@
+package main
+
+/*
+// for free and size_t
+#include <stdlib.h>
+#include "error.h"
+
+// The struct for a single FSM transition in the history
+typedef struct fsmHistoryEntry {
+  unsigned long long int time;
+  int from;
+  int to;
+  const char* description;
+  int handled;
+  unsigned long long int duration;
+  const char* error;
+} fsmHistoryEntry;
+
+// The struct for the FSM history
+typedef struct fsmHistory {
+  fsmHistoryEntry** entries;
+  size_t total_entries;
+} fsmHistory;
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/eduvpn/eduvpn-common/client"
+)
+
+// Get the pointer to the C struct for a history entry
+// The time is in Unix nanoseconds and the duration in nanoseconds
+func getCPtrHistoryEntry(entry *client.FSMHistoryEntry) *C.fsmHistoryEntry {
+	cEntry := (*C.fsmHistoryEntry)(C.malloc(C.size_t(unsafe.Sizeof(C.fsmHistoryEntry{}))))
+	cEntry.time = C.ulonglong(entry.Time.UnixNano())
+	cEntry.from = C.int(entry.From)
+	cEntry.to = C.int(entry.To)
+	cEntry.description = C.CString(entry.Description)
+	if entry.Handled {
+		cEntry.handled = C.int(1)
+	} else {
+		cEntry.handled = C.int(0)
+	}
+	cEntry.duration = C.ulonglong(entry.Duration.Nanoseconds())
+	cEntry.error = C.CString(entry.Error)
+	return cEntry
+}
+
+// Get the pointer to the C struct for the history
+func getCPtrHistory(history []client.FSMHistoryEntry) *C.fsmHistory {
+	cHistory := (*C.fsmHistory)(C.malloc(C.size_t(unsafe.Sizeof(C.fsmHistory{}))))
+	totalEntries := C.size_t(len(history))
+	cHistory.entries = nil
+	cHistory.total_entries = totalEntries
+	if totalEntries > 0 {
+		entriesPtr := C.malloc(totalEntries * C.size_t(unsafe.Sizeof(uintptr(0))))
+		entries := (*[1<<30 - 1]*C.fsmHistoryEntry)(unsafe.Pointer(entriesPtr))[:totalEntries:totalEntries]
+		for i := range history {
+			entries[i] = getCPtrHistoryEntry(&history[i])
+		}
+		cHistory.entries = (**C.fsmHistoryEntry)(entriesPtr)
+	}
+	return cHistory
+}
+
+// Free the history by looping through the entries if there are any
+// Also free the pointer itself
+//
+//export FreeFSMHistory
+func FreeFSMHistory(history *C.fsmHistory) {
+	if history.total_entries > 0 {
+		entries := (*[1<<30 - 1]*C.fsmHistoryEntry)(unsafe.Pointer(history.entries))[:history.total_entries:history.total_entries]
+		for i := C.size_t(0); i < history.total_entries; i++ {
+			C.free(unsafe.Pointer(entries[i].description))
+			C.free(unsafe.Pointer(entries[i].error))
+			C.free(unsafe.Pointer(entries[i]))
+		}
+		C.free(unsafe.Pointer(history.entries))
+	}
+	C.free(unsafe.Pointer(history))
+}
+
+// Get the last FSM transitions, the oldest first
+// This function takes the name as input which is the name of the client
+// It returns the history as a C struct pointer and an error
+//
+//export GetFSMHistory
+func GetFSMHistory(name *C.char) (*C.fsmHistory, *C.error) {
+	nameStr := C.GoString(name)
+	state, stateErr := GetVPNState(nameStr)
+	if stateErr != nil {
+		return nil, getError(stateErr)
+	}
+	return getCPtrHistory(state.FSMHistory()), nil
+}
@@ -3,6 +3,7 @@ module github.com/eduvpn/eduvpn-common
 go 1.15
 
 require (
+	github.com/dop251/goja v0.0.0-20220806120448-1444e6b94559
 	github.com/jedisct1/go-minisign v0.0.0-20211028175153-1c139d1cc84b
 	golang.zx2c4.com/wireguard/wgctrl v0.0.0-20220916014741-473347a5e6e3
 )
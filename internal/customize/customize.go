@@ -0,0 +1,146 @@
+// Package customize implements hooks that customize the obtained OpenVPN and WireGuard configurations on the client
+// It also has declarative transforms for common tweaks that the server cannot provide, e.g. an MTU override
+package customize
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+)
+
+// Config is an obtained configuration that is customized by the hooks.
+// Exactly one of WireGuard and OpenVPN is set, the hooks modify it in place.
+type Config struct {
+	// ServerURL is the base URL of the server the configuration was obtained from
+	ServerURL string
+
+	// ProfileID is the profile the configuration was obtained for
+	ProfileID string
+
+	// WireGuard is the parsed WireGuard configuration, nil for OpenVPN
+	WireGuard *wireguard.Config
+
+	// OpenVPN is the parsed OpenVPN configuration, nil for WireGuard
+	OpenVPN *openvpn.Config
+}
+
+// Hook customizes an obtained configuration.
+type Hook interface {
+	// Customize modifies `config` in place, an error means that the configuration cannot be used
+	Customize(config *Config) error
+}
+
+// HookFunc is a function that is used as a hook.
+type HookFunc func(config *Config) error
+
+// Customize calls the function.
+func (f HookFunc) Customize(config *Config) error {
+	return f(config)
+}
+
+// Transforms are the declarative customizations for a server and profile.
+// They are saved in the state file.
+type Transforms struct {
+	// MTU overrides the MTU of the tunnel, e.g. 1412 for a PPPoE uplink, zero to keep the MTU of the server
+	MTU int `json:"mtu,omitempty"`
+
+	// DNSSearch are the search domains that are added to the configuration
+	DNSSearch []string `json:"dns_search,omitempty"`
+
+	// ExcludeRoutes are the networks that are not routed through the tunnel, e.g. the LAN subnet "192.168.1.0/24"
+	ExcludeRoutes []string `json:"exclude_routes,omitempty"`
+}
+
+// excludeRoutes parses the networks that are not routed through the tunnel.
+func (transforms Transforms) excludeRoutes() ([]net.IPNet, error) {
+	var networks []net.IPNet
+	for _, route := range transforms.ExcludeRoutes {
+		_, network, parseErr := net.ParseCIDR(route)
+		if parseErr != nil {
+			return nil, &TransformError{Message: fmt.Sprintf("invalid route to exclude: %s", route)}
+		}
+		networks = append(networks, *network)
+	}
+	return networks, nil
+}
+
+// Validate returns a *TransformError if the transforms cannot be applied.
+func (transforms Transforms) Validate() error {
+	if transforms.MTU < 0 {
+		return &TransformError{Message: fmt.Sprintf("invalid MTU: %d", transforms.MTU)}
+	}
+	_, routesErr := transforms.excludeRoutes()
+	return routesErr
+}
+
+// Customize applies the transforms to the WireGuard or OpenVPN configuration.
+func (transforms Transforms) Customize(config *Config) error {
+	if validateErr := transforms.Validate(); validateErr != nil {
+		return validateErr
+	}
+	// Already validated
+	excluded, _ := transforms.excludeRoutes()
+
+	if wg := config.WireGuard; wg != nil {
+		if transforms.MTU != 0 {
+			wg.Interface.MTU = transforms.MTU
+		}
+		wg.Interface.DNSSearch = append(wg.Interface.DNSSearch, transforms.DNSSearch...)
+		for i := range wg.Peers {
+			wg.Peers[i].AllowedIPs = Exclude(wg.Peers[i].AllowedIPs, excluded)
+		}
+	}
+
+	if ovpn := config.OpenVPN; ovpn != nil {
+		if transforms.MTU != 0 {
+			ovpn.Set("tun-mtu", strconv.Itoa(transforms.MTU))
+		}
+		for _, domain := range transforms.DNSSearch {
+			ovpn.Directives = append(ovpn.Directives, openvpn.Directive{
+				Name: "dhcp-option",
+				Args: []string{"DOMAIN-SEARCH", domain},
+			})
+		}
+		// Route the networks through the gateway of the client instead of the tunnel
+		for _, network := range excluded {
+			directive := openvpn.Directive{
+				Name: "route-ipv6",
+				Args: []string{network.String(), "net_gateway"},
+			}
+			if ip4 := network.IP.To4(); ip4 != nil {
+				directive = openvpn.Directive{
+					Name: "route",
+					Args: []string{ip4.String(), net.IP(network.Mask).String(), "net_gateway"},
+				}
+			}
+			ovpn.Directives = append(ovpn.Directives, directive)
+		}
+	}
+	return nil
+}
+
+// Apply applies the `hooks` in order to `config`.
+func Apply(config *Config, hooks ...Hook) error {
+	for _, hook := range hooks {
+		if hook == nil {
+			continue
+		}
+		if customizeErr := hook.Customize(config); customizeErr != nil {
+			return customizeErr
+		}
+	}
+	return nil
+}
+
+// TransformError is returned when transforms cannot be applied.
+type TransformError struct {
+	// Message is the reason the transforms cannot be applied
+	Message string
+}
+
+func (e *TransformError) Error() string {
+	return fmt.Sprintf("failed applying config transforms: %s", e.Message)
+}
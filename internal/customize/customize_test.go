@@ -0,0 +1,147 @@
+package customize
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+)
+
+func parseNetworks(t *testing.T, cidrs ...string) []net.IPNet {
+	t.Helper()
+	var networks []net.IPNet
+	for _, cidr := range cidrs {
+		_, network, parseErr := net.ParseCIDR(cidr)
+		if parseErr != nil {
+			t.Fatalf("Got parse error: %v", parseErr)
+		}
+		networks = append(networks, *network)
+	}
+	return networks
+}
+
+func networkStrings(networks []net.IPNet) []string {
+	var strings []string
+	for _, network := range networks {
+		strings = append(strings, network.String())
+	}
+	return strings
+}
+
+func TestExclude(t *testing.T) {
+	tests := []struct {
+		networks []string
+		excluded []string
+		want     []string
+	}{
+		{[]string{"0.0.0.0/0"}, []string{"128.0.0.0/1"}, []string{"0.0.0.0/1"}},
+		{[]string{"10.0.0.0/8"}, []string{"192.168.1.0/24"}, []string{"10.0.0.0/8"}},
+		{[]string{"10.0.0.0/8"}, []string{"0.0.0.0/0"}, nil},
+		{[]string{"10.0.0.0/30"}, []string{"10.0.0.1/32"}, []string{"10.0.0.0/32", "10.0.0.2/31"}},
+		{[]string{"::/0", "10.0.0.0/8"}, []string{"10.0.0.0/9"}, []string{"::/0", "10.128.0.0/9"}},
+		{[]string{"fd00::/63"}, []string{"fd00::/64"}, []string{"fd00:0:0:1::/64"}},
+	}
+	for _, test := range tests {
+		got := networkStrings(Exclude(parseNetworks(t, test.networks...), parseNetworks(t, test.excluded...)))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("Got: %v for %v without %v, want: %v", got, test.networks, test.excluded, test.want)
+		}
+	}
+
+	// Excluding a /24 from the default route gives a route for every other part of the address space
+	got := Exclude(parseNetworks(t, "0.0.0.0/0"), parseNetworks(t, "192.168.1.0/24"))
+	if len(got) != 24 {
+		t.Fatalf("Got %d networks: %v, want 24", len(got), networkStrings(got))
+	}
+	for _, network := range got {
+		if network.Contains(net.ParseIP("192.168.1.10")) {
+			t.Fatalf("Got network: %s that contains the excluded network", network.String())
+		}
+	}
+}
+
+func TestTransformsWireGuard(t *testing.T) {
+	parsed, parseErr := wireguard.Parse(`[Interface]
+Address = 10.10.10.2/24
+DNS = 9.9.9.9, example.org
+MTU = 1420
+
+[Peer]
+PublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=
+AllowedIPs = 0.0.0.0/1, 128.0.0.0/1
+Endpoint = vpn.example.org:51820
+`)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	transforms := Transforms{MTU: 1412, DNSSearch: []string{"lan"}, ExcludeRoutes: []string{"192.168.0.0/16"}}
+	if applyErr := Apply(&Config{WireGuard: parsed}, transforms); applyErr != nil {
+		t.Fatalf("Got apply error: %v", applyErr)
+	}
+	if parsed.Interface.MTU != 1412 {
+		t.Fatalf("Got MTU: %d, want: 1412", parsed.Interface.MTU)
+	}
+	if want := []string{"example.org", "lan"}; !reflect.DeepEqual(parsed.Interface.DNSSearch, want) {
+		t.Fatalf("Got search domains: %v, want: %v", parsed.Interface.DNSSearch, want)
+	}
+	for _, network := range parsed.Peers[0].AllowedIPs {
+		if network.Contains(net.ParseIP("192.168.1.10")) {
+			t.Fatalf("Got allowed IP: %s that contains the LAN", network.String())
+		}
+	}
+	if !reflect.DeepEqual(parsed.Peers[0].AllowedIPs[0], parseNetworks(t, "0.0.0.0/1")[0]) {
+		t.Fatalf("Got allowed IPs: %v, want the first half unchanged", networkStrings(parsed.Peers[0].AllowedIPs))
+	}
+}
+
+func TestTransformsOpenVPN(t *testing.T) {
+	parsed, parseErr := openvpn.Parse("dev tun\ntun-mtu 1500\nremote vpn.example.org 1194 udp\n")
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	transforms := Transforms{MTU: 1412, DNSSearch: []string{"lan"}, ExcludeRoutes: []string{"192.168.1.0/24", "fd00::/64"}}
+	if applyErr := Apply(&Config{OpenVPN: parsed}, transforms); applyErr != nil {
+		t.Fatalf("Got apply error: %v", applyErr)
+	}
+	want := "dev tun\n" +
+		"tun-mtu 1412\n" +
+		"remote vpn.example.org 1194 udp\n" +
+		"dhcp-option DOMAIN-SEARCH lan\n" +
+		"route 192.168.1.0 255.255.255.0 net_gateway\n" +
+		"route-ipv6 fd00::/64 net_gateway\n"
+	if got := parsed.String(); got != want {
+		t.Fatalf("Got config:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestApplyHooks(t *testing.T) {
+	var order []string
+	hook := func(name string) Hook {
+		return HookFunc(func(config *Config) error {
+			order = append(order, name+":"+config.ProfileID)
+			return nil
+		})
+	}
+	config := &Config{ProfileID: "internet", OpenVPN: &openvpn.Config{}}
+	if applyErr := Apply(config, hook("first"), nil, hook("second")); applyErr != nil {
+		t.Fatalf("Got apply error: %v", applyErr)
+	}
+	if want := []string{"first:internet", "second:internet"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("Got hook order: %v, want: %v", order, want)
+	}
+
+	// Invalid transforms are an error
+	for _, transforms := range []Transforms{{ExcludeRoutes: []string{"192.168.1.0"}}, {MTU: -1}} {
+		applyErr := Apply(config, transforms)
+		var transformErr *TransformError
+		if !errors.As(applyErr, &transformErr) {
+			t.Fatalf("Got error: %v for transforms: %+v, want a transform error", applyErr, transforms)
+		}
+		if validateErr := transforms.Validate(); !errors.As(validateErr, &transformErr) {
+			t.Fatalf("Got validate error: %v for transforms: %+v, want a transform error", validateErr, transforms)
+		}
+	}
+}
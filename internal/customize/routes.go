@@ -0,0 +1,57 @@
+package customize
+
+import (
+	"net"
+)
+
+// normalize returns the network with a 4 byte IP for IPv4 and with the host bits cleared.
+func normalize(network net.IPNet) net.IPNet {
+	ip := network.IP
+	if ip4 := ip.To4(); ip4 != nil && len(network.Mask) == net.IPv4len {
+		ip = ip4
+	}
+	return net.IPNet{IP: ip.Mask(network.Mask), Mask: network.Mask}
+}
+
+// excludeNetwork returns the networks that cover `network` without `excluded`.
+// The networks are split in halves until the excluded part is removed, e.g. 0.0.0.0/0 without 128.0.0.0/1 is 0.0.0.0/1.
+func excludeNetwork(network net.IPNet, excluded net.IPNet) []net.IPNet {
+	// Different address families
+	if len(network.IP) != len(excluded.IP) {
+		return []net.IPNet{network}
+	}
+	ones, bits := network.Mask.Size()
+	excludedOnes, _ := excluded.Mask.Size()
+
+	// The excluded network covers the whole network
+	if excludedOnes <= ones && excluded.Contains(network.IP) {
+		return nil
+	}
+	// No overlap
+	if !network.Contains(excluded.IP) {
+		return []net.IPNet{network}
+	}
+
+	// Split the network in two halves and exclude from both
+	mask := net.CIDRMask(ones+1, bits)
+	lower := net.IPNet{IP: network.IP, Mask: mask}
+	upperIP := make(net.IP, len(network.IP))
+	copy(upperIP, network.IP)
+	upperIP[ones/8] |= 0x80 >> uint(ones%8)
+	upper := net.IPNet{IP: upperIP, Mask: mask}
+	return append(excludeNetwork(lower, excluded), excludeNetwork(upper, excluded)...)
+}
+
+// Exclude returns the networks that cover `networks` without the `excluded` networks.
+func Exclude(networks []net.IPNet, excluded []net.IPNet) []net.IPNet {
+	result := networks
+	for _, exclude := range excluded {
+		exclude = normalize(exclude)
+		var next []net.IPNet
+		for _, network := range result {
+			next = append(next, excludeNetwork(normalize(network), exclude)...)
+		}
+		result = next
+	}
+	return result
+}
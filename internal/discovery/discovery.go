@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/internal/verify"
 	"github.com/eduvpn/eduvpn-common/types"
 )
@@ -18,16 +19,25 @@ type Discovery struct {
 
 	// servers represents the servers that are returned by the discovery server
 	servers types.DiscoveryServers
+
+	// Progress is called with the progress of fetching the discovery files
+	Progress progress.Callback `json:"-"`
+
+	// HTTPClient is the client that fetches the discovery files, nil means the shared default client
+	HTTPClient *http.Client `json:"-"`
 }
 
+// discoURL is the URL of the discovery server.
+const discoURL = "https://disco.eduvpn.org/v2/"
+
 // discoFile is a helper function that gets a disco JSON and fills the structure with it
+// The file and signature can come from the HTTP cache, the signature is verified every time such that cached content is not trusted blindly
 // If it was unsuccessful it returns an error.
-func discoFile(jsonFile string, previousVersion uint64, structure interface{}) error {
+func (discovery *Discovery) discoFile(jsonFile string, previousVersion uint64, structure interface{}) error {
 	errorMessage := fmt.Sprintf("failed getting file: %s from the Discovery server", jsonFile)
 	// Get json data
-	discoURL := "https://disco.eduvpn.org/v2/"
 	fileURL := discoURL + jsonFile
-	_, fileBody, fileErr := http.Get(fileURL)
+	_, fileBody, fileErr := discovery.HTTPClient.GetCached(fileURL)
 
 	if fileErr != nil {
 		return types.NewWrappedError(errorMessage, fileErr)
@@ -36,7 +46,7 @@ func discoFile(jsonFile string, previousVersion uint64, structure interface{}) e
 	// Get signature
 	sigFile := jsonFile + ".minisig"
 	sigURL := discoURL + sigFile
-	_, sigBody, sigFileErr := http.Get(sigURL)
+	_, sigBody, sigFileErr := discovery.HTTPClient.GetCached(sigURL)
 
 	if sigFileErr != nil {
 		return types.NewWrappedError(errorMessage, sigFileErr)
@@ -184,7 +194,9 @@ func (discovery *Discovery) Organizations() (*types.DiscoveryOrganizations, erro
 		return &discovery.organizations, nil
 	}
 	file := "organization_list.json"
-	bodyErr := discoFile(file, discovery.organizations.Version, &discovery.organizations)
+	tracker := progress.New(discovery.Progress, discoURL+file)
+	tracker.Step(progress.StepDiscoveryOrganizations)
+	bodyErr := discovery.discoFile(file, discovery.organizations.Version, &discovery.organizations)
 	if bodyErr != nil {
 		// Return previous with an error
 		return &discovery.organizations, types.NewWrappedError(
@@ -193,6 +205,7 @@ func (discovery *Discovery) Organizations() (*types.DiscoveryOrganizations, erro
 		)
 	}
 	discovery.organizations.Timestamp = time.Now()
+	tracker.Done()
 	return &discovery.organizations, nil
 }
 
@@ -203,7 +216,9 @@ func (discovery *Discovery) Servers() (*types.DiscoveryServers, error) {
 		return &discovery.servers, nil
 	}
 	file := "server_list.json"
-	bodyErr := discoFile(file, discovery.servers.Version, &discovery.servers)
+	tracker := progress.New(discovery.Progress, discoURL+file)
+	tracker.Step(progress.StepDiscoveryServers)
+	bodyErr := discovery.discoFile(file, discovery.servers.Version, &discovery.servers)
 	if bodyErr != nil {
 		// Return previous with an error
 		return &discovery.servers, types.NewWrappedError(
@@ -213,6 +228,7 @@ func (discovery *Discovery) Servers() (*types.DiscoveryServers, error) {
 	}
 	// Update servers timestamp
 	discovery.servers.Timestamp = time.Now()
+	tracker.Done()
 	return &discovery.servers, nil
 }
 
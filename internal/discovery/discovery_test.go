@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"errors"
+	"io/ioutil"
+	nethttp "net/http"
+	"strings"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/verify"
+)
+
+// roundTripFunc is a function that is used as a round tripper.
+type roundTripFunc func(*nethttp.Request) (*nethttp.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *nethttp.Request) (*nethttp.Response, error) {
+	return f(req)
+}
+
+func TestCachedFilesAreVerified(t *testing.T) {
+	cache := http.NewCache(t.TempDir())
+	for _, file := range []string{"server_list.json", "server_list.json.minisig"} {
+		setErr := cache.Set(http.CacheEntry{URL: discoURL + file, ETag: `"tampered"`, Body: []byte("tampered")})
+		if setErr != nil {
+			t.Fatalf("Got cache error: %v", setErr)
+		}
+	}
+
+	responses := map[string]func(req *nethttp.Request) (*nethttp.Response, error){
+		// The server says the cached files are still valid
+		"not modified": func(req *nethttp.Request) (*nethttp.Response, error) {
+			return &nethttp.Response{
+				StatusCode: nethttp.StatusNotModified,
+				Header:     nethttp.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+				Request:    req,
+			}, nil
+		},
+		// The network is down
+		"offline": func(req *nethttp.Request) (*nethttp.Response, error) {
+			return nil, errors.New("network is down")
+		},
+	}
+	for name, response := range responses {
+		discovery := &Discovery{HTTPClient: http.NewClient(http.Options{
+			Cache:     cache,
+			Retry:     http.RetryPolicy{IdempotentAttempts: 1},
+			Transport: roundTripFunc(response),
+		})}
+		_, serversErr := discovery.Servers()
+		var sigErr *verify.InvalidSignatureFormatError
+		if !errors.As(serversErr, &sigErr) {
+			t.Fatalf("Got error: %v for %s, want the cached signature to be verified", serversErr, name)
+		}
+	}
+}
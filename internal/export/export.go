@@ -0,0 +1,108 @@
+// Package export implements exporting the obtained OpenVPN and WireGuard configurations for the network managers of the operating system
+//
+// The WireGuard exports put the private and preshared keys in separate files that are only readable by the owner,
+// the other files do not contain secrets.
+package export
+
+import (
+	"crypto/sha1" //nolint:gosec // SHA-1 is mandated for name based (version 5) UUIDs, it is not used for security
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/eduvpn/eduvpn-common/internal/util"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// Identity identifies the server and profile that a configuration was obtained for.
+// The exported connections are named after it such that the same server and profile always give the same connection.
+type Identity struct {
+	// ServerURL is the base URL of the server
+	ServerURL string
+
+	// ProfileID is the ID of the profile
+	ProfileID string
+}
+
+// namespace is the namespace for the connection UUIDs, this is the UUID namespace for URLs from RFC 4122.
+var namespace = [16]byte{
+	0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1,
+	0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
+}
+
+// UUID returns a name based (version 5) UUID for the server and profile.
+func (identity Identity) UUID() string {
+	hash := sha1.New() //nolint:gosec
+	hash.Write(namespace[:])
+	hash.Write([]byte(identity.ServerURL + "#" + identity.ProfileID))
+	sum := hash.Sum(nil)[:16]
+	// Set the version (5) and the variant (RFC 4122)
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// ID returns a human readable ID for the connection, e.g. "vpn.example.org (internet)".
+func (identity Identity) ID() string {
+	host := identity.ServerURL
+	if parsed, parseErr := url.Parse(identity.ServerURL); parseErr == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return fmt.Sprintf("%s (%s)", host, identity.ProfileID)
+}
+
+// File is a file that is part of an exported connection.
+type File struct {
+	// Path is the path of the file
+	Path string
+
+	// Content is the content of the file
+	Content string
+
+	// Mode are the permissions of the file, files with secrets are only readable by the owner
+	Mode os.FileMode
+}
+
+// Write writes the file, creating the directory if it does not exist.
+func (file File) Write() error {
+	errorMessage := fmt.Sprintf("failed writing exported file: %s", file.Path)
+	dirErr := util.EnsureDirectory(filepath.Dir(file.Path))
+	if dirErr != nil {
+		return types.NewWrappedError(errorMessage, dirErr)
+	}
+	writeErr := ioutil.WriteFile(file.Path, []byte(file.Content), file.Mode)
+	if writeErr != nil {
+		return types.NewWrappedError(errorMessage, writeErr)
+	}
+	// WriteFile does not change the permissions of an existing file
+	chmodErr := os.Chmod(file.Path, file.Mode)
+	if chmodErr != nil {
+		return types.NewWrappedError(errorMessage, chmodErr)
+	}
+	return nil
+}
+
+// WriteFiles writes all `files`.
+func WriteFiles(files []File) error {
+	for _, file := range files {
+		if writeErr := file.Write(); writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+// UnsupportedError is returned when a configuration cannot be exported.
+type UnsupportedError struct {
+	// Target is the format the configuration is exported to
+	Target string
+
+	// Reason is why the configuration cannot be exported
+	Reason string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("failed exporting config to %s: %s", e.Target, e.Reason)
+}
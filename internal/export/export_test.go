@@ -0,0 +1,263 @@
+package export
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in test_data")
+
+var testIdentity = Identity{ServerURL: "https://vpn.example.org/", ProfileID: "internet"}
+
+// readTestData reads the file with `name` from the test data directory.
+func readTestData(t *testing.T, name string) string {
+	t.Helper()
+	contents, readErr := ioutil.ReadFile(filepath.Join("test_data", name))
+	if readErr != nil {
+		t.Fatalf("Failed reading test data %s: %v", name, readErr)
+	}
+	return string(contents)
+}
+
+// golden compares `got` with the golden file with `name`, the golden file is written with -update.
+func golden(t *testing.T, name string, got string) {
+	t.Helper()
+	if *update {
+		writeErr := ioutil.WriteFile(filepath.Join("test_data", name), []byte(got), 0o644)
+		if writeErr != nil {
+			t.Fatalf("Failed updating golden file %s: %v", name, writeErr)
+		}
+		return
+	}
+	if want := readTestData(t, name); got != want {
+		t.Fatalf("Got %s:\n%s\nWant:\n%s", name, got, want)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	if got := testIdentity.ID(); got != "vpn.example.org (internet)" {
+		t.Fatalf("Got ID: %s, want: vpn.example.org (internet)", got)
+	}
+	uuid := testIdentity.UUID()
+	if uuid != testIdentity.UUID() {
+		t.Fatalf("Got a different UUID for the same identity")
+	}
+	if len(uuid) != 36 || uuid[14] != '5' {
+		t.Fatalf("Got UUID: %s, want a version 5 UUID", uuid)
+	}
+	other := Identity{ServerURL: testIdentity.ServerURL, ProfileID: "employees"}
+	if other.UUID() == uuid {
+		t.Fatalf("Got the same UUID for a different profile")
+	}
+}
+
+func TestNetworkManagerWireGuard(t *testing.T) {
+	connection, exportErr := NetworkManager(
+		readTestData(t, "wireguard.conf"),
+		"wireguard",
+		testIdentity,
+		NetworkManagerOptions{InterfaceName: "eduvpn0"},
+	)
+	if exportErr != nil {
+		t.Fatalf("Got export error: %v", exportErr)
+	}
+	if len(connection.Files) != 0 {
+		t.Fatalf("Got files: %v, want none for WireGuard", connection.Files)
+	}
+	golden(t, "wireguard.nmconnection", connection.Keyfile)
+}
+
+func TestNetworkManagerOpenVPN(t *testing.T) {
+	directory := "/etc/eduvpn"
+	connection, exportErr := NetworkManager(
+		readTestData(t, "openvpn.ovpn"),
+		"openvpn",
+		testIdentity,
+		NetworkManagerOptions{Directory: directory},
+	)
+	if exportErr != nil {
+		t.Fatalf("Got export error: %v", exportErr)
+	}
+	golden(t, "openvpn.nmconnection", connection.Keyfile)
+
+	wantFiles := map[string]os.FileMode{"ca": 0o644, "cert": 0o644, "key": 0o600, "tls-crypt": 0o600}
+	if len(connection.Files) != len(wantFiles) {
+		t.Fatalf("Got files: %v, want: %v", connection.Files, wantFiles)
+	}
+	for _, file := range connection.Files {
+		found := false
+		for block, mode := range wantFiles {
+			if file.Path == filepath.Join(directory, connection.UUID+"-"+block+".pem") {
+				found = true
+				if file.Mode != mode {
+					t.Fatalf("Got mode: %v for %s, want: %v", file.Mode, file.Path, mode)
+				}
+			}
+		}
+		if !found {
+			t.Fatalf("Got unexpected file: %s", file.Path)
+		}
+	}
+
+	// Writing the connection gives a keyfile that only the owner can read
+	connection.Files = nil
+	keyfileDirectory := t.TempDir()
+	if writeErr := connection.Write(keyfileDirectory); writeErr != nil {
+		t.Fatalf("Got write error: %v", writeErr)
+	}
+	stat, statErr := os.Stat(filepath.Join(keyfileDirectory, connection.KeyfileName()))
+	if statErr != nil {
+		t.Fatalf("Got stat error: %v", statErr)
+	}
+	if stat.Mode().Perm() != 0o600 {
+		t.Fatalf("Got keyfile permissions: %v, want: 0600", stat.Mode().Perm())
+	}
+}
+
+func TestNetworkManagerSameConnection(t *testing.T) {
+	// Both protocols replace the same connection for the server and profile
+	var uuids []string
+	for _, test := range []struct{ file, configType string }{{"wireguard.conf", "wireguard"}, {"openvpn.ovpn", "openvpn"}} {
+		connection, exportErr := NetworkManager(
+			readTestData(t, test.file),
+			test.configType,
+			testIdentity,
+			NetworkManagerOptions{Directory: "/etc/eduvpn"},
+		)
+		if exportErr != nil {
+			t.Fatalf("Got export error for %s: %v", test.configType, exportErr)
+		}
+		uuids = append(uuids, connection.UUID)
+	}
+	if uuids[0] != uuids[1] || uuids[0] != testIdentity.UUID() {
+		t.Fatalf("Got UUIDs: %v, want: %s for both", uuids, testIdentity.UUID())
+	}
+}
+
+func TestNetworkManagerUnsupported(t *testing.T) {
+	tests := []struct {
+		config     string
+		configType string
+		options    NetworkManagerOptions
+	}{
+		{readTestData(t, "openvpn.ovpn"), "openvpn", NetworkManagerOptions{Directory: "relative"}},
+		{"dev tun\n", "openvpn", NetworkManagerOptions{Directory: "/etc/eduvpn"}},
+		{"remote vpn.example.org 1194 sctp\n", "openvpn", NetworkManagerOptions{Directory: "/etc/eduvpn"}},
+		{"[Interface]\nAddress = 10.10.10.2/24\n", "wireguard", NetworkManagerOptions{}},
+		{"", "ipsec", NetworkManagerOptions{}},
+	}
+	for _, test := range tests {
+		if _, exportErr := NetworkManager(test.config, test.configType, testIdentity, test.options); exportErr == nil {
+			t.Fatalf("Got no error for config: %s with type: %s", test.config, test.configType)
+		}
+	}
+}
+
+func TestNetworkd(t *testing.T) {
+	tests := []struct {
+		name    string
+		options WireGuardOptions
+	}{
+		{"default", WireGuardOptions{Directory: "/etc/systemd/network", InterfaceName: "eduvpn0"}},
+		{"main-table-search-domains", WireGuardOptions{
+			Directory:     "/etc/systemd/network",
+			InterfaceName: "eduvpn0",
+			RouteTable:    "main",
+			DNS:           DNSSearchDomains,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			export, exportErr := Networkd(readTestData(t, "wireguard.conf"), test.options)
+			if exportErr != nil {
+				t.Fatalf("Got export error: %v", exportErr)
+			}
+			if len(export.Files) != 2 {
+				t.Fatalf("Got files: %v, want a .netdev and a .network", export.Files)
+			}
+			for _, file := range export.Files {
+				golden(t, test.name+"-"+filepath.Base(file.Path), file.Content)
+			}
+			if test.options.RouteTable == "" {
+				checkRulePriorities(t, export.Files[1].Content)
+			}
+			if len(export.Secrets) != 2 {
+				t.Fatalf("Got secrets: %v, want a private and a preshared key", export.Secrets)
+			}
+			for _, secret := range export.Secrets {
+				if secret.Mode != 0o600 {
+					t.Fatalf("Got mode: %v for secret %s, want: 0600", secret.Mode, secret.Path)
+				}
+			}
+		})
+	}
+}
+
+// checkRulePriorities checks that the .network file `network` suppresses the default route of the main table before the tunnel table is used.
+func checkRulePriorities(t *testing.T, network string) {
+	t.Helper()
+	priorities := make(map[string]int)
+	for _, rule := range strings.Split(network, "[RoutingPolicyRule]")[1:] {
+		match := regexp.MustCompile(`Priority=(\d+)`).FindStringSubmatch(rule)
+		if match == nil {
+			t.Fatalf("Got a routing policy rule without priority:%s", rule)
+		}
+		priority, _ := strconv.Atoi(match[1])
+		if strings.Contains(rule, "SuppressPrefixLength=0") {
+			priorities["suppress"] = priority
+		} else {
+			priorities["table"] = priority
+		}
+	}
+	if len(priorities) != 2 || priorities["suppress"] >= priorities["table"] {
+		t.Fatalf("Got priorities: %v, want the suppress rule before the table rule", priorities)
+	}
+}
+
+func TestWGQuick(t *testing.T) {
+	options := WireGuardOptions{Directory: t.TempDir(), InterfaceName: "eduvpn0", RouteTable: "1234", DNS: DNSIgnore}
+	export, exportErr := WGQuick(readTestData(t, "wireguard.conf"), options)
+	if exportErr != nil {
+		t.Fatalf("Got export error: %v", exportErr)
+	}
+	if writeErr := export.Write(); writeErr != nil {
+		t.Fatalf("Got write error: %v", writeErr)
+	}
+
+	// The config has no secrets and no hooks from the server, only the hooks to set the keys
+	config := readTestData(t, "wireguard.conf")
+	got := strings.ReplaceAll(export.Files[0].Content, options.Directory, "/etc/wireguard")
+	golden(t, "eduvpn0.conf", got)
+	for _, secret := range export.Secrets {
+		stat, statErr := os.Stat(secret.Path)
+		if statErr != nil {
+			t.Fatalf("Got stat error: %v", statErr)
+		}
+		if stat.Mode().Perm() != 0o600 {
+			t.Fatalf("Got permissions: %v for %s, want: 0600", stat.Mode().Perm(), secret.Path)
+		}
+		if strings.Contains(export.Files[0].Content, strings.TrimSpace(secret.Content)) {
+			t.Fatalf("Got secret %s in the config", secret.Path)
+		}
+		if !strings.Contains(config, strings.TrimSpace(secret.Content)) {
+			t.Fatalf("Got secret %s that is not in the original config", secret.Path)
+		}
+	}
+
+	if _, searchErr := WGQuick(config, WireGuardOptions{
+		Directory:     "/etc/wireguard",
+		InterfaceName: "eduvpn0",
+		DNS:           DNSSearchDomains,
+	}); searchErr == nil {
+		t.Fatalf("Got no error for limiting DNS to the search domains with wg-quick")
+	}
+	if _, relativeErr := WGQuick(config, WireGuardOptions{Directory: "wireguard", InterfaceName: "eduvpn0"}); relativeErr == nil {
+		t.Fatalf("Got no error for a relative directory")
+	}
+}
@@ -0,0 +1,155 @@
+package export
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// The priorities of the routing policy rules, these are the same as wg-quick gives them by default.
+// The rule that suppresses the default route of the main table is looked up before the rule for the tunnel table.
+const (
+	suppressPriority = 32764
+	tablePriority    = 32765
+)
+
+// networkdTable returns the route table for systemd-networkd.
+func networkdTable(config *wireguard.Config, options WireGuardOptions) string {
+	if options.RouteTable != "" {
+		return options.RouteTable
+	}
+	// Routing all traffic in the main table would also route the WireGuard packets to the endpoint through the tunnel
+	if routesAll(config) {
+		return strconv.Itoa(defaultTable)
+	}
+	return "main"
+}
+
+// networkdNetDev returns the .netdev file that creates the WireGuard interface with its peers.
+func networkdNetDev(
+	config *wireguard.Config,
+	options WireGuardOptions,
+	table string,
+	privateKey File,
+	presharedKeys map[int]File,
+) string {
+	file := &keyfile{}
+	netdev := file.group("NetDev")
+	netdev.set("Name", options.InterfaceName)
+	netdev.set("Kind", "wireguard")
+	if config.Interface.MTU != 0 {
+		netdev.set("MTUBytes", strconv.Itoa(config.Interface.MTU))
+	}
+
+	wg := file.group("WireGuard")
+	wg.set("PrivateKeyFile", privateKey.Path)
+	if config.Interface.ListenPort != 0 {
+		wg.set("ListenPort", strconv.Itoa(config.Interface.ListenPort))
+	}
+	wg.set("RouteTable", table)
+	if table != "main" && table != "off" {
+		wg.set("FirewallMark", firewallMark(table))
+	}
+
+	for i, peer := range config.Peers {
+		group := file.group("WireGuardPeer")
+		group.set("PublicKey", peer.PublicKey.String())
+		if presharedKey, ok := presharedKeys[i]; ok {
+			group.set("PresharedKeyFile", presharedKey.Path)
+		}
+		var allowedIPs []string
+		for _, allowedIP := range peer.AllowedIPs {
+			allowedIPs = append(allowedIPs, allowedIP.String())
+		}
+		group.set("AllowedIPs", strings.Join(allowedIPs, ", "))
+		group.set("Endpoint", peer.Endpoint)
+		if peer.PersistentKeepalive != 0 {
+			group.set("PersistentKeepalive", strconv.Itoa(peer.PersistentKeepalive))
+		}
+	}
+	return file.String()
+}
+
+// networkdNetwork returns the .network file that configures the addresses, DNS and routing policy of the interface.
+func networkdNetwork(config *wireguard.Config, options WireGuardOptions, table string) string {
+	file := &keyfile{}
+	file.group("Match").set("Name", options.InterfaceName)
+
+	network := file.group("Network")
+	for _, address := range config.Interface.Addresses {
+		network.set("Address", address.String())
+	}
+	if options.DNS != DNSIgnore {
+		for _, dns := range config.Interface.DNS {
+			network.set("DNS", dns.String())
+		}
+		domains := append([]string(nil), config.Interface.DNSSearch...)
+		if options.DNS == DNSDefault && len(config.Interface.DNS) > 0 {
+			// Use the DNS servers of the tunnel for all domains
+			domains = append(domains, "~.")
+			network.set("DNSDefaultRoute", "yes")
+		} else {
+			network.set("DNSDefaultRoute", "no")
+		}
+		network.set("Domains", strings.Join(domains, " "))
+	}
+
+	// Traffic that is not from WireGuard itself uses the tunnel table, like wg-quick does
+	if table != "main" && table != "off" {
+		rule := file.group("RoutingPolicyRule")
+		rule.set("FirewallMark", firewallMark(table))
+		rule.set("InvertRule", "yes")
+		rule.set("Table", table)
+		rule.set("Priority", strconv.Itoa(tablePriority))
+		rule.set("Family", "both")
+
+		// Do not use the default route of the main table, the other routes of the main table are still used
+		// This rule has to come first, otherwise all traffic goes through the tunnel table
+		suppress := file.group("RoutingPolicyRule")
+		suppress.set("Table", "main")
+		suppress.set("SuppressPrefixLength", "0")
+		suppress.set("Priority", strconv.Itoa(suppressPriority))
+		suppress.set("Family", "both")
+	}
+	return file.String()
+}
+
+// Networkd exports the WireGuard `config` as a systemd-networkd .netdev and .network file.
+// Note that systemd-networkd needs to be able to read the key files, e.g. by changing the group to systemd-network.
+func Networkd(config string, options WireGuardOptions) (*WireGuardExport, error) {
+	errorMessage := "failed exporting config for systemd-networkd"
+	if optionsErr := options.validate("systemd-networkd"); optionsErr != nil {
+		return nil, types.NewWrappedError(errorMessage, optionsErr)
+	}
+	parsed, parseErr := parseWireGuard(config, "systemd-networkd")
+	if parseErr != nil {
+		return nil, types.NewWrappedError(errorMessage, parseErr)
+	}
+
+	table := networkdTable(parsed, options)
+	privateKey, presharedKeys := options.secretFiles(parsed)
+	secrets := []File{privateKey}
+	for i := range parsed.Peers {
+		if presharedKey, ok := presharedKeys[i]; ok {
+			secrets = append(secrets, presharedKey)
+		}
+	}
+
+	return &WireGuardExport{
+		Files: []File{
+			{
+				Path:    options.path(".netdev"),
+				Content: networkdNetDev(parsed, options, table, privateKey, presharedKeys),
+				Mode:    0o644,
+			},
+			{
+				Path:    options.path(".network"),
+				Content: networkdNetwork(parsed, options, table),
+				Mode:    0o644,
+			},
+		},
+		Secrets: secrets,
+	}, nil
+}
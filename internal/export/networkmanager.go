@@ -0,0 +1,373 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// NetworkManagerOptions are the options for exporting a configuration as a NetworkManager keyfile.
+type NetworkManagerOptions struct {
+	// Directory is the absolute directory where the files that the keyfile references are written
+	// These are the certificates and keys of OpenVPN configurations
+	Directory string
+
+	// InterfaceName is the name of the interface, empty to let NetworkManager choose one
+	InterfaceName string
+
+	// Autoconnect indicates that NetworkManager connects automatically
+	Autoconnect bool
+}
+
+// NetworkManagerConnection is a configuration that is exported as a NetworkManager connection.
+type NetworkManagerConnection struct {
+	// ID is the name of the connection
+	ID string
+
+	// UUID is the UUID of the connection, this is the same for the same server and profile
+	UUID string
+
+	// Keyfile is the content of the .nmconnection keyfile
+	Keyfile string
+
+	// Files are the files that are referenced by the keyfile
+	Files []File
+}
+
+// KeyfileName returns the filename of the keyfile.
+func (connection *NetworkManagerConnection) KeyfileName() string {
+	return connection.UUID + ".nmconnection"
+}
+
+// Write writes the keyfile in `keyfileDirectory`, e.g. /etc/NetworkManager/system-connections, and the files that it references.
+// The keyfile is only readable by the owner as NetworkManager requires this and it can contain secrets.
+func (connection *NetworkManagerConnection) Write(keyfileDirectory string) error {
+	keyfile := File{
+		Path:    filepath.Join(keyfileDirectory, connection.KeyfileName()),
+		Content: connection.Keyfile,
+		Mode:    0o600,
+	}
+	return WriteFiles(append(connection.Files, keyfile))
+}
+
+// keyfileGroup is a group of a keyfile with its keys in order.
+type keyfileGroup struct {
+	name string
+	keys [][2]string
+}
+
+// set adds the `key` with `value` to the group, empty values are skipped.
+func (group *keyfileGroup) set(key string, value string) {
+	if value == "" {
+		return
+	}
+	group.keys = append(group.keys, [2]string{key, value})
+}
+
+// keyfile builds the content of a keyfile.
+type keyfile struct {
+	groups []*keyfileGroup
+}
+
+// group adds a group with `name` and returns it such that keys can be added.
+func (file *keyfile) group(name string) *keyfileGroup {
+	group := &keyfileGroup{name: name}
+	file.groups = append(file.groups, group)
+	return group
+}
+
+// String returns the keyfile with an empty line between the groups.
+func (file *keyfile) String() string {
+	var builder strings.Builder
+	for i, group := range file.groups {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		fmt.Fprintf(&builder, "[%s]\n", group.name)
+		for _, key := range group.keys {
+			fmt.Fprintf(&builder, "%s=%s\n", key[0], key[1])
+		}
+	}
+	return builder.String()
+}
+
+// connectionGroup adds the [connection] group with the ID, UUID and the connection `connectionType`.
+func (file *keyfile) connectionGroup(
+	identity Identity,
+	connectionType string,
+	options NetworkManagerOptions,
+) {
+	group := file.group("connection")
+	group.set("id", identity.ID())
+	group.set("uuid", identity.UUID())
+	group.set("type", connectionType)
+	group.set("interface-name", options.InterfaceName)
+	group.set("autoconnect", fmt.Sprintf("%t", options.Autoconnect))
+}
+
+// list joins `values` as a keyfile list, each value is terminated by a semicolon.
+func list(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.Join(values, ";") + ";"
+}
+
+// wireguardNetworkManager exports the WireGuard `config` as a NetworkManager keyfile.
+func wireguardNetworkManager(
+	config *wireguard.Config,
+	identity Identity,
+	options NetworkManagerOptions,
+) (*NetworkManagerConnection, error) {
+	if config.Interface.PrivateKey == nil {
+		return nil, &UnsupportedError{Target: "NetworkManager", Reason: "the WireGuard config has no private key"}
+	}
+	file := &keyfile{}
+	file.connectionGroup(identity, "wireguard", options)
+
+	wg := file.group("wireguard")
+	wg.set("private-key", config.Interface.PrivateKey.String())
+	if config.Interface.ListenPort != 0 {
+		wg.set("listen-port", fmt.Sprintf("%d", config.Interface.ListenPort))
+	}
+	if config.Interface.MTU != 0 {
+		wg.set("mtu", fmt.Sprintf("%d", config.Interface.MTU))
+	}
+
+	for _, peer := range config.Peers {
+		group := file.group("wireguard-peer." + peer.PublicKey.String())
+		group.set("endpoint", peer.Endpoint)
+		if peer.PresharedKey != nil {
+			group.set("preshared-key", peer.PresharedKey.String())
+			group.set("preshared-key-flags", "0")
+		}
+		if peer.PersistentKeepalive != 0 {
+			group.set("persistent-keepalive", fmt.Sprintf("%d", peer.PersistentKeepalive))
+		}
+		var allowedIPs []string
+		for _, allowedIP := range peer.AllowedIPs {
+			allowedIPs = append(allowedIPs, allowedIP.String())
+		}
+		group.set("allowed-ips", list(allowedIPs))
+	}
+
+	// The addresses and DNS servers go in the IPv4 and IPv6 groups
+	for _, family := range []string{"ipv4", "ipv6"} {
+		group := file.group(family)
+		var dns []string
+		for _, ip := range config.Interface.DNS {
+			if (ip.To4() != nil) == (family == "ipv4") {
+				dns = append(dns, ip.String())
+			}
+		}
+		index := 1
+		for _, address := range config.Interface.Addresses {
+			if (address.IP.To4() != nil) == (family == "ipv4") {
+				group.set(fmt.Sprintf("address%d", index), address.String())
+				index++
+			}
+		}
+		group.set("dns", list(dns))
+		if family == "ipv4" {
+			group.set("dns-search", list(config.Interface.DNSSearch))
+		}
+		if index == 1 {
+			group.set("method", "disabled")
+		} else {
+			group.set("method", "manual")
+		}
+	}
+
+	return &NetworkManagerConnection{
+		ID:      identity.ID(),
+		UUID:    identity.UUID(),
+		Keyfile: file.String(),
+	}, nil
+}
+
+// openVPNFiles are the inline blocks that are moved to files with the keyfile key and whether the file is secret.
+var openVPNFiles = []struct {
+	block  string
+	key    string
+	secret bool
+}{
+	{"ca", "ca", false},
+	{"cert", "cert", false},
+	{"key", "key", true},
+	{"tls-crypt", "tls-crypt", true},
+	{"tls-auth", "ta", true},
+}
+
+// openVPNOptions are the directives that are copied to the keyfile with the keyfile key.
+var openVPNOptions = map[string]string{
+	"auth":             "auth",
+	"cipher":           "cipher",
+	"data-ciphers":     "data-ciphers",
+	"remote-cert-tls":  "remote-cert-tls",
+	"reneg-sec":        "reneg-seconds",
+	"tls-cipher":       "tls-cipher",
+	"tls-version-min":  "tls-version-min",
+	"key-direction":    "ta-dir",
+	"verify-x509-name": "verify-x509-name",
+}
+
+// openVPNRemotes returns the remotes as the keyfile "remote" value, e.g. "vpn.example.org:1194:udp, vpn.example.org:1194:tcp".
+func openVPNRemotes(config *openvpn.Config) (string, error) {
+	proto := "udp"
+	if directive, ok := config.Get("proto"); ok && len(directive.Args) > 0 {
+		proto = directive.Args[0]
+	}
+	port := "1194"
+	if directive, ok := config.Get("port"); ok && len(directive.Args) > 0 {
+		port = directive.Args[0]
+	}
+
+	var remotes []string
+	for _, remote := range config.All("remote") {
+		if len(remote.Args) == 0 {
+			return "", &UnsupportedError{Target: "NetworkManager", Reason: "remote without a host"}
+		}
+		remotePort := port
+		if len(remote.Args) > 1 {
+			remotePort = remote.Args[1]
+		}
+		remoteProto := proto
+		if len(remote.Args) > 2 {
+			remoteProto = remote.Args[2]
+		}
+		// NetworkManager only knows udp and tcp, e.g. tcp-client and udp6 are normalized
+		switch {
+		case strings.HasPrefix(remoteProto, "tcp"):
+			remoteProto = "tcp"
+		case strings.HasPrefix(remoteProto, "udp"):
+			remoteProto = "udp"
+		default:
+			return "", &UnsupportedError{
+				Target: "NetworkManager",
+				Reason: fmt.Sprintf("unknown protocol: %s", remoteProto),
+			}
+		}
+		remotes = append(remotes, fmt.Sprintf("%s:%s:%s", remote.Args[0], remotePort, remoteProto))
+	}
+	if len(remotes) == 0 {
+		return "", &UnsupportedError{Target: "NetworkManager", Reason: "the OpenVPN config has no remotes"}
+	}
+	return strings.Join(remotes, ", "), nil
+}
+
+// openVPNNetworkManager exports the OpenVPN `config` as a NetworkManager keyfile.
+// The inline certificates and keys are moved to files in the directory of the options.
+// Directives that NetworkManager has no setting for are not exported.
+func openVPNNetworkManager(
+	config *openvpn.Config,
+	identity Identity,
+	options NetworkManagerOptions,
+) (*NetworkManagerConnection, error) {
+	if !filepath.IsAbs(options.Directory) {
+		return nil, &UnsupportedError{
+			Target: "NetworkManager",
+			Reason: fmt.Sprintf("the directory for the OpenVPN files is not absolute: %s", options.Directory),
+		}
+	}
+	file := &keyfile{}
+	file.connectionGroup(identity, "vpn", options)
+
+	vpn := file.group("vpn")
+	vpn.set("service-type", "org.freedesktop.NetworkManager.openvpn")
+	vpn.set("connection-type", "tls")
+
+	remotes, remotesErr := openVPNRemotes(config)
+	if remotesErr != nil {
+		return nil, remotesErr
+	}
+	vpn.set("remote", remotes)
+
+	if directive, ok := config.Get("dev"); ok && len(directive.Args) > 0 {
+		vpn.set("dev-type", strings.TrimRight(directive.Args[0], "0123456789"))
+	}
+
+	var files []File
+	for _, inline := range openVPNFiles {
+		directive, ok := config.Get(inline.block)
+		if !ok || !directive.Inline {
+			continue
+		}
+		mode := os.FileMode(0o644)
+		if inline.secret {
+			mode = 0o600
+		}
+		path := filepath.Join(options.Directory, fmt.Sprintf("%s-%s.pem", identity.UUID(), inline.block))
+		files = append(files, File{Path: path, Content: directive.Content + "\n", Mode: mode})
+		vpn.set(inline.key, path)
+	}
+	// The private key is not protected with a password
+	if _, ok := config.Get("key"); ok {
+		vpn.set("cert-pass-flags", "4")
+	}
+
+	for _, directive := range config.Directives {
+		key, ok := openVPNOptions[directive.Name]
+		if !ok || directive.Inline || len(directive.Args) == 0 {
+			continue
+		}
+		value := directive.Args[0]
+		// NetworkManager has the type and the name of verify-x509-name in one value
+		if directive.Name == "verify-x509-name" && len(directive.Args) > 1 {
+			value = directive.Args[1] + ":" + directive.Args[0]
+		}
+		vpn.set(key, value)
+	}
+
+	// The addresses, routes and DNS servers are pushed by the server
+	file.group("ipv4").set("method", "auto")
+	file.group("ipv6").set("method", "auto")
+
+	return &NetworkManagerConnection{
+		ID:      identity.ID(),
+		UUID:    identity.UUID(),
+		Keyfile: file.String(),
+		Files:   files,
+	}, nil
+}
+
+// NetworkManager exports the `config` with type `configType` ("openvpn" or "wireguard") as a NetworkManager keyfile.
+// The connection is identified by the server and profile in `identity`.
+// It returns an error if the config cannot be parsed or has something that cannot be exported.
+func NetworkManager(
+	config string,
+	configType string,
+	identity Identity,
+	options NetworkManagerOptions,
+) (*NetworkManagerConnection, error) {
+	errorMessage := "failed exporting config for NetworkManager"
+	var connection *NetworkManagerConnection
+	var exportErr error
+	switch configType {
+	case "wireguard":
+		parsed, parseErr := wireguard.Parse(config)
+		if parseErr != nil {
+			return nil, types.NewWrappedError(errorMessage, parseErr)
+		}
+		connection, exportErr = wireguardNetworkManager(parsed, identity, options)
+	case "openvpn":
+		parsed, parseErr := openvpn.Parse(config)
+		if parseErr != nil {
+			return nil, types.NewWrappedError(errorMessage, parseErr)
+		}
+		connection, exportErr = openVPNNetworkManager(parsed, identity, options)
+	default:
+		exportErr = &UnsupportedError{
+			Target: "NetworkManager",
+			Reason: fmt.Sprintf("unknown config type: %s", configType),
+		}
+	}
+	if exportErr != nil {
+		return nil, types.NewWrappedError(errorMessage, exportErr)
+	}
+	return connection, nil
+}
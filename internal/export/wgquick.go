@@ -0,0 +1,81 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// WGQuick exports the WireGuard `config` as a normalized wg-quick configuration.
+// The private and preshared keys are set with PostUp from their files.
+// The hooks of the config are removed as a server should not be able to run commands.
+// wg-quick cannot limit the DNS servers to the search domains, DNSSearchDomains gives an error.
+func WGQuick(config string, options WireGuardOptions) (*WireGuardExport, error) {
+	errorMessage := "failed exporting config for wg-quick"
+	if optionsErr := options.validate("wg-quick"); optionsErr != nil {
+		return nil, types.NewWrappedError(errorMessage, optionsErr)
+	}
+	if options.DNS == DNSSearchDomains {
+		return nil, types.NewWrappedError(
+			errorMessage,
+			&UnsupportedError{Target: "wg-quick", Reason: "DNS servers cannot be limited to the search domains"},
+		)
+	}
+	parsed, parseErr := parseWireGuard(config, "wg-quick")
+	if parseErr != nil {
+		return nil, types.NewWrappedError(errorMessage, parseErr)
+	}
+
+	privateKey, presharedKeys := options.secretFiles(parsed)
+	secrets := []File{privateKey}
+
+	iface := &parsed.Interface
+	iface.PrivateKey = nil
+	if options.DNS == DNSIgnore {
+		iface.DNS = nil
+		iface.DNSSearch = nil
+	}
+	// Remove the hooks and the keys that are set by the options
+	var extra []wireguard.KeyValue
+	for _, keyValue := range iface.Extra {
+		switch strings.ToLower(keyValue.Key) {
+		case "table", "preup", "postup", "predown", "postdown":
+		default:
+			extra = append(extra, keyValue)
+		}
+	}
+	if options.RouteTable != "" {
+		extra = append(extra, wireguard.KeyValue{Key: "Table", Value: options.RouteTable})
+	}
+	extra = append(extra, wireguard.KeyValue{
+		Key:   "PostUp",
+		Value: fmt.Sprintf("wg set %%i private-key %s", privateKey.Path),
+	})
+	for i := range parsed.Peers {
+		peer := &parsed.Peers[i]
+		presharedKey, ok := presharedKeys[i]
+		if !ok {
+			continue
+		}
+		peer.PresharedKey = nil
+		secrets = append(secrets, presharedKey)
+		extra = append(extra, wireguard.KeyValue{
+			Key:   "PostUp",
+			Value: fmt.Sprintf("wg set %%i peer %s preshared-key %s", peer.PublicKey.String(), presharedKey.Path),
+		})
+	}
+	iface.Extra = extra
+
+	return &WireGuardExport{
+		Files: []File{
+			{
+				Path:    options.path(".conf"),
+				Content: parsed.String(),
+				Mode:    0o600,
+			},
+		},
+		Secrets: secrets,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+)
+
+// DNSMode defines how the DNS servers of a WireGuard configuration are used.
+type DNSMode int8
+
+const (
+	// DNSDefault uses the DNS servers for all DNS queries
+	DNSDefault DNSMode = iota
+
+	// DNSSearchDomains only uses the DNS servers for the search domains of the configuration
+	DNSSearchDomains
+
+	// DNSIgnore does not configure DNS
+	DNSIgnore
+)
+
+// ParseDNSMode parses a DNS mode from "default", "search-domains" or "ignore".
+func ParseDNSMode(mode string) (DNSMode, error) {
+	switch mode {
+	case "", "default":
+		return DNSDefault, nil
+	case "search-domains":
+		return DNSSearchDomains, nil
+	case "ignore":
+		return DNSIgnore, nil
+	default:
+		return DNSDefault, fmt.Errorf("unknown DNS mode: %s", mode)
+	}
+}
+
+// defaultTable is the route table and firewall mark for routing all traffic through the tunnel, this is the same as wg-quick uses.
+const defaultTable = 51820
+
+// WireGuardOptions are the options for exporting a WireGuard configuration as systemd-networkd or wg-quick files.
+type WireGuardOptions struct {
+	// Directory is the absolute directory where the files are written, e.g. /etc/systemd/network or /etc/wireguard
+	Directory string
+
+	// InterfaceName is the name of the interface, this is also the name of the files
+	InterfaceName string
+
+	// RouteTable is the route table for the AllowedIPs routes, e.g. "main", "off" or a number
+	// If empty, wg-quick chooses itself and systemd-networkd uses table 51820 with policy routing if all traffic is routed through the tunnel, and "main" otherwise
+	RouteTable string
+
+	// DNS defines how the DNS servers are used
+	DNS DNSMode
+}
+
+// WireGuardExport is a WireGuard configuration that is exported as files.
+type WireGuardExport struct {
+	// Files are the configuration files without secrets
+	Files []File
+
+	// Secrets are the files with the private and preshared keys, these are only readable by the owner
+	Secrets []File
+}
+
+// Write writes the configuration and secret files.
+func (export *WireGuardExport) Write() error {
+	return WriteFiles(append(export.Files, export.Secrets...))
+}
+
+// validate checks that the options can be used for exporting.
+func (options WireGuardOptions) validate(target string) error {
+	if options.InterfaceName == "" {
+		return &UnsupportedError{Target: target, Reason: "no interface name given"}
+	}
+	if !filepath.IsAbs(options.Directory) {
+		return &UnsupportedError{
+			Target: target,
+			Reason: fmt.Sprintf("the directory is not absolute: %s", options.Directory),
+		}
+	}
+	return nil
+}
+
+// path returns the path of the file with `suffix` for the interface, e.g. /etc/wireguard/wg0.conf.
+func (options WireGuardOptions) path(suffix string) string {
+	return filepath.Join(options.Directory, options.InterfaceName+suffix)
+}
+
+// secretFiles returns the file for the private key and the files for the preshared keys by peer index.
+func (options WireGuardOptions) secretFiles(config *wireguard.Config) (File, map[int]File) {
+	privateKey := File{
+		Path:    options.path(".key"),
+		Content: config.Interface.PrivateKey.String() + "\n",
+		Mode:    0o600,
+	}
+	presharedKeys := make(map[int]File)
+	for i, peer := range config.Peers {
+		if peer.PresharedKey == nil {
+			continue
+		}
+		presharedKeys[i] = File{
+			Path:    options.path(fmt.Sprintf("-peer%d.psk", i+1)),
+			Content: peer.PresharedKey.String() + "\n",
+			Mode:    0o600,
+		}
+	}
+	return privateKey, presharedKeys
+}
+
+// routesAll returns whether or not the configuration routes all traffic of an address family through the tunnel.
+func routesAll(config *wireguard.Config) bool {
+	for _, peer := range config.Peers {
+		for _, allowedIP := range peer.AllowedIPs {
+			if ones, _ := allowedIP.Mask.Size(); ones == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firewallMark returns the firewall mark for policy routing with `table`.
+// A named table cannot be used as a mark, the default table number is used instead.
+func firewallMark(table string) string {
+	if _, numberErr := strconv.ParseUint(table, 10, 32); numberErr == nil {
+		return table
+	}
+	return strconv.Itoa(defaultTable)
+}
+
+// parseWireGuard parses `config` for exporting to `target`, it must have a private key.
+func parseWireGuard(config string, target string) (*wireguard.Config, error) {
+	parsed, parseErr := wireguard.Parse(config)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if parsed.Interface.PrivateKey == nil {
+		return nil, &UnsupportedError{Target: target, Reason: "the WireGuard config has no private key"}
+	}
+	return parsed, nil
+}
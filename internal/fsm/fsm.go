@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"path"
 	"sort"
+	"time"
 
 	"github.com/eduvpn/eduvpn-common/types"
 )
@@ -37,6 +38,9 @@ type Transition struct {
 	To StateID
 	// Description is what type of message the arrow gets in the graph
 	Description string
+	// Guard is an optional function that is ran with the transition data before the transition is taken
+	// If it returns an error, the transition is not taken
+	Guard func(data interface{}) error
 }
 
 type (
@@ -47,6 +51,14 @@ type (
 type State struct {
 	// Transitions indicates which out arrows this node has
 	Transitions []Transition
+
+	// Enter is an optional action that is ran when the state machine enters this state
+	// It gets the previous state and the transition data
+	Enter func(old StateID, data interface{})
+
+	// Exit is an optional action that is ran when the state machine leaves this state
+	// It gets the next state and the transition data
+	Exit func(new StateID, data interface{})
 }
 
 // FSM represents the total graph.
@@ -72,6 +84,135 @@ type FSM struct {
 
 	// GetStateName gets the name of a state as a string
 	GetStateName func(StateID) string
+
+	// Strict represents whether we want to panic on an invalid transition that is not checked by the caller
+	// This is meant for debugging and tests such that mistakes in the state graph surface early
+	Strict bool
+
+	// errorState is the state that can be entered from every state when a failure occurs
+	errorState StateID
+
+	// hasErrorState represents whether or not an error state is configured
+	hasErrorState bool
+
+	// HistorySize is the maximum number of entries that are kept in the history
+	// If it is zero, DefaultHistorySize is used
+	HistorySize int
+
+	// HistoryCallback is an optional function that is ran with each new history entry, e.g. for logging
+	HistoryCallback func(HistoryEntry)
+
+	// history is the ring buffer of the last transitions
+	history []HistoryEntry
+
+	// historyNext is the index in the history where the next entry is written
+	historyNext int
+}
+
+// DefaultHistorySize is the number of transitions that are kept in the history by default.
+const DefaultHistorySize = 100
+
+// HistoryEntry is a single transition in the history of the state machine.
+type HistoryEntry struct {
+	// Time is the time the transition started
+	Time time.Time
+
+	// From is the state before the transition
+	From StateID
+
+	// To is the state the transition went to
+	To StateID
+
+	// Description is the description of the transition
+	Description string
+
+	// Handled represents whether or not the transition was handled by the client
+	Handled bool
+
+	// Duration is how long the transition took, including the actions and the callback
+	Duration time.Duration
+
+	// Error is the reason why the transition was not taken, empty if it was taken
+	Error string
+}
+
+// String returns the history entry as a single line, e.g. for logging.
+func (entry HistoryEntry) String(nameGen func(StateID) string) string {
+	line := fmt.Sprintf(
+		"%s: %s -> %s (%s), handled: %t, duration: %s",
+		entry.Time.Format(time.RFC3339Nano),
+		nameGen(entry.From),
+		nameGen(entry.To),
+		entry.Description,
+		entry.Handled,
+		entry.Duration,
+	)
+	if entry.Error != "" {
+		line += fmt.Sprintf(", error: %s", entry.Error)
+	}
+	return line
+}
+
+// record adds an entry to the history ring buffer and runs the history callback.
+func (fsm *FSM) record(entry HistoryEntry) {
+	fsm.insert(entry)
+	if fsm.HistoryCallback != nil {
+		fsm.HistoryCallback(entry)
+	}
+}
+
+// insert adds an entry to the history ring buffer and returns its index in the buffer.
+func (fsm *FSM) insert(entry HistoryEntry) int {
+	size := fsm.HistorySize
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	// The size has changed, start over with the newest entries
+	if cap(fsm.history) != size {
+		old := fsm.History()
+		fsm.history = make([]HistoryEntry, 0, size)
+		fsm.historyNext = 0
+		if len(old) > size {
+			old = old[len(old)-size:]
+		}
+		fsm.history = append(fsm.history, old...)
+		fsm.historyNext = len(fsm.history) % size
+	}
+
+	index := fsm.historyNext
+	if len(fsm.history) < size {
+		index = len(fsm.history)
+		fsm.history = append(fsm.history, entry)
+	} else {
+		fsm.history[index] = entry
+	}
+	fsm.historyNext = (index + 1) % size
+	return index
+}
+
+// complete fills in the entry at `index` of the history with `entry` and runs the history callback.
+// The entry is only filled in if it is still in the buffer, the transitions in the callback can have pushed it out.
+func (fsm *FSM) complete(index int, entry HistoryEntry) {
+	if index < len(fsm.history) {
+		recorded := fsm.history[index]
+		if recorded.Time.Equal(entry.Time) && recorded.From == entry.From && recorded.To == entry.To {
+			fsm.history[index] = entry
+		}
+	}
+	if fsm.HistoryCallback != nil {
+		fsm.HistoryCallback(entry)
+	}
+}
+
+// History returns a copy of the last transitions, the oldest first.
+func (fsm *FSM) History() []HistoryEntry {
+	history := make([]HistoryEntry, 0, len(fsm.history))
+	// The buffer is not full yet, it is in order
+	if len(fsm.history) < cap(fsm.history) {
+		return append(history, fsm.history...)
+	}
+	history = append(history, fsm.history[fsm.historyNext:]...)
+	return append(history, fsm.history[:fsm.historyNext]...)
 }
 
 // Init initializes the state machine and sets it to the given current state.
@@ -96,15 +237,38 @@ func (fsm *FSM) InState(check StateID) bool {
 	return check == fsm.Current
 }
 
+// SetErrorState configures the state that the state machine goes to with GoError.
+// This state can be entered from every state, the transitions out of this state are defined by the states map.
+func (fsm *FSM) SetErrorState(state StateID) {
+	fsm.errorState = state
+	fsm.hasErrorState = true
+}
+
 // HasTransition checks whether or not the state machine has a transition to the given 'check' state.
 func (fsm *FSM) HasTransition(check StateID) bool {
-	for _, transitionState := range fsm.States[fsm.Current].Transitions {
-		if transitionState.To == check {
-			return true
+	_, ok := fsm.findTransition(check)
+	return ok
+}
+
+// findTransition returns the transition from the current state to the 'to' state.
+// The boolean indicates whether or not such a transition exists.
+func (fsm *FSM) findTransition(to StateID) (*Transition, bool) {
+	transitions := fsm.States[fsm.Current].Transitions
+	for i := range transitions {
+		if transitions[i].To == to {
+			return &transitions[i], true
 		}
 	}
 
-	return false
+	return nil, false
+}
+
+// stateName returns the name of the state, falling back to the identifier if no name generator is given.
+func (fsm *FSM) stateName(state StateID) string {
+	if fsm.GetStateName == nil {
+		return fmt.Sprintf("%d", state)
+	}
+	return fsm.GetStateName(state)
 }
 
 // graphFilename gets the full path to the graph filename including the .graph extension.
@@ -132,17 +296,103 @@ func (fsm *FSM) writeGraph() {
 	}
 }
 
+// enter moves the state machine to the 'newState' with associated state data 'data'
+// It runs the exit action of the current state and the enter action of the new state
+// The transition is recorded in the history with the 'description'
+// It returns whether or not the transition is handled by the client.
+func (fsm *FSM) enter(newState StateID, data interface{}, description string) bool {
+	oldState := fsm.Current
+	entry := HistoryEntry{
+		Time:        time.Now(),
+		From:        oldState,
+		To:          newState,
+		Description: description,
+	}
+	// The entry is recorded before the callback, such that the transitions that the callback does come after it in the history
+	index := fsm.insert(entry)
+	defer func() {
+		entry.Duration = time.Since(entry.Time)
+		fsm.complete(index, entry)
+	}()
+	if exit := fsm.States[oldState].Exit; exit != nil {
+		exit(newState, data)
+	}
+	fsm.Current = newState
+	if fsm.Generate {
+		fsm.writeGraph()
+	}
+	if enter := fsm.States[newState].Enter; enter != nil {
+		enter(oldState, data)
+	}
+
+	if fsm.StateCallback == nil {
+		return false
+	}
+	entry.Handled = fsm.StateCallback(oldState, newState, data)
+	return entry.Handled
+}
+
+// GoTransitionChecked transitions the state machine toward the 'newState' with associated state data 'data'
+// It returns whether or not the transition is handled by the client
+// If the transition does not exist, an *InvalidTransitionError is returned
+// If the guard of the transition does not allow it, a *GuardError is returned.
+func (fsm *FSM) GoTransitionChecked(newState StateID, data interface{}) (bool, error) {
+	transition, ok := fsm.findTransition(newState)
+	if !ok {
+		invalidErr := &InvalidTransitionError{
+			From:     fsm.Current,
+			To:       newState,
+			FromName: fsm.stateName(fsm.Current),
+			ToName:   fsm.stateName(newState),
+		}
+		fsm.recordFailed(newState, "", invalidErr)
+		return false, invalidErr
+	}
+
+	if transition.Guard != nil {
+		if guardErr := transition.Guard(data); guardErr != nil {
+			wrappedGuardErr := &GuardError{
+				From:     fsm.Current,
+				To:       newState,
+				FromName: fsm.stateName(fsm.Current),
+				ToName:   fsm.stateName(newState),
+				Err:      guardErr,
+			}
+			fsm.recordFailed(newState, transition.Description, wrappedGuardErr)
+			return false, wrappedGuardErr
+		}
+	}
+
+	return fsm.enter(newState, data, transition.Description), nil
+}
+
+// recordFailed records a transition to 'newState' that was not taken because of 'err'.
+func (fsm *FSM) recordFailed(newState StateID, description string, err error) {
+	fsm.record(HistoryEntry{
+		Time:        time.Now(),
+		From:        fsm.Current,
+		To:          newState,
+		Description: description,
+		Error:       err.Error(),
+	})
+}
+
 // GoTransitionRequired transitions the state machine to a new state with associated state data 'data'
-// If this transition is not handled by the client, it returns an error.
+// If this transition is not possible or not handled by the client, it returns an error.
 func (fsm *FSM) GoTransitionRequired(newState StateID, data interface{}) error {
+	errorMessage := "failed required transition"
 	oldState := fsm.Current
-	if !fsm.GoTransitionWithData(newState, data) {
+	handled, transitionErr := fsm.GoTransitionChecked(newState, data)
+	if transitionErr != nil {
+		return types.NewWrappedError(errorMessage, transitionErr)
+	}
+	if !handled {
 		return types.NewWrappedError(
-			"failed required transition",
+			errorMessage,
 			fmt.Errorf(
 				"required transition not handled, from: %s -> to: %s",
-				fsm.GetStateName(oldState),
-				fsm.GetStateName(newState),
+				fsm.stateName(oldState),
+				fsm.stateName(newState),
 			),
 		)
 	}
@@ -151,18 +401,12 @@ func (fsm *FSM) GoTransitionRequired(newState StateID, data interface{}) error {
 
 // GoTransitionWithData is a helper that transitions the state machine toward the 'newState' with associated state data 'data'
 // It returns whether or not the transition is handled by the client.
+// The transition is not checked by the caller, if it is invalid this returns false.
+// In strict mode, an invalid transition panics instead.
 func (fsm *FSM) GoTransitionWithData(newState StateID, data interface{}) bool {
-	ok := fsm.HasTransition(newState)
-
-	handled := false
-	if ok {
-		oldState := fsm.Current
-		fsm.Current = newState
-		if fsm.Generate {
-			fsm.writeGraph()
-		}
-
-		handled = fsm.StateCallback(oldState, newState, data)
+	handled, transitionErr := fsm.GoTransitionChecked(newState, data)
+	if transitionErr != nil && fsm.Strict {
+		panic(fmt.Sprintf("unchecked invalid transition: %v", transitionErr))
 	}
 
 	return handled
@@ -174,6 +418,16 @@ func (fsm *FSM) GoTransition(newState StateID) bool {
 	return fsm.GoTransitionWithData(newState, "")
 }
 
+// GoError moves the state machine to the error state from any state with the error as data
+// This way the failure is forwarded to the client
+// It returns whether or not this is handled by the client, false if no error state is configured.
+func (fsm *FSM) GoError(err error) bool {
+	if !fsm.hasErrorState {
+		return false
+	}
+	return fsm.enter(fsm.errorState, err, "An error occurred")
+}
+
 // generateMermaidGraph generates a graph suitable to be converted by the mermaid.js tool
 // it returns the graph as a string.
 func (fsm *FSM) generateMermaidGraph() string {
@@ -212,3 +466,37 @@ func (fsm *FSM) GenerateGraph() string {
 
 	return ""
 }
+
+// InvalidTransitionError indicates that there is no transition between the two states.
+type InvalidTransitionError struct {
+	From     StateID
+	To       StateID
+	FromName string
+	ToName   string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid transition, from: %s -> to: %s", e.FromName, e.ToName)
+}
+
+// GuardError indicates that the guard of the transition between the two states did not allow it.
+type GuardError struct {
+	From     StateID
+	To       StateID
+	FromName string
+	ToName   string
+	Err      error
+}
+
+func (e *GuardError) Error() string {
+	return fmt.Sprintf(
+		"transition from: %s -> to: %s not allowed with error: %v",
+		e.FromName,
+		e.ToName,
+		e.Err,
+	)
+}
+
+func (e *GuardError) Unwrap() error {
+	return e.Err
+}
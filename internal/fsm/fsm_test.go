@@ -0,0 +1,216 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	testStateA StateID = iota
+	testStateB
+	testStateC
+	testStateError
+)
+
+func testStateName(state StateID) string {
+	return fmt.Sprintf("state%d", state)
+}
+
+// testFSM creates a state machine A -> B -> C where the transition to C needs non-nil data.
+// The actions that are ran are appended to `actions`.
+func testFSM(actions *[]string) *FSM {
+	action := func(name string) func(StateID, interface{}) {
+		return func(StateID, interface{}) {
+			*actions = append(*actions, name)
+		}
+	}
+	states := States{
+		testStateA: State{
+			Transitions: []Transition{{To: testStateB, Description: "A to B"}},
+			Exit:        action("exit A"),
+		},
+		testStateB: State{
+			Transitions: []Transition{
+				{
+					To:          testStateC,
+					Description: "B to C",
+					Guard: func(data interface{}) error {
+						if data == nil {
+							return errors.New("no data")
+						}
+						return nil
+					},
+				},
+			},
+			Enter: action("enter B"),
+			Exit:  action("exit B"),
+		},
+		testStateC: State{},
+		testStateError: State{
+			Transitions: []Transition{{To: testStateA, Description: "Error to A"}},
+		},
+	}
+	callback := func(StateID, StateID, interface{}) bool {
+		*actions = append(*actions, "callback")
+		return true
+	}
+	fsm := &FSM{}
+	fsm.Init(testStateA, states, callback, "", testStateName, false)
+	return fsm
+}
+
+func TestGoTransitionChecked(t *testing.T) {
+	var actions []string
+	fsm := testFSM(&actions)
+
+	var invalidErr *InvalidTransitionError
+	_, transitionErr := fsm.GoTransitionChecked(testStateC, "data")
+	if !errors.As(transitionErr, &invalidErr) {
+		t.Fatalf("Got error: %v, want: %T", transitionErr, invalidErr)
+	}
+	if invalidErr.From != testStateA || invalidErr.To != testStateC {
+		t.Fatalf("Got invalid transition: %v, want: from A to C", invalidErr)
+	}
+
+	handled, transitionErr := fsm.GoTransitionChecked(testStateB, nil)
+	if transitionErr != nil || !handled {
+		t.Fatalf("Got handled: %v, error: %v, want: handled and no error", handled, transitionErr)
+	}
+
+	var guardErr *GuardError
+	_, transitionErr = fsm.GoTransitionChecked(testStateC, nil)
+	if !errors.As(transitionErr, &guardErr) {
+		t.Fatalf("Got error: %v, want: %T", transitionErr, guardErr)
+	}
+	if !fsm.InState(testStateB) {
+		t.Fatalf("Got state: %d, want: %d after a failed guard", fsm.Current, testStateB)
+	}
+
+	_, transitionErr = fsm.GoTransitionChecked(testStateC, "data")
+	if transitionErr != nil {
+		t.Fatalf("Got error: %v, want: nil", transitionErr)
+	}
+
+	wantActions := []string{"exit A", "enter B", "callback", "exit B", "callback"}
+	if fmt.Sprint(actions) != fmt.Sprint(wantActions) {
+		t.Fatalf("Got actions: %v, want: %v", actions, wantActions)
+	}
+}
+
+func TestGoTransitionStrict(t *testing.T) {
+	var actions []string
+	fsm := testFSM(&actions)
+
+	// Not strict so an invalid transition is just not handled
+	if fsm.GoTransition(testStateC) {
+		t.Fatalf("Invalid transition is handled")
+	}
+
+	fsm.Strict = true
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Invalid unchecked transition did not panic in strict mode")
+		}
+	}()
+	fsm.GoTransition(testStateC)
+}
+
+func TestGoError(t *testing.T) {
+	var actions []string
+	fsm := testFSM(&actions)
+
+	if fsm.GoError(errors.New("no error state")) {
+		t.Fatalf("Error is handled without an error state")
+	}
+
+	fsm.SetErrorState(testStateError)
+	var gotErr error
+	fsm.StateCallback = func(_ StateID, _ StateID, data interface{}) bool {
+		gotErr, _ = data.(error)
+		return true
+	}
+
+	wantErr := errors.New("failure")
+	if !fsm.GoError(wantErr) {
+		t.Fatalf("Error is not handled")
+	}
+	if gotErr != wantErr {
+		t.Fatalf("Got error data: %v, want: %v", gotErr, wantErr)
+	}
+	if !fsm.InState(testStateError) {
+		t.Fatalf("Got state: %d, want: %d", fsm.Current, testStateError)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	var actions []string
+	fsm := testFSM(&actions)
+	fsm.HistorySize = 3
+	var logged []HistoryEntry
+	fsm.HistoryCallback = func(entry HistoryEntry) {
+		logged = append(logged, entry)
+	}
+
+	// Invalid transition, is recorded but not taken
+	fsm.GoTransition(testStateC)
+	fsm.GoTransition(testStateB)
+	// Failed guard
+	fsm.GoTransitionWithData(testStateC, nil)
+	fsm.GoTransitionWithData(testStateC, "data")
+
+	if len(logged) != 4 {
+		t.Fatalf("Got %d logged entries, want: 4", len(logged))
+	}
+
+	history := fsm.History()
+	if len(history) != 3 {
+		t.Fatalf("Got history length: %d, want: 3", len(history))
+	}
+	// The oldest entry, the invalid transition, is dropped
+	want := []struct {
+		from        StateID
+		to          StateID
+		description string
+		handled     bool
+		failed      bool
+	}{
+		{testStateA, testStateB, "A to B", true, false},
+		{testStateB, testStateC, "B to C", false, true},
+		{testStateB, testStateC, "B to C", true, false},
+	}
+	for i, entry := range history {
+		w := want[i]
+		if entry.From != w.from || entry.To != w.to || entry.Description != w.description ||
+			entry.Handled != w.handled || (entry.Error != "") != w.failed {
+			t.Fatalf("Got history entry %d: %+v, want: %+v", i, entry, w)
+		}
+		if i > 0 && entry.Time.Before(history[i-1].Time) {
+			t.Fatalf("History entry %d is older than the previous entry", i)
+		}
+	}
+}
+
+func TestHistoryNestedTransition(t *testing.T) {
+	var actions []string
+	fsm := testFSM(&actions)
+	// The callback of B moves on to C, that transition is caused by the one to B so it comes after it
+	fsm.StateCallback = func(_ StateID, newState StateID, _ interface{}) bool {
+		if newState == testStateB {
+			fsm.GoTransitionWithData(testStateC, "data")
+		}
+		return true
+	}
+	fsm.GoTransition(testStateB)
+
+	history := fsm.History()
+	if len(history) != 2 {
+		t.Fatalf("Got history length: %d, want: 2", len(history))
+	}
+	if history[0].To != testStateB || history[1].To != testStateC {
+		t.Fatalf("Got history: %+v, want the transition to B before the one to C", history)
+	}
+	if !history[0].Handled || history[0].Duration < history[1].Duration {
+		t.Fatalf("Got entry: %+v, want it handled with the duration of the nested transition", history[0])
+	}
+}
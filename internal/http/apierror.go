@@ -0,0 +1,63 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// APIError is an error that the server returned in a JSON body, e.g. {"error": "profile not available"}.
+// The vpn-user-portal API and the OAuth token endpoint both return errors in this form.
+type APIError struct {
+	// Status is the HTTP status code of the response
+	Status int
+
+	// Message is the "error" field of the body
+	Message string
+
+	// Description is the "error_description" field of an OAuth error, empty if there is none
+	Description string
+}
+
+// portalCodes are the codes of the exact error messages of vpn-user-portal and its OAuth token endpoint.
+var portalCodes = map[string]types.ErrCode{
+	"invalid_grant":                          types.ErrCodeInvalidGrant,
+	"invalid_token":                          types.ErrCodeInvalidToken,
+	"public key already in use":              types.ErrCodeInvalidPublicKey,
+	`invalid "public_key"`:                   types.ErrCodeInvalidPublicKey,
+	"account is disabled":                    types.ErrCodeAccountDisabled,
+	"limit of available connections reached": types.ErrCodeTooManyConnections,
+	"profile not available":                  types.ErrCodeProfileNotAvailable,
+}
+
+// Code returns the stable code of the error.
+// Only the exact messages of the portal have a specific code, other messages have ErrCodeUnknown.
+func (e *APIError) Code() types.ErrCode {
+	if code, ok := portalCodes[strings.TrimSpace(e.Message)]; ok {
+		return code
+	}
+	return types.ErrCodeUnknown
+}
+
+// Error returns the message of the server, it is meant to be shown to the user.
+func (e *APIError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("the server returned an error: %s (%s)", e.Message, e.Description)
+	}
+	return fmt.Sprintf("the server returned an error: %s", e.Message)
+}
+
+// parseAPIError parses the error body `body` of a response with status code `status`.
+// It returns nil if the body is not a JSON error.
+func parseAPIError(status int, body []byte) *APIError {
+	structure := struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}{}
+	if json.Unmarshal(body, &structure) != nil || structure.Error == "" {
+		return nil
+	}
+	return &APIError{Status: status, Message: structure.Error, Description: structure.Description}
+}
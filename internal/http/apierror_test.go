@@ -0,0 +1,58 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+func TestAPIError(t *testing.T) {
+	cases := map[string]types.ErrCode{
+		// The exact messages of the portal
+		`{"error": "profile not available"}`:                         types.ErrCodeProfileNotAvailable,
+		`{"error": "account is disabled"}`:                           types.ErrCodeAccountDisabled,
+		`{"error": "limit of available connections reached"}`:        types.ErrCodeTooManyConnections,
+		`{"error": "public key already in use"}`:                     types.ErrCodeInvalidPublicKey,
+		`{"error": "invalid \"public_key\""}`:                        types.ErrCodeInvalidPublicKey,
+		`{"error": "invalid_grant", "error_description": "expired"}`: types.ErrCodeInvalidGrant,
+		`{"error": "invalid_token"}`:                                 types.ErrCodeInvalidToken,
+		// Other messages are not matched on their words, a similar message can mean something else
+		`{"error": "Profile \"internet\" does not exist"}`:   types.ErrCodeUnknown,
+		`{"error": "maximum number of connections reached"}`: types.ErrCodeUnknown,
+		`{"error": "user account disabled"}`:                 types.ErrCodeUnknown,
+		`{"error": "Account is disabled"}`:                   types.ErrCodeUnknown,
+		`{"error": "invalid_grant: code expired"}`:           types.ErrCodeUnknown,
+		`{"error": "something else"}`:                        types.ErrCodeUnknown,
+		`not json`:                                           types.ErrCodeNone,
+		`{"message": "no error field"}`:                      types.ErrCodeNone,
+	}
+	for body, want := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(body))
+		}))
+		_, _, getErr := NewClient(Options{}).Get(server.URL)
+		server.Close()
+		if got := types.ErrorCode(getErr); got != want {
+			t.Fatalf("Got code: %s for body: %s, want: %s", got, body, want)
+		}
+
+		// The status code can still be checked and the parsed error is the cause that is shown to the user
+		var statusErr *StatusError
+		if !errors.As(getErr, &statusErr) || statusErr.Status != http.StatusBadRequest {
+			t.Fatalf("Got error: %v for body: %s, want a status error", getErr, body)
+		}
+		// The raw body is not in the error
+		if strings.Contains(getErr.Error(), body) {
+			t.Fatalf("Got error: %v, want it without the body: %s", getErr, body)
+		}
+		var apiErr *APIError
+		if (want != types.ErrCodeNone) != errors.As(types.ErrorCause(getErr), &apiErr) {
+			t.Fatalf("Got cause: %v for body: %s, want the parsed error only for a JSON error", types.ErrorCause(getErr), body)
+		}
+	}
+}
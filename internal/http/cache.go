@@ -0,0 +1,193 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/util"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// CacheEntry is a response that is saved in the cache together with its validators.
+type CacheEntry struct {
+	// URL is the URL of the response
+	URL string `json:"url"`
+
+	// ETag is the ETag header of the response, empty if there is none
+	ETag string `json:"etag,omitempty"`
+
+	// LastModified is the Last-Modified header of the response, empty if there is none
+	LastModified string `json:"last_modified,omitempty"`
+
+	// Body is the body of the response
+	Body []byte `json:"body"`
+
+	// Stored is the time the response was last fetched or validated
+	Stored time.Time `json:"stored"`
+}
+
+// Cache is a persistent cache for public documents such as the discovery files and the well-known endpoints.
+// Each response is saved in a file in the directory of the cache.
+// Responses with authorization or other secrets must not be cached as the files are not encrypted.
+type Cache struct {
+	// Directory is the directory where the responses are saved
+	Directory string
+
+	// mu protects the files of the cache
+	mu sync.Mutex
+}
+
+// NewCache creates a cache that saves the responses in `directory`.
+func NewCache(directory string) *Cache {
+	return &Cache{Directory: directory}
+}
+
+// filename returns the file of the response for `url`.
+func (cache *Cache) filename(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return filepath.Join(cache.Directory, hex.EncodeToString(hash[:])+".json")
+}
+
+// Get returns the cached response for `url`, the boolean is false if there is none.
+func (cache *Cache) Get(url string) (*CacheEntry, bool, error) {
+	if cache == nil {
+		return nil, false, nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	bytes, readErr := ioutil.ReadFile(cache.filename(url))
+	if readErr != nil {
+		if os.IsNotExist(readErr) {
+			return nil, false, nil
+		}
+		return nil, false, types.NewWrappedError("failed getting cached response", readErr)
+	}
+	entry := &CacheEntry{}
+	jsonErr := json.Unmarshal(bytes, entry)
+	if jsonErr != nil {
+		return nil, false, types.NewWrappedError("failed getting cached response", jsonErr)
+	}
+	// A different URL with the same hash is not the response that was asked for
+	if entry.URL != url {
+		return nil, false, nil
+	}
+	return entry, true, nil
+}
+
+// Set saves the response `entry`.
+func (cache *Cache) Set(entry CacheEntry) error {
+	if cache == nil {
+		return nil
+	}
+	errorMessage := "failed caching response"
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	dirErr := util.EnsureDirectory(cache.Directory)
+	if dirErr != nil {
+		return types.NewWrappedError(errorMessage, dirErr)
+	}
+	bytes, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		return types.NewWrappedError(errorMessage, jsonErr)
+	}
+	writeErr := ioutil.WriteFile(cache.filename(entry.URL), bytes, 0o600)
+	if writeErr != nil {
+		return types.NewWrappedError(errorMessage, writeErr)
+	}
+	return nil
+}
+
+// Remove removes the cached response for `url`.
+func (cache *Cache) Remove(url string) error {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	removeErr := os.Remove(cache.filename(url))
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		return types.NewWrappedError("failed removing cached response", removeErr)
+	}
+	return nil
+}
+
+// GetCached creates a Get request for a public document that is cached and returns the headers, body and an error.
+// If the document is cached, the request is conditional and the cached body is returned when the server responds with 304 Not Modified.
+// If the server cannot be reached or fails with a server error, the cached body is returned instead of the error.
+// Without a cache this is the same as Get.
+func (c *Client) GetCached(url string) (http.Header, []byte, error) {
+	if c == nil {
+		c = defaultClient
+	}
+	entry, cached, cacheErr := c.cache.Get(url)
+	if cacheErr != nil {
+		c.debugf("HTTP cache for %s: %v", url, cacheErr)
+		cached = false
+	}
+	if !cached {
+		header, body, getErr := c.Get(url)
+		if getErr == nil {
+			c.store(url, header, body)
+		}
+		return header, body, getErr
+	}
+
+	headers := http.Header{}
+	if entry.ETag != "" {
+		headers.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		headers.Set("If-Modified-Since", entry.LastModified)
+	}
+	header, body, getErr := c.GetWithOpts(url, &OptionalParams{Headers: headers})
+	if getErr == nil {
+		c.store(url, header, body)
+		return header, body, nil
+	}
+
+	var statusErr *StatusError
+	isStatus := errors.As(getErr, &statusErr)
+	if isStatus && statusErr.Status == http.StatusNotModified {
+		c.debugf("HTTP cache for %s: not modified since %v", url, entry.Stored)
+		entry.Stored = time.Now()
+		if setErr := c.cache.Set(*entry); setErr != nil {
+			c.debugf("HTTP cache for %s: %v", url, setErr)
+		}
+		return header, entry.Body, nil
+	}
+	// A client error such as a 404 means the document is gone, the cached body is no longer right
+	if isStatus && statusErr.Status < 500 {
+		return header, body, getErr
+	}
+	// A certificate that does not match the pins or a redirect that is not allowed could be an attack, this must not be hidden by the cache
+	if refused(getErr) {
+		return header, body, getErr
+	}
+	c.debugf("HTTP cache for %s: using the body from %v as the request failed: %v", url, entry.Stored, getErr)
+	return header, entry.Body, nil
+}
+
+// store saves the response for `url` with `header` and `body` in the cache, failures are only logged.
+func (c *Client) store(url string, header http.Header, body []byte) {
+	if c.cache == nil {
+		return
+	}
+	setErr := c.cache.Set(CacheEntry{
+		URL:          url,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Body:         body,
+		Stored:       time.Now(),
+	})
+	if setErr != nil {
+		c.debugf("HTTP cache for %s: %v", url, setErr)
+	}
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestGetCached(t *testing.T) {
+	var mu sync.Mutex
+	body := "v1"
+	status := 0
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditional = append(conditional, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		etag := `"` + body + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Sat, 01 Oct 2022 12:00:00 GMT")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	get := func(want string) {
+		t.Helper()
+		// A new client each time, the cache is persistent
+		c := NewClient(Options{Cache: NewCache(directory), Retry: RetryPolicy{IdempotentAttempts: 1}})
+		_, got, getErr := c.GetCached(server.URL)
+		if getErr != nil {
+			t.Fatalf("Got error: %v", getErr)
+		}
+		if string(got) != want {
+			t.Fatalf("Got body: %s, want: %s", got, want)
+		}
+	}
+
+	get("v1")
+	get("v1")
+	mu.Lock()
+	body = "v2"
+	mu.Unlock()
+	get("v2")
+	want := []string{"|", `"v1"|Sat, 01 Oct 2022 12:00:00 GMT`, `"v1"|Sat, 01 Oct 2022 12:00:00 GMT`}
+	mu.Lock()
+	if len(conditional) != len(want) {
+		t.Fatalf("Got conditional headers: %v, want: %v", conditional, want)
+	}
+	for i := range want {
+		if conditional[i] != want[i] {
+			t.Fatalf("Got conditional headers: %v, want: %v", conditional, want)
+		}
+	}
+
+	// The cached body is used when the server fails
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	get("v2")
+	server.Close()
+	get("v2")
+
+	// But not when the document is gone
+	gone := httptest.NewServer(http.NotFoundHandler())
+	defer gone.Close()
+	c := NewClient(Options{Cache: NewCache(directory)})
+	if setErr := c.cache.Set(CacheEntry{URL: gone.URL, Body: []byte("old")}); setErr != nil {
+		t.Fatalf("Got cache error: %v", setErr)
+	}
+	_, _, getErr := c.GetCached(gone.URL)
+	var statusErr *StatusError
+	if !errors.As(getErr, &statusErr) || statusErr.Status != http.StatusNotFound {
+		t.Fatalf("Got error: %v, want a 404", getErr)
+	}
+
+	// Without a cache nothing is cached
+	c = NewClient(Options{Retry: RetryPolicy{IdempotentAttempts: 1}})
+	if _, _, getErr = c.GetCached(server.URL); getErr == nil {
+		t.Fatalf("Got no error without a cache for a server that is down")
+	}
+}
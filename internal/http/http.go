@@ -2,14 +2,20 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/eduvpn/eduvpn-common/internal/version"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
@@ -21,7 +27,12 @@ type OptionalParams struct {
 	Headers       http.Header
 	URLParameters URLParameters
 	Body          url.Values
-	Timeout       time.Duration
+
+	// Timeout is the timeout of the request, zero means the timeout of the client
+	Timeout time.Duration
+
+	// Operation is the label of the request for the observer, e.g. "oauth.token.refresh_token"
+	Operation string
 }
 
 // ConstructURL creates a URL with the included parameters.
@@ -47,24 +58,171 @@ func ConstructURL(baseURL string, parameters URLParameters) (string, error) {
 	return url.String(), nil
 }
 
+// defaultTimeout is the timeout of a request if the client and the request do not set one.
+const defaultTimeout = 5 * time.Second
+
+// Options are the options of a HTTP client.
+type Options struct {
+	// RootCAs are the certificate authorities that are trusted, nil means the roots of the system
+	RootCAs *x509.CertPool
+
+	// MinTLSVersion is the minimum TLS version, e.g. tls.VersionTLS13, zero means TLS 1.2
+	MinTLSVersion uint16
+
+	// UserAgent is the User-Agent header that is sent with every request, empty means the Go default
+	UserAgent string
+
+	// Timeout is the timeout of a request if it does not set one itself, zero means 5 seconds
+	Timeout time.Duration
+
+	// Retry is the policy for retrying failed requests
+	Retry RetryPolicy
+
+	// Pins are the pinned public keys of the TLS certificates for each host, nil means no host is pinned
+	// They are only enforced by the transport that is created when Transport is nil
+	Pins *Pins
+
+	// AllowedOrigins are the origins in the form scheme://host:port, besides the origin of a server, that its endpoints and redirects can be on
+	// An allowed origin can also use plain HTTP, otherwise HTTPS is required except for the loopback address
+	AllowedOrigins []string
+
+	// Cache is the cache for the public documents that are fetched with GetCached, nil means they are not cached
+	Cache *Cache
+
+	// Debugf logs each attempt of a request, nil means the attempts are not logged
+	Debugf func(msg string, params ...interface{})
+
+	// Observer is called at the start and the end of each attempt of a request, nil means NopObserver
+	Observer Observer
+
+	// Proxy returns the proxy for a request, nil means the proxy from the environment variables
+	// It is only used by the transport that is created when Transport is nil
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Transport is used to do the requests, e.g. to test without network access
+	// If nil, a transport with keep-alive and the TLS options above is created
+	Transport http.RoundTripper
+}
+
+// Client is a HTTP client that reuses its connections for all requests.
+// A nil Client uses a shared client with the default options.
+type Client struct {
+	// client is the underlying HTTP client, the timeout is set per request
+	client *http.Client
+
+	// userAgent is the User-Agent header for every request
+	userAgent string
+
+	// timeout is the default timeout for a request
+	timeout time.Duration
+
+	// retry is the policy for retrying failed requests with the defaults filled in
+	retry RetryPolicy
+
+	// pins are the pinned public keys for each host, nil means none
+	pins *Pins
+
+	// allowedOrigins are the normalized allowed origins
+	allowedOrigins []string
+
+	// cache is the cache for GetCached, nil means no caching
+	cache *Cache
+
+	// debugf logs each attempt of a request, it is never nil
+	debugf func(msg string, params ...interface{})
+
+	// observer observes each attempt of a request, it is never nil
+	observer Observer
+
+	// mu protects redirects as the client is used by multiple goroutines
+	mu sync.Mutex
+
+	// redirects are the URLs that the last request to each URL was permanently redirected to
+	redirects map[string]string
+}
+
+// defaultClient is the client that is used by a nil Client.
+var defaultClient = NewClient(Options{})
+
+// NewClient creates a HTTP client with `options`.
+func NewClient(options Options) *Client {
+	transport := options.Transport
+	if transport == nil {
+		minVersion := options.MinTLSVersion
+		if minVersion == 0 {
+			minVersion = tls.VersionTLS12
+		}
+		defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
+		defaultTransport.TLSClientConfig = &tls.Config{
+			RootCAs:    options.RootCAs,
+			MinVersion: minVersion,
+		}
+		if options.Pins != nil {
+			defaultTransport.TLSClientConfig.VerifyConnection = options.Pins.verifyConnection
+		}
+		if options.Proxy != nil {
+			defaultTransport.Proxy = options.Proxy
+		}
+		transport = defaultTransport
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	debugf := options.Debugf
+	if debugf == nil {
+		debugf = func(string, ...interface{}) {}
+	}
+	observer := options.Observer
+	if observer == nil {
+		observer = NopObserver{}
+	}
+	var allowedOrigins []string
+	for _, allowedOrigin := range options.AllowedOrigins {
+		if normalized, originErr := Origin(allowedOrigin); originErr == nil {
+			allowedOrigins = append(allowedOrigins, normalized)
+		}
+	}
+	c := &Client{
+		client:    &http.Client{Transport: transport},
+		userAgent: options.UserAgent,
+		timeout:   timeout,
+		retry:     options.Retry.withDefaults(),
+		cache:     options.Cache,
+		pins:      options.Pins,
+		debugf:    debugf,
+		observer:  observer,
+		redirects: make(map[string]string),
+
+		allowedOrigins: allowedOrigins,
+	}
+	c.client.CheckRedirect = c.checkRedirect
+	return c
+}
+
+// UserAgent returns the User-Agent for the client with ID `clientID`, it includes the version of the library.
+func UserAgent(clientID string) string {
+	return fmt.Sprintf("%s eduvpn-common/%s", clientID, version.Version)
+}
+
 // Get creates a Get request and returns the headers, body and an error.
-func Get(url string) (http.Header, []byte, error) {
-	return MethodWithOpts(http.MethodGet, url, nil)
+func (c *Client) Get(url string) (http.Header, []byte, error) {
+	return c.MethodWithOpts(http.MethodGet, url, nil)
 }
 
 // Post creates a Post request and returns the headers, body and an error.
-func Post(url string, body url.Values) (http.Header, []byte, error) {
-	return MethodWithOpts(http.MethodGet, url, &OptionalParams{Body: body})
+func (c *Client) Post(url string, body url.Values) (http.Header, []byte, error) {
+	return c.MethodWithOpts(http.MethodPost, url, &OptionalParams{Body: body})
 }
 
 // GetWithOpts creates a Get request with optional parameters and returns the headers, body and an error.
-func GetWithOpts(url string, opts *OptionalParams) (http.Header, []byte, error) {
-	return MethodWithOpts(http.MethodGet, url, opts)
+func (c *Client) GetWithOpts(url string, opts *OptionalParams) (http.Header, []byte, error) {
+	return c.MethodWithOpts(http.MethodGet, url, opts)
 }
 
 // PostWithOpts creates a Post request with optional parameters and returns the headers, body and an error.
-func PostWithOpts(url string, opts *OptionalParams) (http.Header, []byte, error) {
-	return MethodWithOpts(http.MethodPost, url, opts)
+func (c *Client) PostWithOpts(url string, opts *OptionalParams) (http.Header, []byte, error) {
+	return c.MethodWithOpts(http.MethodPost, url, opts)
 }
 
 // optionalURL ensures that the URL contains the optional parameters
@@ -102,13 +260,58 @@ func optionalBodyReader(opts *OptionalParams) io.Reader {
 	return nil
 }
 
+// attempt does one attempt of a request with a method, an url, optional parameters and a timeout.
+// It returns the response with the body that is already read or an error.
+func (c *Client) attempt(
+	method string,
+	url string,
+	opts *OptionalParams,
+	timeout time.Duration,
+) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Create request object with the body reader generated from the optional arguments
+	// The body reader is created for every attempt as a previous attempt consumed it
+	req, reqErr := http.NewRequestWithContext(ctx, method, url, optionalBodyReader(opts))
+	if reqErr != nil {
+		return nil, nil, reqErr
+	}
+
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	// Make sure the headers contain all the parameters
+	optionalHeaders(req, opts)
+
+	// Do request
+	resp, respErr := c.client.Do(req)
+	if respErr != nil {
+		return nil, nil, respErr
+	}
+
+	// Request successful, make sure body is closed at the end
+	defer resp.Body.Close()
+
+	body, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		return resp, nil, readErr
+	}
+	return resp, body, nil
+}
+
 // MethodWithOpts creates a HTTP request using a method (e.g. GET, POST), an url and optional parameters
+// Failed requests are retried according to the retry policy of the client.
 // It returns the HTTP headers, the body and an error if there is one.
-func MethodWithOpts(
+func (c *Client) MethodWithOpts(
 	method string,
 	url string,
 	opts *OptionalParams,
 ) (http.Header, []byte, error) {
+	if c == nil {
+		c = defaultClient
+	}
 	// Make sure the url contains all the parameters
 	// This can return an error,
 	// it already has the right error so we don't wrap it further
@@ -118,48 +321,69 @@ func MethodWithOpts(
 		return nil, nil, urlErr
 	}
 
-	// Default timeout is 5 seconds
-	// If a different timeout is given, set it
-	var timeout time.Duration = 5
+	// Use the timeout of the request if it is given, otherwise the one of the client
+	timeout := c.timeout
 	if opts != nil && opts.Timeout > 0 {
 		timeout = opts.Timeout
 	}
 
-	// Create a client
-	client := &http.Client{Timeout: timeout * time.Second}
-
 	errorMessage := fmt.Sprintf("failed HTTP request with method %s and url %s", method, url)
 
-	// Create request object with the body reader generated from the optional arguments
-	req, reqErr := http.NewRequest(method, url, optionalBodyReader(opts))
-	if reqErr != nil {
-		return nil, nil, types.NewWrappedError(errorMessage, reqErr)
+	attempts := c.retry.attempts(method)
+	var resp *http.Response
+	var body []byte
+	var attemptErr error
+	start := RequestStart{ID: newRequestID(), Method: method, URL: RedactURL(url)}
+	if opts != nil {
+		start.Operation = opts.Operation
 	}
-
-	// See https://stackoverflow.com/questions/17714494/golang-http-request-results-in-eof-errors-when-making-multiple-requests-successi
-	req.Close = true
-
-	// Make sure the headers contain all the parameters
-	optionalHeaders(req, opts)
-
-	// Do request
-	resp, respErr := client.Do(req)
-	if respErr != nil {
-		return nil, nil, types.NewWrappedError(errorMessage, respErr)
+	var sent int64
+	if opts != nil && opts.Body != nil {
+		sent = int64(len(opts.Body.Encode()))
 	}
+	for attempt := 1; ; attempt++ {
+		start.Attempt = attempt
+		start.Time = time.Now()
+		c.observer.RequestStarted(start)
+		resp, body, attemptErr = c.attempt(method, url, opts, timeout)
+		end := RequestEnd{
+			RequestStart:  start,
+			BytesSent:     sent,
+			BytesReceived: int64(len(body)),
+			Duration:      time.Since(start.Time),
+			Err:           attemptErr,
+		}
+		if resp != nil {
+			end.Status = resp.StatusCode
+		}
+		c.observer.RequestFinished(end)
+		var result string
+		if attemptErr != nil {
+			// The error can have the URL with its query
+			result = strings.ReplaceAll(attemptErr.Error(), url, start.URL)
+		} else {
+			result = fmt.Sprintf("status code %d", resp.StatusCode)
+		}
+		delay, retry := c.retry.retryDelay(method, resp, attemptErr, attempt)
+		if !retry {
+			c.debugf("HTTP %s %s attempt %d/%d: %s", method, start.URL, attempt, attempts, result)
+			break
+		}
+		c.debugf("HTTP %s %s attempt %d/%d: %s, retrying in %v", method, start.URL, attempt, attempts, result, delay)
+		sleep(delay)
+	}
+	c.recordRedirect(url, resp)
 
-	// Request successful, make sure body is closed at the end
-	defer resp.Body.Close()
-
-	// Return a string
-	body, readErr := ioutil.ReadAll(resp.Body)
-	if readErr != nil {
-		return resp.Header, nil, types.NewWrappedError(errorMessage, readErr)
+	if attemptErr != nil {
+		if resp != nil {
+			return resp.Header, nil, types.NewWrappedError(errorMessage, attemptErr)
+		}
+		return nil, nil, types.NewWrappedError(errorMessage, attemptErr)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		// We make this a custom error because we want to extract the status code later
-		statusErr := &StatusError{URL: url, Body: string(body), Status: resp.StatusCode}
+		statusErr := &StatusError{URL: url, Body: string(body), Status: resp.StatusCode, API: parseAPIError(resp.StatusCode, body)}
 		return resp.Header, body, types.NewWrappedError(errorMessage, statusErr)
 	}
 
@@ -168,19 +392,29 @@ func MethodWithOpts(
 }
 
 // StatusError indicates that we have received a HTTP status error.
+// If the body is a JSON error of the server, it is parsed in API such that it is the cause of the error.
 type StatusError struct {
 	URL    string
 	Body   string
 	Status int
+	API    *APIError
+}
+
+// Unwrap returns the parsed error of the server, nil if the body is not a JSON error.
+func (e *StatusError) Unwrap() error {
+	if e.API == nil {
+		return nil
+	}
+	return e.API
 }
 
 // Error returns the StatusError as an error string.
+// The body is left out as it can have secrets or a whole error page, the parsed error of the server is the cause instead.
 func (e *StatusError) Error() string {
 	return fmt.Sprintf(
-		"failed obtaining HTTP resource: %s as it gave an unsuccessful status code: %d. Body: %s",
-		e.URL,
+		"failed obtaining HTTP resource: %s as it gave an unsuccessful status code: %d",
+		RedactURL(e.URL),
 		e.Status,
-		e.Body,
 	)
 }
 
@@ -202,3 +436,31 @@ func (e *ParseJSONError) Error() string {
 		e.Err,
 	)
 }
+
+// LoadRootCAs loads the PEM encoded certificate authorities in the file with `path`.
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	errorMessage := fmt.Sprintf("failed loading the root CAs from %s", path)
+	pem, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, types.NewWrappedError(errorMessage, readErr)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, types.NewWrappedError(errorMessage, errors.New("no PEM certificates found"))
+	}
+	return pool, nil
+}
+
+// ParseTLSVersion parses a TLS version in the form "1.2" or "1.3", an empty version is zero.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version: %s, want 1.2 or 1.3", version)
+	}
+}
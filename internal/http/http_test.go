@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientTrust(t *testing.T) {
+	var userAgent string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	// The test certificate is not trusted by the system roots
+	if _, _, getErr := (*Client)(nil).Get(server.URL); getErr == nil {
+		t.Fatalf("Got no error for an untrusted certificate")
+	}
+
+	c := NewClient(Options{RootCAs: roots, UserAgent: UserAgent("org.eduvpn.app.linux")})
+	_, body, getErr := c.Get(server.URL)
+	if getErr != nil {
+		t.Fatalf("Got error: %v", getErr)
+	}
+	if string(body) != "ok" {
+		t.Fatalf("Got body: %s, want: ok", string(body))
+	}
+	if !strings.HasPrefix(userAgent, "org.eduvpn.app.linux eduvpn-common/") {
+		t.Fatalf("Got User-Agent: %s, want the client ID and library version", userAgent)
+	}
+
+	// The server only supports TLS 1.2
+	c = NewClient(Options{RootCAs: roots, MinTLSVersion: tls.VersionTLS13})
+	if _, _, getErr = c.Get(server.URL); getErr == nil {
+		t.Fatalf("Got no error for a server below the minimum TLS version")
+	}
+}
+
+// roundTripFunc is a function that is used as a round tripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientTimeout(t *testing.T) {
+	c := NewClient(Options{
+		Timeout: 10 * time.Millisecond,
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}),
+	})
+	start := time.Now()
+	_, _, getErr := c.Get("https://eduvpn.example.org")
+	if !errors.Is(getErr, context.DeadlineExceeded) {
+		t.Fatalf("Got error: %v, want a timeout", getErr)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Got a timeout after: %v, want: 10ms", elapsed)
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	// The requests go through the proxy, the host of the server does not have to resolve
+	c := NewClient(Options{Proxy: http.ProxyURL(proxyURL)})
+	_, body, getErr := c.Get("http://portal.test/.well-known/vpn-user-portal")
+	if getErr != nil || string(body) != "ok" {
+		t.Fatalf("Got body: %s and error: %v, want ok from the proxy", body, getErr)
+	}
+	if len(proxied) != 1 || proxied[0] != "GET http://portal.test/.well-known/vpn-user-portal" {
+		t.Fatalf("Got proxied requests: %v, want the request to the server", proxied)
+	}
+
+	// A transport of the caller is used as is
+	c = NewClient(Options{
+		Proxy: http.ProxyURL(proxyURL),
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("not sent")
+		}),
+	})
+	if _, _, getErr = c.Get("http://portal.test/"); getErr == nil || len(proxied) != 1 {
+		t.Fatalf("Got error: %v and proxied requests: %v, want the transport of the caller", getErr, proxied)
+	}
+}
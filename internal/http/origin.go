@@ -0,0 +1,212 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/internal/proxy"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// OriginError is returned when a URL that a server gives, such as an endpoint in its well-known document or the location of a redirect, is not allowed.
+// It is not retried and no token is sent to such a URL.
+type OriginError struct {
+	// URL is the URL that is not allowed
+	URL string
+
+	// Reason is why the URL is not allowed
+	Reason string
+}
+
+func (e *OriginError) Error() string {
+	return fmt.Sprintf("the URL %s is not allowed: %s", e.URL, e.Reason)
+}
+
+// Code returns the stable code of the error.
+func (e *OriginError) Code() types.ErrCode {
+	return types.ErrCodeEndpointNotAllowed
+}
+
+// RedirectError is returned when a server redirects to a URL that is not allowed.
+type RedirectError struct {
+	OriginError
+
+	// From is the URL that redirected
+	From string
+
+	// Status is the status code of the redirect, e.g. 301
+	Status int
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("refused the redirect with status %d from %s: %s", e.Status, e.From, e.OriginError.Error())
+}
+
+// Origin returns the origin of `rawURL` in the form scheme://host:port, with the default port of the scheme filled in.
+func Origin(rawURL string) (string, error) {
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return "", types.NewWrappedError(fmt.Sprintf("failed getting the origin of: %s", rawURL), parseErr)
+	}
+	return origin(parsed), nil
+}
+
+// origin returns the origin of the parsed URL `u`.
+func origin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// allowed returns whether or not the origin of `u` is explicitly allowed by the client.
+func (c *Client) allowed(u *url.URL) bool {
+	o := origin(u)
+	for _, allowedOrigin := range c.allowedOrigins {
+		if allowedOrigin == o {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecure checks that `u` uses HTTPS.
+// Plain HTTP is only allowed for a test server on the loopback address and for the origins that the client allows.
+func (c *Client) checkSecure(u *url.URL) *OriginError {
+	if u.Hostname() == "" {
+		return &OriginError{URL: u.String(), Reason: "it has no host"}
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		return nil
+	case "http":
+		if proxy.IsLoopback(u.Hostname()) || c.allowed(u) {
+			return nil
+		}
+	}
+	return &OriginError{URL: u.String(), Reason: "it does not use HTTPS"}
+}
+
+// CheckSecure checks that `rawURL` uses HTTPS, it returns an *OriginError if it does not.
+// Plain HTTP is only allowed for a test server on the loopback address and for the origins that the client allows.
+func (c *Client) CheckSecure(rawURL string) error {
+	if c == nil {
+		c = defaultClient
+	}
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return &OriginError{URL: rawURL, Reason: parseErr.Error()}
+	}
+	if secureErr := c.checkSecure(parsed); secureErr != nil {
+		return secureErr
+	}
+	return nil
+}
+
+// CheckOrigin checks that `endpoint` uses HTTPS and has the same origin as `baseURL`, it returns an *OriginError if it does not.
+// An endpoint on another origin is only allowed if the client allows that origin.
+func (c *Client) CheckOrigin(baseURL string, endpoint string) error {
+	if c == nil {
+		c = defaultClient
+	}
+	if secureErr := c.CheckSecure(endpoint); secureErr != nil {
+		return secureErr
+	}
+	base, baseErr := url.Parse(baseURL)
+	if baseErr != nil {
+		return &OriginError{URL: baseURL, Reason: baseErr.Error()}
+	}
+	parsed, _ := url.Parse(endpoint)
+	if origin(parsed) != origin(base) && !c.allowed(parsed) {
+		return &OriginError{URL: endpoint, Reason: fmt.Sprintf("it is not on the origin of %s", origin(base))}
+	}
+	return nil
+}
+
+// checkRedirect is the redirect policy of the HTTP client.
+// It refuses a redirect that downgrades to plain HTTP or that leaves the origin of the first request, unless the client allows the new origin.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	first := via[0].URL
+	previous := via[len(via)-1].URL
+	status := 0
+	if req.Response != nil {
+		status = req.Response.StatusCode
+	}
+	refuse := func(reason string) error {
+		return &RedirectError{
+			OriginError: OriginError{URL: req.URL.String(), Reason: reason},
+			From:        previous.String(),
+			Status:      status,
+		}
+	}
+	if secureErr := c.checkSecure(req.URL); secureErr != nil {
+		return refuse(secureErr.Reason)
+	}
+	if strings.EqualFold(previous.Scheme, "https") && !strings.EqualFold(req.URL.Scheme, "https") && !c.allowed(req.URL) {
+		return refuse("it downgrades HTTPS to HTTP")
+	}
+	if origin(req.URL) != origin(first) && !c.allowed(req.URL) {
+		return refuse(fmt.Sprintf("it leaves the origin %s", origin(first)))
+	}
+	return nil
+}
+
+// permanentRedirect returns the URL that `resp` was fetched from if its request was redirected and every redirect was permanent, i.e. had status 301 or 308.
+func permanentRedirect(resp *http.Response) (string, bool) {
+	if resp == nil || resp.Request == nil || resp.Request.Response == nil {
+		return "", false
+	}
+	for redirect := resp.Request.Response; redirect != nil; redirect = redirect.Request.Response {
+		if redirect.StatusCode != http.StatusMovedPermanently && redirect.StatusCode != http.StatusPermanentRedirect {
+			return "", false
+		}
+	}
+	return resp.Request.URL.String(), true
+}
+
+// recordRedirect records where the request to `url` with response `resp` was permanently redirected to, or that it was not.
+func (c *Client) recordRedirect(url string, resp *http.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if redirected, ok := permanentRedirect(resp); ok {
+		c.redirects[url] = redirected
+		return
+	}
+	delete(c.redirects, url)
+}
+
+// Redirected returns the URL that the last request to `url` was permanently redirected to and followed.
+// It returns false if that request was not redirected, if one of its redirects was temporary or if it failed before a response.
+// A redirect that is refused by the redirect policy is a *RedirectError of the request instead.
+func (c *Client) Redirected(url string) (string, bool) {
+	if c == nil {
+		c = defaultClient
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	redirected, ok := c.redirects[url]
+	return redirected, ok
+}
+
+// refused returns whether or not `err` is an error for a connection or URL that the client refused, e.g. because the pins do not match.
+// These errors are never retried nor hidden by the cache.
+func refused(err error) bool {
+	var pinErr *PinError
+	var originErr *OriginError
+	var redirectErr *RedirectError
+	return errors.As(err, &pinErr) || errors.As(err, &originErr) || errors.As(err, &redirectErr)
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	client := NewClient(Options{AllowedOrigins: []string{"https://api.example.org", "http://portal.test:8080"}})
+	cases := []struct {
+		base     string
+		endpoint string
+		wantErr  bool
+	}{
+		{base: "https://vpn.example.org/", endpoint: "https://vpn.example.org/api/v3"},
+		{base: "https://vpn.example.org/", endpoint: "https://VPN.example.org:443/oauth/token"},
+		{base: "http://127.0.0.1:1234/", endpoint: "http://127.0.0.1:1234/api/v3"},
+		{base: "http://localhost:1234/", endpoint: "http://localhost:1234/api/v3"},
+		{base: "https://vpn.example.org/", endpoint: "https://api.example.org/api/v3"},
+		{base: "http://portal.test:8080/", endpoint: "http://portal.test:8080/api/v3"},
+		// Plain HTTP for a server that is not on the loopback address
+		{base: "http://vpn.example.org/", endpoint: "http://vpn.example.org/api/v3", wantErr: true},
+		{base: "https://vpn.example.org/", endpoint: "http://vpn.example.org/api/v3", wantErr: true},
+		// Another origin
+		{base: "https://vpn.example.org/", endpoint: "https://evil.example.org/oauth/token", wantErr: true},
+		{base: "https://vpn.example.org/", endpoint: "https://vpn.example.org:8443/oauth/token", wantErr: true},
+		{base: "http://127.0.0.1:1234/", endpoint: "http://127.0.0.1:4321/api/v3", wantErr: true},
+		{base: "https://vpn.example.org/", endpoint: "", wantErr: true},
+	}
+	for _, c := range cases {
+		originErr := client.CheckOrigin(c.base, c.endpoint)
+		if c.wantErr != (originErr != nil) {
+			t.Errorf("got error: %v for endpoint: %s of: %s, want error: %v", originErr, c.endpoint, c.base, c.wantErr)
+			continue
+		}
+		if c.wantErr && types.ErrorCode(originErr) != types.ErrCodeEndpointNotAllowed {
+			t.Errorf("got error code: %q for endpoint: %s, want: %q", types.ErrorCode(originErr), c.endpoint, types.ErrCodeEndpointNotAllowed)
+		}
+	}
+}
+
+func TestRedirectRefused(t *testing.T) {
+	stubSleep(t)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("moved"))
+	}))
+	defer target.Close()
+	redirects := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/target":
+			_, _ = w.Write([]byte("same"))
+		case "/downgrade":
+			http.Redirect(w, r, "http://vpn.example.org/", http.StatusMovedPermanently)
+		default:
+			http.Redirect(w, r, target.URL+"/", http.StatusMovedPermanently)
+		}
+	}))
+	defer redirects.Close()
+
+	client := NewClient(Options{})
+	_, body, getErr := client.Get(redirects.URL + "/same")
+	if getErr != nil || string(body) != "same" {
+		t.Fatalf("got: %s, %v for a redirect on the same origin, want: same", body, getErr)
+	}
+
+	for _, path := range []string{"/other", "/downgrade"} {
+		_, _, getErr = client.Get(redirects.URL + path)
+		var redirectErr *RedirectError
+		if !errors.As(getErr, &redirectErr) {
+			t.Fatalf("got error: %v for %s, want a RedirectError", getErr, path)
+		}
+		if redirectErr.Status != http.StatusMovedPermanently || redirectErr.From != redirects.URL+path {
+			t.Fatalf("got redirect error: %+v for %s, want the status and the URL that redirected", redirectErr, path)
+		}
+		if types.ErrorCode(getErr) != types.ErrCodeEndpointNotAllowed {
+			t.Fatalf("got error code: %q for %s, want: %q", types.ErrorCode(getErr), path, types.ErrCodeEndpointNotAllowed)
+		}
+	}
+
+	// The origin can be allowed
+	allowed := NewClient(Options{AllowedOrigins: []string{target.URL}})
+	_, body, getErr = allowed.Get(redirects.URL + "/other")
+	if getErr != nil || string(body) != "moved" {
+		t.Fatalf("got: %s, %v for a redirect to an allowed origin, want: moved", body, getErr)
+	}
+}
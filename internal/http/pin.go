@@ -0,0 +1,167 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// PinError is returned when the TLS certificate chain of a server has none of the public keys that are pinned for it.
+// It is not retried, as it indicates that the connection is intercepted or that the server changed its keys without a backup pin.
+type PinError struct {
+	// Host is the host whose certificate did not match
+	Host string
+
+	// Got are the pins of the certificate chain that the server presented
+	Got []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf(
+		"the TLS certificate of %s does not match any of the pinned public keys, got: %s",
+		e.Host,
+		strings.Join(e.Got, ", "),
+	)
+}
+
+// Code returns the stable code of the error.
+func (e *PinError) Code() types.ErrCode {
+	return types.ErrCodePinMismatch
+}
+
+// SPKIPin returns the pin of `cert`, the base64 encoded SHA-256 hash of its SubjectPublicKeyInfo.
+// This is the same format as the pins of HPKP, e.g. as printed by:
+// openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64.
+func SPKIPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ParsePin parses a pin in the form "sha256/<base64>" or "<base64>" and returns it without the prefix.
+func ParsePin(pin string) (string, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	hash, decodeErr := base64.StdEncoding.DecodeString(pin)
+	if decodeErr != nil {
+		return "", types.NewWrappedError(fmt.Sprintf("failed parsing pin: %s", pin), decodeErr)
+	}
+	if len(hash) != sha256.Size {
+		return "", types.NewWrappedError(
+			fmt.Sprintf("failed parsing pin: %s", pin),
+			fmt.Errorf("got a hash of %d bytes, want a SHA-256 hash of %d bytes", len(hash), sha256.Size),
+		)
+	}
+	return pin, nil
+}
+
+// Pins are the pinned public keys for each host.
+// A host without pins accepts any certificate that is trusted by the root CAs.
+// Hosts are matched by the TLS server name, so a server that is reached by its IP address cannot be pinned.
+// The pins do not replace the normal certificate verification, they restrict it further.
+type Pins struct {
+	// mu protects the maps below as the pins are checked by the connections of the transport
+	mu sync.Mutex
+
+	// pins are the pins for each host, a certificate chain must have at least one of them
+	pins map[string][]string
+
+	// observed are the pins of the last certificate chain that each host presented
+	observed map[string][]string
+}
+
+// NewPins creates the pins without any pinned host.
+func NewPins() *Pins {
+	return &Pins{pins: make(map[string][]string), observed: make(map[string][]string)}
+}
+
+// Replace replaces all pins with `pins`, a map from the host to its pins.
+func (p *Pins) Replace(pins map[string][]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pins = make(map[string][]string)
+	for host, hostPins := range pins {
+		if len(hostPins) > 0 {
+			p.pins[strings.ToLower(host)] = append([]string(nil), hostPins...)
+		}
+	}
+}
+
+// Get returns the pins for `host`.
+func (p *Pins) Get(host string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.pins[strings.ToLower(host)]...)
+}
+
+// Observed returns the pins of the last certificate chain that `host` presented, the leaf first.
+// These are learned on first use.
+func (p *Pins) Observed(host string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.observed[strings.ToLower(host)]...)
+}
+
+// verifyConnection checks the verified certificate chain of the connection `state` against the pins of its host.
+// It is used as the VerifyConnection of the TLS configuration such that it runs after the normal verification.
+func (p *Pins) verifyConnection(state tls.ConnectionState) error {
+	host := strings.ToLower(state.ServerName)
+	// The leaf and the certificates of the verified chains, such that the key of an intermediate or root CA can be pinned as a backup
+	var got []string
+	seen := make(map[string]bool)
+	add := func(cert *x509.Certificate) {
+		pin := SPKIPin(cert)
+		if !seen[pin] {
+			seen[pin] = true
+			got = append(got, pin)
+		}
+	}
+	if len(state.PeerCertificates) > 0 {
+		add(state.PeerCertificates[0])
+	}
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			add(cert)
+		}
+	}
+
+	p.mu.Lock()
+	p.observed[host] = got
+	pins := p.pins[host]
+	p.mu.Unlock()
+
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, pin := range pins {
+		if seen[pin] {
+			return nil
+		}
+	}
+	return &PinError{Host: host, Got: got}
+}
+
+// VerifyPins checks the pins of the host of `rawURL` by connecting to it, if the host has pins.
+// This is used for URLs that are opened by the browser, such as the authorization URL, as the client cannot enforce the pins there.
+// Only a *PinError is returned, other errors are left for the browser to show.
+func (c *Client) VerifyPins(rawURL string) error {
+	if c == nil {
+		c = defaultClient
+	}
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil || parsed.Scheme != "https" || c.pins == nil || len(c.pins.Get(parsed.Hostname())) == 0 {
+		return nil
+	}
+	_, _, headErr := c.MethodWithOpts("HEAD", (&url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/"}).String(), nil)
+	var pinErr *PinError
+	if errors.As(headErr, &pinErr) {
+		return types.NewWrappedError(fmt.Sprintf("failed verifying the pins of %s", parsed.Host), pinErr)
+	}
+	return nil
+}
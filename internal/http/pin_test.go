@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// pinServer returns the URL of a TLS server, its certificate, a client that trusts it with `pins` and the number of requests that the server got.
+// The URL has the host example.com as the pins are matched by the TLS server name, which is empty for an IP address.
+func pinServer(t *testing.T) (string, *x509.Certificate, *Pins, *Client, func() int) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	roots := x509.NewCertPool()
+	cert := server.Certificate()
+	roots.AddCert(cert)
+	pins := NewPins()
+	client := NewClient(Options{RootCAs: roots, Pins: pins})
+	address := server.Listener.Addr().String()
+	dialer := &net.Dialer{}
+	client.client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network string, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	_, port, _ := net.SplitHostPort(address)
+	return "https://example.com:" + port, cert, pins, client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+func TestPinsMatch(t *testing.T) {
+	serverURL, cert, pins, client, _ := pinServer(t)
+	pin := SPKIPin(cert)
+	// The first pin is a backup pin that the server does not use
+	pins.Replace(map[string][]string{"example.com": {"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", pin}})
+
+	_, body, getErr := client.Get(serverURL)
+	if getErr != nil {
+		t.Fatalf("got error with a matching pin: %v", getErr)
+	}
+	if string(body) != "ok" {
+		t.Fatalf("got body: %s, want: ok", body)
+	}
+	if observed := pins.Observed("example.com"); len(observed) == 0 || observed[0] != pin {
+		t.Fatalf("got observed pins: %v, want the leaf first: %s", observed, pin)
+	}
+}
+
+func TestPinsMismatch(t *testing.T) {
+	stubSleep(t)
+	serverURL, cert, pins, client, count := pinServer(t)
+	pins.Replace(map[string][]string{"example.com": {"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}})
+
+	_, _, getErr := client.Get(serverURL)
+	var pinErr *PinError
+	if !errors.As(getErr, &pinErr) {
+		t.Fatalf("got error: %v, want a PinError", getErr)
+	}
+	if types.ErrorCode(getErr) != types.ErrCodePinMismatch {
+		t.Fatalf("got error code: %s, want: %s", types.ErrorCode(getErr), types.ErrCodePinMismatch)
+	}
+	// The handshake fails so the server never gets a request, it is not retried either
+	if count() != 0 {
+		t.Fatalf("got %d requests, want 0", count())
+	}
+	if len(pinErr.Got) == 0 || pinErr.Got[0] != SPKIPin(cert) {
+		t.Fatalf("got pins in the error: %v, want the pin of the server", pinErr.Got)
+	}
+
+	verifyErr := client.VerifyPins(serverURL + "/authorize")
+	if !errors.As(verifyErr, &pinErr) {
+		t.Fatalf("got verify error: %v, want a PinError", verifyErr)
+	}
+
+	// Without pins the host accepts the certificate again
+	pins.Replace(nil)
+	if _, _, getErr = client.Get(serverURL); getErr != nil {
+		t.Fatalf("got error without pins: %v", getErr)
+	}
+	if verifyErr = client.VerifyPins(serverURL); verifyErr != nil {
+		t.Fatalf("got verify error without pins: %v", verifyErr)
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	valid := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+	cases := []struct {
+		pin     string
+		want    string
+		wantErr bool
+	}{
+		{pin: valid, want: valid},
+		{pin: "sha256/" + valid, want: valid},
+		{pin: " sha256/" + valid + "\n", want: valid},
+		{pin: "not base64!", wantErr: true},
+		// A SHA-1 hash is too short
+		{pin: "2jmj7l5rSw0yVb/vlWAYkK/YBwk=", wantErr: true},
+	}
+	for _, c := range cases {
+		got, parseErr := ParsePin(c.pin)
+		if c.wantErr {
+			if parseErr == nil {
+				t.Errorf("got no error for pin: %q", c.pin)
+			}
+			continue
+		}
+		if parseErr != nil || got != c.want {
+			t.Errorf("got: %q, %v for pin: %q, want: %q", got, parseErr, c.pin, c.want)
+		}
+	}
+}
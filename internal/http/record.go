@@ -0,0 +1,374 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// Fixture is a recorded session of requests and responses that a Replayer serves in tests.
+type Fixture struct {
+	// Exchanges are the request and response pairs in the order they were recorded
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// Exchange is a recorded request with its response.
+type Exchange struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request with the secrets and the values that change on every run redacted.
+// The origin of the server is replaced by the {{origin}} placeholder, such that the fixture can be replayed for any server URL.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response with the secrets redacted.
+// Besides {{origin}}, it can contain the placeholders {{origin.query}} for the query escaped origin and {{query.NAME}} for the value of the query parameter NAME of the request.
+type RecordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// secretParameters are the parameters, in a query, form or JSON body, whose values are redacted as they are tokens or keys.
+var secretParameters = []string{
+	"access_token",
+	"client_secret",
+	"code",
+	"code_verifier",
+	"private_key",
+	"public_key",
+	"refresh_token",
+}
+
+// volatileParameters are the request parameters whose values change on every run and are therefore redacted for matching.
+// Their values in a response, e.g. the state in the redirect of the authorization, are replaced by a {{query.NAME}} placeholder.
+var volatileParameters = []string{
+	"code_challenge",
+	"redirect_uri",
+	"state",
+}
+
+// recordedHeaders are the headers that are recorded, other headers such as the Date and the User-Agent are left out.
+var recordedHeaders = []string{
+	"Accept",
+	"Authorization",
+	"Cache-Control",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"If-None-Match",
+	"Location",
+	"Retry-After",
+}
+
+// parameterPattern returns the pattern that matches the values of `names` in a query, a form or a JSON body.
+func parameterPattern(names []string) *regexp.Regexp {
+	joined := strings.Join(names, "|")
+	return regexp.MustCompile(`((?:^|[?&])(?:` + joined + `)=)[^&#"\s]*|("(?:` + joined + `)"\s*:\s*")[^"]*(")`)
+}
+
+// keyBlocks are the inline blocks of an OpenVPN config with secret keys, the longer names first such that they match completely.
+const keyBlocks = "key|tls-crypt-v2|tls-crypt|tls-auth"
+
+var (
+	secretPattern       = parameterPattern(secretParameters)
+	requestPattern      = parameterPattern(append(append([]string{}, secretParameters...), volatileParameters...))
+	keyLinePattern      = regexp.MustCompile(`(?m)^((?:PrivateKey|PresharedKey)\s*=\s*).*$`)
+	keyBlockPattern     = regexp.MustCompile(`(?s)<(` + keyBlocks + `)>.*?</(?:` + keyBlocks + `)>`)
+	placeholderPattern  = regexp.MustCompile(`{{query\.([a-z_]+)}}`)
+	redactedReplacement = "${1}${2}" + redacted + "${3}"
+)
+
+// redactText redacts the values of the parameters that `pattern` matches and the keys in configs:
+// the private and preshared keys of WireGuard and the private key, tls-crypt, tls-crypt-v2 and tls-auth blocks of OpenVPN.
+func redactText(text string, pattern *regexp.Regexp) string {
+	text = pattern.ReplaceAllString(text, redactedReplacement)
+	text = keyLinePattern.ReplaceAllString(text, "${1}"+redacted)
+	return keyBlockPattern.ReplaceAllString(text, "<${1}>\n"+redacted+"\n</${1}>")
+}
+
+// recordHeader returns the recorded headers of `header`, with the credentials redacted and `replace` applied.
+func recordHeader(header http.Header, replace func(string) string) http.Header {
+	recorded := http.Header{}
+	for _, name := range recordedHeaders {
+		for _, value := range header.Values(name) {
+			if name == "Authorization" {
+				scheme := strings.SplitN(value, " ", 2)[0]
+				value = scheme + " " + redacted
+			}
+			recorded.Add(name, replace(value))
+		}
+	}
+	if len(recorded) == 0 {
+		return nil
+	}
+	return recorded
+}
+
+// readBody reads and restores the body of a request or response.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	content, readErr := ioutil.ReadAll(*body)
+	(*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(content))
+	return content, readErr
+}
+
+// requestOrigin returns the origin of `req` in the form scheme://host as it is used in the URLs of the server.
+func requestOrigin(req *http.Request) string {
+	return req.URL.Scheme + "://" + req.URL.Host
+}
+
+// recordRequest returns `req` in the form it is recorded and matched, it is the same for every run.
+func recordRequest(req *http.Request, body []byte) RecordedRequest {
+	origin := requestOrigin(req)
+	replace := func(text string) string {
+		return strings.ReplaceAll(redactText(text, requestPattern), origin, "{{origin}}")
+	}
+	return RecordedRequest{
+		Method: req.Method,
+		URL:    replace(req.URL.String()),
+		Header: recordHeader(req.Header, replace),
+		Body:   replace(string(body)),
+	}
+}
+
+// Recorder is a transport that records the requests and responses of another transport, such that they can be saved as a fixture.
+// The tokens and keys are redacted, see RecordedRequest and RecordedResponse.
+type Recorder struct {
+	// Transport does the requests, nil means http.DefaultTransport
+	Transport http.RoundTripper
+
+	// Skip returns whether or not a request is not recorded, e.g. the OAuth callback to the client itself
+	Skip func(*http.Request) bool
+
+	// mu protects the exchanges as the client and a browser can use the recorder at the same time
+	mu sync.Mutex
+
+	// exchanges are the recorded exchanges
+	exchanges []Exchange
+}
+
+// RoundTrip does the request with the transport of the recorder and records it with its response.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if r.Skip != nil && r.Skip(req) {
+		return transport.RoundTrip(req)
+	}
+	var requestBody []byte
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, bodyErr
+		}
+		requestBody, bodyErr = ioutil.ReadAll(body)
+		if bodyErr != nil {
+			return nil, bodyErr
+		}
+	}
+	resp, respErr := transport.RoundTrip(req)
+	if respErr != nil {
+		return nil, respErr
+	}
+	responseBody, readErr := readBody(&resp.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	// The values of the request that the response echoes, such as the state, are replaced by placeholders before anything is redacted
+	origin := requestOrigin(req)
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	replace := func(text string) string {
+		for _, name := range names {
+			if value := query.Get(name); len(value) >= 8 {
+				text = strings.ReplaceAll(text, value, "{{query."+name+"}}")
+			}
+		}
+		text = redactText(text, secretPattern)
+		text = strings.ReplaceAll(text, url.QueryEscape(origin), "{{origin.query}}")
+		return strings.ReplaceAll(text, origin, "{{origin}}")
+	}
+	exchange := Exchange{
+		Request: recordRequest(req, requestBody),
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: recordHeader(resp.Header, replace),
+			Body:   replace(string(responseBody)),
+		},
+	}
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, exchange)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Fixture returns the recorded exchanges as a fixture.
+func (r *Recorder) Fixture() *Fixture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Fixture{Exchanges: append([]Exchange{}, r.exchanges...)}
+}
+
+// Save writes the recorded exchanges as a fixture to the file with `path`.
+func (r *Recorder) Save(path string) error {
+	errorMessage := fmt.Sprintf("failed saving the fixture %s", path)
+	// The fixtures are reviewed in diffs, so the URLs and configs are not escaped
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if jsonErr := encoder.Encode(r.Fixture()); jsonErr != nil {
+		return types.NewWrappedError(errorMessage, jsonErr)
+	}
+	if writeErr := ioutil.WriteFile(path, content.Bytes(), 0o644); writeErr != nil {
+		return types.NewWrappedError(errorMessage, writeErr)
+	}
+	return nil
+}
+
+// LoadFixture loads the fixture in the file with `path`.
+func LoadFixture(path string) (*Fixture, error) {
+	errorMessage := fmt.Sprintf("failed loading the fixture %s", path)
+	content, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, types.NewWrappedError(errorMessage, readErr)
+	}
+	fixture := &Fixture{}
+	if jsonErr := json.Unmarshal(content, fixture); jsonErr != nil {
+		return nil, types.NewWrappedError(errorMessage, jsonErr)
+	}
+	return fixture, nil
+}
+
+// ReplayError is returned by a Replayer for a request that is not in its fixture.
+type ReplayError struct {
+	// Request is the request in the form it is matched
+	Request RecordedRequest
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("no recorded response for the request %s %s with body: %q", e.Request.Method, e.Request.URL, e.Request.Body)
+}
+
+// Replayer is a transport that serves the responses of a fixture instead of doing the requests.
+// A request is served the first unused exchange with the same method, URL and body after redaction, other requests fail with a *ReplayError.
+type Replayer struct {
+	// Transport does the requests that are skipped, nil means http.DefaultTransport
+	Transport http.RoundTripper
+
+	// Skip returns whether or not a request is done with Transport instead of being replayed, e.g. the OAuth callback to the client itself
+	Skip func(*http.Request) bool
+
+	// mu protects the used exchanges
+	mu sync.Mutex
+
+	// fixture is the fixture that is replayed
+	fixture *Fixture
+
+	// used are the exchanges that were served
+	used []bool
+}
+
+// NewReplayer creates a transport that replays `fixture`.
+func NewReplayer(fixture *Fixture) *Replayer {
+	return &Replayer{fixture: fixture, used: make([]bool, len(fixture.Exchanges))}
+}
+
+// RoundTrip serves the recorded response for `req`, with the placeholders filled in for the request.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.Skip != nil && r.Skip(req) {
+		transport := r.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		return transport.RoundTrip(req)
+	}
+	body, readErr := readBody(&req.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+	recorded := recordRequest(req, body)
+
+	r.mu.Lock()
+	var exchange *Exchange
+	for i := range r.fixture.Exchanges {
+		candidate := &r.fixture.Exchanges[i].Request
+		if r.used[i] || candidate.Method != recorded.Method || candidate.URL != recorded.URL || candidate.Body != recorded.Body {
+			continue
+		}
+		r.used[i] = true
+		exchange = &r.fixture.Exchanges[i]
+		break
+	}
+	r.mu.Unlock()
+	if exchange == nil {
+		return nil, &ReplayError{Request: recorded}
+	}
+
+	origin := requestOrigin(req)
+	query := req.URL.Query()
+	fill := func(text string) string {
+		text = placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			return query.Get(placeholderPattern.FindStringSubmatch(placeholder)[1])
+		})
+		text = strings.ReplaceAll(text, "{{origin.query}}", url.QueryEscape(origin))
+		return strings.ReplaceAll(text, "{{origin}}", origin)
+	}
+	header := http.Header{}
+	for name, values := range exchange.Response.Header {
+		for _, value := range values {
+			header.Add(name, fill(value))
+		}
+	}
+	responseBody := fill(exchange.Response.Body)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Response.Status, http.StatusText(exchange.Response.Status)),
+		StatusCode:    exchange.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(responseBody)),
+		ContentLength: int64(len(responseBody)),
+		Request:       req,
+	}, nil
+}
+
+// Unused returns the exchanges of the fixture that were not served, such that a test can check that the whole session was replayed.
+func (r *Replayer) Unused() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unused []Exchange
+	for i, exchange := range r.fixture.Exchanges {
+		if !r.used[i] {
+			unused = append(unused, exchange)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordServer returns a server that redirects /authorize back with a code, returns tokens at /token and a config with every kind of key at /connect.
+func recordServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/authorize":
+			query := r.URL.Query()
+			http.Redirect(w, r, query.Get("redirect_uri")+"?code=secretcode&state="+query.Get("state"), http.StatusFound)
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token": "secretaccess", "refresh_token":"secretrefresh", "expires_in": 3600}`))
+		case "/connect":
+			_, _ = w.Write([]byte("[Interface]\nPrivateKey = secretprivate\n\n[Peer]\nPresharedKey = secretpreshared\n\n" +
+				"<key>\nsecretkey\n</key>\n<tls-crypt>\nsecretcrypt\n</tls-crypt>\n<tls-crypt-v2>\nsecretcryptv2\n</tls-crypt-v2>\n" +
+				"<tls-auth>\nsecretauth\n</tls-auth>\nremote http://" + r.Host + "\n"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRecordReplay(t *testing.T) {
+	server := recordServer(t)
+	recorder := &Recorder{}
+	client := NewClient(Options{Transport: recorder})
+	browser := &http.Client{
+		Transport: recorder,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	authorize := server.URL + "/authorize?state=randomstate&redirect_uri=" + url.QueryEscape("http://127.0.0.1:1234/callback")
+	resp, getErr := browser.Get(authorize)
+	if getErr != nil {
+		t.Fatalf("got error: %v", getErr)
+	}
+	resp.Body.Close()
+	headers := &OptionalParams{Headers: http.Header{"Authorization": {"Bearer secretaccess"}}}
+	if _, _, postErr := client.PostWithOpts(server.URL+"/token", &OptionalParams{Body: url.Values{"code": {"secretcode"}}}); postErr != nil {
+		t.Fatalf("got error: %v", postErr)
+	}
+	if _, _, postErr := client.PostWithOpts(server.URL+"/connect", headers); postErr != nil {
+		t.Fatalf("got error: %v", postErr)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if saveErr := recorder.Save(path); saveErr != nil {
+		t.Fatalf("got save error: %v", saveErr)
+	}
+	content, _ := ioutil.ReadFile(path)
+	for _, secret := range []string{"secret", "randomstate", "127.0.0.1"} {
+		if strings.Contains(string(content), secret) {
+			t.Fatalf("got a fixture with %q: %s", secret, content)
+		}
+	}
+
+	fixture, loadErr := LoadFixture(path)
+	if loadErr != nil {
+		t.Fatalf("got load error: %v", loadErr)
+	}
+	replayer := NewReplayer(fixture)
+	replay := NewClient(Options{Transport: replayer})
+	browser.Transport = replayer
+
+	// The fixture is replayed for another origin with the state of the request
+	authorize = "https://vpn.example.org/authorize?state=otherstate&redirect_uri=" + url.QueryEscape("http://127.0.0.1:4321/callback")
+	resp, getErr = browser.Get(authorize)
+	if getErr != nil {
+		t.Fatalf("got replay error: %v", getErr)
+	}
+	resp.Body.Close()
+	if location := resp.Header.Get("Location"); location != "http://127.0.0.1:4321/callback?code=REDACTED&state=otherstate" {
+		t.Fatalf("got location: %s", location)
+	}
+	_, body, postErr := replay.PostWithOpts("https://vpn.example.org/token", &OptionalParams{Body: url.Values{"code": {"REDACTED"}}})
+	if postErr != nil || !strings.Contains(string(body), `"access_token": "REDACTED"`) {
+		t.Fatalf("got: %s, %v for the token request", body, postErr)
+	}
+	_, body, postErr = replay.PostWithOpts("https://vpn.example.org/connect", nil)
+	if postErr != nil || !strings.Contains(string(body), "remote https://vpn.example.org\n") {
+		t.Fatalf("got: %s, %v for the connect request", body, postErr)
+	}
+	for _, key := range []string{
+		"PrivateKey = REDACTED\n",
+		"PresharedKey = REDACTED\n",
+		"<key>\nREDACTED\n</key>",
+		"<tls-crypt>\nREDACTED\n</tls-crypt>",
+		"<tls-crypt-v2>\nREDACTED\n</tls-crypt-v2>",
+		"<tls-auth>\nREDACTED\n</tls-auth>",
+	} {
+		if !strings.Contains(string(body), key) {
+			t.Fatalf("got: %s for the connect request, want the redacted key: %s", body, key)
+		}
+	}
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Fatalf("got %d unused exchanges", len(unused))
+	}
+
+	// Every exchange is served once
+	_, _, postErr = replay.PostWithOpts("https://vpn.example.org/connect", nil)
+	var replayErr *ReplayError
+	if !errors.As(postErr, &replayErr) || replayErr.Request.URL != "{{origin}}/connect" {
+		t.Fatalf("got error: %v, want a ReplayError", postErr)
+	}
+}
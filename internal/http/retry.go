@@ -0,0 +1,192 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy defines how often and how long after a failed request it is retried.
+// The zero values of the fields mean the defaults.
+type RetryPolicy struct {
+	// IdempotentAttempts is the maximum number of attempts, including the first, for GET and other idempotent requests
+	// These are retried after network errors and the 408, 429, 500, 502, 503 and 504 status codes
+	// Zero means 3, 1 disables retrying
+	IdempotentAttempts int
+
+	// NonIdempotentAttempts is the maximum number of attempts, including the first, for POST requests such as /connect
+	// These are only retried if the server certainly did not process them:
+	// when the connection could not be made or when the server asks for it with a 429 or 503 status code with Retry-After
+	// Zero means 2, 1 disables retrying
+	NonIdempotentAttempts int
+
+	// BaseDelay is the delay before the first retry, it doubles for each next retry, zero means 500 milliseconds
+	BaseDelay time.Duration
+
+	// MaxDelay is the maximum delay between attempts, zero means 10 seconds
+	// A Retry-After from the server that is longer is not honoured and the request fails
+	MaxDelay time.Duration
+}
+
+// Defaults for the zero values of a RetryPolicy.
+const (
+	defaultIdempotentAttempts    = 3
+	defaultNonIdempotentAttempts = 2
+	defaultBaseDelay             = 500 * time.Millisecond
+	defaultMaxDelay              = 10 * time.Second
+)
+
+// withDefaults returns the policy with the defaults for the zero values.
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.IdempotentAttempts <= 0 {
+		policy.IdempotentAttempts = defaultIdempotentAttempts
+	}
+	if policy.NonIdempotentAttempts <= 0 {
+		policy.NonIdempotentAttempts = defaultNonIdempotentAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultMaxDelay
+	}
+	return policy
+}
+
+// idempotent returns whether or not a request with `method` can be sent again without side effects.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// attempts returns the maximum number of attempts for a request with `method`.
+func (policy RetryPolicy) attempts(method string) int {
+	if idempotent(method) {
+		return policy.IdempotentAttempts
+	}
+	return policy.NonIdempotentAttempts
+}
+
+// backoff returns the delay before retry `retry`, starting at 1.
+// It is exponential with jitter such that clients that failed at the same time do not retry at the same time.
+func (policy RetryPolicy) backoff(retry int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < retry && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	// Choose a delay between half and the full delay
+	return delay/2 + time.Duration(jitter()*float64(delay/2))
+}
+
+// jitter returns a random number in [0, 1), it is a variable such that the tests can replace it.
+var jitter = rand.Float64
+
+// sleep waits for `delay`, it is a variable such that the tests can replace it.
+var sleep = time.Sleep
+
+// parseRetryAfter parses the Retry-After header `value` in seconds or as a HTTP date.
+// The boolean is false if there is no valid value.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, dateErr := http.ParseTime(value)
+	if dateErr != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// retryableStatus returns whether or not an idempotent request with the status code `status` is retried.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// notSent returns whether or not the error `err` happened before the request was sent, e.g. a DNS error or a refused connection.
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// transient returns whether or not the error `err` is a network error that can be gone with the next attempt.
+// Errors such as an untrusted certificate or an unsupported TLS version are not transient.
+func transient(err error) bool {
+	if notSent(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// TLS alerts are also operation errors, with the operation "local error" or "remote error"
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "read" || opErr.Op == "write")
+}
+
+// retryDelay decides whether or not the request with `method` is retried after attempt `attempt`, starting at 1, resulted in `resp` or `err`.
+// It returns the delay before the next attempt and false if the request is not retried.
+func (policy RetryPolicy) retryDelay(method string, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= policy.attempts(method) {
+		return 0, false
+	}
+	delay := policy.backoff(attempt)
+	if err != nil {
+		// A request that timed out already took the full timeout, the user should not wait for it multiple times
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return 0, false
+		}
+		// A certificate that does not match the pins or a URL that is not allowed will not be the next time either
+		if refused(err) {
+			return 0, false
+		}
+		if !transient(err) || !idempotent(method) && !notSent(err) {
+			return 0, false
+		}
+		return delay, true
+	}
+
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if idempotent(method) {
+		if !retryableStatus(resp.StatusCode) {
+			return 0, false
+		}
+	} else if !hasRetryAfter || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	if hasRetryAfter {
+		if retryAfter > policy.MaxDelay {
+			return 0, false
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+	return delay, true
+}
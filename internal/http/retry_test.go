@@ -0,0 +1,173 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubSleep replaces the sleep between attempts until the test finishes and returns the delays.
+func stubSleep(t *testing.T) *[]time.Duration {
+	delays := &[]time.Duration{}
+	previous := sleep
+	sleep = func(delay time.Duration) {
+		*delays = append(*delays, delay)
+	}
+	t.Cleanup(func() { sleep = previous })
+	return delays
+}
+
+// statusServer returns a server that responds with the status codes and headers of `responses` in order, afterwards with 200.
+// It also returns the number of requests that it got.
+func statusServer(t *testing.T, responses ...http.Header) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		if count > len(responses) {
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		status := http.StatusOK
+		for name, values := range responses[count-1] {
+			if name == "Status" {
+				fmt.Sscan(values[0], &status)
+				continue
+			}
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+// status returns the response of a statusServer with `status` and optionally a Retry-After.
+func status(code int, retryAfter string) http.Header {
+	header := http.Header{"Status": {fmt.Sprint(code)}}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return header
+}
+
+func TestRetry(t *testing.T) {
+	delays := stubSleep(t)
+	var logs []string
+	c := NewClient(Options{
+		Retry: RetryPolicy{BaseDelay: 100 * time.Millisecond},
+		Debugf: func(msg string, params ...interface{}) {
+			logs = append(logs, fmt.Sprintf(msg, params...))
+		},
+	})
+
+	// Idempotent requests are retried after a server error with backoff
+	server, count := statusServer(t, status(502, ""), status(503, ""))
+	_, body, getErr := c.Get(server.URL)
+	if getErr != nil || string(body) != "ok" {
+		t.Fatalf("Got body: %s and error: %v, want ok after retrying", body, getErr)
+	}
+	if count() != 3 {
+		t.Fatalf("Got %d requests, want: 3", count())
+	}
+	if len(*delays) != 2 || (*delays)[0] < 50*time.Millisecond || (*delays)[0] > 100*time.Millisecond ||
+		(*delays)[1] < 100*time.Millisecond || (*delays)[1] > 200*time.Millisecond {
+		t.Fatalf("Got delays: %v, want exponential backoff from 100ms with jitter", *delays)
+	}
+	if len(logs) != 3 || !strings.Contains(logs[0], "attempt 1/3: status code 502, retrying") ||
+		!strings.Contains(logs[2], "attempt 3/3: status code 200") {
+		t.Fatalf("Got logs: %v, want one for each attempt", logs)
+	}
+
+	// The logged URL is redacted
+	logs = nil
+	server, _ = statusServer(t, status(200, ""))
+	if _, _, getErr = c.Get(server.URL + "/?code=secret"); getErr != nil {
+		t.Fatalf("Got error: %v", getErr)
+	}
+	if len(logs) != 1 || strings.Contains(logs[0], "secret") || !strings.Contains(logs[0], "code=REDACTED") {
+		t.Fatalf("Got logs: %v, want the redacted URL", logs)
+	}
+
+	// The attempts are limited
+	*delays = nil
+	server, count = statusServer(t, status(503, ""), status(503, ""), status(503, ""))
+	if _, _, getErr = c.Get(server.URL); getErr == nil || count() != 3 {
+		t.Fatalf("Got error: %v after %d requests, want an error after 3", getErr, count())
+	}
+
+	// Retry-After is honoured unless it is longer than the maximum delay
+	*delays = nil
+	server, count = statusServer(t, status(429, "3"))
+	if _, _, getErr = c.Get(server.URL); getErr != nil || len(*delays) != 1 || (*delays)[0] != 3*time.Second {
+		t.Fatalf("Got error: %v and delays: %v, want one retry after 3s", getErr, *delays)
+	}
+	server, count = statusServer(t, status(503, "3600"))
+	if _, _, getErr = c.Get(server.URL); getErr == nil || count() != 1 {
+		t.Fatalf("Got error: %v after %d requests, want an error without retrying", getErr, count())
+	}
+
+	// Client errors are not retried
+	server, count = statusServer(t, status(404, ""))
+	if _, _, getErr = c.Get(server.URL); getErr == nil || count() != 1 {
+		t.Fatalf("Got error: %v after %d requests, want an error without retrying", getErr, count())
+	}
+
+	// Non-idempotent requests are not retried after a server error as the server could have processed them
+	server, count = statusServer(t, status(502, ""))
+	if _, _, postErr := c.Post(server.URL, nil); postErr == nil || count() != 1 {
+		t.Fatalf("Got error: %v after %d requests, want an error without retrying", postErr, count())
+	}
+	// Unless the server asks for it
+	server, count = statusServer(t, status(503, "1"))
+	if _, _, postErr := c.Post(server.URL, nil); postErr != nil || count() != 2 {
+		t.Fatalf("Got error: %v after %d requests, want success after retrying", postErr, count())
+	}
+
+	// Requests that could not be sent are retried, also when they are not idempotent
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	logs = nil
+	if _, _, postErr := c.Post(closed.URL, nil); postErr == nil || len(logs) != 2 {
+		t.Fatalf("Got error: %v with logs: %v, want an error after 2 attempts", postErr, logs)
+	}
+
+	// Retrying can be disabled
+	c = NewClient(Options{Retry: RetryPolicy{IdempotentAttempts: 1}})
+	server, count = statusServer(t, status(503, ""))
+	if _, _, getErr = c.Get(server.URL); getErr == nil || count() != 1 {
+		t.Fatalf("Got error: %v after %d requests, want an error without retrying", getErr, count())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		delay time.Duration
+		valid bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Sat, 01 Oct 2022 12:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 01 Oct 2022 11:00:00 GMT", 0, true},
+	}
+	for _, c := range cases {
+		delay, valid := parseRetryAfter(c.value, now)
+		if delay != c.delay || valid != c.valid {
+			t.Fatalf("Got delay: %v and valid: %v for: %q, want: %v and %v", delay, valid, c.value, c.delay, c.valid)
+		}
+	}
+}
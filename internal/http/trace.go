@@ -0,0 +1,329 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestStart is the observation of the start of an outbound request or operation.
+type RequestStart struct {
+	// ID identifies the request, all attempts of a request share it
+	ID string
+
+	// Operation is the label of the request, e.g. "oauth.token.refresh_token", empty for a plain request
+	Operation string
+
+	// Method is the HTTP method, e.g. GET
+	Method string
+
+	// URL is the URL with the user info and the query values redacted, see RedactURL
+	URL string
+
+	// Attempt is the attempt of the request, starting at 1
+	Attempt int
+
+	// Time is the time the attempt started
+	Time time.Time
+}
+
+// RequestEnd is the observation of the end of an outbound request or operation.
+type RequestEnd struct {
+	RequestStart
+
+	// Status is the HTTP status code, zero if there is no response
+	Status int
+
+	// BytesSent is the size of the request body
+	BytesSent int64
+
+	// BytesReceived is the size of the response body
+	BytesReceived int64
+
+	// Duration is the time from the start to the end
+	Duration time.Duration
+
+	// Err is the error of the attempt, nil if there is a response
+	// A response with an unsuccessful status code has no error, the status is set instead
+	Err error
+}
+
+// Observer is called at the start and the end of each attempt of an outbound request.
+// It is called from the goroutine that does the request and should return quickly.
+type Observer interface {
+	// RequestStarted is called right before the request is sent
+	RequestStarted(start RequestStart)
+
+	// RequestFinished is called when the response body is read or the request failed
+	RequestFinished(end RequestEnd)
+}
+
+// NopObserver is an observer that does nothing, it is the default.
+type NopObserver struct{}
+
+// RequestStarted does nothing.
+func (NopObserver) RequestStarted(RequestStart) {}
+
+// RequestFinished does nothing.
+func (NopObserver) RequestFinished(RequestEnd) {}
+
+// LogObserver logs every observation with Debugf.
+type LogObserver struct {
+	Debugf func(msg string, params ...interface{})
+}
+
+// RequestStarted logs the start of the request.
+func (o LogObserver) RequestStarted(start RequestStart) {
+	if o.Debugf == nil {
+		return
+	}
+	o.Debugf("Trace %s start: %s %s %s attempt %d", start.ID, start.label(), start.Method, start.URL, start.Attempt)
+}
+
+// RequestFinished logs the end of the request with its status, sizes and duration.
+func (o LogObserver) RequestFinished(end RequestEnd) {
+	if o.Debugf == nil {
+		return
+	}
+	result := "ok"
+	if end.Err != nil {
+		result = end.Err.Error()
+	}
+	o.Debugf(
+		"Trace %s end: %s %s %s attempt %d, status %d, sent %d bytes, received %d bytes in %v: %s",
+		end.ID,
+		end.label(),
+		end.Method,
+		end.URL,
+		end.Attempt,
+		end.Status,
+		end.BytesSent,
+		end.BytesReceived,
+		end.Duration,
+		result,
+	)
+}
+
+// Observers calls every observer in order.
+type Observers []Observer
+
+// RequestStarted calls RequestStarted of every observer.
+func (o Observers) RequestStarted(start RequestStart) {
+	for _, observer := range o {
+		observer.RequestStarted(start)
+	}
+}
+
+// RequestFinished calls RequestFinished of every observer.
+func (o Observers) RequestFinished(end RequestEnd) {
+	for _, observer := range o {
+		observer.RequestFinished(end)
+	}
+}
+
+// label returns the operation, or "http" if the request has none.
+func (s RequestStart) label() string {
+	if s.Operation == "" {
+		return "http"
+	}
+	return s.Operation
+}
+
+// redacted replaces a redacted user info or query value.
+const redacted = "REDACTED"
+
+// RedactURL returns `rawURL` without secrets such that it can be logged or exported.
+// The user info is removed and the value of every query parameter is redacted, as these can contain authorization codes and tokens.
+// The fragment is removed as well. An URL that cannot be parsed is redacted entirely.
+func RedactURL(rawURL string) string {
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		return redacted
+	}
+	parsed.User = nil
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parameters := make([]string, 0, len(keys))
+		for _, key := range keys {
+			parameters = append(parameters, url.QueryEscape(key)+"="+redacted)
+		}
+		parsed.RawQuery = strings.Join(parameters, "&")
+	}
+	return parsed.String()
+}
+
+// randomHex returns `n` random bytes hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, randErr := rand.Read(b); randErr != nil {
+		return strings.Repeat("0", 2*n)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newRequestID returns a new ID of a request, it has the form of an OpenTelemetry trace ID.
+func newRequestID() string {
+	return randomHex(16)
+}
+
+// Trace observes an operation that is not a request of the client, e.g. the authorization of the user in the browser.
+// It calls RequestStarted of the observer of the client and returns the function that ends the operation with the status and error.
+func (c *Client) Trace(operation string, method string, rawURL string) func(status int, err error) {
+	if c == nil {
+		c = defaultClient
+	}
+	start := RequestStart{
+		ID:        newRequestID(),
+		Operation: operation,
+		Method:    method,
+		URL:       RedactURL(rawURL),
+		Attempt:   1,
+		Time:      time.Now(),
+	}
+	c.observer.RequestStarted(start)
+	return func(status int, err error) {
+		c.observer.RequestFinished(RequestEnd{
+			RequestStart: start,
+			Status:       status,
+			Duration:     time.Since(start.Time),
+			Err:          err,
+		})
+	}
+}
+
+// SpanStatus is the status code of a span as defined by OpenTelemetry.
+type SpanStatus string
+
+const (
+	// SpanStatusUnset is the status of a span that did not fail
+	SpanStatusUnset SpanStatus = "Unset"
+
+	// SpanStatusError is the status of a span with an error or an unsuccessful status code
+	SpanStatusError SpanStatus = "Error"
+)
+
+// Span is an observed request in the form of an OpenTelemetry client span, such that the host application can forward it to its tracer.
+// The attributes follow the OpenTelemetry semantic conventions for HTTP clients.
+type Span struct {
+	// TraceID is the ID of the request, all attempts of a request are in the same trace
+	TraceID string `json:"trace_id"`
+
+	// SpanID is the ID of the attempt
+	SpanID string `json:"span_id"`
+
+	// Name is the name of the span, e.g. "POST oauth.token.refresh_token" or "GET"
+	Name string `json:"name"`
+
+	// Kind is the kind of the span, always "client"
+	Kind string `json:"kind"`
+
+	// StartTime is the start of the attempt
+	StartTime time.Time `json:"start_time"`
+
+	// EndTime is the end of the attempt
+	EndTime time.Time `json:"end_time"`
+
+	// Status is the status code of the span
+	Status SpanStatus `json:"status"`
+
+	// StatusMessage is the error message if the status is an error
+	StatusMessage string `json:"status_message,omitempty"`
+
+	// Attributes are the attributes of the span, e.g. "http.request.method"
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// NewSpan converts the observed end of a request to a span.
+func NewSpan(end RequestEnd) Span {
+	name := end.Method
+	if end.Operation != "" {
+		name = strings.TrimSpace(end.Method + " " + end.Operation)
+	}
+	attributes := map[string]interface{}{
+		"http.request.method":     end.Method,
+		"url.full":                end.URL,
+		"http.request.body.size":  end.BytesSent,
+		"http.response.body.size": end.BytesReceived,
+	}
+	if end.Operation != "" {
+		attributes["eduvpn.operation"] = end.Operation
+	}
+	if end.Attempt > 1 {
+		attributes["http.request.resend_count"] = end.Attempt - 1
+	}
+	if parsed, parseErr := url.Parse(end.URL); parseErr == nil && parsed.Hostname() != "" {
+		attributes["server.address"] = parsed.Hostname()
+	}
+	span := Span{
+		TraceID:    end.ID,
+		SpanID:     randomHex(8),
+		Name:       name,
+		Kind:       "client",
+		StartTime:  end.Time,
+		EndTime:    end.Time.Add(end.Duration),
+		Status:     SpanStatusUnset,
+		Attributes: attributes,
+	}
+	if end.Status != 0 {
+		attributes["http.response.status_code"] = end.Status
+	}
+	switch {
+	case end.Err != nil:
+		span.Status = SpanStatusError
+		span.StatusMessage = end.Err.Error()
+		attributes["error.type"] = errorType(end.Err)
+	case end.Status >= 400:
+		span.Status = SpanStatusError
+		// The semantic conventions use the status code as the error type
+		attributes["error.type"] = strconv.Itoa(end.Status)
+	}
+	return span
+}
+
+// errorType returns the type of `err` for the error.type attribute of a span.
+func errorType(err error) string {
+	var pinErr *PinError
+	var originErr *OriginError
+	var redirectErr *RedirectError
+	switch {
+	case errors.As(err, &pinErr):
+		return "pin_mismatch"
+	case errors.As(err, &redirectErr):
+		return "redirect_not_allowed"
+	case errors.As(err, &originErr):
+		return "endpoint_not_allowed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "_OTHER"
+	}
+}
+
+// SpanObserver is an observer that converts every finished request to a span and passes it to Export.
+type SpanObserver struct {
+	Export func(span Span)
+}
+
+// RequestStarted does nothing, the span is exported when the request is finished.
+func (SpanObserver) RequestStarted(RequestStart) {}
+
+// RequestFinished exports the span of the request.
+func (o SpanObserver) RequestFinished(end RequestEnd) {
+	if o.Export == nil {
+		return
+	}
+	o.Export(NewSpan(end))
+}
@@ -93,6 +93,9 @@ type OAuth struct {
 
 	// Token is where the access and refresh tokens are stored along with the timestamps
 	token Token `json:"-"`
+
+	// HTTPClient is the client for the token requests and the API of the server, nil means the shared default client
+	HTTPClient *httpw.Client `json:"-"`
 }
 
 // ExchangeSession is a structure that gets passed to the callback for easy access to the current state.
@@ -120,6 +123,9 @@ type ExchangeSession struct {
 
 	// Listener is the listener where the servers 'listens' on
 	Listener net.Listener
+
+	// endAuthorization ends the observation of the authorization in the browser, nil if it has ended
+	endAuthorization func(status int, err error)
 }
 
 // AccessToken gets the OAuth access token used for contacting the server API
@@ -153,12 +159,13 @@ func (oauth *OAuth) AccessToken() (string, error) {
 			errorMessage,
 			&TokensInvalidError{
 				Cause: fmt.Sprintf("tokens failed refresh with error: %v", refreshErr),
+				Err:   refreshErr,
 			},
 		)
 	}
 
 	// We have obtained new tokens with refresh
-	return tokens.access, nil
+	return oauth.token.access, nil
 }
 
 // setupListener sets up an OAuth listener
@@ -183,6 +190,11 @@ func (oauth *OAuth) tokensWithCallback() error {
 	if oauth.session.Listener == nil {
 		return types.NewWrappedError(errorMessage, errors.New("no listener"))
 	}
+	// OAuth was cancelled before the server was started, e.g. right after getting the URL
+	if oauth.session.CallbackError != nil {
+		_ = oauth.session.Listener.Close()
+		return oauth.session.CallbackError
+	}
 	mux := http.NewServeMux()
 	// server /callback over the listener address
 	oauth.session.Server = &http.Server{
@@ -258,9 +270,9 @@ func (oauth *OAuth) tokensWithAuthCode(authCode string) error {
 	headers := http.Header{
 		"content-type": {"application/x-www-form-urlencoded"},
 	}
-	opts := &httpw.OptionalParams{Headers: headers, Body: data}
+	opts := &httpw.OptionalParams{Headers: headers, Body: data, Operation: "oauth.token." + data.Get("grant_type")}
 	currentTime := time.Now()
-	_, body, bodyErr := httpw.PostWithOpts(reqURL, opts)
+	_, body, bodyErr := oauth.HTTPClient.PostWithOpts(reqURL, opts)
 	if bodyErr != nil {
 		return types.NewWrappedError(errorMessage, bodyErr)
 	}
@@ -286,9 +298,9 @@ func (oauth *OAuth) tokensWithRefresh() error {
 	headers := http.Header{
 		"content-type": {"application/x-www-form-urlencoded"},
 	}
-	opts := &httpw.OptionalParams{Headers: headers, Body: data}
+	opts := &httpw.OptionalParams{Headers: headers, Body: data, Operation: "oauth.token." + data.Get("grant_type")}
 	currentTime := time.Now()
-	_, body, bodyErr := httpw.PostWithOpts(reqURL, opts)
+	_, body, bodyErr := oauth.HTTPClient.PostWithOpts(reqURL, opts)
 	if bodyErr != nil {
 		return types.NewWrappedError(errorMessage, bodyErr)
 	}
@@ -370,6 +382,7 @@ func (oauth *OAuth) Callback(w http.ResponseWriter, req *http.Request) {
 
 	// Shutdown after we're done
 	defer func() {
+		oauth.endAuthorization(oauth.session.CallbackError)
 		// writing the html is best effort
 		if oauth.session.CallbackError != nil {
 			_ = writeResponseHTML(
@@ -431,6 +444,8 @@ func (oauth *OAuth) Callback(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	oauth.endAuthorization(nil)
+
 	// Now that we have obtained the authorization code, we can move to the next step:
 	// Obtaining the access and refresh tokens
 	getTokensErr := oauth.tokensWithAuthCode(extractedCode)
@@ -518,10 +533,22 @@ func (oauth *OAuth) AuthURL(name string, postProcessAuth func(string) string) (s
 		return "", types.NewWrappedError(errorMessage, urlErr)
 	}
 
+	// The authorization is observed from now until the callback, this is the time the user takes in the browser
+	oauth.session.endAuthorization = oauth.HTTPClient.Trace("oauth.authorize", http.MethodGet, authURL)
+
 	// Return the url processed
 	return postProcessAuth(authURL), nil
 }
 
+// endAuthorization ends the observation of the authorization in the browser with `err`, if it has not ended yet.
+func (oauth *OAuth) endAuthorization(err error) {
+	if oauth.session.endAuthorization == nil {
+		return
+	}
+	oauth.session.endAuthorization(0, err)
+	oauth.session.endAuthorization = nil
+}
+
 // Exchange starts the OAuth exchange by getting the tokens with the redirect callback
 // If it was unsuccessful it returns an error.
 func (oauth *OAuth) Exchange() error {
@@ -541,6 +568,7 @@ func (oauth *OAuth) Cancel() {
 		"cancelled OAuth",
 		&CancelledCallbackError{},
 	)
+	oauth.endAuthorization(oauth.session.CallbackError)
 	if oauth.session.Server != nil {
 		oauth.session.Server.Shutdown(oauth.session.Context) //nolint:errcheck
 	}
@@ -581,8 +609,15 @@ func (e *CallbackISSMatchError) Error() string {
 
 type TokensInvalidError struct {
 	Cause string
+	// Err is the error that made the tokens invalid, e.g. the error of the refresh, nil if there is none
+	Err error
 }
 
 func (e *TokensInvalidError) Error() string {
 	return fmt.Sprintf("tokens are invalid due to: %s", e.Cause)
 }
+
+// Unwrap returns the error that made the tokens invalid, such that e.g. the code of the server error is kept.
+func (e *TokensInvalidError) Unwrap() error {
+	return e.Err
+}
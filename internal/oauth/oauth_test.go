@@ -1,8 +1,16 @@
 package oauth
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/types"
 )
 
 func Test_verifiergen(t *testing.T) {
@@ -25,3 +33,108 @@ func Test_verifiergen(t *testing.T) {
 		t.Fatalf("Verifier: %s can not be unescaped", verifier)
 	}
 }
+
+// tokenServer returns a token endpoint that responds with `status` and `body`.
+func tokenServer(t *testing.T, status int, body string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/oauth/token"
+}
+
+func TestAccessTokenRefreshed(t *testing.T) {
+	tokenURL := tokenServer(t, http.StatusOK, `{"access_token":"new-access","refresh_token":"new-refresh","token_type":"bearer","expires_in":3600}`)
+	oauth := &OAuth{TokenURL: tokenURL}
+	oauth.token = Token{access: "stale-access", refresh: "refresh", expiredTimestamp: time.Now()}
+
+	// The access token of the refresh is returned, not the expired one that was there before
+	access, accessErr := oauth.AccessToken()
+	if accessErr != nil {
+		t.Fatalf("Got error: %v", accessErr)
+	}
+	if access != "new-access" {
+		t.Fatalf("Got access token: %s, want: new-access", access)
+	}
+}
+
+func TestAccessTokenRefreshRejected(t *testing.T) {
+	tokenURL := tokenServer(t, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"refresh token is revoked"}`)
+	oauth := &OAuth{TokenURL: tokenURL}
+	oauth.token = Token{access: "stale-access", refresh: "revoked", expiredTimestamp: time.Now()}
+
+	// The tokens are invalid and the code of the server error is kept
+	_, accessErr := oauth.AccessToken()
+	var invalidErr *TokensInvalidError
+	if !errors.As(accessErr, &invalidErr) {
+		t.Fatalf("Got error: %v, want: %T", accessErr, invalidErr)
+	}
+	if code := types.ErrorCode(accessErr); code != types.ErrCodeInvalidGrant {
+		t.Fatalf("Got error code: %v, want: %v", code, types.ErrCodeInvalidGrant)
+	}
+}
+
+func TestCancelBeforeExchange(t *testing.T) {
+	oauth := &OAuth{BaseAuthorizationURL: "https://vpn.example.org/oauth/authorize"}
+	if _, urlErr := oauth.AuthURL("org.eduvpn.app.linux", func(url string) string { return url }); urlErr != nil {
+		t.Fatalf("Got error: %v", urlErr)
+	}
+	// The UI cancels right after it got the URL, before the callback server is started
+	oauth.Cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- oauth.Exchange()
+	}()
+	select {
+	case exchangeErr := <-done:
+		var cancelledErr *CancelledCallbackError
+		if !errors.As(exchangeErr, &cancelledErr) {
+			t.Fatalf("Got error: %v, want: %T", exchangeErr, cancelledErr)
+		}
+	case <-time.After(5 * time.Second):
+		oauth.Cancel()
+		t.Fatalf("The exchange waits for a callback after OAuth was cancelled")
+	}
+}
+
+// endObserver records the requests that finished.
+type endObserver struct {
+	ends []httpw.RequestEnd
+}
+
+func (o *endObserver) RequestStarted(httpw.RequestStart) {}
+
+func (o *endObserver) RequestFinished(end httpw.RequestEnd) {
+	o.ends = append(o.ends, end)
+}
+
+func TestAuthorizationTraced(t *testing.T) {
+	observer := &endObserver{}
+	oauth := &OAuth{
+		BaseAuthorizationURL: "https://vpn.example.org/oauth/authorize",
+		HTTPClient:           httpw.NewClient(httpw.Options{Observer: observer}),
+	}
+	if _, urlErr := oauth.AuthURL("org.eduvpn.app.linux", func(url string) string { return url }); urlErr != nil {
+		t.Fatalf("Got error: %v", urlErr)
+	}
+	if len(observer.ends) != 0 {
+		t.Fatalf("Got finished requests: %v before the authorization ended", observer.ends)
+	}
+
+	// The time in the browser is observed until the authorization ends, with the parameters redacted
+	oauth.Cancel()
+	oauth.Cancel()
+	if len(observer.ends) != 1 {
+		t.Fatalf("Got finished requests: %v, want one for the authorization", observer.ends)
+	}
+	end := observer.ends[0]
+	if end.Operation != "oauth.authorize" || end.Method != http.MethodGet || end.Err == nil {
+		t.Fatalf("Got: %s %s with error: %v, want a cancelled GET oauth.authorize", end.Method, end.Operation, end.Err)
+	}
+	if !strings.Contains(end.URL, "state=REDACTED") || !strings.Contains(end.URL, "code_challenge=REDACTED") {
+		t.Fatalf("Got URL: %s, want the parameters redacted", end.URL)
+	}
+}
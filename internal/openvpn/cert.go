@@ -0,0 +1,35 @@
+package openvpn
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Certificate returns the client certificate of the inline <cert> block.
+// It returns nil without an error if the configuration has no inline certificate.
+func (config *Config) Certificate() (*x509.Certificate, error) {
+	directive, ok := config.Get("cert")
+	if !ok || !directive.Inline {
+		return nil, nil
+	}
+	block, _ := pem.Decode([]byte(directive.Content))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, &CertificateError{Message: "no PEM encoded certificate found"}
+	}
+	certificate, parseErr := x509.ParseCertificate(block.Bytes)
+	if parseErr != nil {
+		return nil, &CertificateError{Message: parseErr.Error()}
+	}
+	return certificate, nil
+}
+
+// CertificateError is returned when the inline certificate of an OpenVPN configuration cannot be parsed.
+type CertificateError struct {
+	// Message is the reason the certificate cannot be parsed
+	Message string
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("failed parsing OpenVPN client certificate: %s", e.Message)
+}
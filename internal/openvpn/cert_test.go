@@ -0,0 +1,75 @@
+package openvpn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// generateCertificate generates a self-signed PEM encoded certificate with `commonName` that expires at `notAfter`.
+func generateCertificate(t *testing.T, commonName string, notAfter time.Time) string {
+	t.Helper()
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatalf("Got key error: %v", keyErr)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	der, certErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if certErr != nil {
+		t.Fatalf("Got certificate error: %v", certErr)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificate(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	config := "dev tun\n<cert>\n" + generateCertificate(t, "4b2e7c0a9d3f", notAfter) + "</cert>\n"
+	parsed, parseErr := Parse(config)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	certificate, certificateErr := parsed.Certificate()
+	if certificateErr != nil {
+		t.Fatalf("Got certificate error: %v", certificateErr)
+	}
+	if certificate.Subject.CommonName != "4b2e7c0a9d3f" {
+		t.Fatalf("Got subject: %s, want common name: 4b2e7c0a9d3f", certificate.Subject)
+	}
+	if !certificate.NotAfter.Equal(notAfter) {
+		t.Fatalf("Got not after: %v, want: %v", certificate.NotAfter, notAfter)
+	}
+}
+
+func TestCertificateMissing(t *testing.T) {
+	parsed, parseErr := Parse("dev tun\ncert client.crt\n")
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	// A certificate that is not inline is not an error
+	certificate, certificateErr := parsed.Certificate()
+	if certificate != nil || certificateErr != nil {
+		t.Fatalf("Got certificate: %v and error: %v, want none", certificate, certificateErr)
+	}
+
+	parsed, parseErr = Parse("<cert>\nMIIB\n</cert>\n")
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	_, certificateErr = parsed.Certificate()
+	var certificateError *CertificateError
+	if !errors.As(certificateErr, &certificateError) {
+		t.Fatalf("Got error: %v, want a certificate error", certificateErr)
+	}
+}
@@ -0,0 +1,231 @@
+// Package openvpn implements a parser for OpenVPN configurations and a policy that rejects unsafe directives and rewrites the configuration
+package openvpn
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// Directive is a single directive of an OpenVPN configuration.
+// This is either a line with a name and arguments, e.g. "remote vpn.example.org 1194 udp", or an inline block, e.g. "<ca>...</ca>".
+type Directive struct {
+	// Name is the name of the directive, e.g. "remote" or "ca"
+	Name string
+
+	// Args are the arguments of the directive, empty for inline blocks
+	Args []string
+
+	// Inline indicates that the directive is an inline block
+	Inline bool
+
+	// Content is the content of the inline block without the surrounding tags
+	Content string
+}
+
+// Config is a parsed OpenVPN configuration.
+type Config struct {
+	// Directives are the directives in the order they appeared, comments are not kept
+	Directives []Directive
+}
+
+// splitArgs splits a line in its arguments, arguments can be quoted with double or single quotes.
+// As in OpenVPN, a backslash escapes the next character outside single quotes and everything inside single quotes is literal.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	// The quote that the current argument is in, 0 if not quoted
+	var quote rune
+	escaped := false
+	for _, char := range line {
+		switch {
+		case escaped:
+			current.WriteRune(char)
+			escaped = false
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '\\':
+			escaped = true
+			inArg = true
+		case char == '"' && quote == '"':
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\''):
+			quote = char
+			inArg = true
+		case quote == 0 && (char == ' ' || char == '\t'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in line: %s", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// Parse parses an OpenVPN configuration into its directives.
+// It returns an error if an inline block is not closed or a line cannot be split in arguments.
+func Parse(config string) (*Config, error) {
+	parsed := &Config{}
+	// The inline block that is currently parsed, nil if not in a block
+	var block *Directive
+	var blockLines []string
+	var blockStart int
+
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if block != nil {
+			if line == fmt.Sprintf("</%s>", block.Name) {
+				block.Content = strings.Join(blockLines, "\n")
+				parsed.Directives = append(parsed.Directives, *block)
+				block = nil
+				continue
+			}
+			blockLines = append(blockLines, line)
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "<") && strings.HasSuffix(line, ">") && !strings.HasPrefix(line, "</") {
+			block = &Directive{Name: line[1 : len(line)-1], Inline: true}
+			blockLines = nil
+			blockStart = lineNumber
+			continue
+		}
+
+		args, argsErr := splitArgs(line)
+		if argsErr != nil {
+			return nil, &ParseError{Line: lineNumber, Message: argsErr.Error()}
+		}
+		// OpenVPN also accepts the directives in a config file with the leading "--" of the command line options
+		name := strings.TrimPrefix(args[0], "--")
+		parsed.Directives = append(parsed.Directives, Directive{Name: name, Args: args[1:]})
+	}
+
+	if block != nil {
+		return nil, &ParseError{
+			Line:    blockStart,
+			Message: fmt.Sprintf("inline block <%s> is not closed", block.Name),
+		}
+	}
+	return parsed, nil
+}
+
+// Get returns the first directive with `name`.
+func (config *Config) Get(name string) (*Directive, bool) {
+	for i := range config.Directives {
+		if config.Directives[i].Name == name {
+			return &config.Directives[i], true
+		}
+	}
+	return nil, false
+}
+
+// All returns all directives with `name`, e.g. all remotes.
+func (config *Config) All(name string) []Directive {
+	var directives []Directive
+	for _, directive := range config.Directives {
+		if directive.Name == name {
+			directives = append(directives, directive)
+		}
+	}
+	return directives
+}
+
+// Remove removes all directives with `name`, also if they are wrapped with "setenv opt".
+func (config *Config) Remove(name string) {
+	directives := config.Directives[:0]
+	for _, directive := range config.Directives {
+		if directive.Name != name && unwrap(directive).Name != name {
+			directives = append(directives, directive)
+		}
+	}
+	config.Directives = directives
+}
+
+// Set sets the directive with `name` to `args`.
+// The first directive with `name` is replaced and the others are removed, if there is none it is added to the end.
+func (config *Config) Set(name string, args ...string) {
+	directive := Directive{Name: name, Args: args}
+	existing, ok := config.Get(name)
+	if !ok {
+		config.Directives = append(config.Directives, directive)
+		return
+	}
+	*existing = directive
+	directives := config.Directives[:0]
+	set := false
+	for _, current := range config.Directives {
+		if current.Name == name {
+			if set {
+				continue
+			}
+			set = true
+		}
+		directives = append(directives, current)
+	}
+	config.Directives = directives
+}
+
+// quoteArg quotes an argument if it is needed to parse it back as a single argument.
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg)
+	return fmt.Sprintf(`"%s"`, escaped)
+}
+
+// String returns the directive as it is written in a configuration.
+func (directive Directive) String() string {
+	if directive.Inline {
+		return fmt.Sprintf("<%s>\n%s\n</%s>", directive.Name, directive.Content, directive.Name)
+	}
+	parts := []string{directive.Name}
+	for _, arg := range directive.Args {
+		parts = append(parts, quoteArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// String serializes the configuration with a directive on each line.
+func (config *Config) String() string {
+	var builder strings.Builder
+	for _, directive := range config.Directives {
+		builder.WriteString(directive.String())
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// ParseError is returned when an OpenVPN configuration cannot be parsed.
+type ParseError struct {
+	// Line is the line number of the error
+	Line int
+
+	// Message is the reason the configuration cannot be parsed
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed parsing OpenVPN config on line %d: %s", e.Line, e.Message)
+}
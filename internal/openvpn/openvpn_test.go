@@ -0,0 +1,60 @@
+package openvpn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	config := `# comment
+dev tun
+; another comment
+remote vpn.example.org 1194 udp
+verify-x509-name "CN=vpn example" subject
+setenv UV_NAME 'vpn "example" \org'
+<ca>
+-----BEGIN CERTIFICATE-----
+MIIB
+-----END CERTIFICATE-----
+</ca>
+--remote vpn.example.org 1194 tcp
+`
+	parsed, parseErr := Parse(config)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	want := []Directive{
+		{Name: "dev", Args: []string{"tun"}},
+		{Name: "remote", Args: []string{"vpn.example.org", "1194", "udp"}},
+		{Name: "verify-x509-name", Args: []string{"CN=vpn example", "subject"}},
+		{Name: "setenv", Args: []string{"UV_NAME", `vpn "example" \org`}},
+		{Name: "ca", Inline: true, Content: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"},
+		{Name: "remote", Args: []string{"vpn.example.org", "1194", "tcp"}},
+	}
+	if !reflect.DeepEqual(parsed.Directives, want) {
+		t.Fatalf("Got directives: %v, want: %v", parsed.Directives, want)
+	}
+	if remotes := parsed.All("remote"); len(remotes) != 2 {
+		t.Fatalf("Got remotes: %v, want 2", remotes)
+	}
+
+	// The serialized config parses to the same directives
+	reparsed, reparseErr := Parse(parsed.String())
+	if reparseErr != nil {
+		t.Fatalf("Got reparse error: %v", reparseErr)
+	}
+	if !reflect.DeepEqual(reparsed.Directives, want) {
+		t.Fatalf("Got directives after reparse: %v, want: %v", reparsed.Directives, want)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, config := range []string{"<ca>\nMIIB\n", "verify-x509-name \"CN=vpn\n", "verify-x509-name 'CN=vpn\n"} {
+		_, parseErr := Parse(config)
+		var parseError *ParseError
+		if !errors.As(parseErr, &parseError) {
+			t.Fatalf("Got error: %v, want a parse error for config: %s", parseErr, config)
+		}
+	}
+}
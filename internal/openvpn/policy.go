@@ -0,0 +1,222 @@
+package openvpn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultDenylist are the directives that are always rejected.
+// These directives run scripts, load plugins, crypto engines or providers, or read and write other files such that a compromised server could run code on the client or overwrite its files.
+// script-security is rejected as well if it allows running scripts, i.e. if its level is 2 or higher.
+// The directives are also rejected if they are wrapped in "setenv opt", which OpenVPN applies as the directive itself.
+var DefaultDenylist = []string{
+	"up",
+	"down",
+	"plugin",
+	"route-up",
+	"route-pre-down",
+	"ipchange",
+	"tls-verify",
+	"auth-user-pass-verify",
+	"client-connect",
+	"client-disconnect",
+	"learn-address",
+	"iproute",
+	"config",
+	"pkcs11-providers",
+	"engine",
+	"providers",
+	"tls-export-cert",
+	"tls-crypt-v2-verify",
+	"log",
+	"log-append",
+	"status",
+	"writepid",
+}
+
+// scriptSecurityDenied is the lowest script-security level that is rejected, the level that allows running scripts.
+const scriptSecurityDenied = 2
+
+// Management is the management interface that OpenVPN listens on.
+type Management struct {
+	// Address is the address to listen on, e.g. 127.0.0.1 or the path of a unix socket
+	Address string
+
+	// Port is the port to listen on, 0 if Address is a unix socket
+	Port int
+
+	// PasswordFile is the optional file with the password for the management interface
+	PasswordFile string
+}
+
+// args returns the arguments of the management directive.
+func (management *Management) args() []string {
+	args := []string{management.Address}
+	if management.Port != 0 {
+		args = append(args, strconv.Itoa(management.Port))
+	} else {
+		args = append(args, "unix")
+	}
+	if management.PasswordFile != "" {
+		args = append(args, management.PasswordFile)
+	}
+	return args
+}
+
+// Policy defines the directives that are rejected and the rewrites that are applied to an OpenVPN configuration.
+type Policy struct {
+	// Denylist are the directives that are rejected in addition to DefaultDenylist
+	Denylist []string
+
+	// Management is the management interface that is added, the management interface of the server is always removed
+	// If nil, no management interface is added
+	Management *Management
+
+	// RemoveScriptSecurity removes the script-security directives
+	RemoveScriptSecurity bool
+
+	// RemoteRandom makes OpenVPN try the remotes in a random order
+	RemoteRandom bool
+
+	// ForceTCP only keeps the TCP remotes and sets the protocol to TCP
+	ForceTCP bool
+}
+
+// denylist returns the directives that are rejected by the policy.
+func (policy Policy) denylist() []string {
+	return append(append([]string(nil), DefaultDenylist...), policy.Denylist...)
+}
+
+// unwrap returns the directive that `directive` wraps with "setenv opt", e.g. "up /tmp/up.sh" for "setenv opt up /tmp/up.sh".
+// OpenVPN applies a wrapped directive as the directive itself and only ignores it if it is unknown.
+// Other directives are returned as is.
+func unwrap(directive Directive) Directive {
+	for directive.Name == "setenv" && len(directive.Args) >= 2 && directive.Args[0] == "opt" {
+		directive = Directive{Name: strings.TrimPrefix(directive.Args[1], "--"), Args: directive.Args[2:]}
+	}
+	return directive
+}
+
+// scriptsAllowed returns whether or not the script-security directive `directive` allows running scripts.
+// A level that is not a number is treated as allowing scripts.
+func scriptsAllowed(directive Directive) bool {
+	if len(directive.Args) == 0 {
+		return false
+	}
+	level, levelErr := strconv.Atoi(directive.Args[0])
+	return levelErr != nil || level >= scriptSecurityDenied
+}
+
+// Check returns a *DeniedError if the configuration has a directive that is on the denylist of `policy`,
+// or a script-security directive that allows running scripts.
+func (config *Config) Check(policy Policy) error {
+	denylist := policy.denylist()
+	for _, directive := range config.Directives {
+		directive = unwrap(directive)
+		for _, denied := range denylist {
+			if directive.Name == denied {
+				return &DeniedError{Directive: denied}
+			}
+		}
+		if directive.Name == "script-security" && scriptsAllowed(directive) {
+			return &DeniedError{Directive: "script-security"}
+		}
+	}
+	return nil
+}
+
+// isTCP returns whether or not an OpenVPN protocol, e.g. "udp", "tcp-client" or "tcp6", is TCP.
+func isTCP(proto string) bool {
+	return strings.HasPrefix(proto, "tcp")
+}
+
+// forceTCP removes the remotes that do not use TCP and sets the protocol to TCP.
+func (config *Config) forceTCP() error {
+	// The protocol of remotes without a protocol argument
+	defaultProto := "udp"
+	if proto, ok := config.Get("proto"); ok && len(proto.Args) > 0 {
+		defaultProto = proto.Args[0]
+	}
+
+	directives := config.Directives[:0]
+	remotes := 0
+	for _, directive := range config.Directives {
+		if directive.Name == "remote" {
+			proto := defaultProto
+			if len(directive.Args) > 2 {
+				proto = directive.Args[2]
+			}
+			if !isTCP(proto) {
+				continue
+			}
+			remotes++
+		}
+		directives = append(directives, directive)
+	}
+	config.Directives = directives
+
+	if remotes == 0 {
+		return &PolicyError{Message: "the config has no TCP remotes"}
+	}
+	config.Set("proto", "tcp-client")
+	return nil
+}
+
+// Apply checks the configuration against the denylist of `policy` and then applies its rewrites.
+// It returns a *DeniedError if a directive is on the denylist and a *PolicyError if a rewrite cannot be applied.
+func (config *Config) Apply(policy Policy) error {
+	if checkErr := config.Check(policy); checkErr != nil {
+		return checkErr
+	}
+
+	config.Remove("management")
+	if policy.Management != nil {
+		config.Set("management", policy.Management.args()...)
+	}
+	if policy.RemoveScriptSecurity {
+		config.Remove("script-security")
+	}
+	if policy.RemoteRandom {
+		config.Set("remote-random")
+	}
+	if policy.ForceTCP {
+		if tcpErr := config.forceTCP(); tcpErr != nil {
+			return tcpErr
+		}
+	}
+	return nil
+}
+
+// ApplyPolicy parses the OpenVPN configuration `config` and applies `policy`.
+// It returns the rewritten configuration and the parsed configuration.
+func ApplyPolicy(config string, policy Policy) (string, *Config, error) {
+	parsed, parseErr := Parse(config)
+	if parseErr != nil {
+		return "", nil, parseErr
+	}
+	if applyErr := parsed.Apply(policy); applyErr != nil {
+		return "", nil, applyErr
+	}
+	return parsed.String(), parsed, nil
+}
+
+// DeniedError is returned when an OpenVPN configuration has a directive that is on the denylist.
+type DeniedError struct {
+	// Directive is the name of the directive that is denied
+	Directive string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("OpenVPN config has the denied directive: %s", e.Directive)
+}
+
+// PolicyError is returned when the rewrites of a policy cannot be applied.
+type PolicyError struct {
+	// Message is the reason the policy cannot be applied
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("failed applying OpenVPN policy: %s", e.Message)
+}
@@ -0,0 +1,136 @@
+package openvpn
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const policyConfig = `dev tun
+client
+proto udp
+remote vpn.example.org 1194
+remote vpn.example.org 1194 tcp
+remote vpn.example.org 443 tcp-client
+script-security 1
+management 0.0.0.0 7505
+<ca>
+MIIB
+</ca>
+`
+
+func TestApplyPolicy(t *testing.T) {
+	policy := Policy{
+		Management:           &Management{Address: "127.0.0.1", Port: 7505, PasswordFile: "/run/eduvpn/management"},
+		RemoveScriptSecurity: true,
+		RemoteRandom:         true,
+		ForceTCP:             true,
+	}
+	_, parsed, applyErr := ApplyPolicy(policyConfig, policy)
+	if applyErr != nil {
+		t.Fatalf("Got apply error: %v", applyErr)
+	}
+	want := []Directive{
+		{Name: "dev", Args: []string{"tun"}},
+		{Name: "client", Args: []string{}},
+		{Name: "proto", Args: []string{"tcp-client"}},
+		{Name: "remote", Args: []string{"vpn.example.org", "1194", "tcp"}},
+		{Name: "remote", Args: []string{"vpn.example.org", "443", "tcp-client"}},
+		{Name: "ca", Inline: true, Content: "MIIB"},
+		{Name: "management", Args: []string{"127.0.0.1", "7505", "/run/eduvpn/management"}},
+		{Name: "remote-random"},
+	}
+	if !reflect.DeepEqual(parsed.Directives, want) {
+		t.Fatalf("Got directives: %v, want: %v", parsed.Directives, want)
+	}
+}
+
+func TestApplyPolicyDefault(t *testing.T) {
+	config, parsed, applyErr := ApplyPolicy(policyConfig, Policy{})
+	if applyErr != nil {
+		t.Fatalf("Got apply error: %v", applyErr)
+	}
+	// Only the management interface of the server is removed
+	if _, ok := parsed.Get("management"); ok {
+		t.Fatalf("Got the management interface of the server in config: %s", config)
+	}
+	if len(parsed.All("remote")) != 3 {
+		t.Fatalf("Got remotes: %v, want 3", parsed.All("remote"))
+	}
+	if _, ok := parsed.Get("script-security"); !ok {
+		t.Fatalf("Got no script-security in config: %s", config)
+	}
+
+	// A management interface that is wrapped with setenv opt is removed as well
+	_, parsed, applyErr = ApplyPolicy(policyConfig+"setenv opt management 0.0.0.0 7506\n", Policy{})
+	if applyErr != nil {
+		t.Fatalf("Got apply error: %v", applyErr)
+	}
+	if directives := parsed.String(); strings.Contains(directives, "management") {
+		t.Fatalf("Got the wrapped management interface of the server in config: %s", directives)
+	}
+}
+
+func TestApplyPolicyDenied(t *testing.T) {
+	directives := []string{
+		"up /tmp/evil.sh",
+		"plugin /tmp/evil.so",
+		"route-up /tmp/evil.sh",
+		"config /tmp/evil.ovpn",
+		"pkcs11-providers /tmp/evil.so",
+		"engine dynamic",
+		"providers legacy /tmp/evil",
+		"tls-export-cert /tmp",
+		"script-security 2",
+		"script-security 3 execve",
+		"script-security system",
+		// The command line form is accepted in config files as well
+		"--up /tmp/evil.sh",
+		"--script-security 2",
+		// Quoting does not hide the name
+		"'up' /tmp/evil.sh",
+		"\"up\" /tmp/evil.sh",
+		// OpenVPN applies directives that are wrapped with setenv opt
+		"setenv opt up /tmp/evil.sh",
+		"setenv opt --up /tmp/evil.sh",
+		"--setenv opt up /tmp/evil.sh",
+		"setenv opt setenv opt up /tmp/evil.sh",
+		"setenv opt script-security 2",
+		"tls-crypt-v2-verify /tmp/evil.sh",
+		"--tls-crypt-v2-verify /tmp/evil.sh",
+		"log /tmp/evil",
+		"log-append /tmp/evil",
+		"status /tmp/evil",
+		"writepid /tmp/evil",
+	}
+	for _, directive := range directives {
+		_, _, applyErr := ApplyPolicy(policyConfig+directive+"\n", Policy{})
+		var deniedErr *DeniedError
+		if !errors.As(applyErr, &deniedErr) {
+			t.Fatalf("Got error: %v, want a denied error for: %s", applyErr, directive)
+		}
+	}
+
+	// A custom denylist is added to the default
+	for _, denylist := range [][]string{{}, {"route"}} {
+		_, _, applyErr := ApplyPolicy(policyConfig+"up /tmp/up.sh\n", Policy{Denylist: denylist})
+		var deniedErr *DeniedError
+		if !errors.As(applyErr, &deniedErr) || deniedErr.Directive != "up" {
+			t.Fatalf("Got error: %v with denylist: %v, want a denied error for up", applyErr, denylist)
+		}
+	}
+	_, _, applyErr := ApplyPolicy(policyConfig+"route 10.0.0.0 255.0.0.0\n", Policy{Denylist: []string{"route"}})
+	var deniedErr *DeniedError
+	if !errors.As(applyErr, &deniedErr) || deniedErr.Directive != "route" {
+		t.Fatalf("Got error: %v, want a denied error for route", applyErr)
+	}
+}
+
+func TestApplyPolicyNoTCP(t *testing.T) {
+	_, _, applyErr := ApplyPolicy("dev tun\nremote vpn.example.org 1194 udp\n", Policy{ForceTCP: true})
+	var policyErr *PolicyError
+	if !errors.As(applyErr, &policyErr) {
+		t.Fatalf("Got error: %v, want a policy error", applyErr)
+	}
+}
@@ -0,0 +1,84 @@
+// Package progress implements progress reporting for long-running operations such as adding a server or getting a config
+package progress
+
+import "time"
+
+// Step identifies a step of a long-running operation.
+// The values are stable such that UIs can use them to show a status.
+type Step string
+
+const (
+	// StepDiscoveryServers means the server list is being fetched from the discovery server
+	StepDiscoveryServers Step = "discovery_servers"
+
+	// StepDiscoveryOrganizations means the organization list is being fetched from the discovery server
+	StepDiscoveryOrganizations Step = "discovery_organizations"
+
+	// StepEndpoints means the endpoints are being fetched from the well-known URL of the server
+	StepEndpoints Step = "endpoints"
+
+	// StepAuthorize means the user is authorizing the client with OAuth
+	StepAuthorize Step = "authorize"
+
+	// StepServerInfo means the profiles are being fetched with /info
+	StepServerInfo Step = "server_info"
+
+	// StepWireGuardKey means a WireGuard key is being generated
+	StepWireGuardKey Step = "wireguard_key"
+
+	// StepConnect means the configuration is being obtained with /connect
+	StepConnect Step = "connect"
+
+	// StepDone means the operation is done
+	StepDone Step = "done"
+)
+
+// Event is a progress event of a long-running operation.
+type Event struct {
+	// Step is the step that is started
+	Step Step
+
+	// URL is the URL of the server the operation is for
+	URL string
+
+	// Elapsed is the time since the start of the operation
+	Elapsed time.Duration
+}
+
+// Callback is the function that is called with each progress event.
+type Callback func(Event)
+
+// Tracker tracks the progress of a single long-running operation.
+// A nil tracker does not report anything such that it can be passed when there is no one interested.
+type Tracker struct {
+	// callback is called for each step
+	callback Callback
+
+	// url is the URL of the server the operation is for
+	url string
+
+	// start is the time the operation started
+	start time.Time
+}
+
+// New creates a tracker for an operation for the server with `url` that starts now.
+// The events are reported with `callback`, it returns nil if the callback is nil.
+func New(callback Callback, url string) *Tracker {
+	if callback == nil {
+		return nil
+	}
+	return &Tracker{callback: callback, url: url, start: time.Now()}
+}
+
+// Step reports that `step` is started.
+func (tracker *Tracker) Step(step Step) {
+	if tracker == nil {
+		return
+	}
+	tracker.callback(Event{Step: step, URL: tracker.url, Elapsed: time.Since(tracker.start)})
+}
+
+// Done reports that the operation is done.
+func (tracker *Tracker) Done() {
+	tracker.Step(StepDone)
+}
@@ -0,0 +1,36 @@
+package progress
+
+import (
+	"testing"
+)
+
+func TestTracker(t *testing.T) {
+	// A nil tracker must not panic
+	var nilTracker *Tracker
+	nilTracker.Step(StepEndpoints)
+	if New(nil, "https://example.com/") != nil {
+		t.Fatalf("Got a tracker without a callback, want nil")
+	}
+
+	var events []Event
+	tracker := New(func(event Event) {
+		events = append(events, event)
+	}, "https://example.com/")
+	tracker.Step(StepEndpoints)
+	tracker.Done()
+
+	if len(events) != 2 {
+		t.Fatalf("Got %d events, want: 2", len(events))
+	}
+	if events[0].Step != StepEndpoints || events[1].Step != StepDone {
+		t.Fatalf("Got steps: %s, %s, want: %s, %s", events[0].Step, events[1].Step, StepEndpoints, StepDone)
+	}
+	for _, event := range events {
+		if event.URL != "https://example.com/" {
+			t.Fatalf("Got URL: %s, want: https://example.com/", event.URL)
+		}
+	}
+	if events[1].Elapsed < events[0].Elapsed {
+		t.Fatalf("Elapsed time of the last event: %s is less than the first: %s", events[1].Elapsed, events[0].Elapsed)
+	}
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// environment is the proxy configuration from the environment variables.
+type environment struct {
+	// httpProxy is the proxy for http URLs, nil for none
+	httpProxy *url.URL
+
+	// httpsProxy is the proxy for https URLs, nil for none
+	httpsProxy *url.URL
+
+	// noProxy are the entries of NO_PROXY
+	noProxy []string
+}
+
+// getEnv returns the environment variable `name` in upper or lower case.
+func getEnv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// fromEnvironment reads the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+// Unlike http.ProxyFromEnvironment the variables are read every time the proxy is configured.
+func fromEnvironment() (*environment, error) {
+	env := &environment{}
+	if value := getEnv("HTTP_PROXY"); value != "" {
+		proxyURL, urlErr := parseProxyURL(value)
+		if urlErr != nil {
+			return nil, urlErr
+		}
+		env.httpProxy = proxyURL
+	}
+	if value := getEnv("HTTPS_PROXY"); value != "" {
+		proxyURL, urlErr := parseProxyURL(value)
+		if urlErr != nil {
+			return nil, urlErr
+		}
+		env.httpsProxy = proxyURL
+	}
+	for _, entry := range strings.Split(getEnv("NO_PROXY"), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" {
+			env.noProxy = append(env.noProxy, entry)
+		}
+	}
+	return env, nil
+}
+
+// excluded returns whether or not the `host` with `port` matches an entry of NO_PROXY.
+// The entries are "*", IP addresses, CIDR networks and domains that also match their subdomains, optionally with a port.
+func (env *environment) excluded(host string, port string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range env.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, network, cidrErr := net.ParseCIDR(entry); cidrErr == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if splitHost, splitPort, splitErr := net.SplitHostPort(entry); splitErr == nil {
+			entryHost, entryPort = splitHost, splitPort
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(strings.Trim(entryHost, "[]")); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxy returns the proxy for the request from the environment.
+func (env *environment) proxy(req *http.Request) (*url.URL, error) {
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if env.excluded(req.URL.Hostname(), port) {
+		return nil, nil
+	}
+	if req.URL.Scheme == "https" {
+		return env.httpsProxy, nil
+	}
+	return env.httpProxy, nil
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// This file implements proxy auto-config (PAC) scripts with the goja JavaScript engine.
+// The PAC helper functions are available except for the date and time functions, weekdayRange, dateRange and timeRange.
+// The script cannot do I/O other than the DNS lookups of the helper functions.
+// Each run of the script is limited in time and in the depth of nested function calls, such that a script cannot hang the requests.
+
+// PACError is returned when a PAC script cannot be parsed or evaluated.
+type PACError struct {
+	Message string
+}
+
+func (e *PACError) Error() string {
+	return fmt.Sprintf("PAC script: %s", e.Message)
+}
+
+// pacTimeout is the maximum time a run of the script can take, it is a variable such that the tests can lower it.
+var pacTimeout = 5 * time.Second
+
+// maxCallStackSize is the maximum depth of nested function calls.
+const maxCallStackSize = 256
+
+// PAC is a parsed proxy auto-config script.
+type PAC struct {
+	// mu guards the runtime, it can only be used by one goroutine at a time
+	mu sync.Mutex
+
+	// vm is the runtime with the script and the helper functions
+	vm *goja.Runtime
+
+	// findProxyForURL is the FindProxyForURL function of the script
+	findProxyForURL goja.Callable
+}
+
+// ParsePAC parses the proxy auto-config script `script`.
+// It returns an error if the script cannot be parsed, fails to run or has no FindProxyForURL function.
+func ParsePAC(script string) (*PAC, error) {
+	errorMessage := "failed parsing the PAC script"
+	program, compileErr := goja.Compile("proxy.pac", script, false)
+	if compileErr != nil {
+		return nil, types.NewWrappedError(errorMessage, &PACError{Message: compileErr.Error()})
+	}
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStackSize)
+	for name, helper := range helpers {
+		if setErr := vm.Set(name, helper); setErr != nil {
+			return nil, types.NewWrappedError(errorMessage, setErr)
+		}
+	}
+	pac := &PAC{vm: vm}
+	if _, runErr := pac.run(func() (goja.Value, error) { return vm.RunProgram(program) }); runErr != nil {
+		return nil, types.NewWrappedError(errorMessage, runErr)
+	}
+	value := vm.Get("FindProxyForURL")
+	findProxyForURL, ok := goja.AssertFunction(value)
+	if !ok || value.ToObject(vm).Get("length").ToInteger() != 2 {
+		return nil, types.NewWrappedError(errorMessage, &PACError{Message: "no FindProxyForURL(url, host) function"})
+	}
+	pac.findProxyForURL = findProxyForURL
+	return pac, nil
+}
+
+// run runs `f` with the runtime, it is interrupted if it takes longer than pacTimeout.
+// The caller must not use the runtime concurrently.
+func (pac *PAC) run(f func() (goja.Value, error)) (goja.Value, error) {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		timer := time.NewTimer(pacTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			pac.vm.Interrupt(fmt.Sprintf("the script did not finish within %v", pacTimeout))
+		case <-stop:
+		}
+	}()
+	value, runErr := f()
+	close(stop)
+	// Wait for the interrupt such that it does not interrupt the next run
+	<-stopped
+	pac.vm.ClearInterrupt()
+	if runErr != nil {
+		return nil, &PACError{Message: runErr.Error()}
+	}
+	return value, nil
+}
+
+// FindProxyForURL calls the FindProxyForURL function of the script and returns its result, e.g. "PROXY proxy.example.org:3128; DIRECT".
+func (pac *PAC) FindProxyForURL(rawURL string, host string) (string, error) {
+	pac.mu.Lock()
+	defer pac.mu.Unlock()
+	value, callErr := pac.run(func() (goja.Value, error) {
+		return pac.findProxyForURL(goja.Undefined(), pac.vm.ToValue(rawURL), pac.vm.ToValue(host))
+	})
+	if callErr != nil {
+		return "", types.NewWrappedError("failed evaluating the PAC script", callErr)
+	}
+	if goja.IsUndefined(value) || goja.IsNull(value) {
+		return "", nil
+	}
+	return value.String(), nil
+}
+
+// parseResult returns the first supported proxy of the PAC result `result`, nil for DIRECT.
+func parseResult(result string) (*url.URL, error) {
+	if strings.TrimSpace(result) == "" {
+		return nil, nil
+	}
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.EqualFold(fields[0], "DIRECT") {
+			return nil, nil
+		}
+		if len(fields) != 2 {
+			continue
+		}
+		scheme := ""
+		switch strings.ToUpper(fields[0]) {
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			// E.g. SOCKS4 is not supported by the Go HTTP client
+			continue
+		}
+		proxyURL, urlErr := parseProxyURL(scheme + "://" + fields[1])
+		if urlErr != nil {
+			continue
+		}
+		return proxyURL, nil
+	}
+	return nil, types.NewWrappedError(
+		fmt.Sprintf("failed using the PAC result: %s", result),
+		errNoProxy,
+	)
+}
+
+// proxy returns the proxy for the request according to the script.
+func (pac *PAC) proxy(req *http.Request) (*url.URL, error) {
+	// Like browsers, only give the scheme and host of https URLs to the script
+	rawURL := req.URL.String()
+	if req.URL.Scheme == "https" {
+		rawURL = (&url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: "/"}).String()
+	}
+	result, resultErr := pac.FindProxyForURL(rawURL, req.URL.Hostname())
+	if resultErr != nil {
+		return nil, resultErr
+	}
+	return parseResult(result)
+}
+
+// lookupIP resolves a host name, it is a variable such that the tests can replace it.
+var lookupIP = net.LookupIP
+
+// resolveIPv4 returns the first IPv4 address of `host`, nil if it does not resolve.
+func resolveIPv4(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4()
+	}
+	ips, lookupErr := lookupIP(host)
+	if lookupErr != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return nil
+}
+
+// myIPAddress returns the address of the interface that is used for the default route.
+func myIPAddress() string {
+	// Connecting a UDP socket does not send any packets
+	conn, dialErr := net.Dial("udp4", "192.0.2.1:9")
+	if dialErr != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return "127.0.0.1"
+}
+
+// shExpMatch matches `str` against the shell expression `pattern` with the * and ? wildcards.
+func shExpMatch(str string, pattern string) bool {
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+	matched, matchErr := regexp.MatchString("^"+expression+"$", str)
+	return matchErr == nil && matched
+}
+
+// helpers are the PAC helper functions, the arguments and results are converted by the runtime.
+var helpers = map[string]interface{}{
+	"isPlainHostName": func(host string) bool {
+		return !strings.Contains(host, ".")
+	},
+	"dnsDomainIs": func(host string, domain string) bool {
+		return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+	},
+	"localHostOrDomainIs": func(host string, hostdom string) bool {
+		host, hostdom = strings.ToLower(host), strings.ToLower(hostdom)
+		return host == hostdom || !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+".")
+	},
+	"isResolvable": func(host string) bool {
+		return resolveIPv4(host) != nil
+	},
+	"dnsResolve": func(host string) interface{} {
+		if ip := resolveIPv4(host); ip != nil {
+			return ip.String()
+		}
+		return nil
+	},
+	"isInNet": func(host string, pattern string, mask string) bool {
+		ip := resolveIPv4(host)
+		patternIP := net.ParseIP(pattern).To4()
+		maskIP := net.ParseIP(mask).To4()
+		if ip == nil || patternIP == nil || maskIP == nil {
+			return false
+		}
+		return ip.Mask(net.IPMask(maskIP)).Equal(patternIP.Mask(net.IPMask(maskIP)))
+	},
+	"myIpAddress": myIPAddress,
+	"dnsDomainLevels": func(host string) int {
+		return strings.Count(host, ".")
+	},
+	"shExpMatch": shExpMatch,
+	"alert":      func(string) {},
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testPAC = `
+/* A PAC script like the ones that are deployed */
+function isInternal(host) {
+	var internal = dnsDomainIs(host, ".corp.example.org") || localHostOrDomainIs(host, "intranet.example.org");
+	return internal || isPlainHostName(host);
+}
+
+var bypass = ["vpn.example.net", "portal.example.net"];
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (bypass.indexOf(host) >= 0 || /^10\.\d+\.\d+\.\d+$/.test(host)) {
+		return "DIRECT";
+	}
+	switch (url.substring(0, url.indexOf(":"))) {
+	case "ftp":
+		return "PROXY ftp.example.org:2121";
+	}
+	if (isInternal(host)) {
+		return "DIRECT";
+	} else if (isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0")) {
+		return "DIRECT";
+	}
+	if (shExpMatch(url, "http://*.example.com/downloads/*")) return "PROXY downloads.example.org:8080";
+	let level = dnsDomainLevels(host);
+	const socks = level > 2 ? "SOCKS5 socks.example.org:1080" : "";
+	if (socks !== "") {
+		return socks + "; DIRECT";
+	}
+	return "SOCKS4 old.example.org:1080; HTTPS secure.example.org:443; DIRECT";
+}
+`
+
+func TestPAC(t *testing.T) {
+	previous := lookupIP
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host == "resolved.example.net" {
+			return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("10.1.2.3")}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	defer func() { lookupIP = previous }()
+
+	pac, parseErr := ParsePAC(testPAC)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	cases := map[string]string{
+		"https://vpn.corp.example.org/":                "",
+		"http://intranet/":                             "",
+		"https://intranet.example.org/":                "",
+		"https://resolved.example.net/":                "",
+		"http://www.example.com/downloads/client.tar":  "http://downloads.example.org:8080",
+		"https://www.example.com/downloads/client.tar": "https://secure.example.org:443",
+		"https://a.b.example.net/":                     "socks5://socks.example.org:1080",
+		"http://example.net/":                          "https://secure.example.org:443",
+		"https://VPN.example.net/":                     "",
+		"http://10.2.3.4/":                             "",
+		"ftp://files.example.com/":                     "http://ftp.example.org:2121",
+	}
+	for rawURL, want := range cases {
+		if got := proxyFor(t, pac.proxy, rawURL); got != want {
+			t.Fatalf("Got proxy: %s for: %s, want: %s", got, rawURL, want)
+		}
+	}
+}
+
+func TestPACErrors(t *testing.T) {
+	previous := pacTimeout
+	pacTimeout = 100 * time.Millisecond
+	defer func() { pacTimeout = previous }()
+
+	invalid := []string{
+		"",
+		"function FindProxyForURL(url, host) { return \"DIRECT\";",
+		"function FindProxyForURL(url, host) { return 'DIRECT }",
+		"var proxy = \"DIRECT\";",
+		"var FindProxyForURL = \"DIRECT\";",
+		"function FindProxyForURL(url) { return \"DIRECT\"; }",
+		// The script has to finish in time
+		"for (;;) {} function FindProxyForURL(url, host) { return \"DIRECT\"; }",
+		"function FindProxyForURL(url, host) { return url # host; }",
+	}
+	for _, script := range invalid {
+		if _, parseErr := ParsePAC(script); parseErr == nil {
+			t.Fatalf("Got no parse error for script: %s", script)
+		}
+	}
+
+	failing := []string{
+		// Unknown variables and functions
+		"function FindProxyForURL(url, host) { return proxy; }",
+		"function FindProxyForURL(url, host) { return weekdayRange(\"MON\", \"FRI\") ? \"DIRECT\" : \"DIRECT\"; }",
+		// Endless recursion and loops
+		"function FindProxyForURL(url, host) { return FindProxyForURL(url, host); }",
+		"function FindProxyForURL(url, host) { for (;;) {} }",
+		// Exceptions
+		"function FindProxyForURL(url, host) { throw new Error(\"failed\"); }",
+		// No supported proxy and no DIRECT
+		"function FindProxyForURL(url, host) { return \"SOCKS4 old.example.org:1080\"; }",
+	}
+	for _, script := range failing {
+		pac, parseErr := ParsePAC(script)
+		if parseErr != nil {
+			t.Fatalf("Got parse error for script: %s: %v", script, parseErr)
+		}
+		req, reqErr := http.NewRequest(http.MethodGet, "https://vpn.example.org/", nil)
+		if reqErr != nil {
+			t.Fatalf("Got request error: %v", reqErr)
+		}
+		if _, proxyErr := pac.proxy(req); proxyErr == nil {
+			t.Fatalf("Got no error for script: %s", script)
+		}
+	}
+}
+
+func TestPACInterrupted(t *testing.T) {
+	previous := pacTimeout
+	pacTimeout = 100 * time.Millisecond
+	defer func() { pacTimeout = previous }()
+
+	pac, parseErr := ParsePAC(`function FindProxyForURL(url, host) {
+	if (host == "loop.example.org") {
+		for (;;) {}
+	}
+	return "PROXY proxy.example.org:3128";
+}`)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	if _, findErr := pac.FindProxyForURL("https://loop.example.org/", "loop.example.org"); findErr == nil {
+		t.Fatalf("Got no error for a script that does not finish")
+	}
+	// The script can be used after it was interrupted
+	if got := proxyFor(t, pac.proxy, "https://vpn.example.org/"); got != "http://proxy.example.org:3128" {
+		t.Fatalf("Got proxy: %s after an interrupted run, want: http://proxy.example.org:3128", got)
+	}
+}
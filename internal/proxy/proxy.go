@@ -0,0 +1,134 @@
+// Package proxy implements the proxy configuration for the HTTP requests of the library
+// The proxy is given explicitly, taken from the environment variables or chosen by a proxy auto-config (PAC) script.
+// It is only used for the HTTP requests to discovery and the servers, never for the OAuth loopback listener or the VPN connections themselves.
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// Config is the proxy configuration, at most one of the fields can be set.
+// The zero Config leaves the proxy to the HTTP client, which uses the environment variables like any Go program.
+type Config struct {
+	// URL is the proxy for all requests, e.g. http://proxy.example.org:3128 or socks5://127.0.0.1:1080
+	URL string `json:"url,omitempty"`
+
+	// Environment indicates that the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used
+	Environment bool `json:"environment,omitempty"`
+
+	// PAC is a proxy auto-config script with a FindProxyForURL(url, host) function
+	PAC string `json:"pac,omitempty"`
+}
+
+// Func returns the proxy for a request, nil means that the request is done directly.
+type Func func(*http.Request) (*url.URL, error)
+
+// ConfigError is returned when the proxy configuration is invalid.
+type ConfigError struct {
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid proxy configuration: %s", e.Message)
+}
+
+// schemes are the supported proxy schemes.
+var schemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"socks5": true,
+}
+
+// parseProxyURL parses the proxy URL `proxy`, a proxy without a scheme is a HTTP proxy.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	parsed, parseErr := url.Parse(proxy)
+	if parseErr != nil {
+		return nil, &ConfigError{Message: parseErr.Error()}
+	}
+	if !schemes[parsed.Scheme] {
+		return nil, &ConfigError{Message: fmt.Sprintf("unsupported proxy scheme: %s", parsed.Scheme)}
+	}
+	if parsed.Hostname() == "" {
+		return nil, &ConfigError{Message: fmt.Sprintf("proxy URL: %s has no host", proxy)}
+	}
+	return parsed, nil
+}
+
+// IsLoopback returns whether or not `host` is the local machine, requests to it are never proxied.
+// This is where the OAuth loopback listener runs.
+func IsLoopback(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// New returns the proxy function for `config`.
+// It returns nil for the zero Config and an error if the configuration is invalid.
+func New(config Config) (Func, error) {
+	errorMessage := "failed creating the proxy"
+	set := 0
+	if config.URL != "" {
+		set++
+	}
+	if config.Environment {
+		set++
+	}
+	if config.PAC != "" {
+		set++
+	}
+	if set > 1 {
+		return nil, types.NewWrappedError(
+			errorMessage,
+			&ConfigError{Message: "only one of the URL, the environment or a PAC script can be used"},
+		)
+	}
+
+	var proxy Func
+	switch {
+	case config.URL != "":
+		proxyURL, urlErr := parseProxyURL(config.URL)
+		if urlErr != nil {
+			return nil, types.NewWrappedError(errorMessage, urlErr)
+		}
+		proxy = func(*http.Request) (*url.URL, error) {
+			return proxyURL, nil
+		}
+	case config.Environment:
+		environment, environmentErr := fromEnvironment()
+		if environmentErr != nil {
+			return nil, types.NewWrappedError(errorMessage, environmentErr)
+		}
+		proxy = environment.proxy
+	case config.PAC != "":
+		script, pacErr := ParsePAC(config.PAC)
+		if pacErr != nil {
+			return nil, types.NewWrappedError(errorMessage, pacErr)
+		}
+		proxy = script.proxy
+	default:
+		return nil, nil
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if req.URL == nil || IsLoopback(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// errNoProxy is returned by a PAC script that gives no supported proxy and no DIRECT.
+var errNoProxy = errors.New("no supported proxy")
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+// proxyFor returns the proxy that `proxy` chooses for `rawURL` as a string, empty for direct.
+func proxyFor(t *testing.T, proxy Func, rawURL string) string {
+	t.Helper()
+	req, reqErr := http.NewRequest(http.MethodGet, rawURL, nil)
+	if reqErr != nil {
+		t.Fatalf("Got request error: %v", reqErr)
+	}
+	proxyURL, proxyErr := proxy(req)
+	if proxyErr != nil {
+		t.Fatalf("Got proxy error for: %s: %v", rawURL, proxyErr)
+	}
+	if proxyURL == nil {
+		return ""
+	}
+	return proxyURL.String()
+}
+
+func TestNew(t *testing.T) {
+	zero, zeroErr := New(Config{})
+	if zeroErr != nil || zero != nil {
+		t.Fatalf("Got proxy: %v and error: %v for the zero config, want nil", zero, zeroErr)
+	}
+
+	invalid := []Config{
+		{URL: "http://proxy.example.org", Environment: true},
+		{URL: "http://proxy.example.org", PAC: "function FindProxyForURL(url, host) { return \"DIRECT\"; }"},
+		{URL: "ftp://proxy.example.org"},
+		{URL: "socks5://"},
+		{PAC: "function FindProxy(url, host) { return \"DIRECT\"; }"},
+	}
+	for _, config := range invalid {
+		if _, newErr := New(config); newErr == nil {
+			t.Fatalf("Got no error for invalid config: %+v", config)
+		}
+	}
+
+	cases := []struct {
+		config Config
+		url    string
+		want   string
+	}{
+		{Config{URL: "proxy.example.org:3128"}, "https://vpn.example.org/", "http://proxy.example.org:3128"},
+		{Config{URL: "socks5://127.0.0.1:1080"}, "https://vpn.example.org/", "socks5://127.0.0.1:1080"},
+		// The OAuth loopback listener is never proxied
+		{Config{URL: "http://proxy.example.org:3128"}, "http://127.0.0.1:8000/callback", ""},
+		{Config{URL: "http://proxy.example.org:3128"}, "http://[::1]:8000/callback", ""},
+		{Config{URL: "http://proxy.example.org:3128"}, "http://localhost:8000/callback", ""},
+		{
+			Config{PAC: "function FindProxyForURL(url, host) { return \"PROXY proxy.example.org:3128\"; }"},
+			"http://127.0.0.1:8000/callback",
+			"",
+		},
+	}
+	for _, c := range cases {
+		proxy, newErr := New(c.config)
+		if newErr != nil {
+			t.Fatalf("Got error for config: %+v: %v", c.config, newErr)
+		}
+		if got := proxyFor(t, proxy, c.url); got != c.want {
+			t.Fatalf("Got proxy: %s for: %s with config: %+v, want: %s", got, c.url, c.config, c.want)
+		}
+	}
+}
+
+// setEnv sets the environment variable `name` to `value` until the test finishes.
+func setEnv(t *testing.T, name string, value string) {
+	t.Helper()
+	previous, exists := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if exists {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		setEnv(t, name, "")
+	}
+	setEnv(t, "http_proxy", "http://plain.example.org:3128")
+	setEnv(t, "HTTPS_PROXY", "socks5://secure.example.org:1080")
+	setEnv(t, "no_proxy", "internal.example.org, 10.0.0.0/8, 192.0.2.1, .lan, example.com:8443")
+
+	proxy, newErr := New(Config{Environment: true})
+	if newErr != nil {
+		t.Fatalf("Got error: %v", newErr)
+	}
+	cases := map[string]string{
+		"http://vpn.example.org/":              "http://plain.example.org:3128",
+		"https://vpn.example.org/":             "socks5://secure.example.org:1080",
+		"https://internal.example.org/":        "",
+		"https://portal.internal.example.org/": "",
+		"https://10.1.2.3/":                    "",
+		"https://192.0.2.1/":                   "",
+		"https://192.0.2.2/":                   "socks5://secure.example.org:1080",
+		"https://vpn.lan/":                     "",
+		"https://example.com:8443/":            "",
+		"https://example.com/":                 "socks5://secure.example.org:1080",
+		"http://127.0.0.1:8000/callback":       "",
+	}
+	for rawURL, want := range cases {
+		if got := proxyFor(t, proxy, rawURL); got != want {
+			t.Fatalf("Got proxy: %s for: %s, want: %s", got, rawURL, want)
+		}
+	}
+
+	setEnv(t, "HTTPS_PROXY", "ftp://secure.example.org")
+	if _, newErr := New(Config{Environment: true}); newErr == nil {
+		t.Fatalf("Got no error for an unsupported proxy scheme in the environment")
+	}
+}
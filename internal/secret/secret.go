@@ -0,0 +1,208 @@
+// Package secret implements storage for secrets such as private keys
+// The secrets are kept separate from the state file such that they are not saved in plain sight with the rest of the state
+package secret
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/eduvpn/eduvpn-common/internal/util"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// Store is a store for secrets identified by a name.
+// Clients can implement this with e.g. the keyring of the operating system.
+type Store interface {
+	// Get returns the secret with `name`
+	// It returns a *NotFoundError if the secret does not exist.
+	Get(name string) (string, error)
+
+	// Set sets the secret with `name` to `value`
+	Set(name string, value string) error
+
+	// Delete deletes the secret with `name`, deleting a secret that does not exist is not an error
+	Delete(name string) error
+}
+
+// IsNotFound returns whether or not the error is because the secret does not exist.
+func IsNotFound(err error) bool {
+	var notFoundErr *NotFoundError
+	return errors.As(err, &notFoundErr)
+}
+
+// MemoryStore is a store that keeps the secrets in memory.
+// The secrets are lost when the client exits.
+type MemoryStore struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+// Get returns the secret with `name`.
+func (store *MemoryStore) Get(name string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	value, exists := store.secrets[name]
+	if !exists {
+		return "", &NotFoundError{Name: name}
+	}
+	return value, nil
+}
+
+// Set sets the secret with `name` to `value`.
+func (store *MemoryStore) Set(name string, value string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.secrets == nil {
+		store.secrets = make(map[string]string)
+	}
+	store.secrets[name] = value
+	return nil
+}
+
+// Delete deletes the secret with `name`.
+func (store *MemoryStore) Delete(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.secrets, name)
+	return nil
+}
+
+// FileStore is a store that saves the secrets as JSON in a file that is only readable by the user.
+type FileStore struct {
+	// Directory is the directory where the file is saved
+	Directory string
+
+	// Name is the name of the file excluding the .json extension
+	Name string
+
+	mu sync.Mutex
+}
+
+// NewFileStore creates a file store that saves the secrets in `directory`/`name`.json.
+func NewFileStore(directory string, name string) *FileStore {
+	return &FileStore{Directory: directory, Name: name}
+}
+
+// filename returns the filename of the store as a full path.
+func (store *FileStore) filename() string {
+	return fmt.Sprintf("%s.json", path.Join(store.Directory, store.Name))
+}
+
+// load reads all the secrets from the file, a file that does not exist has no secrets.
+func (store *FileStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	bytes, readErr := ioutil.ReadFile(store.filename())
+	if readErr != nil {
+		if os.IsNotExist(readErr) {
+			return secrets, nil
+		}
+		return nil, types.NewWrappedError("failed loading secrets", readErr)
+	}
+	jsonErr := json.Unmarshal(bytes, &secrets)
+	if jsonErr != nil {
+		return nil, types.NewWrappedError("failed loading secrets", jsonErr)
+	}
+	return secrets, nil
+}
+
+// save writes all the secrets to the file.
+func (store *FileStore) save(secrets map[string]string) error {
+	errorMessage := "failed saving secrets"
+	dirErr := util.EnsureDirectory(store.Directory)
+	if dirErr != nil {
+		return types.NewWrappedError(errorMessage, dirErr)
+	}
+	bytes, jsonErr := json.Marshal(secrets)
+	if jsonErr != nil {
+		return types.NewWrappedError(errorMessage, jsonErr)
+	}
+	writeErr := writeFileAtomic(store.filename(), bytes)
+	if writeErr != nil {
+		return types.NewWrappedError(errorMessage, writeErr)
+	}
+	return nil
+}
+
+// writeFileAtomic writes `data` to the file `filename` such that it is only readable by the user.
+// The data is written to a temporary file with mode 0600 that is renamed to `filename`.
+// This replaces an existing file with wider permissions and never leaves a partially written file behind.
+func writeFileAtomic(filename string, data []byte) error {
+	// ioutil.TempFile creates the file with mode 0600
+	temp, tempErr := ioutil.TempFile(path.Dir(filename), path.Base(filename)+".*.tmp")
+	if tempErr != nil {
+		return tempErr
+	}
+	_, writeErr := temp.Write(data)
+	if writeErr == nil {
+		writeErr = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr == nil {
+		writeErr = os.Rename(temp.Name(), filename)
+	}
+	if writeErr != nil {
+		// Removing is best effort
+		_ = os.Remove(temp.Name())
+		return writeErr
+	}
+	return nil
+}
+
+// Get returns the secret with `name`.
+func (store *FileStore) Get(name string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	secrets, loadErr := store.load()
+	if loadErr != nil {
+		return "", loadErr
+	}
+	value, exists := secrets[name]
+	if !exists {
+		return "", &NotFoundError{Name: name}
+	}
+	return value, nil
+}
+
+// Set sets the secret with `name` to `value`.
+func (store *FileStore) Set(name string, value string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	secrets, loadErr := store.load()
+	if loadErr != nil {
+		return loadErr
+	}
+	secrets[name] = value
+	return store.save(secrets)
+}
+
+// Delete deletes the secret with `name`.
+func (store *FileStore) Delete(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	secrets, loadErr := store.load()
+	if loadErr != nil {
+		return loadErr
+	}
+	if _, exists := secrets[name]; !exists {
+		return nil
+	}
+	delete(secrets, name)
+	return store.save(secrets)
+}
+
+// NotFoundError is returned when a secret does not exist.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("secret: %s not found", e.Name)
+}
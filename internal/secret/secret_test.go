@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(t.TempDir(), "secrets")
+	if _, getErr := store.Get("key"); !IsNotFound(getErr) {
+		t.Fatalf("Got error: %v, want not found", getErr)
+	}
+	if setErr := store.Set("key", "value"); setErr != nil {
+		t.Fatalf("Got set error: %v", setErr)
+	}
+	stat, statErr := os.Stat(store.filename())
+	if statErr != nil {
+		t.Fatalf("Got stat error: %v", statErr)
+	}
+	if stat.Mode().Perm() != 0o600 {
+		t.Fatalf("Got permissions: %v, want: 0600", stat.Mode().Perm())
+	}
+
+	// A new store for the same file has the saved secrets
+	store = NewFileStore(store.Directory, store.Name)
+	if value, getErr := store.Get("key"); getErr != nil || value != "value" {
+		t.Fatalf("Got value: %s, error: %v, want: value", value, getErr)
+	}
+	if deleteErr := store.Delete("key"); deleteErr != nil {
+		t.Fatalf("Got delete error: %v", deleteErr)
+	}
+	if _, getErr := store.Get("key"); !IsNotFound(getErr) {
+		t.Fatalf("Got error: %v, want not found after delete", getErr)
+	}
+}
+
+func TestFileStorePermissions(t *testing.T) {
+	store := NewFileStore(t.TempDir(), "secrets")
+
+	// An existing file that is readable by others is replaced by a file that only the user can read
+	if writeErr := ioutil.WriteFile(store.filename(), []byte("{}"), 0o644); writeErr != nil {
+		t.Fatalf("Got write error: %v", writeErr)
+	}
+	if setErr := store.Set("key", "value"); setErr != nil {
+		t.Fatalf("Got set error: %v", setErr)
+	}
+	stat, statErr := os.Stat(store.filename())
+	if statErr != nil {
+		t.Fatalf("Got stat error: %v", statErr)
+	}
+	if stat.Mode().Perm() != 0o600 {
+		t.Fatalf("Got permissions: %v, want: 0600", stat.Mode().Perm())
+	}
+
+	// No temporary files are left behind
+	files, readErr := ioutil.ReadDir(store.Directory)
+	if readErr != nil {
+		t.Fatalf("Got read error: %v", readErr)
+	}
+	if len(files) != 1 || files[0].Name() != "secrets.json" {
+		t.Fatalf("Got files: %v, want only secrets.json", files)
+	}
+}
@@ -7,23 +7,96 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 
 	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/util"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
-func APIGetEndpoints(baseURL string) (*Endpoints, error) {
-	errorMessage := "failed getting server endpoints"
+// wellKnownPath is the path of the well-known document with the endpoints of a server.
+const wellKnownPath = "/.well-known/vpn-user-portal"
+
+// MovedError is returned when the well-known document of a server was permanently redirected to a new base URL and the redirect was followed.
+type MovedError struct {
+	// URL is the new base URL of the server
+	URL string
+}
+
+func (e *MovedError) Error() string {
+	return fmt.Sprintf("the server moved to: %s", e.URL)
+}
+
+// movedBase returns the new base URL of a server if its well-known document `from` was permanently redirected to `to`.
+func movedBase(from string, to string) (string, bool) {
+	if !strings.HasSuffix(from, wellKnownPath) || !strings.HasSuffix(to, wellKnownPath) {
+		return "", false
+	}
+	movedURL, urlErr := util.EnsureValidURL(strings.TrimSuffix(to, wellKnownPath))
+	if urlErr != nil {
+		return "", false
+	}
+	return movedURL, true
+}
+
+// MovedURL returns the new base URL of a server if `err`, an error of getting or refreshing its endpoints, is a move of the server.
+// A move is a permanent redirect of the well-known document, either to another origin such that the HTTP client refused it or one that was followed, see RefreshEndpoints.
+// The server has to be migrated to the new URL.
+func MovedURL(err error) (string, bool) {
+	var movedErr *MovedError
+	if errors.As(err, &movedErr) {
+		return movedErr.URL, true
+	}
+	var redirectErr *httpw.RedirectError
+	if !errors.As(err, &redirectErr) {
+		return "", false
+	}
+	if redirectErr.Status != http.StatusMovedPermanently && redirectErr.Status != http.StatusPermanentRedirect {
+		return "", false
+	}
+	return movedBase(redirectErr.From, redirectErr.URL)
+}
+
+// wellKnownURL returns the URL of the well-known document of the server with `baseURL`.
+func wellKnownURL(baseURL string) (string, error) {
 	url, urlErr := url.Parse(baseURL)
+	if urlErr != nil {
+		return "", urlErr
+	}
+	url.Path = path.Join(url.Path, wellKnownPath)
+	return url.String(), nil
+}
+
+// movedEndpoints returns the new base URL of the server with `baseURL` if the last request of `client` for its well-known document was permanently redirected to another base URL.
+func movedEndpoints(client *httpw.Client, baseURL string) (string, bool) {
+	wellKnown, urlErr := wellKnownURL(baseURL)
+	if urlErr != nil {
+		return "", false
+	}
+	redirected, ok := client.Redirected(wellKnown)
+	if !ok {
+		return "", false
+	}
+	movedURL, moved := movedBase(wellKnown, redirected)
+	return movedURL, moved && movedURL != baseURL
+}
+
+func APIGetEndpoints(client *httpw.Client, baseURL string) (*Endpoints, error) {
+	errorMessage := "failed getting server endpoints"
+	wellKnown, urlErr := wellKnownURL(baseURL)
 	if urlErr != nil {
 		return nil, types.NewWrappedError(errorMessage, urlErr)
 	}
 
-	wellKnownPath := "/.well-known/vpn-user-portal"
+	// The well-known document and the endpoints in it must be fetched securely, a downgrade is refused by the redirect policy of the client
+	secureErr := client.CheckSecure(baseURL)
+	if secureErr != nil {
+		return nil, types.NewWrappedError(errorMessage, secureErr)
+	}
 
-	url.Path = path.Join(url.Path, wellKnownPath)
-	_, body, bodyErr := httpw.Get(url.String())
+	// The endpoints rarely change so a cached copy is validated instead of fetched every time
+	_, body, bodyErr := client.GetCached(wellKnown)
 
 	if bodyErr != nil {
 		return nil, types.NewWrappedError(errorMessage, bodyErr)
@@ -36,6 +109,20 @@ func APIGetEndpoints(baseURL string) (*Endpoints, error) {
 		return nil, types.NewWrappedError(errorMessage, jsonErr)
 	}
 
+	// The tokens are sent to the API and token endpoints, so these must be on the origin of the server
+	// The authorization endpoint is opened in the browser and only has to use HTTPS
+	v3 := endpoints.API.V3
+	for _, endpoint := range []string{v3.API, v3.Token} {
+		originErr := client.CheckOrigin(baseURL, endpoint)
+		if originErr != nil {
+			return nil, types.NewWrappedError(errorMessage, originErr)
+		}
+	}
+	authorizationErr := client.CheckSecure(v3.Authorization)
+	if authorizationErr != nil {
+		return nil, types.NewWrappedError(errorMessage, authorizationErr)
+	}
+
 	return endpoints, nil
 }
 
@@ -76,7 +163,7 @@ func apiAuthorized(
 	} else {
 		opts.Headers = http.Header{headerKey: {headerValue}}
 	}
-	return httpw.MethodWithOpts(method, url.String(), opts)
+	return server.OAuth().HTTPClient.MethodWithOpts(method, url.String(), opts)
 }
 
 func apiAuthorizedRetry(
@@ -177,9 +264,7 @@ func APIConnectWireguard(
 		)
 	}
 
-	expires := header.Get("expires")
-
-	pTime, pTimeErr := http.ParseTime(expires)
+	pTime, pTimeErr := parseExpires(header)
 	if pTimeErr != nil {
 		return "", "", time.Time{}, types.NewWrappedError(errorMessage, pTimeErr)
 	}
@@ -193,6 +278,16 @@ func APIConnectWireguard(
 	return string(connectBody), content, pTime, nil
 }
 
+// parseExpires parses the Expires header of a /connect response.
+// It returns the zero time if the header is missing, the end time of the session is then the expiry time of the certificate.
+func parseExpires(header http.Header) (time.Time, error) {
+	expires := header.Get("expires")
+	if expires == "" {
+		return time.Time{}, nil
+	}
+	return http.ParseTime(expires)
+}
+
 func APIConnectOpenVPN(server Server, profileID string, preferTCP bool) (string, time.Time, error) {
 	errorMessage := "failed obtaining an OpenVPN configuration"
 	headers := http.Header{
@@ -215,8 +310,7 @@ func APIConnectOpenVPN(server Server, profileID string, preferTCP bool) (string,
 		return "", time.Time{}, types.NewWrappedError(errorMessage, connectErr)
 	}
 
-	expires := header.Get("expires")
-	pTime, pTimeErr := http.ParseTime(expires)
+	pTime, pTimeErr := parseExpires(header)
 	if pTimeErr != nil {
 		return "", time.Time{}, types.NewWrappedError(errorMessage, pTimeErr)
 	}
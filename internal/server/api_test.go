@@ -0,0 +1,165 @@
+package server_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/test"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+func TestAPIGetEndpointsOrigin(t *testing.T) {
+	other := test.NewPortal(openVPNProfile)
+	defer other.Close()
+
+	// The API and token endpoints on another origin are refused
+	portal := test.NewPortal(openVPNProfile)
+	defer portal.Close()
+	portal.EndpointsURL = other.URL()
+	_, endpointsErr := server.APIGetEndpoints(httpw.NewClient(httpw.Options{}), portal.URL())
+	if code := types.ErrorCode(endpointsErr); code != types.ErrCodeEndpointNotAllowed {
+		t.Fatalf("Got error: %v with code: %q, want: %q", endpointsErr, code, types.ErrCodeEndpointNotAllowed)
+	}
+
+	// Unless the origin is allowed
+	allowed := httpw.NewClient(httpw.Options{AllowedOrigins: []string{strings.TrimSuffix(other.URL(), "/")}})
+	endpoints, endpointsErr := server.APIGetEndpoints(allowed, portal.URL())
+	if endpointsErr != nil {
+		t.Fatalf("Got error: %v for an allowed origin", endpointsErr)
+	}
+	if endpoints.API.V3.Token != other.URL()+"oauth/token" {
+		t.Fatalf("Got token endpoint: %s, want the one on the allowed origin", endpoints.API.V3.Token)
+	}
+	if len(other.Requests()) != 0 {
+		t.Fatalf("Got requests on the other origin: %v, want none", other.Requests())
+	}
+}
+
+func TestAPIGetEndpointsMoved(t *testing.T) {
+	portal := test.NewPortal(openVPNProfile)
+	defer portal.Close()
+	movedURL := portal.Move()
+
+	// A permanent redirect to another origin is refused and is a move of the server
+	_, endpointsErr := server.APIGetEndpoints(httpw.NewClient(httpw.Options{}), portal.URL())
+	if code := types.ErrorCode(endpointsErr); code != types.ErrCodeEndpointNotAllowed {
+		t.Fatalf("Got error: %v with code: %q, want: %q", endpointsErr, code, types.ErrCodeEndpointNotAllowed)
+	}
+	if gotURL, moved := server.MovedURL(endpointsErr); !moved || gotURL != movedURL {
+		t.Fatalf("Got moved URL: %s, moved: %v, want: %s", gotURL, moved, movedURL)
+	}
+
+	// Other errors are not a move
+	if _, moved := server.MovedURL(types.NewWrappedError("failed", http.ErrHandlerTimeout)); moved {
+		t.Fatalf("Got a move for an error that is not a redirect")
+	}
+}
+
+func TestRefreshEndpointsMoved(t *testing.T) {
+	refresh := func(srv server.Server, wantURL string) {
+		t.Helper()
+		refreshErr := server.RefreshEndpoints(srv, nil)
+		// The redirect is followed instead of refused
+		var movedErr *server.MovedError
+		if !errors.As(refreshErr, &movedErr) {
+			t.Fatalf("Got error: %v, want a moved error", refreshErr)
+		}
+		if gotURL, moved := server.MovedURL(refreshErr); !moved || gotURL != wantURL {
+			t.Fatalf("Got moved URL: %s, moved: %v for error: %v, want: %s", gotURL, moved, refreshErr, wantURL)
+		}
+	}
+
+	// A permanent redirect on the same origin is followed and is a move of the server
+	portal := test.NewPortal(openVPNProfile)
+	defer portal.Close()
+	srv := addServer(t, &server.Servers{}, portal)
+	if refreshErr := server.RefreshEndpoints(srv, nil); refreshErr != nil {
+		t.Fatalf("Got refresh error: %v for a server that did not move", refreshErr)
+	}
+	refresh(srv, portal.MovePath("vpn-user-portal"))
+
+	// As is a permanent redirect to an allowed origin
+	other := test.NewPortal(openVPNProfile)
+	defer other.Close()
+	servers := &server.Servers{}
+	otherServer := addServer(t, servers, other)
+	movedURL := other.Move()
+	servers.SetHTTPClient(httpw.NewClient(httpw.Options{AllowedOrigins: []string{strings.TrimSuffix(movedURL, "/")}}))
+	refresh(otherServer, movedURL)
+}
+
+func TestAPIGetEndpointsCached(t *testing.T) {
+	portal := test.NewPortal(openVPNProfile)
+	defer portal.Close()
+
+	client := httpw.NewClient(httpw.Options{
+		Cache: httpw.NewCache(t.TempDir()),
+		Retry: httpw.RetryPolicy{IdempotentAttempts: 1},
+	})
+	get := func() {
+		t.Helper()
+		if _, endpointsErr := server.APIGetEndpoints(client, portal.URL()); endpointsErr != nil {
+			t.Fatalf("Got error: %v", endpointsErr)
+		}
+	}
+
+	// The well-known endpoints are validated with the ETag instead of fetched again
+	get()
+	get()
+	if portal.NotModified() != 1 {
+		t.Fatalf("Got %d conditional requests for the well-known endpoints, want: 1", portal.NotModified())
+	}
+
+	// The cached endpoints are used when the well-known endpoint fails
+	portal.Fail("/.well-known/vpn-user-portal", http.StatusServiceUnavailable, "")
+	get()
+}
+
+func TestMigrate(t *testing.T) {
+	portal := test.NewPortal(openVPNProfile, wireGuardProfile)
+	defer portal.Close()
+
+	servers := &server.Servers{}
+	srv := addServer(t, servers, portal)
+	if _, profileErr := server.HasValidProfile(srv, true, nil); profileErr != nil {
+		t.Fatalf("Got profile error: %v", profileErr)
+	}
+	base, _ := srv.Base()
+	base.Profiles.Current = wireGuardProfile.ID
+
+	// The server is moved with its tokens and its profile choice
+	movedURL := portal.Move()
+	if migrateErr := servers.Migrate(srv, movedURL, nil); migrateErr != nil {
+		t.Fatalf("Got migrate error: %v", migrateErr)
+	}
+	if _, ok := servers.CustomServers.Map[portal.URL()]; ok {
+		t.Fatalf("Got the server with the old URL: %s after the migration", portal.URL())
+	}
+	migrated, ok := servers.CustomServers.Map[movedURL]
+	if !ok {
+		t.Fatalf("Got no server with the new URL: %s after the migration", movedURL)
+	}
+	base, _ = migrated.Base()
+	if base.URL != movedURL || base.Endpoints.API.V3.Token != movedURL+"oauth/token" || base.Profiles.Current != wireGuardProfile.ID {
+		t.Fatalf("Got server: %s with token endpoint: %s and profile: %s, want the new URL: %s", base.URL, base.Endpoints.API.V3.Token, base.Profiles.Current, movedURL)
+	}
+	before := len(portal.Requests())
+	if _, _, _, configErr := server.Config(migrated, true, false, nil, nil); configErr != nil {
+		t.Fatalf("Got config error after the migration: %v", configErr)
+	}
+	if requests := portal.Requests()[before:]; len(requests) != 1 || requests[0] != "POST /api/v3/connect" {
+		t.Fatalf("Got requests: %v, want a /connect without authorizing again", requests)
+	}
+
+	// A server cannot be moved to the URL of another server
+	other := test.NewPortal(openVPNProfile)
+	defer other.Close()
+	otherServer := addServer(t, servers, other)
+	if migrateErr := servers.Migrate(otherServer, movedURL, nil); migrateErr == nil {
+		t.Fatalf("Got no error for a migration to the URL of another server")
+	}
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"time"
 
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
@@ -15,12 +17,18 @@ type Base struct {
 	Profiles       ProfileInfo       `json:"profiles"`
 	StartTime      time.Time         `json:"start_time"`
 	EndTime        time.Time         `json:"expire_time"`
+	Certificate    *CertificateInfo  `json:"certificate,omitempty"`
 	Type           string            `json:"server_type"`
+
+	// Pins are the pinned public keys of the TLS certificate of the server, see httpw.SPKIPin
+	// They are set by managed configuration or learned on first use, any of them must match such that backup pins allow rotating keys
+	Pins []string `json:"pins,omitempty"`
 }
 
-func (base *Base) InitializeEndpoints() error {
+func (base *Base) InitializeEndpoints(client *httpw.Client, tracker *progress.Tracker) error {
 	errorMessage := "failed initializing endpoints"
-	endpoints, endpointsErr := APIGetEndpoints(base.URL)
+	tracker.Step(progress.StepEndpoints)
+	endpoints, endpointsErr := APIGetEndpoints(client, base.URL)
 	if endpointsErr != nil {
 		return types.NewWrappedError(errorMessage, endpointsErr)
 	}
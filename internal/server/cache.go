@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/secret"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// CachePolicy defines if and when obtained configurations are reused instead of doing a new /connect.
+type CachePolicy struct {
+	// Enabled indicates that the configuration for a server and profile is cached and reused until the session expires
+	Enabled bool
+
+	// MinRemaining is the minimum time the session of a cached configuration must still be valid to reuse it
+	// Zero means that it is reused until the session expires
+	MinRemaining time.Duration
+}
+
+// CachedConfig is a configuration that was obtained with /connect together with its session.
+type CachedConfig struct {
+	// Config is the configuration as returned by Config
+	Config string `json:"config"`
+
+	// Protocol is the protocol of the configuration, "openvpn" or "wireguard"
+	Protocol string `json:"protocol"`
+
+	// StartTime is the time the configuration was obtained
+	StartTime time.Time `json:"start_time"`
+
+	// EndTime is the time the session expires on the server, the cached configuration cannot be used afterwards
+	EndTime time.Time `json:"expire_time"`
+
+	// Certificate is the client certificate of an OpenVPN configuration
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+}
+
+// CacheKey identifies the /connect that a configuration was obtained with.
+// A configuration is only reused for the same profile and the same protocols that were asked for,
+// e.g. a WireGuard configuration is not reused when TCP is preferred.
+type CacheKey struct {
+	// ProfileID is the ID of the profile
+	ProfileID string
+
+	// SupportsWireGuard indicates that the client supports WireGuard
+	SupportsWireGuard bool
+
+	// PreferTCP indicates that the client prefers TCP
+	PreferTCP bool
+}
+
+// String returns the key as it is saved in the secret store.
+func (key CacheKey) String() string {
+	return fmt.Sprintf("%s wireguard=%t tcp=%t", key.ProfileID, key.SupportsWireGuard, key.PreferTCP)
+}
+
+// ConfigCache keeps the last obtained configuration for each server in a secret store.
+// A nil ConfigCache or a cache with a policy that is not enabled never returns a configuration.
+type ConfigCache struct {
+	// Secrets is the store where the configurations are saved, these contain private keys
+	Secrets secret.Store
+
+	// Policy is the policy for reusing the configurations
+	Policy CachePolicy
+}
+
+// cacheSecretName returns the name of the secret that has the configurations for the server with `serverURL`.
+func cacheSecretName(serverURL string) string {
+	return "config-cache:" + serverURL
+}
+
+// enabled returns whether or not configurations are cached.
+func (cache *ConfigCache) enabled() bool {
+	return cache != nil && cache.Secrets != nil && cache.Policy.Enabled
+}
+
+// load loads the configurations for each key of the server with `serverURL`.
+func (cache *ConfigCache) load(serverURL string) (map[string]CachedConfig, error) {
+	configs := make(map[string]CachedConfig)
+	value, getErr := cache.Secrets.Get(cacheSecretName(serverURL))
+	if getErr != nil {
+		if secret.IsNotFound(getErr) {
+			return configs, nil
+		}
+		return nil, getErr
+	}
+	jsonErr := json.Unmarshal([]byte(value), &configs)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	return configs, nil
+}
+
+// Get returns the cached configuration for the server with `serverURL` that was obtained with `key`.
+// The boolean is false if there is no configuration whose session is still valid for the minimum remaining time.
+func (cache *ConfigCache) Get(serverURL string, key CacheKey) (*CachedConfig, bool, error) {
+	if !cache.enabled() || key.ProfileID == "" {
+		return nil, false, nil
+	}
+	configs, loadErr := cache.load(serverURL)
+	if loadErr != nil {
+		return nil, false, types.NewWrappedError("failed getting cached config", loadErr)
+	}
+	cached, exists := configs[key.String()]
+	if !exists || !time.Now().Add(cache.Policy.MinRemaining).Before(cached.EndTime) {
+		return nil, false, nil
+	}
+	return &cached, true, nil
+}
+
+// Set caches the configuration `config` for the server with `serverURL` that was obtained with `key`.
+// The other configurations are removed, as the server only keeps the session of the last /connect.
+func (cache *ConfigCache) Set(serverURL string, key CacheKey, config CachedConfig) error {
+	if !cache.enabled() {
+		return nil
+	}
+	value, jsonErr := json.Marshal(map[string]CachedConfig{key.String(): config})
+	if jsonErr != nil {
+		return types.NewWrappedError("failed caching config", jsonErr)
+	}
+	setErr := cache.Secrets.Set(cacheSecretName(serverURL), string(value))
+	if setErr != nil {
+		return types.NewWrappedError("failed caching config", setErr)
+	}
+	return nil
+}
+
+// Remove removes the cached configurations for the server with `serverURL`.
+// This is done when the session is gone on the server, e.g. after a /disconnect or when the server is removed.
+// It also removes the cached configurations if caching is disabled such that they are not kept around.
+func (cache *ConfigCache) Remove(serverURL string) error {
+	if cache == nil || cache.Secrets == nil {
+		return nil
+	}
+	deleteErr := cache.Secrets.Delete(cacheSecretName(serverURL))
+	if deleteErr != nil {
+		return types.NewWrappedError("failed removing cached configs", deleteErr)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/secret"
+)
+
+func TestConfigCache(t *testing.T) {
+	const serverURL = "https://vpn.example.org/"
+	store := &secret.MemoryStore{}
+	cache := &ConfigCache{Secrets: store, Policy: CachePolicy{Enabled: true}}
+	key := CacheKey{ProfileID: "internet", SupportsWireGuard: true}
+	config := CachedConfig{
+		Config:    "[Interface]\n",
+		Protocol:  "wireguard",
+		StartTime: time.Now(),
+		EndTime:   time.Now().Add(time.Hour),
+	}
+	get := func(key CacheKey) bool {
+		t.Helper()
+		cached, ok, getErr := cache.Get(serverURL, key)
+		if getErr != nil {
+			t.Fatalf("Got error: %v", getErr)
+		}
+		if ok && cached.Config != config.Config {
+			t.Fatalf("Got config: %s, want: %s", cached.Config, config.Config)
+		}
+		return ok
+	}
+
+	if get(key) {
+		t.Fatalf("Got a config from an empty cache")
+	}
+	if setErr := cache.Set(serverURL, key, config); setErr != nil {
+		t.Fatalf("Got set error: %v", setErr)
+	}
+	if !get(key) {
+		t.Fatalf("Got no config for the key it was cached with")
+	}
+
+	// The config is only reused for the same profile and protocols
+	for _, other := range []CacheKey{
+		{ProfileID: "employees", SupportsWireGuard: true},
+		{ProfileID: "internet", SupportsWireGuard: true, PreferTCP: true},
+		{ProfileID: "internet"},
+		{},
+	} {
+		if get(other) {
+			t.Fatalf("Got a config for: %+v, want none", other)
+		}
+	}
+
+	// A session that is not valid for the minimum remaining time is not reused
+	cache.Policy.MinRemaining = 2 * time.Hour
+	if get(key) {
+		t.Fatalf("Got a config that expires within the minimum remaining time")
+	}
+	cache.Policy.MinRemaining = 0
+
+	// Only the config of the last /connect is kept
+	otherKey := CacheKey{ProfileID: "internet", SupportsWireGuard: true, PreferTCP: true}
+	if setErr := cache.Set(serverURL, otherKey, config); setErr != nil {
+		t.Fatalf("Got set error: %v", setErr)
+	}
+	if get(key) || !get(otherKey) {
+		t.Fatalf("Got the config of a previous /connect")
+	}
+
+	// Nothing is returned when caching is disabled, removing still works
+	cache.Policy.Enabled = false
+	if get(otherKey) {
+		t.Fatalf("Got a config with caching disabled")
+	}
+	if removeErr := cache.Remove(serverURL); removeErr != nil {
+		t.Fatalf("Got remove error: %v", removeErr)
+	}
+	if _, getErr := store.Get(cacheSecretName(serverURL)); !secret.IsNotFound(getErr) {
+		t.Fatalf("Got error: %v, want the cached configs to be removed", getErr)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// CertificateInfo is the subject and validity of the client certificate of an OpenVPN configuration.
+type CertificateInfo struct {
+	// Subject is the subject of the certificate, e.g. "CN=4b2e7c0a9d3f"
+	Subject string `json:"subject"`
+
+	// NotBefore is the time from which the certificate is valid
+	NotBefore time.Time `json:"not_before"`
+
+	// NotAfter is the time the certificate expires
+	NotAfter time.Time `json:"not_after"`
+}
+
+// openVPNCertificate returns the info of the client certificate in the OpenVPN configuration `config`.
+// It returns nil without an error if the configuration has no inline certificate.
+func openVPNCertificate(config string) (*CertificateInfo, error) {
+	parsed, parseErr := openvpn.Parse(config)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	certificate, certificateErr := parsed.Certificate()
+	if certificate == nil || certificateErr != nil {
+		return nil, certificateErr
+	}
+	return &CertificateInfo{
+		Subject:   certificate.Subject.String(),
+		NotBefore: certificate.NotBefore,
+		NotAfter:  certificate.NotAfter,
+	}, nil
+}
+
+// setSessionTimes sets the start and end time of the session for the obtained `config` with content type `content`.
+// For OpenVPN the end time is the earlier of the `expires` time of the server and the expiry time of the client certificate,
+// as OpenVPN cannot connect anymore once the certificate has expired.
+// If the server returned no `expires` time, the expiry time of the certificate is used.
+func setSessionTimes(base *Base, config string, content string, expires time.Time) error {
+	var certificate *CertificateInfo
+	if content == "openvpn" {
+		var certificateErr error
+		certificate, certificateErr = openVPNCertificate(config)
+		if certificateErr != nil {
+			return types.NewWrappedError("failed setting the session times", certificateErr)
+		}
+	}
+
+	base.StartTime = time.Now()
+	base.EndTime = expires
+	base.Certificate = certificate
+	if certificate != nil && (expires.IsZero() || certificate.NotAfter.Before(expires)) {
+		base.EndTime = certificate.NotAfter
+	}
+	return nil
+}
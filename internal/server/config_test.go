@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
+	"github.com/eduvpn/eduvpn-common/internal/secret"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/test"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+const testClientID = "org.letsconnect-vpn.app.linux"
+
+var (
+	openVPNProfile = server.Profile{
+		ID:           "employees",
+		DisplayName:  "Employees",
+		VPNProtoList: []string{"openvpn"},
+	}
+	wireGuardProfile = server.Profile{
+		ID:             "internet",
+		DisplayName:    "Internet",
+		VPNProtoList:   []string{"openvpn", "wireguard"},
+		DefaultGateway: true,
+	}
+)
+
+// addServer adds `portal` as a custom server to `servers` and authorizes it.
+func addServer(t *testing.T, servers *server.Servers, portal *test.Portal) server.Server {
+	t.Helper()
+	srv, addErr := servers.AddCustomServer(&types.DiscoveryServer{BaseURL: portal.URL(), Type: "custom_server"}, nil)
+	if addErr != nil {
+		t.Fatalf("Got add error: %v", addErr)
+	}
+	if authorizeErr := portal.Authorize(srv, testClientID); authorizeErr != nil {
+		t.Fatalf("Got authorize error: %v", authorizeErr)
+	}
+	return srv
+}
+
+// getConfig gets a config for the first profile of `srv` with `keys`, reporting the steps to `tracker`.
+func getConfig(srv server.Server, keys *wireguard.KeyStore, tracker *progress.Tracker) (string, *wireguard.Config, error) {
+	if _, profileErr := server.HasValidProfile(srv, true, tracker); profileErr != nil {
+		return "", nil, profileErr
+	}
+	config, _, parsed, configErr := server.Config(srv, true, false, keys, tracker)
+	return config, parsed, configErr
+}
+
+func TestConfigProgress(t *testing.T) {
+	portal := test.NewPortal(wireGuardProfile)
+	defer portal.Close()
+
+	var steps []progress.Step
+	tracker := progress.New(func(event progress.Event) {
+		if event.URL != portal.URL() {
+			t.Errorf("Got event for: %s, want: %s", event.URL, portal.URL())
+		}
+		steps = append(steps, event.Step)
+	}, portal.URL())
+
+	servers := &server.Servers{}
+	srv := addServer(t, servers, portal)
+	if _, _, configErr := getConfig(srv, nil, tracker); configErr != nil {
+		t.Fatalf("Got config error: %v", configErr)
+	}
+	want := []progress.Step{progress.StepServerInfo, progress.StepWireGuardKey, progress.StepConnect}
+	if len(steps) != len(want) {
+		t.Fatalf("Got steps: %v, want: %v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Fatalf("Got steps: %v, want: %v", steps, want)
+		}
+	}
+}
+
+func TestConfigWireGuardKeys(t *testing.T) {
+	portal := test.NewPortal(wireGuardProfile)
+	defer portal.Close()
+
+	srv := addServer(t, &server.Servers{}, portal)
+	keys := &wireguard.KeyStore{Secrets: &secret.MemoryStore{}, Policy: wireguard.KeyPolicy{Reuse: true}}
+	getKey := func() string {
+		_, parsed, configErr := getConfig(srv, keys, nil)
+		if configErr != nil {
+			t.Fatalf("Got config error: %v", configErr)
+		}
+		return parsed.Interface.PrivateKey.String()
+	}
+
+	first := getKey()
+	if second := getKey(); second != first {
+		t.Fatalf("Got a new key: %s, want the reused key: %s", second, first)
+	}
+
+	// Another bad request keeps the key
+	portal.Reject("/api/v3/connect", http.StatusBadRequest, "profile not available")
+	if _, _, configErr := getConfig(srv, keys, nil); configErr == nil {
+		t.Fatalf("Got no config error for a rejected profile")
+	}
+	if second := getKey(); second != first {
+		t.Fatalf("Got a new key: %s after a bad request, want the reused key: %s", second, first)
+	}
+
+	// A rejected key is rotated
+	publicKeys := portal.PublicKeys()
+	portal.RejectPublicKey(publicKeys[len(publicKeys)-1])
+	third := getKey()
+	if third == first {
+		t.Fatalf("Got the rejected key: %s, want a new key", third)
+	}
+	if fourth := getKey(); fourth != third {
+		t.Fatalf("Got a new key: %s, want the rotated key: %s", fourth, third)
+	}
+}
+
+func TestConfigCertificate(t *testing.T) {
+	portal := test.NewPortal(openVPNProfile)
+	defer portal.Close()
+	portal.SessionExpiry = 24 * time.Hour
+	portal.CertificateExpiry = time.Hour
+
+	srv := addServer(t, &server.Servers{}, portal)
+	if _, _, configErr := getConfig(srv, nil, nil); configErr != nil {
+		t.Fatalf("Got config error: %v", configErr)
+	}
+	base, baseErr := srv.Base()
+	if baseErr != nil {
+		t.Fatalf("Got base error: %v", baseErr)
+	}
+	if base.Certificate == nil || !strings.HasPrefix(base.Certificate.Subject, "CN=") {
+		t.Fatalf("Got certificate: %v, want a certificate with a subject", base.Certificate)
+	}
+	// The certificate expires before the session on the server, so the session ends when the certificate expires
+	if !base.EndTime.Equal(base.Certificate.NotAfter) {
+		t.Fatalf("Got end time: %v, want the certificate expiry: %v", base.EndTime, base.Certificate.NotAfter)
+	}
+	// Without an expiry time of the server, the session ends when the certificate expires
+	portal.SessionExpiry = 0
+	if _, _, configErr := getConfig(srv, nil, nil); configErr != nil {
+		t.Fatalf("Got config error: %v", configErr)
+	}
+	if base.EndTime.IsZero() || !base.EndTime.Equal(base.Certificate.NotAfter) {
+		t.Fatalf("Got end time: %v without an expiry time of the server, want the certificate expiry: %v", base.EndTime, base.Certificate.NotAfter)
+	}
+	// Less than a day is left and the session is short, the renew button is shown after 75% of the hour
+	if server.ShouldRenewButton(srv) {
+		t.Fatalf("Got the renew button right after obtaining the config")
+	}
+
+	// A failed request does not change the session times
+	endTime := base.EndTime
+	portal.CertificateExpiry = 0
+	portal.OpenVPNDirectives = "<cert>\nMIIB\n</cert>\n"
+	if _, _, configErr := getConfig(srv, nil, nil); configErr == nil {
+		t.Fatalf("Got no error for a config with an invalid certificate")
+	}
+	if !base.EndTime.Equal(endTime) {
+		t.Fatalf("Got end time: %v after a failed request, want: %v", base.EndTime, endTime)
+	}
+}
+
+func TestConfigAPIError(t *testing.T) {
+	portal := test.NewPortal(openVPNProfile)
+	defer portal.Close()
+
+	srv := addServer(t, &server.Servers{HTTPClient: httpw.NewClient(httpw.Options{})}, portal)
+
+	// The code of the server error is kept, the codes of the messages are tested in the http package
+	message := "account is disabled"
+	portal.Reject("/api/v3/connect", http.StatusForbidden, message)
+	_, _, configErr := getConfig(srv, nil, nil)
+	if code := types.ErrorCode(configErr); code != types.ErrCodeAccountDisabled {
+		t.Fatalf("Got code: %s for error: %v, want: %s", code, configErr, types.ErrCodeAccountDisabled)
+	}
+	// The user sees the message of the server instead of the raw body
+	if cause := types.ErrorCause(configErr).Error(); !strings.HasSuffix(cause, message) {
+		t.Fatalf("Got cause: %s, want the message of the server: %s", cause, message)
+	}
+}
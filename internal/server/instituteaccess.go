@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/eduvpn/eduvpn-common/internal/oauth"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
@@ -66,6 +67,29 @@ func (servers *InstituteAccessServers) Remove(url string) {
 	delete(servers.Map, url)
 }
 
+// Migrate moves the server with the base URL `from` to the base URL `to` with `endpoints`, e.g. because the portal moved to a new host name.
+// The tokens and the profiles of the server are kept.
+func (servers *InstituteAccessServers) Migrate(from string, to string, endpoints Endpoints) error {
+	errorMessage := fmt.Sprintf("failed migrating server %s to %s", from, to)
+	server, ok := servers.Map[from]
+	if !ok {
+		return types.NewWrappedError(errorMessage, fmt.Errorf("no server with URL: %s", from))
+	}
+	if _, exists := servers.Map[to]; exists {
+		return types.NewWrappedError(errorMessage, fmt.Errorf("a server with URL: %s already exists", to))
+	}
+	delete(servers.Map, from)
+	servers.Map[to] = server
+	if servers.CurrentURL == from {
+		servers.CurrentURL = to
+	}
+	server.Basic.URL = to
+	server.Basic.Endpoints = endpoints
+	API := endpoints.API.V3
+	server.Auth.Init(to, API.Authorization, API.Token)
+	return nil
+}
+
 func (institute *InstituteAccessServer) TemplateAuth() func(string) string {
 	return func(authURL string) string {
 		return authURL
@@ -85,13 +109,14 @@ func (institute *InstituteAccessServer) init(
 	displayName map[string]string,
 	serverType string,
 	supportContact []string,
+	tracker *progress.Tracker,
 ) error {
 	errorMessage := fmt.Sprintf("failed initializing server %s", url)
 	institute.Basic.URL = url
 	institute.Basic.DisplayName = displayName
 	institute.Basic.SupportContact = supportContact
 	institute.Basic.Type = serverType
-	endpointsErr := institute.Basic.InitializeEndpoints()
+	endpointsErr := institute.Basic.InitializeEndpoints(institute.Auth.HTTPClient, tracker)
 	if endpointsErr != nil {
 		return types.NewWrappedError(errorMessage, endpointsErr)
 	}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/eduvpn/eduvpn-common/internal/oauth"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/internal/util"
 	"github.com/eduvpn/eduvpn-common/types"
 )
@@ -94,6 +95,7 @@ func (servers *Servers) HasSecureLocation() bool {
 
 func (server *SecureInternetHomeServer) addLocation(
 	locationServer *types.DiscoveryServer,
+	tracker *progress.Tracker,
 ) (*Base, error) {
 	errorMessage := "failed adding a location"
 	// Initialize the base map if it is non-nil
@@ -111,7 +113,7 @@ func (server *SecureInternetHomeServer) addLocation(
 		base.DisplayName = server.DisplayName
 		base.SupportContact = locationServer.SupportContact
 		base.Type = "secure_internet"
-		endpointsErr := base.InitializeEndpoints()
+		endpointsErr := base.InitializeEndpoints(server.Auth.HTTPClient, tracker)
 		if endpointsErr != nil {
 			return nil, types.NewWrappedError(errorMessage, endpointsErr)
 		}
@@ -126,12 +128,13 @@ func (server *SecureInternetHomeServer) addLocation(
 func (server *SecureInternetHomeServer) init(
 	homeOrg *types.DiscoveryOrganization,
 	homeLocation *types.DiscoveryServer,
+	tracker *progress.Tracker,
 ) error {
 	errorMessage := "failed initializing secure internet home server"
 
 	if server.HomeOrganizationID != homeOrg.OrgID {
-		// New home organisation, clear everything
-		*server = SecureInternetHomeServer{}
+		// New home organisation, clear everything except the HTTP client
+		*server = SecureInternetHomeServer{Auth: oauth.OAuth{HTTPClient: server.Auth.HTTPClient}}
 	}
 
 	// Make sure to set the organization ID
@@ -141,7 +144,7 @@ func (server *SecureInternetHomeServer) init(
 	// Make sure to set the authorization URL template
 	server.AuthorizationTemplate = homeLocation.AuthenticationURLTemplate
 
-	base, baseErr := server.addLocation(homeLocation)
+	base, baseErr := server.addLocation(homeLocation, tracker)
 
 	if baseErr != nil {
 		return types.NewWrappedError(errorMessage, baseErr)
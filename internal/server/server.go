@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"github.com/eduvpn/eduvpn-common/internal/oauth"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/internal/wireguard"
 	"github.com/eduvpn/eduvpn-common/types"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 type Type int8
@@ -149,54 +151,102 @@ func ValidProfiles(server Server, clientSupportsWireguard bool) (*ProfileInfo, e
 	return &profiles, nil
 }
 
+// keyRejected returns whether or not the /connect error `err` indicates that the server rejected the WireGuard public key.
+// Other errors, e.g. a 400 for a profile that is not available, keep the key.
+func keyRejected(err error) bool {
+	return types.ErrorCode(err) == types.ErrCodeInvalidPublicKey
+}
+
+// wireguardConnect does a WireGuard /connect call with the key from `keys` for the current profile.
+// It returns the config, the content type, the expiry time and the private key that was used.
+// If a reused key is rejected by the server, the key is rotated and the call is tried once more with a new key.
+func wireguardConnect(
+	server Server,
+	base *Base,
+	preferTCP bool,
+	supportsOpenVPN bool,
+	keys *wireguard.KeyStore,
+	tracker *progress.Tracker,
+) (string, string, time.Time, wgtypes.Key, error) {
+	profileID := base.Profiles.Current
+	tracker.Step(progress.StepWireGuardKey)
+	wireguardKey, reused, wireguardErr := keys.Key(base.URL, profileID)
+	if wireguardErr != nil {
+		return "", "", time.Time{}, wireguardKey, wireguardErr
+	}
+
+	tracker.Step(progress.StepConnect)
+	config, content, expires, configErr := APIConnectWireguard(
+		server,
+		profileID,
+		wireguardKey.PublicKey().String(),
+		preferTCP,
+		supportsOpenVPN,
+	)
+	if configErr == nil || !reused || !keyRejected(configErr) {
+		return config, content, expires, wireguardKey, configErr
+	}
+
+	// The server rejected the key that we used before, try again with a new one
+	rotateErr := keys.Rotate(base.URL, profileID)
+	if rotateErr != nil {
+		return "", "", time.Time{}, wireguardKey, rotateErr
+	}
+	return wireguardConnect(server, base, preferTCP, supportsOpenVPN, keys, tracker)
+}
+
 func wireguardGetConfig(
 	server Server,
 	preferTCP bool,
 	supportsOpenVPN bool,
-) (string, string, error) {
+	keys *wireguard.KeyStore,
+	tracker *progress.Tracker,
+) (string, string, *wireguard.Config, error) {
 	errorMessage := "failed getting server WireGuard configuration"
 	base, baseErr := server.Base()
 
 	if baseErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, baseErr)
+		return "", "", nil, types.NewWrappedError(errorMessage, baseErr)
 	}
 
-	profileID := base.Profiles.Current
-	wireguardKey, wireguardErr := wireguard.GenerateKey()
-
-	if wireguardErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, wireguardErr)
-	}
-
-	wireguardPublicKey := wireguardKey.PublicKey().String()
-	config, content, expires, configErr := APIConnectWireguard(
+	config, content, expires, wireguardKey, configErr := wireguardConnect(
 		server,
-		profileID,
-		wireguardPublicKey,
+		base,
 		preferTCP,
 		supportsOpenVPN,
+		keys,
+		tracker,
 	)
 
 	if configErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, configErr)
+		return "", "", nil, types.NewWrappedError(errorMessage, configErr)
 	}
 
 	// Store start and end time
-	base.StartTime = time.Now()
-	base.EndTime = expires
+	timesErr := setSessionTimes(base, config, content, expires)
+	if timesErr != nil {
+		return "", "", nil, types.NewWrappedError(errorMessage, timesErr)
+	}
 
-	if content == "wireguard" {
-		// This needs the go code a way to identify a connection
-		// Use the uuid of the connection e.g. on Linux
-		// This needs the client code to call the go code
+	if content != "wireguard" {
+		return config, content, nil, nil
+	}
 
-		config = wireguard.ConfigAddKey(config, wireguardKey)
+	// The config is written back with the private key, this normalizes it, see wireguard.Config.String
+	parsed, parseErr := wireguard.Parse(config)
+	if parseErr != nil {
+		return "", "", nil, types.NewWrappedError(errorMessage, parseErr)
 	}
+	parsed.Interface.PrivateKey = &wireguardKey
 
-	return config, content, nil
+	return parsed.String(), content, parsed, nil
 }
 
-func openVPNGetConfig(server Server, preferTCP bool) (string, string, error) {
+func openVPNGetConfig(
+	server Server,
+	preferTCP bool,
+	tracker *progress.Tracker,
+) (string, string, error) {
 	errorMessage := "failed getting server OpenVPN configuration"
 	base, baseErr := server.Base()
 
@@ -204,21 +254,28 @@ func openVPNGetConfig(server Server, preferTCP bool) (string, string, error) {
 		return "", "", types.NewWrappedError(errorMessage, baseErr)
 	}
 	profileID := base.Profiles.Current
+	tracker.Step(progress.StepConnect)
 	configOpenVPN, expires, configErr := APIConnectOpenVPN(server, profileID, preferTCP)
-
-	// Store start and end time
-	base.StartTime = time.Now()
-	base.EndTime = expires
-
 	if configErr != nil {
 		return "", "", types.NewWrappedError(errorMessage, configErr)
 	}
 
+	// Store start and end time
+	timesErr := setSessionTimes(base, configOpenVPN, "openvpn", expires)
+	if timesErr != nil {
+		return "", "", types.NewWrappedError(errorMessage, timesErr)
+	}
+
 	return configOpenVPN, "openvpn", nil
 }
 
-func HasValidProfile(server Server, clientSupportsWireguard bool) (bool, error) {
+func HasValidProfile(
+	server Server,
+	clientSupportsWireguard bool,
+	tracker *progress.Tracker,
+) (bool, error) {
 	errorMessage := "failed has valid profile check"
+	tracker.Step(progress.StepServerInfo)
 
 	// Get new profiles using the info call
 	// This does not override the current profile
@@ -261,7 +318,7 @@ func HasValidProfile(server Server, clientSupportsWireguard bool) (bool, error)
 	return false, nil
 }
 
-func RefreshEndpoints(server Server) error {
+func RefreshEndpoints(server Server, tracker *progress.Tracker) error {
 	errorMessage := "failed to refresh server endpoints"
 
 	// Re-initialize the endpoints
@@ -271,20 +328,31 @@ func RefreshEndpoints(server Server) error {
 		return types.NewWrappedError(errorMessage, baseErr)
 	}
 
-	endpointsErr := base.InitializeEndpoints()
+	client := server.OAuth().HTTPClient
+	endpointsErr := base.InitializeEndpoints(client, tracker)
 	if endpointsErr != nil {
 		return types.NewWrappedError(errorMessage, endpointsErr)
 	}
 
+	// A permanent redirect of the well-known document that was followed, e.g. on the same origin, is a move of the server as well
+	if movedURL, moved := movedEndpoints(client, base.URL); moved {
+		return types.NewWrappedError(errorMessage, &MovedError{URL: movedURL})
+	}
 	return nil
 }
 
-func Config(server Server, clientSupportsWireguard bool, preferTCP bool) (string, string, error) {
+func Config(
+	server Server,
+	clientSupportsWireguard bool,
+	preferTCP bool,
+	keys *wireguard.KeyStore,
+	tracker *progress.Tracker,
+) (string, string, *wireguard.Config, error) {
 	errorMessage := "failed getting an OpenVPN/WireGuard configuration"
 
 	profile, profileErr := CurrentProfile(server)
 	if profileErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, profileErr)
+		return "", "", nil, types.NewWrappedError(errorMessage, profileErr)
 	}
 
 	supportsOpenVPN := profile.supportsOpenVPN()
@@ -292,6 +360,7 @@ func Config(server Server, clientSupportsWireguard bool, preferTCP bool) (string
 
 	var config string
 	var configType string
+	var parsed *wireguard.Config
 	var configErr error
 
 	switch {
@@ -299,20 +368,26 @@ func Config(server Server, clientSupportsWireguard bool, preferTCP bool) (string
 	case supportsWireguard:
 		// A wireguard connect call needs to generate a wireguard key and add it to the config
 		// Also the server could send back an OpenVPN config if it supports OpenVPN
-		config, configType, configErr = wireguardGetConfig(server, preferTCP, supportsOpenVPN)
+		config, configType, parsed, configErr = wireguardGetConfig(
+			server,
+			preferTCP,
+			supportsOpenVPN,
+			keys,
+			tracker,
+		)
 	//  The config only supports OpenVPN
 	case supportsOpenVPN:
-		config, configType, configErr = openVPNGetConfig(server, preferTCP)
+		config, configType, configErr = openVPNGetConfig(server, preferTCP, tracker)
 		// The config supports no available protocol because the profile only supports WireGuard but the client doesn't
 	default:
-		return "", "", types.NewWrappedError(errorMessage, errors.New("no supported protocol found"))
+		return "", "", nil, types.NewWrappedError(errorMessage, errors.New("no supported protocol found"))
 	}
 
 	if configErr != nil {
-		return "", "", types.NewWrappedError(errorMessage, configErr)
+		return "", "", nil, types.NewWrappedError(errorMessage, configErr)
 	}
 
-	return config, configType, nil
+	return config, configType, parsed, nil
 }
 
 func Disconnect(server Server) {
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+)
+
+// statusError returns the error of a response with status code `status` and JSON error message `message`.
+func statusError(status int, message string) error {
+	return &httpw.StatusError{Status: status, API: &httpw.APIError{Status: status, Message: message}}
+}
+
+func TestKeyRejected(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{statusError(http.StatusBadRequest, "public key already in use"), true},
+		{statusError(http.StatusBadRequest, "profile not available"), false},
+		{&httpw.StatusError{Status: http.StatusBadRequest, Body: "not json"}, false},
+		{errors.New("public key already in use"), false},
+	}
+
+	for _, test := range tests {
+		if got := keyRejected(test.err); got != test.want {
+			t.Errorf("Got key rejected: %v, want: %v, for error: %v", got, test.want, test.err)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package server
 import (
 	"fmt"
 
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
 	"github.com/eduvpn/eduvpn-common/types"
 )
 
@@ -12,16 +14,33 @@ type Servers struct {
 	InstituteServers         InstituteAccessServers   `json:"institute_servers"`
 	SecureInternetHomeServer SecureInternetHomeServer `json:"secure_internet_home"`
 	IsType                   Type                     `json:"is_secure_internet"`
+
+	// HTTPClient is the client for the requests to the servers, it is set on the OAuth of every server
+	HTTPClient *httpw.Client `json:"-"`
+}
+
+// SetHTTPClient sets the client for the requests to all servers, including the servers that are added later.
+func (servers *Servers) SetHTTPClient(client *httpw.Client) {
+	servers.HTTPClient = client
+	for _, server := range servers.CustomServers.Map {
+		server.Auth.HTTPClient = client
+	}
+	for _, server := range servers.InstituteServers.Map {
+		server.Auth.HTTPClient = client
+	}
+	servers.SecureInternetHomeServer.Auth.HTTPClient = client
 }
 
 func (servers *Servers) AddSecureInternet(
 	secureOrg *types.DiscoveryOrganization,
 	secureServer *types.DiscoveryServer,
+	tracker *progress.Tracker,
 ) (Server, error) {
 	errorMessage := "failed adding secure internet server"
 	// If we have specified an organization ID
 	// We also need to get an authorization template
-	initErr := servers.SecureInternetHomeServer.init(secureOrg, secureServer)
+	servers.SecureInternetHomeServer.Auth.HTTPClient = servers.HTTPClient
+	initErr := servers.SecureInternetHomeServer.init(secureOrg, secureServer, tracker)
 
 	if initErr != nil {
 		return nil, types.NewWrappedError(errorMessage, initErr)
@@ -70,6 +89,7 @@ func (servers *Servers) GetCurrentServer() (Server, error) {
 func (servers *Servers) addInstituteAndCustom(
 	discoServer *types.DiscoveryServer,
 	isCustom bool,
+	tracker *progress.Tracker,
 ) (Server, error) {
 	url := discoServer.BaseURL
 	errorMessage := fmt.Sprintf("failed adding institute access server: %s", url)
@@ -91,12 +111,14 @@ func (servers *Servers) addInstituteAndCustom(
 	if !exists {
 		server = &InstituteAccessServer{}
 	}
+	server.Auth.HTTPClient = servers.HTTPClient
 
 	instituteInitErr := server.init(
 		url,
 		discoServer.DisplayName,
 		discoServer.Type,
 		discoServer.SupportContact,
+		tracker,
 	)
 	if instituteInitErr != nil {
 		return nil, types.NewWrappedError(errorMessage, instituteInitErr)
@@ -108,14 +130,46 @@ func (servers *Servers) addInstituteAndCustom(
 
 func (servers *Servers) AddInstituteAccessServer(
 	instituteServer *types.DiscoveryServer,
+	tracker *progress.Tracker,
 ) (Server, error) {
-	return servers.addInstituteAndCustom(instituteServer, false)
+	return servers.addInstituteAndCustom(instituteServer, false, tracker)
 }
 
 func (servers *Servers) AddCustomServer(
 	customServer *types.DiscoveryServer,
+	tracker *progress.Tracker,
 ) (Server, error) {
-	return servers.addInstituteAndCustom(customServer, true)
+	return servers.addInstituteAndCustom(customServer, true, tracker)
+}
+
+// Migrate moves the institute access or custom server `server` to the base URL `to`, e.g. because the portal moved to a new host name.
+// The endpoints are fetched from the new URL first, such that the server is not changed if the new URL does not work.
+// Secure internet servers cannot be migrated as their URLs come from discovery.
+func (servers *Servers) Migrate(server Server, to string, tracker *progress.Tracker) error {
+	errorMessage := fmt.Sprintf("failed migrating server to %s", to)
+	base, baseErr := server.Base()
+	if baseErr != nil {
+		return types.NewWrappedError(errorMessage, baseErr)
+	}
+	var toMigrate *InstituteAccessServers
+	switch base.Type {
+	case "institute_access":
+		toMigrate = &servers.InstituteServers
+	case "custom_server":
+		toMigrate = &servers.CustomServers
+	default:
+		return types.NewWrappedError(errorMessage, fmt.Errorf("a server of type: %s cannot be migrated", base.Type))
+	}
+	tracker.Step(progress.StepEndpoints)
+	endpoints, endpointsErr := APIGetEndpoints(server.OAuth().HTTPClient, to)
+	if endpointsErr != nil {
+		return types.NewWrappedError(errorMessage, endpointsErr)
+	}
+	migrateErr := toMigrate.Migrate(base.URL, to, *endpoints)
+	if migrateErr != nil {
+		return types.NewWrappedError(errorMessage, migrateErr)
+	}
+	return nil
 }
 
 func (servers *Servers) GetSecureLocation() string {
@@ -124,10 +178,11 @@ func (servers *Servers) GetSecureLocation() string {
 
 func (servers *Servers) SetSecureLocation(
 	chosenLocationServer *types.DiscoveryServer,
+	tracker *progress.Tracker,
 ) error {
 	errorMessage := "failed to set secure location"
 	// Make sure to add the current location
-	_, addLocationErr := servers.SecureInternetHomeServer.addLocation(chosenLocationServer)
+	_, addLocationErr := servers.SecureInternetHomeServer.addLocation(chosenLocationServer, tracker)
 
 	if addLocationErr != nil {
 		return types.NewWrappedError(errorMessage, addLocationErr)
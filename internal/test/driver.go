@@ -0,0 +1,283 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/client"
+	"github.com/eduvpn/eduvpn-common/types"
+)
+
+// Answer is a scripted answer to an FSM prompt, e.g. asking for a profile.
+type Answer struct {
+	// State is the state in which the answer is given
+	State client.FSMStateID
+
+	// Description describes the answer for the test output
+	Description string
+
+	// Do answers the prompt using the client and the data of the state
+	Do func(driver *Driver, data interface{}) error
+}
+
+// PickProfile is the answer to the ASK_PROFILE state that chooses the profile with `profileID`.
+func PickProfile(profileID string) Answer {
+	return Answer{
+		State:       client.StateAskProfile,
+		Description: fmt.Sprintf("pick profile %s", profileID),
+		Do: func(driver *Driver, _ interface{}) error {
+			return driver.Client.SetProfileID(profileID)
+		},
+	}
+}
+
+// PickLocation is the answer to the ASK_LOCATION state that chooses the Secure Internet location with `countryCode`.
+func PickLocation(countryCode string) Answer {
+	return Answer{
+		State:       client.StateAskLocation,
+		Description: fmt.Sprintf("pick location %s", countryCode),
+		Do: func(driver *Driver, _ interface{}) error {
+			return driver.Client.SetSecureLocation(countryCode)
+		},
+	}
+}
+
+// ConfirmMigration is the answer to the ASK_MIGRATION state that accepts or declines moving the server to its new URL.
+func ConfirmMigration(accept bool) Answer {
+	return Answer{
+		State:       client.StateAskMigration,
+		Description: fmt.Sprintf("confirm migration: %v", accept),
+		Do: func(driver *Driver, _ interface{}) error {
+			return driver.Client.ConfirmMigration(accept)
+		},
+	}
+}
+
+// CompleteOAuth is the answer to the OAUTH_STARTED state that completes OAuth like a browser would.
+// It fetches the authorization URL with an HTTP client, the portal then redirects back to the client.
+func CompleteOAuth() Answer {
+	return Answer{
+		State:       client.StateOAuthStarted,
+		Description: "complete OAuth",
+		Do: func(driver *Driver, data interface{}) error {
+			authURL, ok := data.(string)
+			if !ok {
+				return fmt.Errorf("OAuth URL is not a string but: %T", data)
+			}
+			// The client waits for the callback after the state callback has returned
+			// So the browser must be done in the background
+			driver.wg.Add(1)
+			go func() {
+				defer driver.wg.Done()
+				if browserErr := browse(driver.Browser, authURL); browserErr != nil {
+					driver.errorf("failed completing OAuth in the browser: %v", browserErr)
+					_ = driver.Client.CancelOAuth()
+				}
+			}()
+			return nil
+		},
+	}
+}
+
+// CancelOAuth is the answer to the OAUTH_STARTED state that cancels OAuth, as if the user closed the browser.
+func CancelOAuth() Answer {
+	return Answer{
+		State:       client.StateOAuthStarted,
+		Description: "cancel OAuth",
+		Do: func(driver *Driver, _ interface{}) error {
+			return driver.Client.CancelOAuth()
+		},
+	}
+}
+
+// browse fetches `url` with `browser` and follows the redirects like a browser.
+func browse(browser *http.Client, url string) error {
+	if browser == nil {
+		browser = http.DefaultClient
+	}
+	resp, getErr := browser.Get(url)
+	if getErr != nil {
+		return getErr
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status code: %d with body: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// prompts are the states in which the client waits for the UI to answer.
+var prompts = map[client.FSMStateID]bool{
+	client.StateAskProfile:   true,
+	client.StateAskLocation:  true,
+	client.StateOAuthStarted: true,
+	client.StateAskMigration: true,
+}
+
+// Driver is a headless UI that registers a client and answers the FSM prompts from a script.
+// It records the states that the client went through such that tests can assert them.
+type Driver struct {
+	// Client is the registered client
+	Client *client.Client
+
+	// Browser is the HTTP client that completes OAuth, nil means http.DefaultClient
+	Browser *http.Client
+
+	// Directory is the directory where the client saves its files
+	Directory string
+
+	// t is the test the driver belongs to
+	t testing.TB
+
+	// mu protects the fields below as the OAuth browser runs in a different goroutine
+	mu sync.Mutex
+
+	// script are the answers that still need to be given, in order
+	script []Answer
+
+	// states are the states the client went through since the last ExpectStates
+	states []client.FSMStateID
+
+	// progress are the progress events since the last ExpectProgress
+	progress []client.ProgressEvent
+
+	// wg waits for the background browsers
+	wg sync.WaitGroup
+}
+
+// NewDriver registers a client with `name` in a temporary directory and returns the driver for it.
+// The client is deregistered when the test finishes.
+func NewDriver(t testing.TB, name string) *Driver {
+	t.Helper()
+	driver := &Driver{Client: &client.Client{StrictFSM: true}, Directory: t.TempDir(), t: t}
+	driver.Client.ProgressCallback = func(event client.ProgressEvent) {
+		driver.mu.Lock()
+		defer driver.mu.Unlock()
+		driver.progress = append(driver.progress, event)
+	}
+	registerErr := driver.Client.Register(name, driver.Directory, "en", driver.callback, false)
+	if registerErr != nil {
+		t.Fatalf("Register error: %s", types.ErrorTraceback(registerErr))
+	}
+	t.Cleanup(func() {
+		driver.wg.Wait()
+		driver.Client.Deregister()
+	})
+	return driver
+}
+
+// Script appends `answers` to the answers that still need to be given.
+func (driver *Driver) Script(answers ...Answer) {
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+	driver.script = append(driver.script, answers...)
+}
+
+// errorf reports an error on the test, it is safe to use in other goroutines.
+func (driver *Driver) errorf(format string, args ...interface{}) {
+	driver.t.Errorf(format, args...)
+}
+
+// callback is the FSM callback of the client.
+// It records the state and answers the prompts with the next answer in the script.
+func (driver *Driver) callback(_ client.FSMStateID, newState client.FSMStateID, data interface{}) bool {
+	driver.mu.Lock()
+	driver.states = append(driver.states, newState)
+	if !prompts[newState] {
+		driver.mu.Unlock()
+		return true
+	}
+	if len(driver.script) == 0 || driver.script[0].State != newState {
+		driver.mu.Unlock()
+		driver.errorf("No scripted answer for state: %s", client.GetStateName(newState))
+		// Do not leave the client waiting for the browser
+		if newState == client.StateOAuthStarted {
+			_ = driver.Client.CancelOAuth()
+		}
+		return true
+	}
+	answer := driver.script[0]
+	driver.script = driver.script[1:]
+	driver.mu.Unlock()
+
+	// The answer calls into the client again so the lock must not be held
+	if answerErr := answer.Do(driver, data); answerErr != nil {
+		driver.errorf("Failed to %s: %s", answer.Description, types.ErrorTraceback(answerErr))
+	}
+	return true
+}
+
+// Wait waits until the answers that run in the background, such as completing OAuth, are done.
+func (driver *Driver) Wait() {
+	driver.wg.Wait()
+}
+
+// AddServer adds `portal` as a custom server, it completes OAuth and then gives `answers`, e.g. to pick a profile.
+// The test fails if the server cannot be added.
+func (driver *Driver) AddServer(portal *Portal, answers ...Answer) {
+	driver.t.Helper()
+	driver.Script(append([]Answer{CompleteOAuth()}, answers...)...)
+	if _, addErr := driver.Client.AddCustomServer(portal.URL()); addErr != nil {
+		driver.t.Fatalf("Got add error: %v", addErr)
+	}
+	driver.Wait()
+}
+
+// ExpectStates asserts that the client went through the states `want` since the previous call.
+func (driver *Driver) ExpectStates(want ...client.FSMStateID) {
+	driver.t.Helper()
+	driver.mu.Lock()
+	got := driver.states
+	driver.states = nil
+	driver.mu.Unlock()
+
+	if stateNames(got) != stateNames(want) {
+		driver.t.Fatalf("Got states: %s, want: %s", stateNames(got), stateNames(want))
+	}
+}
+
+// ExpectProgress asserts that the client reported the progress steps `want` for `url` since the previous call.
+func (driver *Driver) ExpectProgress(url string, want ...client.ProgressStep) {
+	driver.t.Helper()
+	driver.mu.Lock()
+	got := driver.progress
+	driver.progress = nil
+	driver.mu.Unlock()
+
+	gotSteps := make([]client.ProgressStep, len(got))
+	for i, event := range got {
+		gotSteps[i] = event.Step
+		if event.URL != url {
+			driver.t.Fatalf("Got progress URL: %s, want: %s", event.URL, url)
+		}
+		if i > 0 && event.Elapsed < got[i-1].Elapsed {
+			driver.t.Fatalf("Progress step %s has less elapsed time than the previous step", event.Step)
+		}
+	}
+	if fmt.Sprint(gotSteps) != fmt.Sprint(want) {
+		driver.t.Fatalf("Got progress steps: %v, want: %v", gotSteps, want)
+	}
+}
+
+// ExpectScriptDone asserts that all scripted answers have been given.
+func (driver *Driver) ExpectScriptDone() {
+	driver.t.Helper()
+	driver.mu.Lock()
+	defer driver.mu.Unlock()
+	for _, answer := range driver.script {
+		driver.t.Errorf("Scripted answer not given: %s", answer.Description)
+	}
+}
+
+// stateNames returns the names of `states` for the test output.
+func stateNames(states []client.FSMStateID) string {
+	names := make([]string, len(states))
+	for i, state := range states {
+		names[i] = client.GetStateName(state)
+	}
+	return fmt.Sprint(names)
+}
@@ -0,0 +1,330 @@
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/client"
+	"github.com/eduvpn/eduvpn-common/internal/progress"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+)
+
+const testClientID = "org.letsconnect-vpn.app.linux"
+
+var (
+	openVPNProfile = server.Profile{
+		ID:           "employees",
+		DisplayName:  "Employees",
+		VPNProtoList: []string{"openvpn"},
+	}
+	wireGuardProfile = server.Profile{
+		ID:             "internet",
+		DisplayName:    "Internet",
+		VPNProtoList:   []string{"openvpn", "wireguard"},
+		DefaultGateway: true,
+	}
+)
+
+// The states for adding a server and authorizing it.
+var addStates = []client.FSMStateID{
+	client.StateLoadingServer,
+	client.StateChosenServer,
+	client.StateOAuthStarted,
+	client.StateAuthorized,
+	client.StateNoServer,
+}
+
+func states(parts ...[]client.FSMStateID) []client.FSMStateID {
+	var all []client.FSMStateID
+	for _, part := range parts {
+		all = append(all, part...)
+	}
+	return all
+}
+
+func TestAddServerFlows(t *testing.T) {
+	tests := []struct {
+		name              string
+		profiles          []server.Profile
+		supportsWireGuard bool
+		preferTCP         bool
+		script            []Answer
+		wantAddErr        bool
+		wantConfigType    string
+		wantStates        []client.FSMStateID
+	}{
+		{
+			name:           "single OpenVPN profile",
+			profiles:       []server.Profile{openVPNProfile},
+			script:         []Answer{CompleteOAuth()},
+			wantConfigType: "openvpn",
+			wantStates: states(addStates, []client.FSMStateID{
+				client.StateLoadingServer,
+				client.StateChosenServer,
+				client.StateAuthorized,
+				client.StateRequestConfig,
+				client.StateDisconnected,
+			}),
+		},
+		{
+			name:              "pick a WireGuard profile",
+			profiles:          []server.Profile{openVPNProfile, wireGuardProfile},
+			supportsWireGuard: true,
+			script:            []Answer{CompleteOAuth(), PickProfile(wireGuardProfile.ID)},
+			wantConfigType:    "wireguard",
+			wantStates: states(addStates, []client.FSMStateID{
+				client.StateLoadingServer,
+				client.StateChosenServer,
+				client.StateAuthorized,
+				client.StateRequestConfig,
+				client.StateAskProfile,
+				client.StateDisconnected,
+			}),
+		},
+		{
+			name:              "prefer TCP gives OpenVPN",
+			profiles:          []server.Profile{wireGuardProfile},
+			supportsWireGuard: true,
+			preferTCP:         true,
+			script:            []Answer{CompleteOAuth()},
+			wantConfigType:    "openvpn",
+			wantStates: states(addStates, []client.FSMStateID{
+				client.StateLoadingServer,
+				client.StateChosenServer,
+				client.StateAuthorized,
+				client.StateRequestConfig,
+				client.StateDisconnected,
+			}),
+		},
+		{
+			name:       "cancel OAuth",
+			profiles:   []server.Profile{openVPNProfile},
+			script:     []Answer{CancelOAuth()},
+			wantAddErr: true,
+			wantStates: []client.FSMStateID{
+				client.StateLoadingServer,
+				client.StateChosenServer,
+				client.StateOAuthStarted,
+				client.StateError,
+				client.StateNoServer,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portal := NewPortal(test.profiles...)
+			defer portal.Close()
+
+			driver := NewDriver(t, testClientID)
+			driver.ExpectStates(client.StateNoServer)
+			driver.Client.SupportsWireguard = test.supportsWireGuard
+			driver.Script(test.script...)
+
+			_, addErr := driver.Client.AddCustomServer(portal.URL())
+			driver.Wait()
+			if (addErr != nil) != test.wantAddErr {
+				t.Fatalf("Got add error: %v, want error: %v", addErr, test.wantAddErr)
+			}
+			if test.wantAddErr {
+				// The server that could not be added is removed from the saved state
+				state, readErr := ioutil.ReadFile(filepath.Join(driver.Directory, "state.json"))
+				if readErr != nil {
+					t.Fatalf("Got read error: %v", readErr)
+				}
+				if strings.Contains(string(state), portal.URL()) {
+					t.Fatalf("Got saved state with the server that could not be added: %s", state)
+				}
+			}
+			if !test.wantAddErr {
+				driver.ExpectProgress(portal.URL(), progress.StepEndpoints, progress.StepAuthorize, progress.StepDone)
+				result, configErr := driver.Client.GetConfigCustomServer(portal.URL(), test.preferTCP)
+				if configErr != nil {
+					t.Fatalf("Got config error: %v", configErr)
+				}
+				wantProgress := []client.ProgressStep{progress.StepEndpoints, progress.StepServerInfo}
+				if test.supportsWireGuard {
+					wantProgress = append(wantProgress, progress.StepWireGuardKey)
+				}
+				driver.ExpectProgress(portal.URL(), append(wantProgress, progress.StepConnect, progress.StepDone)...)
+				if result.Protocol != test.wantConfigType {
+					t.Fatalf("Got protocol: %s, want: %s", result.Protocol, test.wantConfigType)
+				}
+				if (result.WireGuard != nil) != (result.Protocol == "wireguard") ||
+					(result.OpenVPN != nil) != (result.Protocol == "openvpn") {
+					t.Fatalf("Got parsed configs: %v and %v for protocol: %s", result.WireGuard, result.OpenVPN, result.Protocol)
+				}
+				if result.WireGuard != nil {
+					if result.WireGuard.Interface.PrivateKey == nil {
+						t.Fatalf("Got no private key in the parsed config")
+					}
+					if result.WireGuard.String() != result.Config {
+						t.Fatalf("Got parsed config: %s, want: %s", result.WireGuard.String(), result.Config)
+					}
+				}
+			}
+
+			driver.ExpectStates(test.wantStates...)
+			driver.ExpectScriptDone()
+		})
+	}
+}
+
+func TestTokenFlows(t *testing.T) {
+	tests := []struct {
+		name       string
+		invalidate func(*Portal)
+		script     []Answer
+		wantStates []client.FSMStateID
+	}{
+		{
+			name:       "expired access token is refreshed",
+			invalidate: (*Portal).ExpireTokens,
+			wantStates: []client.FSMStateID{
+				client.StateLoadingServer,
+				client.StateChosenServer,
+				client.StateAuthorized,
+				client.StateRequestConfig,
+				client.StateDisconnected,
+			},
+		},
+		{
+			name:       "revoked tokens need authorization",
+			invalidate: (*Portal).RevokeTokens,
+			script:     []Answer{CompleteOAuth()},
+			wantStates: []client.FSMStateID{
+				client.StateLoadingServer,
+				client.StateChosenServer,
+				client.StateAuthorized,
+				client.StateRequestConfig,
+				client.StateOAuthStarted,
+				client.StateAuthorized,
+				client.StateRequestConfig,
+				client.StateDisconnected,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			portal := NewPortal(openVPNProfile)
+			defer portal.Close()
+
+			driver := NewDriver(t, testClientID)
+			driver.AddServer(portal)
+			driver.ExpectStates(states([]client.FSMStateID{client.StateNoServer}, addStates)...)
+
+			test.invalidate(portal)
+			driver.Script(test.script...)
+			_, configErr := driver.Client.GetConfigCustomServer(portal.URL(), false)
+			driver.Wait()
+			if configErr != nil {
+				t.Fatalf("Got config error: %v", configErr)
+			}
+			driver.ExpectStates(test.wantStates...)
+			driver.ExpectScriptDone()
+		})
+	}
+}
+
+func TestDisconnectFlow(t *testing.T) {
+	portal := NewPortal(openVPNProfile)
+	defer portal.Close()
+
+	driver := NewDriver(t, testClientID)
+	driver.AddServer(portal)
+	if _, configErr := driver.Client.GetConfigCustomServer(portal.URL(), false); configErr != nil {
+		t.Fatalf("Got config error: %v", configErr)
+	}
+
+	for _, step := range []func() error{
+		driver.Client.SetConnecting,
+		driver.Client.SetConnected,
+		driver.Client.SetDisconnecting,
+		func() error { return driver.Client.SetDisconnected(true) },
+	} {
+		if stepErr := step(); stepErr != nil {
+			t.Fatalf("Got error: %v", stepErr)
+		}
+	}
+
+	driver.ExpectStates(states(
+		[]client.FSMStateID{client.StateNoServer},
+		addStates,
+		[]client.FSMStateID{
+			client.StateLoadingServer,
+			client.StateChosenServer,
+			client.StateAuthorized,
+			client.StateRequestConfig,
+			client.StateDisconnected,
+			client.StateConnecting,
+			client.StateConnected,
+			client.StateDisconnecting,
+			client.StateDisconnected,
+		},
+	)...)
+
+	requests := strings.Join(portal.Requests(), "\n")
+	if !strings.Contains(requests, "POST /api/v3/disconnect") {
+		t.Fatalf("Got requests: %s, want a /disconnect", requests)
+	}
+}
+
+func TestConfigMismatchFlow(t *testing.T) {
+	// The portal returns an OpenVPN config for a profile that only supports WireGuard
+	portal := NewPortal(server.Profile{ID: "internet", VPNProtoList: []string{"wireguard"}})
+	defer portal.Close()
+	portal.ConnectContentType = "application/x-openvpn-profile"
+
+	driver := NewDriver(t, testClientID)
+	driver.Client.SupportsWireguard = true
+	driver.AddServer(portal)
+	_, configErr := driver.Client.GetConfigCustomServer(portal.URL(), false)
+	var mismatchErr *client.ConfigMismatchError
+	if !errors.As(configErr, &mismatchErr) {
+		t.Fatalf("Got error: %v, want a mismatch error", configErr)
+	}
+
+	// The session of the config that is not used is ended on the server
+	requests := portal.Requests()
+	if last := requests[len(requests)-1]; last != "POST /api/v3/disconnect" {
+		t.Fatalf("Got requests: %v, want a /disconnect after the /connect", requests)
+	}
+}
+
+func TestProxyFlow(t *testing.T) {
+	fp := NewForwardProxy()
+	defer fp.Close()
+	// The client can only reach the portal through the proxy
+	portal := NewPortal(openVPNProfile)
+	defer portal.Close()
+	portal.Host = "portal.test"
+
+	driver := NewDriver(t, testClientID)
+	driver.Browser = fp.Browser()
+	if proxyErr := driver.Client.SetProxy(client.ProxyConfig{URL: fp.URL()}); proxyErr != nil {
+		t.Fatalf("Got proxy error: %v", proxyErr)
+	}
+	// The portal is not on the loopback address and uses plain HTTP
+	if originsErr := driver.Client.SetAllowedOrigins([]string{strings.TrimSuffix(portal.URL(), "/")}); originsErr != nil {
+		t.Fatalf("Got allowed origins error: %v", originsErr)
+	}
+	// Setting other options keeps the proxy, the allowed origins and the cache
+	cache := driver.Client.HTTPOptions.Cache
+	driver.Client.SetHTTPOptions(client.HTTPOptions{Retry: client.HTTPRetryPolicy{IdempotentAttempts: 1}})
+	if driver.Client.HTTPOptions.Cache != cache {
+		t.Fatalf("Got cache: %v after setting the HTTP options, want: %v", driver.Client.HTTPOptions.Cache, cache)
+	}
+
+	driver.AddServer(portal)
+	if _, configErr := driver.Client.GetConfigCustomServer(portal.URL(), false); configErr != nil {
+		t.Fatalf("Got config error: %v", configErr)
+	}
+	want := "POST " + portal.URL() + "api/v3/connect"
+	if requests := strings.Join(fp.Requests(), "\n"); !strings.Contains(requests, want) {
+		t.Fatalf("Got proxied requests: %s, want: %s", requests, want)
+	}
+}
@@ -0,0 +1,552 @@
+// Package test implements helpers to test the client flows end-to-end without a real server or UI
+// It has a local stand-in for the vpn-user-portal, which is also used by the tests of the server package, and a driver that answers the FSM prompts from a script
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/oauth"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/util"
+)
+
+// portalPublicKey is the WireGuard public key of the portal that is returned in the configs.
+const portalPublicKey = "6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0="
+
+// Portal is a local stand-in for a vpn-user-portal server.
+// It implements the well-known endpoint, OAuth with PKCE and the /info, /connect and /disconnect API calls.
+type Portal struct {
+	// Profiles are the profiles that the portal returns in /info
+	Profiles []server.Profile
+
+	// TokenExpiry is how long the access tokens are valid
+	TokenExpiry time.Duration
+
+	// SessionExpiry is how long the configurations that are returned by /connect are valid
+	// If zero, /connect returns no Expires header
+	SessionExpiry time.Duration
+
+	// CertificateExpiry is how long the client certificates in the OpenVPN configurations are valid
+	// If zero, the OpenVPN configurations have no client certificate
+	CertificateExpiry time.Duration
+
+	// AllowedIPs are the allowed IPs of the peer in the WireGuard configurations
+	AllowedIPs string
+
+	// ConnectContentType is the Content-Type of the /connect responses instead of the one of the returned configuration if not empty
+	// e.g. to return a configuration that does not match the profile
+	ConnectContentType string
+
+	// OpenVPNDirectives are extra directives that are added to the OpenVPN configurations, e.g. to test the OpenVPN policy
+	OpenVPNDirectives string
+
+	// Host is the host name in the URL of the portal instead of 127.0.0.1, e.g. to test a proxy that resolves it
+	// The client cannot resolve it and must reach the portal through a ForwardProxy
+	Host string
+
+	// EndpointsURL is the base URL of the API and token endpoints in the well-known document instead of the URL of the portal
+	// This is used to test that the client refuses endpoints on another origin
+	EndpointsURL string
+
+	// MovedTo is the base URL that the well-known document permanently redirects to, empty means it is not moved
+	MovedTo string
+
+	// server is the underlying HTTP test server
+	server *httptest.Server
+
+	// handler handles the requests of the server and of the server it moved to
+	handler http.Handler
+
+	// mux routes the requests to the handlers of the portal
+	mux *http.ServeMux
+
+	// moved is the HTTP test server that the portal moved to with Move, nil if it did not move
+	moved *httptest.Server
+
+	// mu protects the fields below as the portal is accessed by multiple goroutines
+	mu sync.Mutex
+
+	// codes maps the authorization codes to the PKCE challenges
+	codes map[string]string
+
+	// accessTokens are the access tokens that are currently valid
+	accessTokens map[string]bool
+
+	// refreshTokens are the refresh tokens that are currently valid
+	refreshTokens map[string]bool
+
+	// requests are the requests that were made to the portal in the form "METHOD /path"
+	requests []string
+
+	// publicKeys are the WireGuard public keys that were accepted by /connect
+	publicKeys []string
+
+	// rejectedKeys are the WireGuard public keys that /connect rejects
+	rejectedKeys map[string]bool
+
+	// notModified is the number of 304 Not Modified responses for the well-known endpoints
+	notModified int
+
+	// failures are the failures for the next requests to each path
+	failures map[string][]failure
+}
+
+// failure is a response with an error status code instead of the normal response.
+type failure struct {
+	status     int
+	retryAfter string
+	message    string
+}
+
+// NewPortal creates and starts a portal with `profiles`.
+// The portal must be closed with Close.
+func NewPortal(profiles ...server.Profile) *Portal {
+	portal := &Portal{
+		Profiles:      profiles,
+		TokenExpiry:   time.Hour,
+		SessionExpiry: 24 * time.Hour,
+		AllowedIPs:    "0.0.0.0/0, ::/0",
+		codes:         make(map[string]string),
+		accessTokens:  make(map[string]bool),
+		refreshTokens: make(map[string]bool),
+		rejectedKeys:  make(map[string]bool),
+		failures:      make(map[string][]failure),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/vpn-user-portal", portal.wellKnown)
+	mux.HandleFunc("/oauth/authorize", portal.authorize)
+	mux.HandleFunc("/oauth/token", portal.token)
+	mux.HandleFunc("/api/v3/info", portal.authorized(portal.info))
+	mux.HandleFunc("/api/v3/connect", portal.authorized(portal.connect))
+	mux.HandleFunc("/api/v3/disconnect", portal.authorized(portal.disconnect))
+	portal.mux = mux
+	portal.handler = portal.record(mux)
+	portal.server = httptest.NewServer(portal.handler)
+	return portal
+}
+
+// URL returns the base URL of the portal in the form the client uses it, ending with a /.
+func (portal *Portal) URL() string {
+	base := portal.server.URL
+	if portal.Host != "" {
+		base = "http://" + net.JoinHostPort(portal.Host, portal.port())
+	}
+	url, _ := util.EnsureValidURL(base)
+	return url
+}
+
+// port returns the port the portal listens on.
+func (portal *Portal) port() string {
+	_, port, _ := net.SplitHostPort(portal.server.Listener.Addr().String())
+	return port
+}
+
+// Close shuts the portal down.
+func (portal *Portal) Close() {
+	portal.server.Close()
+	if portal.moved != nil {
+		portal.moved.Close()
+	}
+}
+
+// Move moves the portal to a new origin and returns its new base URL, like an institute that moves its portal to a new host name.
+// The portal keeps its tokens, the well-known document on the old origin permanently redirects to the new one.
+func (portal *Portal) Move() string {
+	portal.moved = httptest.NewServer(portal.handler)
+	url, _ := util.EnsureValidURL(portal.moved.URL)
+	portal.MovedTo = url
+	return url
+}
+
+// MovePath moves the portal to `path` on the same origin and returns its new base URL, e.g. to serve it from a subdirectory.
+// The well-known document at the old path permanently redirects to the one under `path`, the other endpoints stay where they are.
+func (portal *Portal) MovePath(path string) string {
+	portal.mux.HandleFunc("/"+path+"/.well-known/vpn-user-portal", portal.wellKnown)
+	portal.MovedTo = portal.URL() + path + "/"
+	return portal.MovedTo
+}
+
+// Requests returns the requests that were made to the portal in the form "METHOD /path".
+func (portal *Portal) Requests() []string {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	return append([]string(nil), portal.requests...)
+}
+
+// PublicKeys returns the WireGuard public keys that were accepted by /connect in the order they were used.
+func (portal *Portal) PublicKeys() []string {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	return append([]string(nil), portal.publicKeys...)
+}
+
+// RejectPublicKey makes /connect reject the WireGuard public key `publicKey`, e.g. because it is in use by another user.
+func (portal *Portal) RejectPublicKey(publicKey string) {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	portal.rejectedKeys[publicKey] = true
+}
+
+// ExpireTokens invalidates all access tokens, the refresh tokens stay valid.
+func (portal *Portal) ExpireTokens() {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	portal.accessTokens = make(map[string]bool)
+}
+
+// RevokeTokens invalidates all access and refresh tokens such that the client has to authorize again.
+func (portal *Portal) RevokeTokens() {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	portal.accessTokens = make(map[string]bool)
+	portal.refreshTokens = make(map[string]bool)
+}
+
+// Fail makes the next request to `path`, e.g. /api/v3/info, fail with `status`, e.g. a 503 of an overloaded portal.
+// If `retryAfter` is not empty, the response has it as the Retry-After header.
+// Each call fails one more request.
+func (portal *Portal) Fail(path string, status int, retryAfter string) {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	portal.failures[path] = append(portal.failures[path], failure{status: status, retryAfter: retryAfter, message: http.StatusText(status)})
+}
+
+// Reject makes the next request to `path` fail with `status` and the JSON error `message`, e.g. "account is disabled".
+func (portal *Portal) Reject(path string, status int, message string) {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	portal.failures[path] = append(portal.failures[path], failure{status: status, message: message})
+}
+
+// record records each request before handing it to `next` or failing it.
+func (portal *Portal) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		portal.mu.Lock()
+		portal.requests = append(portal.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		failures := portal.failures[r.URL.Path]
+		if len(failures) > 0 {
+			portal.failures[r.URL.Path] = failures[1:]
+		}
+		portal.mu.Unlock()
+		if len(failures) > 0 {
+			if failures[0].retryAfter != "" {
+				w.Header().Set("Retry-After", failures[0].retryAfter)
+			}
+			writeError(w, failures[0].status, failures[0].message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// randomString returns a random hex string that is used for codes and tokens.
+func randomString() string {
+	randomBytes, err := util.MakeRandomByteSlice(16)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(randomBytes)
+}
+
+// writeJSON writes `value` as JSON with status `status`.
+// clientCertificate returns an inline <cert> block with a self-signed client certificate that expires at `notAfter`.
+func clientCertificate(notAfter time.Time) (string, error) {
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		return "", keyErr
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: randomString()},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	der, certificateErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if certificateErr != nil {
+		return "", certificateErr
+	}
+	return fmt.Sprintf("<cert>\n%s</cert>\n", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error in the format of the portal.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (portal *Portal) wellKnown(w http.ResponseWriter, r *http.Request) {
+	// The endpoints are on the origin of the request, such that they are on the new origin after Move
+	originURL := "http://" + r.Host + "/"
+	baseURL := "http://" + r.Host + strings.TrimSuffix(r.URL.Path, ".well-known/vpn-user-portal")
+	if portal.MovedTo != "" && portal.MovedTo != baseURL {
+		http.Redirect(w, r, portal.MovedTo+".well-known/vpn-user-portal", http.StatusMovedPermanently)
+		return
+	}
+	endpointsURL := originURL
+	if portal.EndpointsURL != "" {
+		endpointsURL = portal.EndpointsURL
+	}
+	endpoints := server.Endpoints{V: "3.0.0-test"}
+	endpoints.API.V3 = server.EndpointList{
+		API:           endpointsURL + "api/v3",
+		Authorization: originURL + "oauth/authorize",
+		Token:         endpointsURL + "oauth/token",
+	}
+	// The endpoints have an ETag like the documents of a web server such that clients can cache them
+	body, _ := json.Marshal(endpoints)
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		portal.mu.Lock()
+		portal.notModified++
+		portal.mu.Unlock()
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+// NotModified returns the number of conditional requests for the well-known endpoints that got a 304 Not Modified.
+func (portal *Portal) NotModified() int {
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	return portal.notModified
+}
+
+// authorize handles the authorization request of the browser.
+// As there is no user to log in, it immediately redirects back to the client with a code.
+func (portal *Portal) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	for _, parameter := range []string{"client_id", "code_challenge", "redirect_uri", "state"} {
+		if query.Get(parameter) == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("missing parameter: %s", parameter))
+			return
+		}
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		writeError(w, http.StatusBadRequest, "unsupported response type or challenge method")
+		return
+	}
+	redirect, redirectErr := url.Parse(query.Get("redirect_uri"))
+	if redirectErr != nil || !strings.HasPrefix(redirect.Host, "127.0.0.1:") {
+		writeError(w, http.StatusBadRequest, "invalid redirect URI")
+		return
+	}
+
+	code := randomString()
+	portal.mu.Lock()
+	portal.codes[code] = query.Get("code_challenge")
+	portal.mu.Unlock()
+
+	callback := redirect.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	callback.Set("iss", portal.URL())
+	redirect.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// Authorize authorizes `srv` with OAuth for the client with `clientID` without a client or FSM, like CompleteOAuth.
+// This is used to test the server package directly with the portal.
+func (portal *Portal) Authorize(srv server.Server, clientID string) error {
+	authURL, urlErr := server.OAuthURL(srv, clientID)
+	if urlErr != nil {
+		return urlErr
+	}
+	exchanged := make(chan error, 1)
+	go func() {
+		exchanged <- server.OAuthExchange(srv)
+	}()
+	if browseErr := browse(nil, authURL); browseErr != nil {
+		server.CancelOAuth(srv)
+		<-exchanged
+		return browseErr
+	}
+	return <-exchanged
+}
+
+// token handles the token requests with an authorization code or a refresh token.
+func (portal *Portal) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeError(w, http.StatusBadRequest, "invalid token request")
+		return
+	}
+
+	portal.mu.Lock()
+	defer portal.mu.Unlock()
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		challenge, ok := portal.codes[r.PostForm.Get("code")]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(portal.codes, r.PostForm.Get("code"))
+		hash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(hash[:]) != challenge {
+			writeError(w, http.StatusBadRequest, "invalid code verifier")
+			return
+		}
+	case "refresh_token":
+		refresh := r.PostForm.Get("refresh_token")
+		if !portal.refreshTokens[refresh] {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(portal.refreshTokens, refresh)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	response := oauth.TokenResponse{
+		Access:  randomString(),
+		Refresh: randomString(),
+		Type:    "bearer",
+		Expires: int64(portal.TokenExpiry.Seconds()),
+	}
+	portal.accessTokens[response.Access] = true
+	portal.refreshTokens[response.Refresh] = true
+	writeJSON(w, http.StatusOK, response)
+}
+
+// authorized only calls `next` if the request has a valid access token.
+func (portal *Portal) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		portal.mu.Lock()
+		valid := portal.accessTokens[token]
+		portal.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (portal *Portal) info(w http.ResponseWriter, r *http.Request) {
+	info := server.ProfileInfo{Info: server.ProfileListInfo{ProfileList: portal.Profiles}}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// profile returns the profile with `profileID`.
+func (portal *Portal) profile(profileID string) (*server.Profile, bool) {
+	for i := range portal.Profiles {
+		if portal.Profiles[i].ID == profileID {
+			return &portal.Profiles[i], true
+		}
+	}
+	return nil, false
+}
+
+// supports returns whether or not `profile` supports `protocol`.
+func supports(profile *server.Profile, protocol string) bool {
+	for _, proto := range profile.VPNProtoList {
+		if proto == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// connect returns a WireGuard or OpenVPN configuration based on the profile, the accept header and prefer_tcp.
+func (portal *Portal) connect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeError(w, http.StatusBadRequest, "invalid connect request")
+		return
+	}
+	profile, ok := portal.profile(r.PostForm.Get("profile_id"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "profile not available")
+		return
+	}
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	preferTCP := r.PostForm.Get("prefer_tcp") == "yes"
+	acceptWireGuard := strings.Contains(accept, "application/x-wireguard-profile")
+	acceptOpenVPN := strings.Contains(accept, "application/x-openvpn-profile")
+
+	if portal.SessionExpiry != 0 {
+		w.Header().Set("Expires", time.Now().Add(portal.SessionExpiry).UTC().Format(http.TimeFormat))
+	}
+	switch {
+	// Like the real portal, prefer OpenVPN when TCP is preferred and the profile supports it
+	case supports(profile, "openvpn") && acceptOpenVPN && (preferTCP || !acceptWireGuard || !supports(profile, "wireguard")):
+		portal.writeContentType(w, "application/x-openvpn-profile")
+		remotes := "remote eduvpnserver 1194 udp\nremote eduvpnserver 1194 tcp"
+		if preferTCP {
+			remotes = "remote eduvpnserver 1194 tcp\nremote eduvpnserver 1194 udp"
+		}
+		certificate := ""
+		if portal.CertificateExpiry != 0 {
+			var certificateErr error
+			certificate, certificateErr = clientCertificate(time.Now().Add(portal.CertificateExpiry))
+			if certificateErr != nil {
+				writeError(w, http.StatusInternalServerError, certificateErr.Error())
+				return
+			}
+		}
+		fmt.Fprintf(w, "dev tun\nclient\nnobind\n%s\n%s%s", remotes, certificate, portal.OpenVPNDirectives)
+	case supports(profile, "wireguard") && acceptWireGuard:
+		publicKey := r.PostForm.Get("public_key")
+		if publicKey == "" {
+			writeError(w, http.StatusBadRequest, "missing public key")
+			return
+		}
+		portal.mu.Lock()
+		rejected := portal.rejectedKeys[publicKey]
+		if !rejected {
+			portal.publicKeys = append(portal.publicKeys, publicKey)
+		}
+		portal.mu.Unlock()
+		if rejected {
+			writeError(w, http.StatusBadRequest, "public key already in use")
+			return
+		}
+		portal.writeContentType(w, "application/x-wireguard-profile")
+		fmt.Fprintf(
+			w,
+			"[Interface]\nAddress = 10.10.10.2/24, fd00::2/64\nDNS = 9.9.9.9\n\n[Peer]\nPublicKey = %s\nAllowedIPs = %s\nEndpoint = eduvpnserver:51820\n",
+			portalPublicKey,
+			portal.AllowedIPs,
+		)
+	default:
+		writeError(w, http.StatusNotAcceptable, "profile does not support the accepted protocols")
+	}
+}
+
+// writeContentType sets the Content-Type of a /connect response to `contentType`, or to ConnectContentType if it is set.
+func (portal *Portal) writeContentType(w http.ResponseWriter, contentType string) {
+	if portal.ConnectContentType != "" {
+		contentType = portal.ConnectContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+}
+
+func (portal *Portal) disconnect(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
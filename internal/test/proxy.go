@@ -0,0 +1,110 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/eduvpn/eduvpn-common/internal/proxy"
+)
+
+// ForwardProxy is a local stand-in for an HTTP proxy.
+// It forwards plain HTTP requests and resolves the host names ending in .test, such as the Host of a Portal, to 127.0.0.1.
+type ForwardProxy struct {
+	// server is the underlying HTTP test server
+	server *httptest.Server
+
+	// transport forwards the requests, it never uses a proxy itself
+	transport *http.Transport
+
+	// mu protects the requests as the proxy is accessed by multiple goroutines
+	mu sync.Mutex
+
+	// requests are the requests that were forwarded in the form "METHOD URL"
+	requests []string
+}
+
+// NewForwardProxy creates and starts a forward proxy.
+// The proxy must be closed with Close.
+func NewForwardProxy() *ForwardProxy {
+	dialer := &net.Dialer{}
+	fp := &ForwardProxy{
+		transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				host, port, splitErr := net.SplitHostPort(address)
+				if splitErr != nil {
+					return nil, splitErr
+				}
+				if strings.HasSuffix(host, ".test") {
+					address = net.JoinHostPort("127.0.0.1", port)
+				}
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+	}
+	fp.server = httptest.NewServer(http.HandlerFunc(fp.forward))
+	return fp
+}
+
+// URL returns the URL of the proxy, e.g. http://127.0.0.1:1234.
+func (fp *ForwardProxy) URL() string {
+	return fp.server.URL
+}
+
+// Close shuts the proxy down.
+func (fp *ForwardProxy) Close() {
+	fp.server.Close()
+	fp.transport.CloseIdleConnections()
+}
+
+// Requests returns the requests that were forwarded in the form "METHOD URL".
+func (fp *ForwardProxy) Requests() []string {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return append([]string(nil), fp.requests...)
+}
+
+// Browser returns an HTTP client that uses the proxy like a browser, except for the OAuth callback on the loopback address.
+func (fp *ForwardProxy) Browser() *http.Client {
+	proxyFunc, _ := proxy.New(proxy.Config{URL: fp.URL()})
+	return &http.Client{Transport: &http.Transport{Proxy: proxyFunc}}
+}
+
+// forward forwards a request in the absolute form that a client sends to a proxy.
+func (fp *ForwardProxy) forward(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect || !r.URL.IsAbs() {
+		http.Error(w, "only absolute HTTP requests are forwarded", http.StatusMethodNotAllowed)
+		return
+	}
+	fp.mu.Lock()
+	fp.requests = append(fp.requests, fmt.Sprintf("%s %s", r.Method, (&url.URL{Scheme: r.URL.Scheme, Host: r.URL.Host, Path: r.URL.Path}).String()))
+	fp.mu.Unlock()
+
+	outReq, reqErr := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), r.Body)
+	if reqErr != nil {
+		http.Error(w, reqErr.Error(), http.StatusBadRequest)
+		return
+	}
+	outReq.Header = r.Header.Clone()
+	outReq.Header.Del("Proxy-Connection")
+	outReq.ContentLength = r.ContentLength
+	resp, respErr := fp.transport.RoundTrip(outReq)
+	if respErr != nil {
+		http.Error(w, respErr.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"flag"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/client"
+	httpw "github.com/eduvpn/eduvpn-common/internal/http"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+)
+
+// recordFixtures records the fixtures in testdata against the test portal instead of replaying them:
+// go test ./internal/test -run TestReplayFlows -record
+var recordFixtures = flag.Bool("record", false, "record the replay fixtures in testdata against the test portal")
+
+// replayURL is the server URL of the replayed flows, the fixtures do not depend on it.
+const replayURL = "https://vpn.example.org/"
+
+// oauthCallback returns whether or not `req` is the OAuth callback to the client, which is never recorded nor replayed.
+func oauthCallback(req *http.Request) bool {
+	return req.URL.Path == "/callback"
+}
+
+// TestReplayFlows replays the recorded sessions of adding a server, OAuth, /info and /connect without a portal.
+// They are regression fixtures for the requests that the client does and the responses it understands.
+// The fixtures in testdata are recorded against the Portal stand-in of this package, not against a real vpn-user-portal,
+// so they only show the behaviour of the real portal as far as Portal mimics it and must be recorded again when Portal changes.
+func TestReplayFlows(t *testing.T) {
+	tests := []struct {
+		name              string
+		fixture           string
+		profiles          []server.Profile
+		supportsWireGuard bool
+		script            []Answer
+		wantConfigType    string
+	}{
+		{
+			name:           "OpenVPN",
+			fixture:        "add_server_openvpn.json",
+			profiles:       []server.Profile{openVPNProfile},
+			script:         []Answer{CompleteOAuth()},
+			wantConfigType: "openvpn",
+		},
+		{
+			name:              "WireGuard",
+			fixture:           "add_server_wireguard.json",
+			profiles:          []server.Profile{openVPNProfile, wireGuardProfile},
+			supportsWireGuard: true,
+			script:            []Answer{CompleteOAuth(), PickProfile(wireGuardProfile.ID)},
+			wantConfigType:    "wireguard",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join("testdata", test.fixture)
+			serverURL := replayURL
+			var transport http.RoundTripper
+			var done func()
+			if *recordFixtures {
+				portal := NewPortal(test.profiles...)
+				defer portal.Close()
+				serverURL = portal.URL()
+				recorder := &httpw.Recorder{Skip: oauthCallback}
+				transport = recorder
+				done = func() {
+					if saveErr := recorder.Save(path); saveErr != nil {
+						t.Fatalf("Got save error: %v", saveErr)
+					}
+				}
+			} else {
+				fixture, loadErr := httpw.LoadFixture(path)
+				if loadErr != nil {
+					t.Fatalf("Got load error: %v", loadErr)
+				}
+				replayer := httpw.NewReplayer(fixture)
+				replayer.Skip = oauthCallback
+				transport = replayer
+				done = func() {
+					if unused := replayer.Unused(); len(unused) > 0 {
+						t.Fatalf("Got %d exchanges that were not replayed, the first: %s %s", len(unused), unused[0].Request.Method, unused[0].Request.URL)
+					}
+				}
+			}
+
+			driver := NewDriver(t, testClientID)
+			driver.Client.SupportsWireguard = test.supportsWireGuard
+			driver.Client.SetHTTPOptions(client.HTTPOptions{Transport: transport})
+			// The browser completes OAuth with the same transport, such that the authorization is recorded as well
+			driver.Browser = &http.Client{Transport: transport}
+			driver.Script(test.script...)
+
+			if _, addErr := driver.Client.AddCustomServer(serverURL); addErr != nil {
+				t.Fatalf("Got add error: %v", addErr)
+			}
+			driver.Wait()
+			result, configErr := driver.Client.GetConfigCustomServer(serverURL, false)
+			if configErr != nil {
+				t.Fatalf("Got config error: %v", configErr)
+			}
+			if result.Protocol != test.wantConfigType {
+				t.Fatalf("Got protocol: %s, want: %s", result.Protocol, test.wantConfigType)
+			}
+			driver.ExpectScriptDone()
+			done()
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package validate checks that the configurations returned by /connect match what was requested
+package validate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/eduvpn/eduvpn-common/internal/customize"
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+)
+
+// Kind is the kind of mismatch between a configuration and the request.
+type Kind string
+
+const (
+	// KindProtocol is a configuration with a protocol that was not requested or that the profile does not support
+	KindProtocol Kind = "protocol"
+
+	// KindStructure is a configuration that lacks a part that is needed to connect, e.g. the WireGuard peer or the OpenVPN remotes
+	KindStructure Kind = "structure"
+
+	// KindDefaultGateway is a configuration whose routes do not match the default gateway flag of the profile
+	KindDefaultGateway Kind = "default_gateway"
+
+	// KindPreferTCP is a configuration that does not connect over TCP first while TCP was preferred
+	KindPreferTCP Kind = "prefer_tcp"
+)
+
+// Request is what was asked for when the configuration was obtained.
+type Request struct {
+	// Profile is the profile the configuration was obtained for
+	Profile server.Profile
+
+	// SupportsWireGuard is whether or not the client accepted WireGuard configurations
+	SupportsWireGuard bool
+
+	// PreferTCP is whether or not TCP was preferred
+	PreferTCP bool
+}
+
+// Config is a configuration that was returned by /connect.
+type Config struct {
+	// Protocol is the protocol of the configuration, "openvpn" or "wireguard"
+	Protocol string
+
+	// WireGuard is the parsed WireGuard configuration, nil for OpenVPN
+	WireGuard *wireguard.Config
+
+	// OpenVPN is the parsed OpenVPN configuration, nil for WireGuard
+	OpenVPN *openvpn.Config
+}
+
+// Warning is a mismatch between the configuration and the request that does not stop the configuration from being used.
+type Warning struct {
+	// Kind is the kind of mismatch
+	Kind Kind `json:"kind"`
+
+	// Message describes the mismatch
+	Message string `json:"message"`
+}
+
+func (warning Warning) String() string {
+	return fmt.Sprintf("%s: %s", warning.Kind, warning.Message)
+}
+
+// MismatchError is returned when the configuration cannot be used for the request.
+type MismatchError struct {
+	Kind    Kind
+	Message string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("config does not match the request, %s: %s", e.Kind, e.Message)
+}
+
+// supports returns whether or not `profile` supports `protocol`.
+func supports(profile server.Profile, protocol string) bool {
+	for _, proto := range profile.VPNProtoList {
+		if proto == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// protocol checks the protocol of `config` against the request.
+func (request Request) protocol(config Config) ([]Warning, error) {
+	switch config.Protocol {
+	case "wireguard":
+		if config.WireGuard == nil {
+			return nil, &MismatchError{Kind: KindStructure, Message: "WireGuard config is not parsed"}
+		}
+	case "openvpn":
+		if config.OpenVPN == nil {
+			return nil, &MismatchError{Kind: KindStructure, Message: "OpenVPN config is not parsed"}
+		}
+	default:
+		return nil, &MismatchError{Kind: KindProtocol, Message: fmt.Sprintf("unknown protocol: %s", config.Protocol)}
+	}
+	if !supports(request.Profile, config.Protocol) {
+		return nil, &MismatchError{
+			Kind: KindProtocol,
+			Message: fmt.Sprintf(
+				"got protocol: %s for profile: %s with protocols: %s",
+				config.Protocol,
+				request.Profile.ID,
+				strings.Join(request.Profile.VPNProtoList, ", "),
+			),
+		}
+	}
+	if config.Protocol == "wireguard" && !request.SupportsWireGuard {
+		return nil, &MismatchError{Kind: KindProtocol, Message: "got a WireGuard config while WireGuard was not accepted"}
+	}
+
+	// The server chooses OpenVPN for profiles with both protocols only when TCP is preferred
+	var warnings []Warning
+	bothProtocols := supports(request.Profile, "openvpn") && supports(request.Profile, "wireguard")
+	if bothProtocols && request.SupportsWireGuard {
+		if config.Protocol == "openvpn" && !request.PreferTCP {
+			warnings = append(warnings, Warning{
+				Kind:    KindProtocol,
+				Message: fmt.Sprintf("got OpenVPN for profile: %s while WireGuard was accepted", request.Profile.ID),
+			})
+		}
+		if config.Protocol == "wireguard" && request.PreferTCP {
+			warnings = append(warnings, Warning{
+				Kind:    KindPreferTCP,
+				Message: fmt.Sprintf("got WireGuard, which only uses UDP, for profile: %s while TCP was preferred", request.Profile.ID),
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// defaultRoutes are the networks that a configuration for a default gateway profile must route through the tunnel.
+var defaultRoutes = []net.IPNet{
+	{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+	{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+}
+
+// wireGuard checks the WireGuard configuration `config` against the request.
+func (request Request) wireGuard(config *wireguard.Config) ([]Warning, error) {
+	if config.Interface.PrivateKey == nil {
+		return nil, &MismatchError{Kind: KindStructure, Message: "WireGuard config has no private key"}
+	}
+	if len(config.Interface.Addresses) == 0 {
+		return nil, &MismatchError{Kind: KindStructure, Message: "WireGuard config has no addresses"}
+	}
+	if len(config.Peers) == 0 {
+		return nil, &MismatchError{Kind: KindStructure, Message: "WireGuard config has no peer"}
+	}
+	var allowedIPs []net.IPNet
+	for _, peer := range config.Peers {
+		if peer.Endpoint == "" {
+			return nil, &MismatchError{Kind: KindStructure, Message: "WireGuard peer has no endpoint"}
+		}
+		allowedIPs = append(allowedIPs, peer.AllowedIPs...)
+	}
+	if len(allowedIPs) == 0 {
+		return nil, &MismatchError{Kind: KindStructure, Message: "WireGuard peer has no allowed IPs"}
+	}
+
+	// The IPv4 default route is required, the IPv6 one only if the tunnel has IPv6
+	hasIPv6 := false
+	for _, address := range config.Interface.Addresses {
+		if address.IP.To4() == nil {
+			hasIPv6 = true
+		}
+	}
+	var warnings []Warning
+	for _, route := range defaultRoutes {
+		if route.IP.To4() == nil && !hasIPv6 {
+			continue
+		}
+		// The route is covered if nothing is left after excluding the allowed IPs, this also handles split routes like 0.0.0.0/1 and 128.0.0.0/1
+		covered := len(customize.Exclude([]net.IPNet{route}, allowedIPs)) == 0
+		if request.Profile.DefaultGateway && !covered {
+			warnings = append(warnings, Warning{
+				Kind:    KindDefaultGateway,
+				Message: fmt.Sprintf("default gateway profile: %s has no route for %s", request.Profile.ID, route.String()),
+			})
+		}
+		if !request.Profile.DefaultGateway && covered {
+			warnings = append(warnings, Warning{
+				Kind:    KindDefaultGateway,
+				Message: fmt.Sprintf("profile: %s without default gateway routes %s", request.Profile.ID, route.String()),
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// openVPN checks the OpenVPN configuration `config` against the request.
+// The default gateway is not checked as the server pushes redirect-gateway when connecting.
+func (request Request) openVPN(config *openvpn.Config) ([]Warning, error) {
+	remotes := config.All("remote")
+	if len(remotes) == 0 {
+		return nil, &MismatchError{Kind: KindStructure, Message: "OpenVPN config has no remote"}
+	}
+	if !request.PreferTCP {
+		return nil, nil
+	}
+	// The remotes are tried in order so with TCP preferred the first one should be TCP
+	proto := ""
+	if first := remotes[0]; len(first.Args) >= 3 {
+		proto = first.Args[2]
+	} else if directive, ok := config.Get("proto"); ok && len(directive.Args) > 0 {
+		proto = directive.Args[0]
+	}
+	if strings.HasPrefix(proto, "tcp") {
+		return nil, nil
+	}
+	return []Warning{{Kind: KindPreferTCP, Message: "OpenVPN config does not connect over TCP first while TCP was preferred"}}, nil
+}
+
+// Validate checks the configuration `config` against the request.
+// It returns a *MismatchError if the configuration cannot be used and the warnings for the other mismatches.
+func Validate(request Request, config Config) ([]Warning, error) {
+	warnings, protocolErr := request.protocol(config)
+	if protocolErr != nil {
+		return nil, protocolErr
+	}
+	var configWarnings []Warning
+	var configErr error
+	if config.Protocol == "wireguard" {
+		configWarnings, configErr = request.wireGuard(config.WireGuard)
+	} else {
+		configWarnings, configErr = request.openVPN(config.OpenVPN)
+	}
+	if configErr != nil {
+		return nil, configErr
+	}
+	return append(warnings, configWarnings...), nil
+}
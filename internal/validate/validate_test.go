@@ -0,0 +1,148 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eduvpn/eduvpn-common/internal/openvpn"
+	"github.com/eduvpn/eduvpn-common/internal/server"
+	"github.com/eduvpn/eduvpn-common/internal/wireguard"
+)
+
+func parseWireGuard(t *testing.T, config string) *wireguard.Config {
+	t.Helper()
+	parsed, parseErr := wireguard.Parse(config)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	return parsed
+}
+
+func parseOpenVPN(t *testing.T, config string) *openvpn.Config {
+	t.Helper()
+	parsed, parseErr := openvpn.Parse(config)
+	if parseErr != nil {
+		t.Fatalf("Got parse error: %v", parseErr)
+	}
+	return parsed
+}
+
+func wireGuardConfig(allowedIPs string) string {
+	return `[Interface]
+PrivateKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=
+Address = 10.10.10.2/24, fd00::2/64
+
+[Peer]
+PublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=
+AllowedIPs = ` + allowedIPs + `
+Endpoint = vpn.example.org:51820
+`
+}
+
+func TestValidate(t *testing.T) {
+	both := server.Profile{ID: "internet", VPNProtoList: []string{"openvpn", "wireguard"}, DefaultGateway: true}
+	openVPNOnly := server.Profile{ID: "employees", VPNProtoList: []string{"openvpn"}}
+	wireGuardOnly := server.Profile{ID: "wireguard", VPNProtoList: []string{"wireguard"}, DefaultGateway: true}
+
+	tests := []struct {
+		name         string
+		request      Request
+		config       Config
+		wantErr      Kind
+		wantWarnings []Kind
+	}{
+		{
+			name:    "matching WireGuard config",
+			request: Request{Profile: both, SupportsWireGuard: true},
+			config:  Config{Protocol: "wireguard", WireGuard: parseWireGuard(t, wireGuardConfig("0.0.0.0/0, ::/0"))},
+		},
+		{
+			name:    "split default routes",
+			request: Request{Profile: both, SupportsWireGuard: true},
+			config: Config{
+				Protocol:  "wireguard",
+				WireGuard: parseWireGuard(t, wireGuardConfig("0.0.0.0/1, 128.0.0.0/1, ::/1, 8000::/1")),
+			},
+		},
+		{
+			name:         "default gateway without default route",
+			request:      Request{Profile: both, SupportsWireGuard: true},
+			config:       Config{Protocol: "wireguard", WireGuard: parseWireGuard(t, wireGuardConfig("10.0.0.0/8, ::/0"))},
+			wantWarnings: []Kind{KindDefaultGateway},
+		},
+		{
+			name:    "missing peer",
+			request: Request{Profile: wireGuardOnly, SupportsWireGuard: true},
+			config: Config{
+				Protocol:  "wireguard",
+				WireGuard: parseWireGuard(t, "[Interface]\nPrivateKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=\nAddress = 10.10.10.2/24\n"),
+			},
+			wantErr: KindStructure,
+		},
+		{
+			name:    "WireGuard profile returns OpenVPN",
+			request: Request{Profile: wireGuardOnly, SupportsWireGuard: true},
+			config:  Config{Protocol: "openvpn", OpenVPN: parseOpenVPN(t, "remote vpn.example.org 1194 udp\n")},
+			wantErr: KindProtocol,
+		},
+		{
+			name:    "WireGuard while not accepted",
+			request: Request{Profile: both},
+			config:  Config{Protocol: "wireguard", WireGuard: parseWireGuard(t, wireGuardConfig("0.0.0.0/0, ::/0"))},
+			wantErr: KindProtocol,
+		},
+		{
+			name:         "OpenVPN while WireGuard was accepted",
+			request:      Request{Profile: both, SupportsWireGuard: true},
+			config:       Config{Protocol: "openvpn", OpenVPN: parseOpenVPN(t, "remote vpn.example.org 1194 udp\n")},
+			wantWarnings: []Kind{KindProtocol},
+		},
+		{
+			name:    "OpenVPN with TCP first",
+			request: Request{Profile: both, SupportsWireGuard: true, PreferTCP: true},
+			config: Config{
+				Protocol: "openvpn",
+				OpenVPN:  parseOpenVPN(t, "remote vpn.example.org 1194 tcp\nremote vpn.example.org 1194 udp\n"),
+			},
+		},
+		{
+			name:         "OpenVPN with UDP first while TCP was preferred",
+			request:      Request{Profile: openVPNOnly, PreferTCP: true},
+			config:       Config{Protocol: "openvpn", OpenVPN: parseOpenVPN(t, "remote vpn.example.org 1194 udp\n")},
+			wantWarnings: []Kind{KindPreferTCP},
+		},
+		{
+			name:    "OpenVPN with TCP as the default protocol",
+			request: Request{Profile: openVPNOnly, PreferTCP: true},
+			config:  Config{Protocol: "openvpn", OpenVPN: parseOpenVPN(t, "proto tcp-client\nremote vpn.example.org 1194\n")},
+		},
+		{
+			name:    "OpenVPN without remote",
+			request: Request{Profile: openVPNOnly},
+			config:  Config{Protocol: "openvpn", OpenVPN: parseOpenVPN(t, "dev tun\nclient\n")},
+			wantErr: KindStructure,
+		},
+	}
+
+	for _, test := range tests {
+		warnings, validateErr := Validate(test.request, test.config)
+		if test.wantErr != "" {
+			var mismatchErr *MismatchError
+			if !errors.As(validateErr, &mismatchErr) || mismatchErr.Kind != test.wantErr {
+				t.Fatalf("%s: got error: %v, want a mismatch error of kind: %s", test.name, validateErr, test.wantErr)
+			}
+			continue
+		}
+		if validateErr != nil {
+			t.Fatalf("%s: got error: %v", test.name, validateErr)
+		}
+		if len(warnings) != len(test.wantWarnings) {
+			t.Fatalf("%s: got warnings: %v, want kinds: %v", test.name, warnings, test.wantWarnings)
+		}
+		for i, warning := range warnings {
+			if warning.Kind != test.wantWarnings[i] {
+				t.Fatalf("%s: got warnings: %v, want kinds: %v", test.name, warnings, test.wantWarnings)
+			}
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package version defines the version of the library
+package version
+
+// Version is the version of the library, it is kept in sync with the Python wrapper and the RPM spec
+const Version = "0.1.0"
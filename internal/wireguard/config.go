@@ -0,0 +1,365 @@
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// KeyValue is a key with its value in a section of the configuration.
+// It is used to keep the keys that are not known by this package.
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Interface is the [Interface] section of a wg-quick configuration.
+type Interface struct {
+	// PrivateKey is the private key of the interface, nil if the config does not have one yet
+	PrivateKey *wgtypes.Key `json:"private_key,omitempty"`
+
+	// Addresses are the addresses of the interface including the prefix length, e.g. 10.0.0.2/24
+	Addresses []net.IPNet `json:"addresses"`
+
+	// DNS are the DNS servers
+	DNS []net.IP `json:"dns"`
+
+	// DNSSearch are the search domains, these are given in the DNS key as well
+	DNSSearch []string `json:"dns_search"`
+
+	// MTU is the MTU of the interface, zero if not set
+	MTU int `json:"mtu,omitempty"`
+
+	// ListenPort is the UDP port to listen on, zero if not set
+	ListenPort int `json:"listen_port,omitempty"`
+
+	// Extra are the keys that are not known, in the order they appeared
+	Extra []KeyValue `json:"extra,omitempty"`
+}
+
+// Peer is a [Peer] section of a wg-quick configuration.
+type Peer struct {
+	// PublicKey is the public key of the peer
+	PublicKey wgtypes.Key `json:"public_key"`
+
+	// PresharedKey is the optional preshared key
+	PresharedKey *wgtypes.Key `json:"preshared_key,omitempty"`
+
+	// AllowedIPs are the networks that are routed to the peer
+	AllowedIPs []net.IPNet `json:"allowed_ips"`
+
+	// Endpoint is the host:port of the peer, the host can also be a hostname
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// PersistentKeepalive is the keepalive interval in seconds, zero if not set
+	PersistentKeepalive int `json:"persistent_keepalive,omitempty"`
+
+	// Extra are the keys that are not known, in the order they appeared
+	Extra []KeyValue `json:"extra,omitempty"`
+}
+
+// Config is a parsed wg-quick configuration.
+type Config struct {
+	Interface Interface `json:"interface"`
+	Peers     []Peer    `json:"peers"`
+}
+
+// splitList splits a comma separated list into its trimmed values.
+func splitList(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// parseAddress parses an address with a prefix length, keeping the host part of the address.
+// An address without a prefix length is a single host.
+func parseAddress(value string) (net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return net.IPNet{}, fmt.Errorf("invalid IP address: %s", value)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	ip, network, parseErr := net.ParseCIDR(value)
+	if parseErr != nil {
+		return net.IPNet{}, parseErr
+	}
+	if ip.To4() != nil {
+		ip = ip.To4()
+	}
+	return net.IPNet{IP: ip, Mask: network.Mask}, nil
+}
+
+// parseAddresses parses a comma separated list of addresses.
+func parseAddresses(value string) ([]net.IPNet, error) {
+	var addresses []net.IPNet
+	for _, part := range splitList(value) {
+		address, addressErr := parseAddress(part)
+		if addressErr != nil {
+			return nil, addressErr
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// parseKey parses a base64 WireGuard key.
+func parseKey(value string) (*wgtypes.Key, error) {
+	key, keyErr := wgtypes.ParseKey(value)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	return &key, nil
+}
+
+// parseUint16 parses a number that must fit in 16 bits, such as a port or an interval.
+func parseUint16(value string) (int, error) {
+	number, numberErr := strconv.ParseUint(value, 10, 16)
+	if numberErr != nil {
+		return 0, numberErr
+	}
+	return int(number), nil
+}
+
+// parseKey fills the interface with the `key` and `value`.
+func (iface *Interface) parseKey(key string, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "privatekey":
+		iface.PrivateKey, err = parseKey(value)
+	case "address":
+		var addresses []net.IPNet
+		addresses, err = parseAddresses(value)
+		iface.Addresses = append(iface.Addresses, addresses...)
+	case "dns":
+		for _, part := range splitList(value) {
+			if ip := net.ParseIP(part); ip != nil {
+				iface.DNS = append(iface.DNS, ip)
+			} else {
+				iface.DNSSearch = append(iface.DNSSearch, part)
+			}
+		}
+	case "mtu":
+		iface.MTU, err = parseUint16(value)
+	case "listenport":
+		iface.ListenPort, err = parseUint16(value)
+	default:
+		iface.Extra = append(iface.Extra, KeyValue{Key: key, Value: value})
+	}
+	return err
+}
+
+// parseKey fills the peer with the `key` and `value`.
+func (peer *Peer) parseKey(key string, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "publickey":
+		var publicKey *wgtypes.Key
+		publicKey, err = parseKey(value)
+		if publicKey != nil {
+			peer.PublicKey = *publicKey
+		}
+	case "presharedkey":
+		peer.PresharedKey, err = parseKey(value)
+	case "allowedips":
+		var allowedIPs []net.IPNet
+		allowedIPs, err = parseAddresses(value)
+		peer.AllowedIPs = append(peer.AllowedIPs, allowedIPs...)
+	case "endpoint":
+		_, _, err = net.SplitHostPort(value)
+		peer.Endpoint = value
+	case "persistentkeepalive":
+		if value == "off" {
+			peer.PersistentKeepalive = 0
+			return nil
+		}
+		peer.PersistentKeepalive, err = parseUint16(value)
+	default:
+		peer.Extra = append(peer.Extra, KeyValue{Key: key, Value: value})
+	}
+	return err
+}
+
+// Parse parses a wg-quick configuration.
+// The section names and keys are case insensitive, comments are removed.
+// Keys that are not known are kept such that the configuration can be written back.
+// It returns an error if the configuration is not valid, e.g. because a key is not valid base64.
+func Parse(config string) (*Config, error) {
+	parsed := &Config{}
+	// The section that is currently parsed, nil if not in a section yet
+	var parseKey func(string, string) error
+	var hasInterface bool
+
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			switch section := strings.ToLower(line[1 : len(line)-1]); section {
+			case "interface":
+				if hasInterface {
+					return nil, &ParseError{Line: lineNumber, Message: "duplicate [Interface] section"}
+				}
+				hasInterface = true
+				parseKey = parsed.Interface.parseKey
+			case "peer":
+				parsed.Peers = append(parsed.Peers, Peer{})
+				parseKey = parsed.Peers[len(parsed.Peers)-1].parseKey
+			default:
+				return nil, &ParseError{Line: lineNumber, Message: fmt.Sprintf("unknown section: %s", line)}
+			}
+			continue
+		}
+
+		if parseKey == nil {
+			return nil, &ParseError{Line: lineNumber, Message: "key outside of a section"}
+		}
+		equals := strings.Index(line, "=")
+		if equals < 0 {
+			return nil, &ParseError{Line: lineNumber, Message: fmt.Sprintf("no '=' in line: %s", line)}
+		}
+		key := strings.TrimSpace(line[:equals])
+		value := strings.TrimSpace(line[equals+1:])
+		if keyErr := parseKey(key, value); keyErr != nil {
+			return nil, &ParseError{
+				Line:    lineNumber,
+				Message: fmt.Sprintf("invalid value for %s: %v", key, keyErr),
+			}
+		}
+	}
+
+	if !hasInterface {
+		return nil, &ParseError{Message: "no [Interface] section"}
+	}
+	for i, peer := range parsed.Peers {
+		if peer.PublicKey == (wgtypes.Key{}) {
+			return nil, &ParseError{Message: fmt.Sprintf("peer %d has no public key", i+1)}
+		}
+	}
+	return parsed, nil
+}
+
+// joinAddresses joins addresses for writing them in the configuration.
+func joinAddresses(addresses []net.IPNet) string {
+	values := make([]string, len(addresses))
+	for i, address := range addresses {
+		values[i] = address.String()
+	}
+	return strings.Join(values, ", ")
+}
+
+// configWriter writes the lines of the configuration.
+type configWriter struct {
+	builder strings.Builder
+}
+
+// section starts a new section with `name`.
+func (writer *configWriter) section(name string) {
+	if writer.builder.Len() > 0 {
+		writer.builder.WriteString("\n")
+	}
+	fmt.Fprintf(&writer.builder, "[%s]\n", name)
+}
+
+// key writes `key` with `value`, empty values are not written.
+func (writer *configWriter) key(key string, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(&writer.builder, "%s = %s\n", key, value)
+}
+
+// extra writes an unknown key, unlike the known keys it is also written if its value is empty.
+func (writer *configWriter) extra(extra KeyValue) {
+	if extra.Value == "" {
+		fmt.Fprintf(&writer.builder, "%s =\n", extra.Key)
+		return
+	}
+	writer.key(extra.Key, extra.Value)
+}
+
+// number writes `key` with `value`, zero is not written.
+func (writer *configWriter) number(key string, value int) {
+	if value == 0 {
+		return
+	}
+	writer.key(key, strconv.Itoa(value))
+}
+
+// String serializes the configuration in the wg-quick format.
+// The result is normalized: the comments of the parsed configuration are dropped and the known keys are written first in a fixed order with their canonical names.
+// The unknown keys follow in the order they were parsed, also when their value is empty.
+func (config *Config) String() string {
+	writer := &configWriter{}
+
+	iface := &config.Interface
+	writer.section("Interface")
+	if iface.PrivateKey != nil {
+		writer.key("PrivateKey", iface.PrivateKey.String())
+	}
+	writer.key("Address", joinAddresses(iface.Addresses))
+	dns := make([]string, 0, len(iface.DNS)+len(iface.DNSSearch))
+	for _, ip := range iface.DNS {
+		dns = append(dns, ip.String())
+	}
+	dns = append(dns, iface.DNSSearch...)
+	writer.key("DNS", strings.Join(dns, ", "))
+	writer.number("MTU", iface.MTU)
+	writer.number("ListenPort", iface.ListenPort)
+	for _, extra := range iface.Extra {
+		writer.extra(extra)
+	}
+
+	for _, peer := range config.Peers {
+		writer.section("Peer")
+		writer.key("PublicKey", peer.PublicKey.String())
+		if peer.PresharedKey != nil {
+			writer.key("PresharedKey", peer.PresharedKey.String())
+		}
+		writer.key("AllowedIPs", joinAddresses(peer.AllowedIPs))
+		writer.key("Endpoint", peer.Endpoint)
+		writer.number("PersistentKeepalive", peer.PersistentKeepalive)
+		for _, extra := range peer.Extra {
+			writer.extra(extra)
+		}
+	}
+	return writer.builder.String()
+}
+
+// ParseError is returned when a WireGuard configuration cannot be parsed.
+type ParseError struct {
+	// Line is the line number of the error, zero if the error is not about a single line
+	Line int
+
+	// Message is the reason the configuration cannot be parsed
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("failed parsing WireGuard config: %s", e.Message)
+	}
+	return fmt.Sprintf("failed parsing WireGuard config on line %d: %s", e.Line, e.Message)
+}
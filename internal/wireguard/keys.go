@@ -0,0 +1,171 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/eduvpn/eduvpn-common/internal/secret"
+	"github.com/eduvpn/eduvpn-common/types"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// KeyPolicy defines if and for how long WireGuard keys are reused.
+type KeyPolicy struct {
+	// Reuse indicates that the key for a server and profile is reused for every configuration
+	// If false, a new key is generated for every configuration
+	Reuse bool
+
+	// RotateAfter is the duration after which a reused key is replaced by a new one
+	// Zero means that the key is only replaced when the server rejects it
+	RotateAfter time.Duration
+}
+
+// storedKey is a private key saved in the secret store together with the time it was generated.
+type storedKey struct {
+	PrivateKey string    `json:"private_key"`
+	Created    time.Time `json:"created"`
+}
+
+// KeyStore keeps the WireGuard keys for each server and profile in a secret store.
+// A nil KeyStore or a store with a policy that does not reuse keys generates a new key each time.
+type KeyStore struct {
+	// Secrets is the store where the keys are saved
+	Secrets secret.Store
+
+	// Policy is the policy for reusing the keys
+	Policy KeyPolicy
+}
+
+// secretName returns the name of the secret that has the keys for the server with `serverURL`.
+func secretName(serverURL string) string {
+	return "wireguard-keys:" + serverURL
+}
+
+// reuse returns whether or not keys are reused.
+func (store *KeyStore) reuse() bool {
+	return store != nil && store.Secrets != nil && store.Policy.Reuse
+}
+
+// load loads the keys for each profile of the server with `serverURL`.
+func (store *KeyStore) load(serverURL string) (map[string]storedKey, error) {
+	keys := make(map[string]storedKey)
+	value, getErr := store.Secrets.Get(secretName(serverURL))
+	if getErr != nil {
+		if secret.IsNotFound(getErr) {
+			return keys, nil
+		}
+		return nil, getErr
+	}
+	jsonErr := json.Unmarshal([]byte(value), &keys)
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	return keys, nil
+}
+
+// save saves the keys for each profile of the server with `serverURL`.
+func (store *KeyStore) save(serverURL string, keys map[string]storedKey) error {
+	if len(keys) == 0 {
+		return store.Secrets.Delete(secretName(serverURL))
+	}
+	value, jsonErr := json.Marshal(keys)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	return store.Secrets.Set(secretName(serverURL), string(value))
+}
+
+// Key returns the private key to use for the server with `serverURL` and the profile with `profileID`.
+// It returns a key from the store if keys are reused and it should not be rotated yet, otherwise a new key is generated and saved.
+// The boolean indicates whether or not the key was already used before.
+func (store *KeyStore) Key(serverURL string, profileID string) (wgtypes.Key, bool, error) {
+	errorMessage := "failed getting WireGuard key"
+	if !store.reuse() {
+		key, keyErr := GenerateKey()
+		return key, false, keyErr
+	}
+
+	keys, loadErr := store.load(serverURL)
+	if loadErr != nil {
+		return wgtypes.Key{}, false, types.NewWrappedError(errorMessage, loadErr)
+	}
+
+	stored, exists := keys[profileID]
+	rotateAfter := store.Policy.RotateAfter
+	if exists && (rotateAfter == 0 || time.Since(stored.Created) < rotateAfter) {
+		key, keyErr := wgtypes.ParseKey(stored.PrivateKey)
+		if keyErr != nil {
+			return wgtypes.Key{}, false, types.NewWrappedError(errorMessage, keyErr)
+		}
+		return key, true, nil
+	}
+
+	key, keyErr := GenerateKey()
+	if keyErr != nil {
+		return key, false, types.NewWrappedError(errorMessage, keyErr)
+	}
+	keys[profileID] = storedKey{PrivateKey: key.String(), Created: time.Now()}
+	saveErr := store.save(serverURL, keys)
+	if saveErr != nil {
+		return key, false, types.NewWrappedError(errorMessage, saveErr)
+	}
+	return key, false, nil
+}
+
+// Rotate removes the key for the server with `serverURL` and the profile with `profileID` such that the next key is a new one.
+func (store *KeyStore) Rotate(serverURL string, profileID string) error {
+	errorMessage := "failed rotating WireGuard key"
+	if store == nil || store.Secrets == nil {
+		return nil
+	}
+	keys, loadErr := store.load(serverURL)
+	if loadErr != nil {
+		return types.NewWrappedError(errorMessage, loadErr)
+	}
+	if _, exists := keys[profileID]; !exists {
+		return nil
+	}
+	delete(keys, profileID)
+	saveErr := store.save(serverURL, keys)
+	if saveErr != nil {
+		return types.NewWrappedError(errorMessage, saveErr)
+	}
+	return nil
+}
+
+// Remove removes all keys for the server with `serverURL`, e.g. because the server is removed.
+func (store *KeyStore) Remove(serverURL string) error {
+	if store == nil || store.Secrets == nil {
+		return nil
+	}
+	deleteErr := store.Secrets.Delete(secretName(serverURL))
+	if deleteErr != nil {
+		return types.NewWrappedError("failed removing WireGuard keys", deleteErr)
+	}
+	return nil
+}
+
+// Move moves all keys for the server with `from` to the server with `to`, e.g. because the server moved to a new URL.
+// The server still knows the public keys, so they are reused like before.
+func (store *KeyStore) Move(from string, to string) error {
+	errorMessage := "failed moving WireGuard keys"
+	if store == nil || store.Secrets == nil {
+		return nil
+	}
+	keys, loadErr := store.load(from)
+	if loadErr != nil {
+		return types.NewWrappedError(errorMessage, loadErr)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	saveErr := store.save(to, keys)
+	if saveErr != nil {
+		return types.NewWrappedError(errorMessage, saveErr)
+	}
+	deleteErr := store.Secrets.Delete(secretName(from))
+	if deleteErr != nil {
+		return types.NewWrappedError(errorMessage, deleteErr)
+	}
+	return nil
+}
@@ -2,9 +2,6 @@
 package wireguard
 
 import (
-	"fmt"
-	"regexp"
-
 	"github.com/eduvpn/eduvpn-common/types"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -22,18 +19,3 @@ func GenerateKey() (wgtypes.Key, error) {
 	}
 	return key, nil
 }
-
-// ConfigAddKey takes the WireGuard configuration and adds the PrivateKey to the right section
-// FIXME: Instead of doing a regex replace, decide if we should use a parser.
-func ConfigAddKey(config string, key wgtypes.Key) string {
-	interfaceSection := "[Interface]"
-	InterfaceSectionEscaped := regexp.QuoteMeta(interfaceSection)
-
-	// (?m) enables multi line mode
-	// ^ match from beginning of line
-	// $ match till end of line
-	// So it matches [Interface] section exactly
-	InterfaceRe := regexp.MustCompile(fmt.Sprintf("(?m)^%s$", InterfaceSectionEscaped))
-	toReplace := fmt.Sprintf("%s\nPrivateKey = %s", interfaceSection, key.String())
-	return InterfaceRe.ReplaceAllString(config, toReplace)
-}
@@ -1,46 +1,176 @@
 package wireguard
 
 import (
-	"fmt"
+	"errors"
 	"testing"
-)
+	"time"
 
-func Test_ConfigAddKey(t *testing.T) {
-	config := `
-[Interface]
+	"github.com/eduvpn/eduvpn-common/internal/secret"
+)
 
+func Test_ParseRoundTrip(t *testing.T) {
+	key, keyErr := GenerateKey()
+	if keyErr != nil {
+		t.Fatalf("WireGuard parse, generate key error: %v", keyErr)
+	}
+	config := `# Generated by the portal
 [interface]
+privatekey = ` + key.String() + `
+Address = 10.10.10.2/24, fd00:4242:4242:4242::2/64
+DNS = 9.9.9.9, 2620:fe::fe, example.org
+MTU = 1392
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PreDown =
+
+[Peer]
+PublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0= # the portal
+AllowedIPs = 0.0.0.0/0
+AllowedIPs = ::/0
+Endpoint = vpn.example.org:51820
+PersistentKeepalive = 25
+UnknownPeerKey = value
+`
+	want := `[Interface]
+PrivateKey = ` + key.String() + `
+Address = 10.10.10.2/24, fd00:4242:4242:4242::2/64
+DNS = 9.9.9.9, 2620:fe::fe, example.org
+MTU = 1392
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PreDown =
+
+[Peer]
+PublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = vpn.example.org:51820
+PersistentKeepalive = 25
+UnknownPeerKey = value
+`
 
-[interface2]
+	parsed, parseErr := Parse(config)
+	if parseErr != nil {
+		t.Fatalf("WireGuard parse error: %v", parseErr)
+	}
+	if parsed.Interface.PrivateKey == nil || *parsed.Interface.PrivateKey != key {
+		t.Fatalf("Got private key: %v, want: %v", parsed.Interface.PrivateKey, key)
+	}
+	if len(parsed.Interface.DNS) != 2 || len(parsed.Interface.DNSSearch) != 1 {
+		t.Fatalf("Got DNS: %v, search: %v, want 2 servers and 1 search domain", parsed.Interface.DNS, parsed.Interface.DNSSearch)
+	}
+	if len(parsed.Peers) != 1 || len(parsed.Peers[0].AllowedIPs) != 2 {
+		t.Fatalf("Got peers: %v, want 1 peer with 2 allowed IPs", parsed.Peers)
+	}
+	if got := parsed.String(); got != want {
+		t.Fatalf("Got: %s, Want: %s", got, want)
+	}
 
-interface
+	// Parsing the output again gives the same output
+	reparsed, reparseErr := Parse(want)
+	if reparseErr != nil {
+		t.Fatalf("WireGuard reparse error: %v", reparseErr)
+	}
+	if got := reparsed.String(); got != want {
+		t.Fatalf("Got after reparse: %s, Want: %s", got, want)
+	}
+}
 
- [Interface]
+func Test_ParseSetKey(t *testing.T) {
+	// The portal sends a config without a private key
+	config := `[Interface]
+Address = 10.10.10.2/24
 
-[Interface]test
+[Peer]
+PublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=
+AllowedIPs = 0.0.0.0/0
+Endpoint = vpn.example.org:51820
 `
-	wgKey, wgKeyErr := GenerateKey()
-
-	if wgKeyErr != nil {
-		t.Fatalf("WireGuard config add key, generate key error: %v", wgKeyErr)
+	parsed, parseErr := Parse(config)
+	if parseErr != nil {
+		t.Fatalf("WireGuard parse error: %v", parseErr)
 	}
-	expectedConfig := fmt.Sprintf(`
-[Interface]
-PrivateKey = %s
+	if parsed.Interface.PrivateKey != nil {
+		t.Fatalf("Got private key: %v, want none", parsed.Interface.PrivateKey)
+	}
+	key, keyErr := GenerateKey()
+	if keyErr != nil {
+		t.Fatalf("WireGuard parse, generate key error: %v", keyErr)
+	}
+	parsed.Interface.PrivateKey = &key
 
-[interface]
+	want := `[Interface]
+PrivateKey = ` + key.String() + `
+Address = 10.10.10.2/24
 
-[interface2]
+[Peer]
+PublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=
+AllowedIPs = 0.0.0.0/0
+Endpoint = vpn.example.org:51820
+`
+	if got := parsed.String(); got != want {
+		t.Fatalf("Got: %s, Want: %s", got, want)
+	}
+}
 
-interface
+func Test_ParseInvalid(t *testing.T) {
+	tests := []struct {
+		config string
+		line   int
+	}{
+		{"[Interface]\nPrivateKey = notbase64\n", 2},
+		{"[Interface]\n[Peer]\nPublicKey = dG9vc2hvcnQ=\n", 3},
+		{"[Interface]\n[Peer]\nAllowedIPs = 0.0.0.0/0\n", 0},
+		{"[Interface]\nAddress = 10.10.10.300/24\n", 2},
+		{"[Interface]\nMTU = -1\n", 2},
+		{"[Interface]\n[Peer]\nPublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=\nEndpoint = vpn.example.org\n", 4},
+		{"Address = 10.10.10.2/24\n", 1},
+		{"[Interface]\nAddress\n", 2},
+		{"[Interface]\n[Interface]\n", 2},
+		{"[Interface2]\n", 1},
+		{"[Peer]\nPublicKey = 6+sY4WmbEgfSmPQuumMDPl8NdsZBkSoRfq8LSFtWYh0=\n", 0},
+	}
 
- [Interface]
+	for _, test := range tests {
+		_, parseErr := Parse(test.config)
+		var parseError *ParseError
+		if !errors.As(parseErr, &parseError) {
+			t.Fatalf("Got error: %v, want a parse error for config: %s", parseErr, test.config)
+		}
+		if parseError.Line != test.line {
+			t.Fatalf("Got error on line: %d, want: %d for config: %s", parseError.Line, test.line, test.config)
+		}
+	}
+}
 
-[Interface]test
-`, wgKey.String())
-	gotConfig := ConfigAddKey(config, wgKey)
+func Test_KeyStore(t *testing.T) {
+	keys := &KeyStore{Secrets: &secret.MemoryStore{}, Policy: KeyPolicy{Reuse: true}}
+	first, reused, keyErr := keys.Key("https://example.org/", "internet")
+	if keyErr != nil || reused {
+		t.Fatalf("Got key reused: %v, error: %v, want a new key", reused, keyErr)
+	}
+	second, reused, keyErr := keys.Key("https://example.org/", "internet")
+	if keyErr != nil || !reused || second != first {
+		t.Fatalf("Got key reused: %v, error: %v, want the first key", reused, keyErr)
+	}
+	other, reused, keyErr := keys.Key("https://example.org/", "employees")
+	if keyErr != nil || reused || other == first {
+		t.Fatalf("Got key reused: %v, error: %v, want a new key for another profile", reused, keyErr)
+	}
 
-	if gotConfig != expectedConfig {
-		t.Fatalf("Got: %s, Want: %s", gotConfig, expectedConfig)
+	// The key is rotated on schedule
+	keys.Policy.RotateAfter = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	rotated, reused, keyErr := keys.Key("https://example.org/", "internet")
+	if keyErr != nil || reused || rotated == first {
+		t.Fatalf("Got key reused: %v, error: %v, want a rotated key", reused, keyErr)
+	}
+
+	// Without reuse every key is new and nothing is saved
+	keys = &KeyStore{Secrets: &secret.MemoryStore{}}
+	first, _, _ = keys.Key("https://example.org/", "internet")
+	second, reused, _ = keys.Key("https://example.org/", "internet")
+	if reused || second == first {
+		t.Fatalf("Got key reused: %v, want a new key without reuse", reused)
+	}
+	if _, getErr := keys.Secrets.Get(secretName("https://example.org/")); !secret.IsNotFound(getErr) {
+		t.Fatalf("Got error: %v, want no saved keys without reuse", getErr)
 	}
 }
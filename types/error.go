@@ -96,3 +96,54 @@ func ErrorLevel(err error) ErrLevel {
 	}
 	return ErrOther
 }
+
+// ErrCode is a stable code for an error that frontends can branch on, e.g. to show an actionable message.
+// The codes do not change between versions, unlike the messages.
+type ErrCode string
+
+const (
+	// ErrCodeNone is the code of an error without a specific code.
+	ErrCodeNone ErrCode = ""
+
+	// ErrCodeProfileNotAvailable is the code of the server error that the profile is not available to the user.
+	ErrCodeProfileNotAvailable ErrCode = "profile_not_available"
+
+	// ErrCodeAccountDisabled is the code of the server error that the account of the user is disabled.
+	ErrCodeAccountDisabled ErrCode = "account_disabled"
+
+	// ErrCodeTooManyConnections is the code of the server error that the user has reached the maximum number of connections.
+	ErrCodeTooManyConnections ErrCode = "too_many_connections"
+
+	// ErrCodeInvalidPublicKey is the code of the server error that the WireGuard public key is invalid or already in use.
+	ErrCodeInvalidPublicKey ErrCode = "invalid_public_key"
+
+	// ErrCodeInvalidGrant is the code of the OAuth error that the authorization code or refresh token is invalid, the user has to authorize again.
+	ErrCodeInvalidGrant ErrCode = "invalid_grant"
+
+	// ErrCodeInvalidToken is the code of the OAuth error that the access token is invalid.
+	ErrCodeInvalidToken ErrCode = "invalid_token"
+
+	// ErrCodePinMismatch is the code of the error that the TLS certificate of a server does not match its pinned public keys.
+	ErrCodePinMismatch ErrCode = "certificate_pin_mismatch"
+
+	// ErrCodeEndpointNotAllowed is the code of the error that a server uses an endpoint or redirect that does not use HTTPS or is on another origin.
+	ErrCodeEndpointNotAllowed ErrCode = "endpoint_not_allowed"
+
+	// ErrCodeUnknown is the code of any other error that the server returned, its message has no specific code.
+	ErrCodeUnknown ErrCode = "unknown"
+)
+
+// codedError is an error with a stable code.
+type codedError interface {
+	error
+	Code() ErrCode
+}
+
+// ErrorCode returns the code of the first error with a code in the chain of `err`, ErrCodeNone if there is none.
+func ErrorCode(err error) ErrCode {
+	var coded codedError
+	if errors.As(err, &coded) {
+		return coded.Code()
+	}
+	return ErrCodeNone
+}
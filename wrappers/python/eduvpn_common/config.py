@@ -0,0 +1,109 @@
+from ctypes import CDLL, POINTER, c_void_p, cast
+from datetime import datetime
+from typing import List, Optional
+
+from eduvpn_common.server import Profile
+from eduvpn_common.types import cConfigResult
+
+
+class Certificate:
+    """The class that represents the client certificate of an OpenVPN config
+
+    :param: subject: str: The subject of the certificate
+    :param: not_after: int: The expiry time of the certificate in a Unix timestamp
+    """
+    def __init__(self, subject: str, not_after: int):
+        self.subject = subject
+        self.not_after = datetime.fromtimestamp(not_after)
+
+    def __str__(self):
+        return self.subject
+
+
+class ConfigResult:
+    """The class that represents an OpenVPN/WireGuard config with its session details
+
+    :param: protocol: str: The protocol of the config, 'openvpn' or 'wireguard'
+    :param: config: str: The config
+    :param: server_type: str: The type of the server, 'institute_access', 'secure_internet' or 'custom_server'
+    :param: identifier: str: The URL of the server or the organization ID for secure internet
+    :param: server_url: str: The base URL of the server the config was obtained from
+    :param: location: str: The secure internet location, empty for other server types
+    :param: profile: Profile: The profile the config was obtained for
+    :param: default_gateway: bool: Whether or not the config routes all traffic through the tunnel
+    :param: start_time: int: The time the config was obtained in a Unix timestamp
+    :param: end_time: int: The time the session expires in a Unix timestamp
+    :param: certificate: Optional[Certificate]: The client certificate of an OpenVPN config, defaults to None
+    :param: warnings: Optional[List[str]]: The mismatches between the config and the profile, defaults to None
+    """
+    def __init__(
+        self,
+        protocol: str,
+        config: str,
+        server_type: str,
+        identifier: str,
+        server_url: str,
+        location: str,
+        profile: Profile,
+        default_gateway: bool,
+        start_time: int,
+        end_time: int,
+        certificate: Optional[Certificate] = None,
+        warnings: Optional[List[str]] = None,
+    ):
+        self.protocol = protocol
+        self.config = config
+        self.server_type = server_type
+        self.identifier = identifier
+        self.server_url = server_url
+        self.location = location
+        self.profile = profile
+        self.default_gateway = default_gateway
+        self.start_time = datetime.fromtimestamp(start_time)
+        self.end_time = datetime.fromtimestamp(end_time)
+        self.certificate = certificate
+        self.warnings = warnings or []
+
+    def __str__(self):
+        return self.config
+
+
+def get_config_result(lib: CDLL, ptr: c_void_p) -> Optional[ConfigResult]:
+    """Convert a C config result structure to a Python usable config result
+
+    :param lib: CDLL: The Go shared library
+    :param ptr: c_void_p: The pointer to the C structure
+
+    :meta private:
+
+    :return: The config result if there is one
+    :rtype: Optional[ConfigResult]
+    """
+    if not ptr:
+        return None
+    result = cast(ptr, POINTER(cConfigResult)).contents
+    certificate = None
+    if result.certificate_subject is not None:
+        certificate = Certificate(
+            result.certificate_subject.decode("utf-8"), result.certificate_not_after
+        )
+    config_result = ConfigResult(
+        result.protocol.decode("utf-8"),
+        result.config.decode("utf-8"),
+        result.server_type.decode("utf-8"),
+        result.identifier.decode("utf-8"),
+        result.server_url.decode("utf-8"),
+        result.location.decode("utf-8"),
+        Profile(
+            result.profile_id.decode("utf-8"),
+            result.profile_display_name.decode("utf-8"),
+            result.default_gateway == 1,
+        ),
+        result.default_gateway == 1,
+        result.start_time,
+        result.end_time,
+        certificate,
+        [w for w in result.warnings.decode("utf-8").split("\n") if w],
+    )
+    lib.FreeConfigResult(ptr)
+    return config_result
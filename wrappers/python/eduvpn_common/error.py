@@ -9,15 +9,31 @@ class ErrorLevel(Enum):
     ERR_FATAL = 3
 
 
+class ErrorCode:
+    """The stable codes of errors that frontends can branch on, e.g. to show an actionable message"""
+    NONE = ""
+    PROFILE_NOT_AVAILABLE = "profile_not_available"
+    ACCOUNT_DISABLED = "account_disabled"
+    TOO_MANY_CONNECTIONS = "too_many_connections"
+    INVALID_PUBLIC_KEY = "invalid_public_key"
+    INVALID_GRANT = "invalid_grant"
+    INVALID_TOKEN = "invalid_token"
+    CERTIFICATE_PIN_MISMATCH = "certificate_pin_mismatch"
+    ENDPOINT_NOT_ALLOWED = "endpoint_not_allowed"
+    UNKNOWN = "unknown"
+
+
 class WrappedError(Exception):
     """An exception returned by the Go library
 
     :param: traceback: str: The traceback of the error including newlines
     :param: cause: str: The cause of the error as a message
     :param: level: ErrorLevel: The level of the error
+    :param: code: str: The stable code of the error, see ErrorCode, empty if there is none
     """
-    def __init__(self, traceback: str, cause: str, level: ErrorLevel):
+    def __init__(self, traceback: str, cause: str, level: ErrorLevel, code: str = ErrorCode.NONE):
         super(WrappedError, self).__init__(cause)
         self.traceback = traceback
         self.cause = cause
         self.level = level
+        self.code = code
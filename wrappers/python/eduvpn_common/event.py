@@ -1,3 +1,4 @@
+import json
 from ctypes import CDLL
 from typing import Any, Callable, Dict, List, Tuple
 
@@ -8,7 +9,7 @@ from eduvpn_common.server import (
     get_transition_server,
 )
 from eduvpn_common.state import State, StateType
-from eduvpn_common.types import get_ptr_string
+from eduvpn_common.types import get_error, get_ptr_string
 
 # The attribute that callback functions get
 EDUVPN_CALLBACK_PROPERTY = "_eduvpn_property_callback"
@@ -61,6 +62,10 @@ def convert_data(lib: CDLL, state: int, data: Any) -> None:
         State.CONNECTED,
     ]:
         return get_transition_server(lib, data)
+    if state is State.ERROR:
+        return get_error(lib, data)
+    if state is State.ASK_MIGRATION:
+        return json.loads(get_ptr_string(lib, data))
 
 
 class EventHandler(object):
@@ -0,0 +1,81 @@
+from ctypes import CDLL, POINTER, c_void_p, cast
+from typing import List
+
+from eduvpn_common.state import State
+from eduvpn_common.types import cFSMHistory, cFSMHistoryEntry
+
+
+class FSMHistoryEntry:
+    """The class that represents a single transition of the internal state machine
+
+    :param: time: int: The time the transition started as a Unix timestamp in nanoseconds
+    :param: from_state: State: The state before the transition
+    :param: to_state: State: The state the transition went to
+    :param: description: str: The description of the transition
+    :param: handled: bool: Whether or not the transition was handled by the client
+    :param: duration: int: How long the transition took in nanoseconds
+    :param: error: str: The reason why the transition was not taken, empty if it was taken
+    """
+    def __init__(
+        self,
+        time: int,
+        from_state: State,
+        to_state: State,
+        description: str,
+        handled: bool,
+        duration: int,
+        error: str,
+    ):
+        self.time = time
+        self.from_state = from_state
+        self.to_state = to_state
+        self.description = description
+        self.handled = handled
+        self.duration = duration
+        self.error = error
+
+    def __str__(self):
+        return f"{self.from_state.name} -> {self.to_state.name} ({self.description})"
+
+
+def get_fsm_history_entry(ptr) -> FSMHistoryEntry:
+    """Convert the C structure of a history entry to a Python usable structure
+
+    :param ptr: The pointer to the history entry C structure
+
+    :meta private:
+
+    :return: The history entry
+    :rtype: FSMHistoryEntry
+    """
+    entry = ptr.contents
+    return FSMHistoryEntry(
+        entry.time,
+        State(entry.from_state),
+        State(entry.to_state),
+        entry.description.decode("utf-8"),
+        entry.handled == 1,
+        entry.duration,
+        entry.error.decode("utf-8"),
+    )
+
+
+def get_fsm_history(lib: CDLL, ptr: c_void_p) -> List[FSMHistoryEntry]:
+    """Get the FSM history from the Go library as a C structure and return a Python usable structure
+
+    :param lib: CDLL: The Go shared library
+    :param ptr: c_void_p: The C pointer to the history structure
+
+    :meta private:
+
+    :return: The list of history entries
+    :rtype: List[FSMHistoryEntry]
+    """
+    if not ptr:
+        return []
+    returned = []
+    history = cast(ptr, POINTER(cFSMHistory)).contents
+    for i in range(history.total_entries):
+        returned.append(get_fsm_history_entry(history.entries[i]))
+    lib.FreeFSMHistory(ptr)
+    return returned
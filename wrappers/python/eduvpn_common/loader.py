@@ -1,13 +1,14 @@
 import pathlib
 import platform
 from collections import defaultdict
-from ctypes import CDLL, c_char_p, c_int, c_void_p, cdll
+from ctypes import CDLL, c_char_p, c_int, c_ulonglong, c_void_p, cdll
 
 from eduvpn_common import __version__
 from eduvpn_common.types import (
-    ConfigError,
     DataError,
+    VPNProgress,
     VPNStateChange,
+    VPNTrace,
 )
 
 
@@ -69,8 +70,10 @@ def initialize_functions(lib: CDLL) -> None:
     lib.FreeDiscoOrganizations.argtypes, lib.FreeDiscoOrganizations.restype = [
         c_void_p
     ], None
+    lib.FreeConfigResult.argtypes, lib.FreeConfigResult.restype = [c_void_p], None
     lib.FreeDiscoServers.argtypes, lib.FreeDiscoServers.restype = [c_void_p], None
     lib.FreeError.argtypes, lib.FreeError.restype = [c_void_p], None
+    lib.FreeFSMHistory.argtypes, lib.FreeFSMHistory.restype = [c_void_p], None
     lib.FreeProfiles.argtypes, lib.FreeProfiles.restype = [c_void_p], None
     lib.FreeSecureLocations.argtypes, lib.FreeSecureLocations.restype = [c_void_p], None
     lib.FreeServer.argtypes, lib.FreeServer.restype = [c_void_p], None
@@ -80,22 +83,23 @@ def initialize_functions(lib: CDLL) -> None:
         c_char_p,
         c_char_p,
         c_int,
-    ], ConfigError
+    ], DataError
     lib.GetConfigInstituteAccess.argtypes, lib.GetConfigInstituteAccess.restype = [
         c_char_p,
         c_char_p,
         c_int,
-    ], ConfigError
+    ], DataError
     lib.GetConfigSecureInternet.argtypes, lib.GetConfigSecureInternet.restype = [
         c_char_p,
         c_char_p,
         c_int,
-    ], ConfigError
+    ], DataError
     lib.GetDiscoOrganizations.argtypes, lib.GetDiscoOrganizations.restype = [
         c_char_p
     ], DataError
     lib.GetDiscoServers.argtypes, lib.GetDiscoServers.restype = [c_char_p], DataError
     lib.GetCurrentServer.argtypes, lib.GetCurrentServer.restype = [c_char_p], DataError
+    lib.GetFSMHistory.argtypes, lib.GetFSMHistory.restype = [c_char_p], DataError
     lib.GetSavedServers.argtypes, lib.GetSavedServers.restype = [c_char_p], DataError
     lib.GoBack.argtypes, lib.GoBack.restype = [c_char_p], None
     lib.InFSMState.argtypes, lib.InFSMState.restype = [c_void_p, c_int], int
@@ -133,6 +137,9 @@ def initialize_functions(lib: CDLL) -> None:
         c_char_p
     ], c_void_p
     lib.RenewSession.argtypes, lib.RenewSession.restype = [c_char_p], c_void_p
+    lib.HasSavedSession.argtypes, lib.HasSavedSession.restype = [c_char_p], int
+    lib.ResumeSession.argtypes, lib.ResumeSession.restype = [c_char_p, c_int], c_void_p
+    lib.DiscardSession.argtypes, lib.DiscardSession.restype = [c_char_p], c_void_p
     lib.SetConnected.argtypes, lib.SetConnected.restype = [c_char_p], c_void_p
     lib.SetConnecting.argtypes, lib.SetConnecting.restype = [c_char_p], c_void_p
     lib.SetDisconnected.argtypes, lib.SetDisconnected.restype = [
@@ -141,13 +148,78 @@ def initialize_functions(lib: CDLL) -> None:
     ], c_void_p
     lib.SetDisconnecting.argtypes, lib.SetDisconnecting.restype = [c_char_p], c_void_p
     lib.SetProfileID.argtypes, lib.SetProfileID.restype = [c_char_p, c_char_p], c_void_p
+    lib.ConfirmMigration.argtypes, lib.ConfirmMigration.restype = [c_char_p, c_int], c_void_p
     lib.SetSearchServer.argtypes, lib.SetSearchServer.restype = [c_char_p], c_void_p
     lib.SetSecureLocation.argtypes, lib.SetSecureLocation.restype = [
         c_char_p,
         c_char_p,
     ], c_void_p
+    lib.SetProgressCallback.argtypes, lib.SetProgressCallback.restype = [
+        c_char_p,
+        VPNProgress,
+    ], c_void_p
     lib.SetSupportWireguard.argtypes, lib.SetSupportWireguard.restype = [
         c_char_p,
         c_int,
     ], c_void_p
+    lib.SetWireGuardKeyPolicy.argtypes, lib.SetWireGuardKeyPolicy.restype = [
+        c_char_p,
+        c_int,
+        c_ulonglong,
+    ], c_void_p
+    lib.SetConfigCachePolicy.argtypes, lib.SetConfigCachePolicy.restype = [
+        c_char_p,
+        c_int,
+        c_ulonglong,
+    ], c_void_p
+    lib.SetHTTPOptions.argtypes, lib.SetHTTPOptions.restype = [
+        c_char_p,
+        c_char_p,
+        c_char_p,
+    ], c_void_p
+    lib.SetRetryPolicy.argtypes, lib.SetRetryPolicy.restype = [
+        c_char_p,
+        c_int,
+        c_int,
+        c_ulonglong,
+        c_ulonglong,
+    ], c_void_p
+    lib.SetProxy.argtypes, lib.SetProxy.restype = [
+        c_char_p,
+        c_char_p,
+        c_int,
+        c_char_p,
+    ], c_void_p
+    lib.SetAllowedOrigins.argtypes, lib.SetAllowedOrigins.restype = [
+        c_char_p,
+        c_char_p,
+    ], c_void_p
+    lib.SetServerPins.argtypes, lib.SetServerPins.restype = [
+        c_char_p,
+        c_char_p,
+        c_char_p,
+    ], c_void_p
+    lib.SetPinPolicy.argtypes, lib.SetPinPolicy.restype = [
+        c_char_p,
+        c_int,
+    ], c_void_p
+    lib.SetTracing.argtypes, lib.SetTracing.restype = [
+        c_char_p,
+        c_int,
+        VPNTrace,
+    ], c_void_p
+    lib.SetConfigTransforms.argtypes, lib.SetConfigTransforms.restype = [
+        c_char_p,
+        c_char_p,
+        c_char_p,
+        c_char_p,
+    ], c_void_p
+    lib.SetOpenVPNPolicy.argtypes, lib.SetOpenVPNPolicy.restype = [
+        c_char_p,
+        c_char_p,
+        c_int,
+        c_int,
+        c_int,
+        c_int,
+    ], c_void_p
     lib.ShouldRenewButton.argtypes, lib.ShouldRenewButton.restype = [], int
@@ -1,13 +1,16 @@
+import json
 import threading
 from ctypes import c_int
-from typing import Any, Callable, Dict, Iterator, List, Optional, Tuple
+from typing import Any, Callable, Dict, Iterator, List, Optional
 
+from eduvpn_common.config import ConfigResult, get_config_result
 from eduvpn_common.discovery import DiscoOrganizations, DiscoServers, get_disco_organizations, get_disco_servers
 from eduvpn_common.event import EventHandler
+from eduvpn_common.history import FSMHistoryEntry, get_fsm_history
 from eduvpn_common.loader import initialize_functions, load_lib
 from eduvpn_common.server import Profiles, Server, get_transition_server, get_servers
 from eduvpn_common.state import State, StateType
-from eduvpn_common.types import VPNStateChange, decode_res, encode_args, get_data_error
+from eduvpn_common.types import VPNProgress, VPNStateChange, VPNTrace, decode_res, encode_args, get_data_error
 
 
 class EduVPN(object):
@@ -30,12 +33,19 @@ class EduVPN(object):
 
         self.event_handler = EventHandler(self.lib)
 
+        # The callback for progress events, see set_progress_callback
+        self.progress_handler: Optional[Callable[[str, str, int], None]] = None
+
+        # The callback for the spans of the traced requests, see set_tracing
+        self.trace_handler: Optional[Callable[[Dict[str, Any]], None]] = None
+
         # Callbacks that need to wait for specific events
 
         # The ask profile callback needs to wait for the UI thread to select a profile
         # This is stored in the profile_event
         self.profile_event: Optional[threading.Event] = None
         self.location_event: Optional[threading.Event] = None
+        self.migration_event: Optional[threading.Event] = None
 
         @self.event.on(State.ASK_PROFILE, StateType.WAIT)
         def wait_profile_event(old_state: int, profiles: Profiles):
@@ -48,6 +58,17 @@ class EduVPN(object):
             if self.profile_event:
                 self.profile_event.wait()
 
+        @self.event.on(State.ASK_MIGRATION, StateType.WAIT)
+        def wait_migration_event(old_state: int, migration: Dict[str, str]):
+            """This functions waits until the migration thread event is finished
+
+            :param old_state: int: The old state of the migration event
+            :param migration: Dict[str, str]: The saved URL 'from' and the new URL 'to' of the server
+
+            """
+            if self.migration_event:
+                self.migration_event.wait()
+
         @self.event.on(State.ASK_LOCATION, StateType.WAIT)
         def wait_location_event(old_state: int, locations: List[str]):
             """This functions waits until the location thread event is finished
@@ -219,7 +240,7 @@ class EduVPN(object):
         if remove_err:
             raise remove_err
 
-    def get_config(self, identifier: str, func: Any, prefer_tcp: bool = False) -> Tuple[str, str]:
+    def get_config(self, identifier: str, func: Any, prefer_tcp: bool = False) -> ConfigResult:
         """Get an OpenVPN/WireGuard configuration from the server
 
         :param identifier: str: The identifier of the server, e.g. URL or ORG ID
@@ -230,27 +251,34 @@ class EduVPN(object):
 
         :raises WrappedError: An error by the Go library
 
-        :return: The configuration and configuration type ('openvpn' or 'wireguard')
-        :rtype: Tuple[str, str]
+        :return: The configuration with its protocol ('openvpn' or 'wireguard') and session details
+        :rtype: ConfigResult
         """
         # Because it could be the case that a profile callback is started, store a threading event
         # In the constructor, we have defined a wait event for Ask_Profile, this waits for this event to be set
         # The event is set in self.set_profile
         self.profile_event = threading.Event()
+        self.migration_event = threading.Event()
 
-        config, config_type, config_err = self.go_function(func, identifier, prefer_tcp)
+        config, config_err = self.go_function(
+            func,
+            identifier,
+            prefer_tcp,
+            decode_func=lambda lib, x: get_data_error(lib, x, get_config_result),
+        )
 
         self.profile_event = None
         self.location_event = None
+        self.migration_event = None
 
         if config_err:
             raise config_err
 
-        return config, config_type
+        return config
 
     def get_config_custom_server(
         self, url: str, prefer_tcp: bool = False
-    ) -> Tuple[str, str]:
+    ) -> ConfigResult:
         """Get an OpenVPN/WireGuard configuration from a custom server
 
         :param url: str: The URL of the custom server
@@ -258,14 +286,14 @@ class EduVPN(object):
 
         :raises WrappedError: An error by the Go library
 
-        :return: The configuration and configuration type ('openvpn' or 'wireguard')
-        :rtype: Tuple[str, str]
+        :return: The configuration with its protocol ('openvpn' or 'wireguard') and session details
+        :rtype: ConfigResult
         """
         return self.get_config(url, self.lib.GetConfigCustomServer, prefer_tcp)
 
     def get_config_institute_access(
         self, url: str, prefer_tcp: bool = False
-    ) -> Tuple[str, str]:
+    ) -> ConfigResult:
         """Get an OpenVPN/WireGuard configuration from an institute access server
 
         :param url: str: The URL of the institute access server. Use the one from Discovery
@@ -273,20 +301,23 @@ class EduVPN(object):
 
         :raises WrappedError: An error by the Go library
 
-        :return: The configuration and configuration type ('openvpn' or 'wireguard')
-        :rtype: Tuple[str, str]
+        :return: The configuration with its protocol ('openvpn' or 'wireguard') and session details
+        :rtype: ConfigResult
         """
         return self.get_config(url, self.lib.GetConfigInstituteAccess, prefer_tcp)
 
     def get_config_secure_internet(
         self, org_id: str, prefer_tcp: bool = False
-    ) -> Tuple[str, str]:
+    ) -> ConfigResult:
         """Get an OpenVPN/WireGuard configuration from a secure internet server
 
         :param org_id: str: The organization ID of the secure internet server. Use the one from Discovery
         :param prefer_tcp: bool:  (Default value = False): Whether or not to prefer TCP
 
         :raises WrappedError: An error by the Go library
+
+        :return: The configuration with its protocol ('openvpn' or 'wireguard') and session details
+        :rtype: ConfigResult
         """
         return self.get_config(org_id, self.lib.GetConfigSecureInternet, prefer_tcp)
 
@@ -372,6 +403,60 @@ class EduVPN(object):
         """
         return self.event_handler
 
+    def set_progress_callback(self, handler: Optional[Callable[[str, str, int], None]]) -> None:
+        """Set the callback for the progress of long-running operations, such as adding a server or getting a config.
+        This can be used to show a status within the loading server and request config states.
+        The client must be registered first
+
+        :param handler: Optional[Callable[[str, str, int], None]]: The callback that gets the step identifier, the server URL and the elapsed time in milliseconds. None removes the callback
+
+        :raises WrappedError: An error by the Go library
+        """
+        self.progress_handler = handler
+        callback = progress_callback if handler else VPNProgress()
+        progress_err = self.go_function(self.lib.SetProgressCallback, callback)
+
+        if progress_err:
+            raise progress_err
+
+    def progress(self, step: str, url: str, elapsed_ms: int) -> None:
+        """Run the progress callback
+
+        :param step: str: The identifier of the step, e.g. endpoints
+        :param url: str: The URL of the server
+        :param elapsed_ms: int: The elapsed time since the start of the operation in milliseconds
+
+        :meta private:
+        """
+        if self.progress_handler:
+            self.progress_handler(step, url, elapsed_ms)
+
+    def set_tracing(self, log: bool = False, handler: Optional[Callable[[Dict[str, Any]], None]] = None) -> None:
+        """Set how the outbound HTTP and OAuth requests are traced, e.g. to find out why connecting is slow.
+        The client must be registered first
+
+        :param log: bool: Whether or not every request is logged at debug level with its redacted URL, status, sizes and duration
+        :param handler: Optional[Callable[[Dict[str, Any]], None]]: The callback that gets every finished request as an OpenTelemetry-style span, with the keys trace_id, span_id, name, kind, start_time, end_time, status and attributes. None removes the callback
+
+        :raises WrappedError: An error by the Go library
+        """
+        self.trace_handler = handler
+        callback = trace_callback if handler else VPNTrace()
+        trace_err = self.go_function(self.lib.SetTracing, log, callback)
+
+        if trace_err:
+            raise trace_err
+
+    def trace(self, span: Dict[str, Any]) -> None:
+        """Run the trace callback
+
+        :param span: Dict[str, Any]: The span of the request
+
+        :meta private:
+        """
+        if self.trace_handler:
+            self.trace_handler(span)
+
     def callback(self, old_state: State, new_state: State, data: Any) -> bool:
         """Run an event callback
 
@@ -400,6 +485,24 @@ class EduVPN(object):
         if profile_err:
             raise profile_err
 
+    def confirm_migration(self, accept: bool) -> None:
+        """Confirm or decline that a saved server moved to a new URL, this answers the ASK_MIGRATION state.
+        The tokens, profile choice and WireGuard keys of the server are kept when it is migrated.
+
+        :param accept: bool: Whether or not the server is migrated to its new URL
+
+        :raises WrappedError: An error by the Go library
+        """
+        migration_err = self.go_function(self.lib.ConfirmMigration, accept)
+
+        # If there is a migration event, set it so that the wait callback finishes
+        # And so that the Go code can move to the next state
+        if self.migration_event:
+            self.migration_event.set()
+
+        if migration_err:
+            raise migration_err
+
     def change_secure_location(self) -> None:
         """Change the secure location. This calls the necessary events
 
@@ -440,6 +543,36 @@ class EduVPN(object):
         if renew_err:
             raise renew_err
 
+    def has_saved_session(self) -> bool:
+        """Whether or not there is a session that was saved before the client was restarted
+
+        :return: Whether or not there is a saved session that can be resumed or discarded
+        :rtype: bool
+        """
+        return self.go_function(self.lib.HasSavedSession)
+
+    def resume_session(self, connected: bool) -> None:
+        """Resume the session that was saved before the client was restarted
+
+        :param connected: bool: Whether or not the VPN connection is still up
+
+        :raises WrappedError: An error by the Go library
+        """
+        resume_err = self.go_function(self.lib.ResumeSession, connected)
+
+        if resume_err:
+            raise resume_err
+
+    def discard_session(self) -> None:
+        """Discard the session that was saved before the client was restarted. This calls /disconnect to the server
+
+        :raises WrappedError: An error by the Go library
+        """
+        discard_err = self.go_function(self.lib.DiscardSession)
+
+        if discard_err:
+            raise discard_err
+
     def set_support_wireguard(self, support: bool) -> None:
         """Indicates whether or not the OS supports WireGuard connections.
 
@@ -452,6 +585,207 @@ class EduVPN(object):
         if support_err:
             raise support_err
 
+    def set_wireguard_key_policy(self, reuse: bool, rotate_after: int = 0) -> None:
+        """Sets whether or not WireGuard keys are reused for each server and profile.
+        The keys are saved in the secret store and never in the state file.
+
+        :param reuse: bool: whether or not keys are reused
+        :param rotate_after: int: the number of seconds after which a reused key is replaced, 0 means only when the server rejects it
+
+        :raises WrappedError: An error by the Go library
+        """
+        policy_err = self.go_function(
+            self.lib.SetWireGuardKeyPolicy, reuse, rotate_after
+        )
+
+        if policy_err:
+            raise policy_err
+
+    def set_config_cache_policy(self, enabled: bool, min_remaining: int = 0) -> None:
+        """Sets whether or not configs are cached for each server and profile.
+        A cached config is reused instead of obtaining a new one from the server while its session is still valid.
+        The configs are saved in the secret store and never in the state file.
+
+        :param enabled: bool: whether or not configs are cached
+        :param min_remaining: int: the number of seconds the session of a cached config must still be valid to reuse it
+
+        :raises WrappedError: An error by the Go library
+        """
+        policy_err = self.go_function(
+            self.lib.SetConfigCachePolicy, enabled, min_remaining
+        )
+
+        if policy_err:
+            raise policy_err
+
+    def set_http_options(self, root_cas_file: str = "", min_tls_version: str = "") -> None:
+        """Sets the options of the HTTP client that is used for discovery and all servers.
+        The requests are sent with a User-Agent that includes the client ID and the library version.
+
+        :param root_cas_file: str: a PEM file with the trusted certificate authorities, empty means the roots of the system
+        :param min_tls_version: str: the minimum TLS version, '1.2' or '1.3', empty means TLS 1.2
+
+        :raises WrappedError: An error by the Go library
+        """
+        options_err = self.go_function(
+            self.lib.SetHTTPOptions, root_cas_file, min_tls_version
+        )
+
+        if options_err:
+            raise options_err
+
+    def set_retry_policy(
+        self,
+        idempotent_attempts: int = 0,
+        non_idempotent_attempts: int = 0,
+        base_delay: int = 0,
+        max_delay: int = 0,
+    ) -> None:
+        """Sets how often and how long after a failed request it is retried.
+        Requests are retried with exponential backoff and jitter, a Retry-After from the server is honoured.
+        Zero values mean the defaults and 1 attempt disables retrying.
+
+        :param idempotent_attempts: int: the maximum number of attempts for GET requests, by default 3
+        :param non_idempotent_attempts: int: the maximum number of attempts for POST requests, by default 2. These are only retried if the server certainly did not process them
+        :param base_delay: int: the delay before the first retry in milliseconds, by default 500
+        :param max_delay: int: the maximum delay between attempts in milliseconds, by default 10000
+
+        :raises WrappedError: An error by the Go library
+        """
+        retry_err = self.go_function(
+            self.lib.SetRetryPolicy,
+            idempotent_attempts,
+            non_idempotent_attempts,
+            base_delay,
+            max_delay,
+        )
+
+        if retry_err:
+            raise retry_err
+
+    def set_proxy(
+        self, url: str = "", environment: bool = False, pac_file: str = ""
+    ) -> None:
+        """Sets the proxy that is used for discovery, well-known, token and API requests.
+        The OAuth loopback listener is never proxied and the VPN connections themselves are not affected.
+        At most one of the arguments can be given, none means the environment variables are used.
+
+        :param url: str: a proxy for all requests, e.g. 'http://proxy.example.org:3128' or 'socks5://127.0.0.1:1080'
+        :param environment: bool: whether or not the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used
+        :param pac_file: str: a proxy auto-config script whose FindProxyForURL function chooses the proxy
+
+        :raises WrappedError: An error by the Go library
+        """
+        proxy_err = self.go_function(self.lib.SetProxy, url, environment, pac_file)
+
+        if proxy_err:
+            raise proxy_err
+
+    def set_allowed_origins(self, origins: List[str]) -> None:
+        """Sets the origins, besides the origin of a server, that its API and token endpoints and redirects can be on.
+        By default these must be on the origin of the server and use HTTPS, except for a server on the loopback address.
+        A violation raises a WrappedError with the code ErrorCode.ENDPOINT_NOT_ALLOWED.
+
+        :param origins: List[str]: the origins in the form 'scheme://host[:port]', an allowed origin can also use plain HTTP
+
+        :raises WrappedError: An error by the Go library
+        """
+        origins_err = self.go_function(self.lib.SetAllowedOrigins, ",".join(origins))
+
+        if origins_err:
+            raise origins_err
+
+    def set_server_pins(self, url: str, pins: List[str]) -> None:
+        """Sets the pinned public keys of the TLS certificate of a server.
+        The certificate chain must contain at least one of the pins, a backup pin allows rotating the keys.
+        A mismatch raises a WrappedError with the code ErrorCode.CERTIFICATE_PIN_MISMATCH.
+
+        :param url: str: the URL of the server
+        :param pins: List[str]: the base64 encoded SHA-256 hashes of the SubjectPublicKeyInfo, optionally prefixed with 'sha256/'. An empty list removes the pins
+
+        :raises WrappedError: An error by the Go library
+        """
+        pin_err = self.go_function(self.lib.SetServerPins, url, "\n".join(pins))
+
+        if pin_err:
+            raise pin_err
+
+    def set_pin_policy(self, learn_on_first_use: bool = False) -> None:
+        """Sets whether the TLS public keys of servers without pins are learned when they are first authorized.
+
+        :param learn_on_first_use: bool: whether or not such a server is pinned to the keys of its certificate and issuer
+
+        :raises WrappedError: An error by the Go library
+        """
+        policy_err = self.go_function(self.lib.SetPinPolicy, learn_on_first_use)
+
+        if policy_err:
+            raise policy_err
+
+    def set_openvpn_policy(
+        self,
+        management_address: str = "",
+        management_port: int = 0,
+        remove_script_security: bool = False,
+        remote_random: bool = False,
+        force_tcp: bool = False,
+    ) -> None:
+        """Sets the rewrites that are applied to OpenVPN configs.
+        Configs with directives that could run code, such as up, down and plugin, are always rejected.
+
+        :param management_address: str: the address of the management interface to add, empty for none
+        :param management_port: int: the port of the management interface, 0 for a unix socket
+        :param remove_script_security: bool: whether or not to remove the script-security directives
+        :param remote_random: bool: whether or not to try the remotes in a random order
+        :param force_tcp: bool: whether or not to only keep the TCP remotes
+
+        :raises WrappedError: An error by the Go library
+        """
+        policy_err = self.go_function(
+            self.lib.SetOpenVPNPolicy,
+            management_address,
+            management_port,
+            remove_script_security,
+            remote_random,
+            force_tcp,
+        )
+
+        if policy_err:
+            raise policy_err
+
+    def set_config_transforms(
+        self,
+        server_url: str,
+        profile_id: str = "",
+        mtu: int = 0,
+        dns_search: Optional[List[str]] = None,
+        exclude_routes: Optional[List[str]] = None,
+    ) -> None:
+        """Sets the transforms that are applied to the configs for a server and profile.
+        The transforms are saved in the state file.
+
+        :param server_url: str: the base URL of the server
+        :param profile_id: str: the profile ID, empty for all profiles of the server without their own transforms
+        :param mtu: int: the MTU override, 0 to keep the MTU of the server
+        :param dns_search: Optional[List[str]]: the search domains to add
+        :param exclude_routes: Optional[List[str]]: the networks that are not routed through the tunnel, e.g. the LAN subnet
+
+        :raises WrappedError: An error by the Go library
+        """
+        transforms = json.dumps(
+            {
+                "mtu": mtu,
+                "dns_search": dns_search or [],
+                "exclude_routes": exclude_routes or [],
+            }
+        )
+        transforms_err = self.go_function(
+            self.lib.SetConfigTransforms, server_url, profile_id, transforms
+        )
+
+        if transforms_err:
+            raise transforms_err
+
     def should_renew_button(self) -> bool:
         """Whether or not the UI should show the renew button
 
@@ -486,6 +820,23 @@ class EduVPN(object):
 
         return server
 
+    def get_fsm_history(self) -> List[FSMHistoryEntry]:
+        """Get the last transitions of the internal state machine, the oldest first.
+        This is useful for debugging flows that got stuck
+
+        :return: The list of history entries
+        :rtype: List[FSMHistoryEntry]
+        """
+        history, history_err = self.go_function(
+            self.lib.GetFSMHistory,
+            decode_func=lambda lib, x: get_data_error(lib, x, get_fsm_history),
+        )
+
+        if history_err:
+            raise history_err
+
+        return history
+
     def get_saved_servers(self) -> Optional[List[Server]]:
         """Get a list of saved servers
 
@@ -526,6 +877,38 @@ def state_callback(name: bytes, old_state: int, new_state: int, data: Any) -> in
     return 0
 
 
+@VPNProgress
+def progress_callback(name: bytes, step: bytes, url: bytes, elapsed_ms: int) -> None:
+    """The internal progress callback that is passed to the Go library
+
+    :param name: bytes: The name of the client
+    :param step: bytes: The identifier of the step
+    :param url: bytes: The URL of the server
+    :param elapsed_ms: int: The elapsed time in milliseconds
+
+    :meta private:
+    """
+    name_decoded = name.decode()
+    if name_decoded not in eduvpn_objects:
+        return
+    eduvpn_objects[name_decoded].progress(step.decode(), url.decode(), elapsed_ms)
+
+
+@VPNTrace
+def trace_callback(name: bytes, span: bytes) -> None:
+    """The internal trace callback that is passed to the Go library
+
+    :param name: bytes: The name of the client
+    :param span: bytes: The span of the request in JSON
+
+    :meta private:
+    """
+    name_decoded = name.decode()
+    if name_decoded not in eduvpn_objects:
+        return
+    eduvpn_objects[name_decoded].trace(json.loads(span.decode()))
+
+
 def add_as_global_object(eduvpn: EduVPN) -> bool:
     """Add the provided parameter to the global objects lists so we can call the callback
 
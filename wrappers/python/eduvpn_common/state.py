@@ -28,3 +28,5 @@ class State(IntEnum):
     DISCONNECTING = 11
     CONNECTING = 12
     CONNECTED = 13
+    ERROR = 14
+    ASK_MIGRATION = 15
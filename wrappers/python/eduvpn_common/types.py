@@ -25,6 +25,7 @@ class cError(Structure):
         ("level", c_int),
         ("traceback", c_char_p),
         ("cause", c_char_p),
+        ("code", c_char_p),
     ]
 
 
@@ -148,6 +149,33 @@ class cServers(Structure):
     ]
 
 
+class cFSMHistoryEntry(Structure):
+    """The C type that represents a single FSM transition in the history as returned by the Go library
+
+    :meta private:
+    """
+    _fields_ = [
+        ("time", c_ulonglong),
+        ("from_state", c_int),
+        ("to_state", c_int),
+        ("description", c_char_p),
+        ("handled", c_int),
+        ("duration", c_ulonglong),
+        ("error", c_char_p),
+    ]
+
+
+class cFSMHistory(Structure):
+    """The C type that represents the FSM history as returned by the Go library
+
+    :meta private:
+    """
+    _fields_ = [
+        ("entries", POINTER(POINTER(cFSMHistoryEntry))),
+        ("total_entries", c_size_t),
+    ]
+
+
 class DataError(Structure):
     """The C type that represents a tuple of data and error as returned by the Go library
 
@@ -156,17 +184,38 @@ class DataError(Structure):
     _fields_ = [("data", c_void_p), ("error", c_void_p)]
 
 
-class ConfigError(Structure):
-    """The C type that represents the data that gets by the Go library returned when a config is obtained
+class cConfigResult(Structure):
+    """The C type that represents a config result as returned by the Go library
 
     :meta private:
     """
-    _fields_ = [("config", c_void_p), ("config_type", c_void_p), ("error", c_void_p)]
+    _fields_ = [
+        ("protocol", c_char_p),
+        ("config", c_char_p),
+        ("server_type", c_char_p),
+        ("identifier", c_char_p),
+        ("server_url", c_char_p),
+        ("location", c_char_p),
+        ("profile_id", c_char_p),
+        ("profile_display_name", c_char_p),
+        ("default_gateway", c_int),
+        ("start_time", c_ulonglong),
+        ("end_time", c_ulonglong),
+        ("certificate_subject", c_char_p),
+        ("certificate_not_after", c_ulonglong),
+        ("warnings", c_char_p),
+    ]
 
 
 # The type for a Go state change callback
 VPNStateChange = CFUNCTYPE(c_int, c_char_p, c_int, c_int, c_void_p)
 
+# The type for a Go progress callback
+VPNProgress = CFUNCTYPE(None, c_char_p, c_char_p, c_char_p, c_ulonglong)
+
+# The type for a Go trace callback
+VPNTrace = CFUNCTYPE(None, c_char_p, c_char_p)
+
 
 def encode_args(args: List[Any], types: List[Any]) -> Iterator[Any]:
     """Encode the arguments ready to be used by the Go library
@@ -200,7 +249,6 @@ def decode_res(res: Any) -> Any:
         c_int: get_bool,
         c_void_p: get_error,
         DataError: get_data_error,
-        ConfigError: get_config_error,
     }
     return decode_map.get(res, lambda lib, x: x)
 
@@ -261,31 +309,15 @@ def get_error(lib: CDLL, ptr: c_void_p) -> Optional[WrappedError]:
         return None
     err = cast(ptr, POINTER(cError)).contents
     wrapped = WrappedError(
-        err.traceback.decode("utf-8"), err.cause.decode("utf-8"), ErrorLevel(err.level)
+        err.traceback.decode("utf-8"),
+        err.cause.decode("utf-8"),
+        ErrorLevel(err.level),
+        err.code.decode("utf-8"),
     )
     lib.FreeError(ptr)
     return wrapped
 
 
-def get_config_error(
-    lib: CDLL, config_error: ConfigError
-) -> Tuple[str, str, Optional[WrappedError]]:
-    """Convert a C config structure to a Python usable config structure
-
-    :param lib: CDLL: The Go shared library
-    :param config_error: ConfigError: The config error structure
-
-    :meta private:
-
-    :return: The configuration, configuration type ('openvpn'/'wireguard') and an optional error
-    :rtype: Tuple[str, str, Optional[WrappedError]]
-    """
-    config = get_ptr_string(lib, config_error.config)
-    config_type = get_ptr_string(lib, config_error.config_type)
-    err = get_error(lib, config_error.error)
-    return config, config_type, err
-
-
 def get_data_error(
     lib: CDLL, data_error: DataError, data_conv: Callable = get_ptr_string
 ) -> Tuple[Any, Optional[WrappedError]]:
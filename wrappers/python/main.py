@@ -83,8 +83,8 @@ if __name__ == "__main__":
     # Get a Wireguard/OpenVPN config
     try:
         _eduvpn.add_secure_internet("https://idp.geant.org")
-        config, config_type = _eduvpn.get_config_secure_internet("https://idp.geant.org")
-        print(f"Got a config with type: {config_type} and contents:\n{config}")
+        result = _eduvpn.get_config_secure_internet("https://idp.geant.org")
+        print(f"Got a config with type: {result.protocol} and contents:\n{result.config}")
     except Exception as e:
         print("Failed to connect:", e)
         # Save and exit